@@ -10,6 +10,8 @@
 //
 //	tc-sync -configure              — настроить time pulse и выйти
 //	tc-sync -run -config tc-sync.yml — запуск daemon (выбор источника + servo)
+//	tc-sync simulate-ptp [флаги]     — симулятор мастеров PTP для тестов (tc-sync simulate-ptp -h)
+//	tc-sync pmc [флаги] [GET] [ИМЯ...] — наборы данных ptp4l по сокету управления (tc-sync pmc -h)
 package main
 
 import (
@@ -19,6 +21,7 @@ import (
 	"log"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 
 	"github.com/shiwa/timecard-mini/tc-sync/internal/config"
@@ -28,6 +31,16 @@ import (
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		switch strings.TrimLeft(os.Args[1], "-") {
+		case "simulate-ptp":
+			runSimulator(os.Args[2:])
+			return
+		case "pmc":
+			runPMC(os.Args[2:])
+			return
+		}
+	}
 	configure := flag.Bool("configure", false, "настроить time pulse на UBX устройстве и выйти")
 	run := flag.Bool("run", false, "запуск daemon: выбор источника времени + servo (аналог Timebeat)")
 	configPath := flag.String("config", "", "путь к YAML конфигу (по умолчанию tc-sync.yml)")
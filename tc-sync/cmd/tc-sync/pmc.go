@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/shiwa/timecard-mini/tc-sync/internal/ptp4l"
+)
+
+// pmcAll — наборы данных, запрашиваемые без аргументов
+var pmcAll = []ptp4l.ManagementID{ptp4l.IDTimeStatusNP, ptp4l.IDPortDataSet, ptp4l.IDParentDataSet,
+	ptp4l.IDCurrentDataSet, ptp4l.IDGrandmasterSettingsNP}
+
+// runPMC — режим pmc: GET наборов данных ptp4l по сокету управления, вывод как у pmc
+// из linuxptp или JSON (-json). Аргументы — [GET] ИМЯ…; без имён — все поддерживаемые.
+func runPMC(args []string) {
+	fs := flag.NewFlagSet("pmc", flag.ExitOnError)
+	socket := fs.String("s", ptp4l.DefaultSocket, "сокет управления ptp4l (uds_address)")
+	domain := fs.Int("d", 0, "домен PTP (domainNumber ptp4l)")
+	timeout := fs.Duration("t", time.Second, "ожидание ответа")
+	asJSON := fs.Bool("json", false, "вывод в JSON")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Использование: tc-sync pmc [флаги] [GET] [ИМЯ...]\nИМЯ: %s\n", strings.Join(pmcNames(pmcAll), ", "))
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if *domain < 0 || *domain > 255 {
+		log.Fatalf("pmc: domain 0..255")
+	}
+
+	names := fs.Args()
+	if len(names) > 0 && strings.EqualFold(names[0], "GET") {
+		names = names[1:]
+	}
+	ids := pmcAll
+	if len(names) > 0 {
+		ids = nil
+		for _, n := range names {
+			id, err := ptp4l.ParseManagementID(strings.ToUpper(n))
+			if err != nil {
+				log.Fatal(err)
+			}
+			ids = append(ids, id)
+		}
+	}
+
+	c, err := ptp4l.DialPMC(ptp4l.PMCConfig{Socket: *socket, Domain: uint8(*domain), Timeout: *timeout})
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer c.Close()
+	result := make(map[string]any, len(ids))
+	failed := false
+	for _, id := range ids {
+		v, err := pmcGet(c, id)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			failed = true
+			continue
+		}
+		if *asJSON {
+			result[id.String()] = v
+			continue
+		}
+		writePMCDataSet(os.Stdout, id, v)
+	}
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(result)
+	}
+	if failed {
+		c.Close()
+		os.Exit(1)
+	}
+}
+
+func pmcNames(ids []ptp4l.ManagementID) []string {
+	names := make([]string, len(ids))
+	for i, id := range ids {
+		names[i] = id.String()
+	}
+	return names
+}
+
+func pmcGet(c *ptp4l.PMC, id ptp4l.ManagementID) (any, error) {
+	switch id {
+	case ptp4l.IDTimeStatusNP:
+		return c.TimeStatus()
+	case ptp4l.IDPortDataSet:
+		return c.PortDataSets()
+	case ptp4l.IDParentDataSet:
+		return c.ParentDataSet()
+	case ptp4l.IDCurrentDataSet:
+		return c.CurrentDataSet()
+	case ptp4l.IDGrandmasterSettingsNP:
+		return c.GrandmasterSettings()
+	}
+	return nil, fmt.Errorf("pmc: unsupported management id %s", id)
+}
+
+// writePMCDataSet выводит набор данных с именами полей pmc
+func writePMCDataSet(w io.Writer, id ptp4l.ManagementID, v any) {
+	field := func(name string, value any) {
+		fmt.Fprintf(w, "\t\t%-40s %v\n", name, value)
+	}
+	bit := func(b bool) int {
+		if b {
+			return 1
+		}
+		return 0
+	}
+	switch d := v.(type) {
+	case ptp4l.TimeStatusNP:
+		fmt.Fprintf(w, "%s\n", id)
+		field("master_offset", int64(d.MasterOffset))
+		field("ingress_time", d.IngressTime.UnixNano())
+		field("cumulativeScaledRateOffset", fmt.Sprintf("%+.9f", float64(d.CumulativeScaledRateOffset)/(1<<41)))
+		field("scaledLastGmPhaseChange", d.ScaledLastGmPhaseChange)
+		field("gmTimeBaseIndicator", d.GmTimeBaseIndicator)
+		field("lastGmPhaseChange", fmt.Sprintf("0x0000'%016x.0000", uint64(d.LastGmPhaseChange)))
+		field("gmPresent", d.GmPresent)
+		field("gmIdentity", d.GmIdentity)
+	case []ptp4l.PortDataSet:
+		for _, p := range d {
+			fmt.Fprintf(w, "%s %s\n", id, p.Port)
+			field("portIdentity", p.Port)
+			field("portState", p.State)
+			field("logMinDelayReqInterval", p.LogMinDelayReqInterval)
+			field("peerMeanPathDelay", int64(p.PeerMeanPathDelay))
+			field("logAnnounceInterval", p.LogAnnounceInterval)
+			field("announceReceiptTimeout", p.AnnounceReceiptTimeout)
+			field("logSyncInterval", p.LogSyncInterval)
+			field("delayMechanism", p.DelayMechanism)
+			field("logMinPdelayReqInterval", p.LogMinPdelayReqInterval)
+			field("versionNumber", p.Version)
+		}
+	case ptp4l.ParentDataSet:
+		fmt.Fprintf(w, "%s\n", id)
+		field("parentPortIdentity", d.ParentPort)
+		field("parentStats", bit(d.ParentStats))
+		field("observedParentOffsetScaledLogVariance", fmt.Sprintf("0x%04x", d.ObservedParentOffsetScaledLogVariance))
+		field("observedParentClockPhaseChangeRate", fmt.Sprintf("0x%08x", uint32(d.ObservedParentClockPhaseChangeRate)))
+		field("grandmasterPriority1", d.GrandmasterPriority1)
+		field("gm.ClockClass", d.GrandmasterQuality.Class)
+		field("gm.ClockAccuracy", fmt.Sprintf("0x%02x", d.GrandmasterQuality.Accuracy))
+		field("gm.OffsetScaledLogVariance", fmt.Sprintf("0x%04x", d.GrandmasterQuality.Variance))
+		field("grandmasterPriority2", d.GrandmasterPriority2)
+		field("grandmasterIdentity", d.GrandmasterIdentity)
+	case ptp4l.CurrentDataSet:
+		fmt.Fprintf(w, "%s\n", id)
+		field("stepsRemoved", d.StepsRemoved)
+		field("offsetFromMaster", fmt.Sprintf("%.1f", float64(d.OffsetFromMaster)))
+		field("meanPathDelay", fmt.Sprintf("%.1f", float64(d.MeanPathDelay)))
+	case ptp4l.GrandmasterSettingsNP:
+		fmt.Fprintf(w, "%s\n", id)
+		field("clockClass", d.Quality.Class)
+		field("clockAccuracy", fmt.Sprintf("0x%02x", d.Quality.Accuracy))
+		field("offsetScaledLogVariance", fmt.Sprintf("0x%04x", d.Quality.Variance))
+		field("currentUtcOffset", d.UTCOffset)
+		field("leap61", bit(d.TimeFlags&0x01 != 0))
+		field("leap59", bit(d.TimeFlags&0x02 != 0))
+		field("currentUtcOffsetValid", bit(d.TimeFlags&0x04 != 0))
+		field("ptpTimescale", bit(d.TimeFlags&0x08 != 0))
+		field("timeTraceable", bit(d.TimeFlags&0x10 != 0))
+		field("frequencyTraceable", bit(d.TimeFlags&0x20 != 0))
+		field("timeSource", fmt.Sprintf("0x%02x", d.TimeSource))
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/shiwa/timecard-mini/tc-sync/internal/logger"
+	"github.com/shiwa/timecard-mini/tc-sync/internal/ptpsim"
+)
+
+// runSimulator — режим simulate-ptp: мастера PTP симулятора (internal/ptpsim) с искажениями
+// до SIGINT/SIGTERM; раз в -stats в лог пишутся счётчики мастеров
+func runSimulator(args []string) {
+	fs := flag.NewFlagSet("simulate-ptp", flag.ExitOnError)
+	iface := fs.String("interface", "", "сетевой интерфейс (например, конец veth); пусто — loopback")
+	transport := fs.String("transport", "", "транспорт на интерфейсе: udp (по умолчанию), udp6, l2")
+	targets := fs.String("targets", "", "loopback: адреса slave через запятую для multicast сообщений (по умолчанию 127.0.0.1)")
+	eventPort := fs.Int("event-port", 0, "loopback: порт event (по умолчанию 319)")
+	generalPort := fs.Int("general-port", 0, "loopback: порт general (по умолчанию 320)")
+	domain := fs.Int("domain", 0, "домен PTP")
+	masters := fs.Int("masters", 1, "число мастеров (priority1 128, 129, …; на loopback — адреса 127.0.0.2, 127.0.0.3, …)")
+	announce := fs.Int("announce-interval", 0, "интервал Announce, log2 секунд")
+	syncInterval := fs.Int("sync-interval", 0, "интервал Sync, log2 секунд")
+	delayReq := fs.Int("delayrequest-interval", 0, "logMinDelayReqInterval, log2 секунд")
+	offset := fs.Duration("offset", 0, "сдвиг времени мастеров относительно системных часов")
+	drift := fs.Float64("drift", 0, "дрейф времени мастеров, ppb")
+	toSlave := fs.Duration("delay-to-slave", 0, "дополнительная задержка мастер → slave (асимметрия)")
+	fromSlave := fs.Duration("delay-from-slave", 0, "дополнительная задержка slave → мастер (асимметрия)")
+	loss := fs.Float64("loss", 0, "вероятность потери сообщения (0..1)")
+	reorder := fs.Float64("reorder", 0, "вероятность перестановки сообщения со следующим (0..1)")
+	failover := fs.Duration("failover-after", 0, "отказ первого мастера через заданное время (0 — без отказа)")
+	seed := fs.Int64("seed", 0, "seed потерь и перестановок (0 — по времени)")
+	statsEvery := fs.Duration("stats", 10*time.Second, "интервал вывода счётчиков (0 — без вывода)")
+	fs.Parse(args)
+
+	if *domain < 0 || *domain > 255 || *masters < 1 {
+		log.Fatalf("simulate-ptp: domain 0..255, masters >= 1")
+	}
+	cfg := ptpsim.Config{
+		Domain:                 uint8(*domain),
+		Interface:              *iface,
+		Transport:              *transport,
+		EventPort:              *eventPort,
+		GeneralPort:            *generalPort,
+		LogAnnounceInterval:    int8(*announce),
+		LogSyncInterval:        int8(*syncInterval),
+		LogMinDelayReqInterval: int8(*delayReq),
+		Impairments: ptpsim.Impairments{
+			Offset:         *offset,
+			Drift:          *drift,
+			DelayToSlave:   *toSlave,
+			DelayFromSlave: *fromSlave,
+			Loss:           *loss,
+			Reorder:        *reorder,
+		},
+		FailoverAfter: *failover,
+		Seed:          *seed,
+	}
+	for _, s := range strings.Split(*targets, ",") {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		ip := net.ParseIP(s)
+		if ip == nil {
+			log.Fatalf("simulate-ptp: bad target %q", s)
+		}
+		cfg.Targets = append(cfg.Targets, ip)
+	}
+	for i := 0; i < *masters; i++ {
+		cfg.Masters = append(cfg.Masters, ptpsim.MasterConfig{Priority1: uint8(128 + i)})
+	}
+	sim, err := ptpsim.New(cfg)
+	if err != nil {
+		log.Fatalf("simulate-ptp: %v", err)
+	}
+	defer sim.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-sigCh
+		logger.Info("получен сигнал %v, завершение...", sig)
+		cancel()
+	}()
+
+	for i := 0; i < sim.Len(); i++ {
+		id, _ := sim.PortIdentity(i)
+		logger.Info("simulate-ptp: master %d %s, domain %d", i, id, *domain)
+	}
+	if *statsEvery > 0 {
+		go func() {
+			t := time.NewTicker(*statsEvery)
+			defer t.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case now := <-t.C:
+					logSimulator(sim, now)
+				}
+			}
+		}()
+	}
+	sim.Run(ctx)
+	logSimulator(sim, time.Now())
+}
+
+func logSimulator(sim *ptpsim.Simulator, now time.Time) {
+	for i, m := range sim.Masters() {
+		state := "up"
+		if m.Failed {
+			state = "failed"
+		}
+		in := m.Port.In.String()
+		if in == "" {
+			in = "-"
+		}
+		logger.Info("simulate-ptp: master %d %s %s, offset %v: sent %d, lost %d, reordered %d, received %s",
+			i, m.Identity, state, sim.Offset(now), m.Stats.Sent, m.Stats.Lost, m.Stats.Reordered, in)
+	}
+}
@@ -1,6 +1,7 @@
 package clockselect
 
 import (
+	"sync"
 	"time"
 
 	"github.com/shiwa/timecard-mini/tc-sync/internal/source"
@@ -8,6 +9,7 @@ import (
 
 // Election — выбор активного источника времени (аналог Timebeat: primary → secondary)
 type Election struct {
+	mu        sync.Mutex
 	primary   []source.TimeSource
 	secondary []source.TimeSource
 	active    source.TimeSource
@@ -21,37 +23,88 @@ func NewElection(primary, secondary []source.TimeSource) *Election {
 	}
 }
 
-// Select выбирает лучший доступный источник: сначала primary, при недоступности — secondary
+// Select выбирает лучший доступный источник: сначала primary, при недоступности — secondary.
+// Источники опрашиваются без блокировки (GetTime может ждать сеть): Active, Sources и изменения
+// списка secondary не ждут опроса.
 func (e *Election) Select() source.TimeSource {
-	for _, s := range e.primary {
+	primary, secondary := e.Sources()
+	var best source.TimeSource
+	for _, s := range append(primary, secondary...) {
 		if _, st := s.GetTime(); st.IsUsable() {
-			e.active = s
-			return s
+			best = s
+			break
 		}
 	}
-	for _, s := range e.secondary {
-		if _, st := s.GetTime(); st.IsUsable() {
-			e.active = s
-			return s
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	// Источник удалён (RemoveSecondary) во время опроса — не делать его активным
+	if best != nil && !e.contains(best) {
+		best = nil
+	}
+	e.active = best
+	return best
+}
+
+func (e *Election) contains(s source.TimeSource) bool {
+	for _, x := range e.primary {
+		if x == s {
+			return true
 		}
 	}
-	e.active = nil
-	return nil
+	for _, x := range e.secondary {
+		if x == s {
+			return true
+		}
+	}
+	return false
 }
 
 // Active возвращает текущий активный источник (после Select)
 func (e *Election) Active() source.TimeSource {
+	e.mu.Lock()
+	defer e.mu.Unlock()
 	return e.active
 }
 
+// Sources возвращает копии списков primary и secondary (для статуса)
+func (e *Election) Sources() (primary, secondary []source.TimeSource) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]source.TimeSource(nil), e.primary...), append([]source.TimeSource(nil), e.secondary...)
+}
+
+// AddSecondary добавляет secondary источник в конец списка (динамические источники,
+// например обнаруженные мастера PTP)
+func (e *Election) AddSecondary(s source.TimeSource) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.secondary = append(e.secondary, s)
+}
+
+// RemoveSecondary удаляет secondary источник; если он был активным, активного нет до следующего Select
+func (e *Election) RemoveSecondary(s source.TimeSource) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for i, x := range e.secondary {
+		if x == s {
+			e.secondary = append(e.secondary[:i:i], e.secondary[i+1:]...)
+			break
+		}
+	}
+	if e.active == s {
+		e.active = nil
+	}
+}
+
 // GetTimeFromActive возвращает время от активного источника; если активного нет — (zero, false)
 func (e *Election) GetTimeFromActive() (time.Time, bool) {
-	if e.active == nil {
-		e.Select()
+	active := e.Active()
+	if active == nil {
+		active = e.Select()
 	}
-	if e.active == nil {
+	if active == nil {
 		return time.Time{}, false
 	}
-	t, st := e.active.GetTime()
+	t, st := active.GetTime()
 	return t, st.IsUsable()
 }
@@ -100,3 +100,78 @@ func TestElection_GetTimeFromActive(t *testing.T) {
 		}
 	})
 }
+
+func TestElection_DynamicSecondary(t *testing.T) {
+	now := time.Now()
+	primary := &mockSource{"p1", "gnss", now, source.StatusUnavailable}
+	static := &mockSource{"s1", "ntp", now, source.StatusUnlocked}
+	discovered := &mockSource{"ptp:discovered", "ptp", now, source.StatusLocked}
+	e := NewElection([]source.TimeSource{primary}, []source.TimeSource{static})
+	e.AddSecondary(discovered)
+	if e.Select() != discovered {
+		t.Fatal("expected dynamic secondary")
+	}
+	if _, sec := e.Sources(); len(sec) != 2 || sec[1] != discovered {
+		t.Errorf("secondary %v", sec)
+	}
+	e.RemoveSecondary(discovered)
+	if e.Active() != nil || e.Select() != nil {
+		t.Error("removed source still active")
+	}
+	if _, sec := e.Sources(); len(sec) != 1 || sec[0] != static {
+		t.Errorf("secondary after remove %v", sec)
+	}
+}
+
+// blockingSource — GetTime ждёт release (долгий запрос NTP)
+type blockingSource struct {
+	mockSource
+	called, release chan struct{}
+}
+
+func (b *blockingSource) GetTime() (time.Time, source.Status) {
+	close(b.called)
+	<-b.release
+	return b.t, b.st
+}
+
+func TestElection_SelectDoesNotBlock(t *testing.T) {
+	slow := &blockingSource{mockSource{"slow", "ntp", time.Now(), source.StatusLocked}, make(chan struct{}), make(chan struct{})}
+	e := NewElection([]source.TimeSource{slow}, nil)
+	done := make(chan source.TimeSource)
+	go func() { done <- e.Select() }()
+	<-slow.called
+
+	// Во время опроса: статус и изменения secondary не ждут
+	other := &mockSource{"s1", "ptp", time.Now(), source.StatusLocked}
+	finished := make(chan struct{})
+	go func() {
+		e.Active()
+		e.Sources()
+		e.AddSecondary(other)
+		e.RemoveSecondary(other)
+		close(finished)
+	}()
+	select {
+	case <-finished:
+	case <-time.After(time.Second):
+		t.Fatal("election blocked while a source is queried")
+	}
+	close(slow.release)
+	if got := <-done; got != slow || e.Active() != slow {
+		t.Errorf("selected %v", got)
+	}
+}
+
+func TestElection_SelectRemovedDuringQuery(t *testing.T) {
+	slow := &blockingSource{mockSource{"slow", "ptp", time.Now(), source.StatusLocked}, make(chan struct{}), make(chan struct{})}
+	e := NewElection(nil, []source.TimeSource{slow})
+	done := make(chan source.TimeSource)
+	go func() { done <- e.Select() }()
+	<-slow.called
+	e.RemoveSecondary(slow)
+	close(slow.release)
+	if got := <-done; got != nil || e.Active() != nil {
+		t.Errorf("removed source selected: %v", got)
+	}
+}
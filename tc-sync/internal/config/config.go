@@ -4,7 +4,6 @@ import (
 	"fmt"
 	"os"
 
-	"github.com/shiwa/timecard-mini/tc-sync/internal/ptp4l"
 	"gopkg.in/yaml.v3"
 )
 
@@ -26,11 +25,79 @@ type ClockSyncConfig struct {
 	StepLimit       string        `yaml:"step_limit"` // порог step vs slew, например "500ms", "15m"; пусто = 500ms
 	PrimaryClocks   []ClockSource `yaml:"primary_clocks"`
 	SecondaryClocks []ClockSource `yaml:"secondary_clocks"`
+	NTPServer       *NTPServerConfig `yaml:"ntp_server"`
+	NTPKeys         string        `yaml:"ntp_keys"` // keys файл (id тип ключ) для key_id источников ntp и ntp_server
+	Advanced        *AdvancedConfig `yaml:"advanced"`
+}
+
+// AdvancedConfig — clock_sync.advanced (как в shiwatime): ptp_tuning и интерфейс статуса http
+type AdvancedConfig struct {
+	PTPTuning PTPTuningConfig `yaml:"ptp_tuning"`
+	HTTP      *HTTPConfig     `yaml:"http"`
+}
+
+// HTTPConfig — clock_sync.advanced.http: статус daemon по HTTP (curl http://127.0.0.1:8088/)
+type HTTPConfig struct {
+	Enable   bool   `yaml:"enable"`
+	BindHost string `yaml:"bind_host"` // пусто — 127.0.0.1
+	BindPort int    `yaml:"bind_port"` // 0 — 8088
+}
+
+// PTPTuningConfig — clock_sync.advanced.ptp_tuning
+type PTPTuningConfig struct {
+	ClockQuality *ClockQualityConfig `yaml:"clock_quality"`
+	// RelaxDelayRequests — Delay_Req native slave через случайные 200–800 мс после Sync
+	RelaxDelayRequests bool `yaml:"relax_delay_requests"`
+	// AutoDiscoverEnabled — мастера multicast в доменах без записи в конфиге становятся
+	// динамическими secondary источниками (по Announce на интерфейсах записей ptp)
+	AutoDiscoverEnabled bool `yaml:"auto_discover_enabled"`
+	// EnablePTPGlobalSockets — одна пара сокетов UDP на все интерфейсы (без привязки к интерфейсу)
+	EnablePTPGlobalSockets bool `yaml:"enable_ptp_global_sockets"`
+	// MulticastTTL — TTL (hop limit для IPv6) исходящего multicast PTP; 0 — 1
+	MulticastTTL int `yaml:"multicast_ttl"`
+	// DSCP сообщений general и event: число 0–63 или имя (ef, af33, cs6, …)
+	DSCPGeneral string `yaml:"dscp.general"`
+	DSCPEvent   string `yaml:"dscp.event"`
+	// SynchroniseTX — интервал между отправками сообщений PTP серверов на интерфейсе:
+	// "<interface>:<интервал>", например "ens1:5us"
+	SynchroniseTX []string `yaml:"synchronise_tx"`
+	// PTPStandard — 1588-2008 (по умолчанию) или 1588-2019; аутентификация — только с 1588-2019
+	PTPStandard string `yaml:"ptp_standard"`
+}
+
+// ClockQualityConfig — качество часов в Announce PTP сервера. auto — clockClass, clockAccuracy и
+// timeSource по активному источнику; иначе объявляются заданные значения. Без секции — auto.
+type ClockQualityConfig struct {
+	Auto       bool `yaml:"auto"`
+	Class      int  `yaml:"class"`      // clockClass, например 6
+	Accuracy   int  `yaml:"accuracy"`   // clockAccuracy, например 0x21 (100 нс)
+	Variance   int  `yaml:"variance"`   // offsetScaledLogVariance; 0 = 0xFFFF
+	TimeSource int  `yaml:"timesource"` // timeSource, например 0x20 (GPS)
+}
+
+// PTPAuthConfig — аутентификация записи ptp (native slave или сервер): сообщения подписываются
+// TLV AUTHENTICATION (IEEE 1588-2019), принятые без верного TLV отбрасываются
+type PTPAuthConfig struct {
+	SPP  int    `yaml:"spp"`  // security parameter pointer, 0–255
+	Keys string `yaml:"keys"` // файл ключей: «key_id алгоритм ключ [начало [конец]]»
+}
+
+// NTPServerConfig — встроенный NTP сервер, отдающий время дисциплинируемых часов
+type NTPServerConfig struct {
+	Enable        bool     `yaml:"enable"`
+	Listen        string   `yaml:"listen"`         // адрес UDP, по умолчанию ":123"
+	HoldoverLimit string   `yaml:"holdover_limit"` // сколько отдавать время без источника; пусто = 1h
+	Interleaved   bool     `yaml:"interleaved"`    // interleaved режим: клиенты получают точную метку передачи ответа
+	Allow         []string `yaml:"allow"`          // сети CIDR, которым разрешены запросы; пусто — всем
+	Deny          []string `yaml:"deny"`           // сети CIDR, запросы из которых отбрасываются (приоритет над allow)
+	RequireAuth   bool     `yaml:"require_auth"`   // отвечать только на запросы с верным MAC (ключи из ntp_keys)
+	RateLimit     string   `yaml:"rate_limit"`     // минимальный средний интервал запросов клиента, например "1s"; пусто — без ограничения
+	RateBurst     int      `yaml:"rate_burst"`     // запросов подряд сверх rate_limit; 0 = 1
 }
 
 // ClockSource — один источник времени (protocol: gnss, ntp, pps, ptp)
 type ClockSource struct {
-	Protocol string `yaml:"protocol"` // gnss, timebeat_opentimecard_mini, ntp, pps, ptp
+	Protocol string `yaml:"protocol"` // gnss, timebeat_opentimecard_mini, ntp, ntp_pool, pps, ptp
 	Disable  bool   `yaml:"disable"`
 	MonitorOnly bool `yaml:"monitor_only"`
 
@@ -40,14 +107,49 @@ type ClockSource struct {
 	// NTP
 	IP         string `yaml:"ip"`
 	PollInterval string `yaml:"pollinterval"`
+	MaxPollInterval string `yaml:"max_pollinterval"` // верхняя граница адаптивного опроса; пусто = 16 × pollinterval
+	// NTP pool: список серверов для отбора truechimers (пусто — адреса из DNS имени ip)
+	Servers []string `yaml:"servers"`
+	NTS     bool     `yaml:"nts"` // Network Time Security (RFC 8915): NTS-KE по TLS на порт 4460
+	Interleaved bool `yaml:"interleaved"` // interleaved режим (точная метка передачи сервера); без поддержки сервером — basic
+	KeyID   uint32   `yaml:"key_id"` // симметричный ключ из clock_sync.ntp_keys (MD5/SHA1/AES128)
 	// PTP
 	Domain     int    `yaml:"domain"`
 	Interface  string `yaml:"interface"`
 	UnicastMasterTable []string `yaml:"unicast_master_table"`
+	Native     bool   `yaml:"native"` // встроенный slave IEEE 1588 (UDP 319/320) вместо ptp4l + PHC
+	Transport  string `yaml:"transport"` // транспорт native slave и сервера: udp (по умолчанию) или l2 (Ethernet, EtherType 0x88F7)
+	Profile    string `yaml:"profile"`   // профиль: G.8275.1, G.8275.2, G.8265.1, enterprise-draft, IEC/IEEE 61850-9-3, gptp
+	DelayStrategy string `yaml:"delay_strategy"` // e2e (по умолчанию) или p2p
+	DelayMechanism string `yaml:"delay_mechanism"` // то же, что delay_strategy (имя ptp4l); приоритетнее
+	NeighborPropDelayThresh int64 `yaml:"neighbor_prop_delay_thresh"` // P2P gPTP: предел задержки линии для asCapable, нс (0 — 800)
+	HybridE2E  bool   `yaml:"hybrid_e2e"` // native slave: multicast Sync, Delay_Req unicast на адрес мастера (enterprise profile)
+	// Authentication — TLV AUTHENTICATION для native slave и сервера (ptp_standard: 1588-2019)
+	Authentication *PTPAuthConfig `yaml:"authentication"`
+	// PTP сервер (grandmaster): запись с server_only/serve_unicast/serve_multicast — не источник, а порт master
+	ServeUnicast   bool `yaml:"serve_unicast"`   // отвечать на unicast Delay_Req
+	ServeMulticast bool `yaml:"serve_multicast"` // Announce/Sync на 224.0.1.129, multicast Delay_Req
+	ServerOnly     bool `yaml:"server_only"`     // всегда master; иначе passive при лучшем мастере в домене
+	// Интервалы log2 секунд; для native slave с unicast_master_table — запрашиваемые у мастеров
+	AnnounceInterval     int `yaml:"announce_interval"`     // log2 секунд: 1 = 2 с, -3 = 125 мс
+	SyncInterval         int `yaml:"sync_interval"`         // log2 секунд
+	DelayRequestInterval int `yaml:"delayrequest_interval"` // logMinDelayReqInterval для клиентов, log2 секунд
+	Priority1            int `yaml:"priority1"`             // 0 = 128
+	Priority2            int `yaml:"priority2"`             // 0 = 128
+	MaxUnicastSubscribers int `yaml:"max_unicast_subscribers"` // предел unicast клиентов (согласование); 0 — без ограничения
+	MaxPacketsPerSecond int `yaml:"max_packets_per_second"` // порог входящих Delay_Req/Signaling сервера, выше — WRED; 0 — без ограничения
 	// Запуск ptp4l внутри tc-sync (linuxptp)
 	StartPtp4l bool     `yaml:"start_ptp4l"`
 	Ptp4lPath  string   `yaml:"ptp4l_path"`
 	Ptp4lArgs  []string `yaml:"ptp4l_args"`
+	// Ptp4lSocket — сокет управления ptp4l (uds_address) для запроса состояния; пусто — /var/run/ptp4l
+	Ptp4lSocket string `yaml:"ptp4l_socket"`
+	// phc2sys: ptp — PHC интерфейса → системные часы (сервер: системные часы → PHC);
+	// ts2phc: pps — PPS на входе pin сетевой карты interface → её PHC (секунда — linked_device)
+	StartPhc2sys bool   `yaml:"start_phc2sys"`
+	Phc2sysPath  string `yaml:"phc2sys_path"`
+	StartTs2phc  bool   `yaml:"start_ts2phc"`
+	Ts2phcPath   string `yaml:"ts2phc_path"`
 	// PPS
 	Pin        int    `yaml:"pin"`
 	Index      int    `yaml:"index"`
@@ -117,25 +219,6 @@ func Load(path string) (*Config, error) {
 	return &c, nil
 }
 
-// Ptp4lJobs возвращает список заданий ptp4l для источников ptp с start_ptp4l: true.
-func (c *ClockSyncConfig) Ptp4lJobs() []ptp4l.Job {
-	if c == nil {
-		return nil
-	}
-	var jobs []ptp4l.Job
-	for _, s := range c.PrimaryClocks {
-		if s.Protocol == "ptp" && s.StartPtp4l && s.Interface != "" {
-			jobs = append(jobs, ptp4l.Job{Interface: s.Interface, Domain: s.Domain, Path: s.Ptp4lPath, Args: s.Ptp4lArgs})
-		}
-	}
-	for _, s := range c.SecondaryClocks {
-		if s.Protocol == "ptp" && s.StartPtp4l && s.Interface != "" {
-			jobs = append(jobs, ptp4l.Job{Interface: s.Interface, Domain: s.Domain, Path: s.Ptp4lPath, Args: s.Ptp4lArgs})
-		}
-	}
-	return jobs
-}
-
 func applyDefaults(c *Config) {
 	d := Default()
 	if c.Device.Port == "" {
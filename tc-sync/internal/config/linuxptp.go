@@ -0,0 +1,140 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/shiwa/timecard-mini/tc-sync/internal/ptp"
+	"github.com/shiwa/timecard-mini/tc-sync/internal/ptp4l"
+)
+
+// LinuxptpJobs возвращает задания linuxptp для записей clock_sync: ptp4l для ptp с start_ptp4l,
+// phc2sys для ptp с start_phc2sys, ts2phc для pps с start_ts2phc. Конфиги программ строятся
+// из записей (ptp4l — если в ptp4l_args нет своего -f). Записи, конфиг которых не построить,
+// пропускаются; их ошибки возвращаются вместе с остальными заданиями. phc2sys slave записи
+// при adjust_clock не запускается: системные часы подстраивал бы и он, и tc-sync.
+func (c *ClockSyncConfig) LinuxptpJobs() ([]ptp4l.Job, error) {
+	if c == nil {
+		return nil, nil
+	}
+	var jobs []ptp4l.Job
+	var errs []error
+	for _, list := range [][]ClockSource{c.PrimaryClocks, c.SecondaryClocks} {
+		for _, s := range list {
+			if s.Disable || s.Interface == "" {
+				continue
+			}
+			js, err := c.linuxptpJobs(s)
+			if err != nil {
+				errs = append(errs, err)
+			}
+			jobs = append(jobs, js...)
+		}
+	}
+	return jobs, errors.Join(errs...)
+}
+
+// linuxptpJobs — задания одной записи
+func (c *ClockSyncConfig) linuxptpJobs(s ClockSource) ([]ptp4l.Job, error) {
+	var jobs []ptp4l.Job
+	switch {
+	case s.Protocol == "ptp" && !s.Native:
+		if s.StartPtp4l {
+			j := ptp4l.Job{Interface: s.Interface, Domain: s.Domain, Path: s.Ptp4lPath, Args: s.Ptp4lArgs}
+			if !hasFlag(s.Ptp4lArgs, "-f") {
+				conf, err := ptp4l.RenderPtp4l(c.ptp4lOptions(s))
+				if err != nil {
+					return nil, err
+				}
+				j.Config = conf
+			}
+			jobs = append(jobs, j)
+		}
+		if s.StartPhc2sys {
+			server := s.ServerOnly || s.ServeUnicast || s.ServeMulticast
+			if !server && c.AdjustClock {
+				return jobs, fmt.Errorf("phc2sys %s: start_phc2sys conflicts with adjust_clock (both steer CLOCK_REALTIME), phc2sys not started", s.Interface)
+			}
+			o := ptp4l.Phc2sysOptions{Source: s.Interface, Sink: "CLOCK_REALTIME", Domain: profileDomain(s), UDSAddress: s.Ptp4lSocket, Wait: true}
+			if server {
+				o.Source, o.Sink = o.Sink, o.Source
+			}
+			conf, args, err := ptp4l.RenderPhc2sys(o)
+			if err != nil {
+				return jobs, err
+			}
+			jobs = append(jobs, ptp4l.Job{Program: ptp4l.ProgramPhc2sys, Interface: s.Interface, Path: s.Phc2sysPath, Args: args, Config: conf})
+		}
+	case s.Protocol == "pps" && s.StartTs2phc:
+		o := ptp4l.Ts2phcOptions{Interface: s.Interface, Pin: s.Pin, CableDelay: s.CableDelay, NMEASerialPort: s.LinkedDevice}
+		if s.LinkedDevice != "" {
+			// Скорость linked_device — как у источника pps (0 = 115200)
+			o.NMEABaud = s.Baud
+			if o.NMEABaud == 0 {
+				o.NMEABaud = 115200
+			}
+		}
+		conf, args, err := ptp4l.RenderTs2phc(o)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, ptp4l.Job{Program: ptp4l.ProgramTs2phc, Interface: s.Interface, Path: s.Ts2phcPath, Args: args, Config: conf})
+	}
+	return jobs, nil
+}
+
+// ptp4lOptions — параметры конфига ptp4l записи; clock_quality без auto объявляется сервером
+func (c *ClockSyncConfig) ptp4lOptions(s ClockSource) ptp4l.Ptp4lOptions {
+	o := ptp4l.Ptp4lOptions{
+		Interface:               s.Interface,
+		Domain:                  s.Domain,
+		Profile:                 s.Profile,
+		Transport:               s.Transport,
+		DelayMechanism:          s.DelayMechanism,
+		AnnounceInterval:        s.AnnounceInterval,
+		SyncInterval:            s.SyncInterval,
+		DelayRequestInterval:    s.DelayRequestInterval,
+		Priority1:               s.Priority1,
+		Priority2:               s.Priority2,
+		UnicastMasters:          s.UnicastMasterTable,
+		HybridE2E:               s.HybridE2E,
+		ServerOnly:              s.ServerOnly,
+		ServeUnicast:            s.ServeUnicast,
+		ServeMulticast:          s.ServeMulticast,
+		NeighborPropDelayThresh: s.NeighborPropDelayThresh,
+		UDSAddress:              s.Ptp4lSocket,
+	}
+	if o.DelayMechanism == "" {
+		o.DelayMechanism = s.DelayStrategy
+	}
+	if c.Advanced != nil && (o.ServerOnly || o.ServeUnicast || o.ServeMulticast) {
+		if q := c.Advanced.PTPTuning.ClockQuality; q != nil && !q.Auto {
+			cq := ptp.ClockQuality{Class: uint8(q.Class), Accuracy: uint8(q.Accuracy), Variance: uint16(q.Variance)}
+			if cq.Variance == 0 {
+				cq.Variance = 0xFFFF
+			}
+			o.ClockQuality, o.TimeSource = &cq, uint8(q.TimeSource)
+		}
+	}
+	return o
+}
+
+// profileDomain — домен записи; 0 — домен профиля
+func profileDomain(s ClockSource) int {
+	if s.Domain != 0 {
+		return s.Domain
+	}
+	if p, err := ptp.LookupProfile(s.Profile); err == nil && p != nil {
+		return p.Domain
+	}
+	return 0
+}
+
+func hasFlag(args []string, flag string) bool {
+	for _, a := range args {
+		if a == flag {
+			return true
+		}
+	}
+	return false
+}
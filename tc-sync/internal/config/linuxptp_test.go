@@ -0,0 +1,38 @@
+package config
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/shiwa/timecard-mini/tc-sync/internal/ptp4l"
+)
+
+func TestLinuxptpJobs_Phc2sysAdjustClock(t *testing.T) {
+	slave := ClockSource{Protocol: "ptp", Interface: "eth0", StartPtp4l: true, StartPhc2sys: true}
+	server := ClockSource{Protocol: "ptp", Interface: "eth1", StartPhc2sys: true, ServerOnly: true}
+
+	// slave + adjust_clock: phc2sys не запускается, ptp4l остаётся
+	c := &ClockSyncConfig{AdjustClock: true, PrimaryClocks: []ClockSource{slave, server}}
+	jobs, err := c.LinuxptpJobs()
+	if err == nil || !strings.Contains(err.Error(), "adjust_clock") {
+		t.Fatalf("err %v, want start_phc2sys/adjust_clock conflict", err)
+	}
+	var got []string
+	for _, j := range jobs {
+		got = append(got, ptp4l.Key(j.Program, j.Interface))
+	}
+	want := []string{ptp4l.Key(ptp4l.ProgramPtp4l, "eth0"), ptp4l.Key(ptp4l.ProgramPhc2sys, "eth1")}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("jobs %v, want %v", got, want)
+	}
+
+	// Без adjust_clock phc2sys slave записи синхронизирует CLOCK_REALTIME с PHC
+	c.AdjustClock = false
+	jobs, err = c.LinuxptpJobs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 3 || jobs[1].Program != ptp4l.ProgramPhc2sys || jobs[1].Interface != "eth0" {
+		t.Errorf("jobs %+v", jobs)
+	}
+}
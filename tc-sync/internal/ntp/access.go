@@ -0,0 +1,139 @@
+package ntp
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// AccessList — списки доступа сервера: запрос принимается, если адрес клиента не входит в Deny
+// и входит в Allow (пустой Allow — любые адреса). Deny имеет приоритет.
+type AccessList struct {
+	Allow []*net.IPNet
+	Deny  []*net.IPNet
+}
+
+// ParseAccessList разбирает списки CIDR; одиночный адрес — сеть /32 (/128 для IPv6)
+func ParseAccessList(allow, deny []string) (*AccessList, error) {
+	a := &AccessList{}
+	var err error
+	if a.Allow, err = parseNets(allow); err != nil {
+		return nil, err
+	}
+	if a.Deny, err = parseNets(deny); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func parseNets(list []string) ([]*net.IPNet, error) {
+	var out []*net.IPNet
+	for _, s := range list {
+		s = strings.TrimSpace(s)
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, fmt.Errorf("ntp: bad address %q", s)
+			}
+			bits := 128
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 32
+			}
+			out = append(out, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("ntp: bad network %q", s)
+		}
+		out = append(out, n)
+	}
+	return out, nil
+}
+
+// Permit возвращает true, если клиенту с адресом ip разрешено обращаться к серверу (nil — всем)
+func (a *AccessList) Permit(ip net.IP) bool {
+	if a == nil {
+		return true
+	}
+	for _, n := range a.Deny {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	if len(a.Allow) == 0 {
+		return true
+	}
+	for _, n := range a.Allow {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// RateLimit — ограничение частоты запросов одного клиента (token bucket):
+// в среднем не чаще одного запроса за Interval, подряд — до Burst запросов.
+type RateLimit struct {
+	Interval time.Duration // 0 — без ограничения
+	Burst    int           // < 1 — 1
+}
+
+// rateBucket — состояние ограничителя для одного клиента
+type rateBucket struct {
+	tokens  float64
+	last    time.Time // время последнего пополнения
+	lastKoD time.Time // время последнего KoD RATE этому клиенту
+}
+
+// maxRateClients — размер таблицы клиентов ограничителя
+const maxRateClients = 4096
+
+// rateLimiter — ограничитель частоты запросов по IP клиента (только в Serve)
+type rateLimiter struct {
+	limit   RateLimit
+	clients map[string]*rateBucket
+}
+
+// allow учитывает запрос клиента key в момент now. ok — запрос обслуживается;
+// иначе kod — ответить KoD RATE (не чаще раза за Interval, остальные запросы отбрасываются молча).
+func (l *rateLimiter) allow(key string, now time.Time) (ok, kod bool) {
+	if l.limit.Interval <= 0 {
+		return true, false
+	}
+	burst := float64(l.limit.Burst)
+	if burst < 1 {
+		burst = 1
+	}
+	if l.clients == nil {
+		l.clients = make(map[string]*rateBucket)
+	}
+	b := l.clients[key]
+	if b == nil {
+		if len(l.clients) >= maxRateClients {
+			for k := range l.clients {
+				delete(l.clients, k)
+				break
+			}
+		}
+		b = &rateBucket{tokens: burst, last: now}
+		l.clients[key] = b
+	}
+	if el := now.Sub(b.last); el > 0 {
+		b.tokens += float64(el) / float64(l.limit.Interval)
+		if b.tokens > burst {
+			b.tokens = burst
+		}
+	}
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, false
+	}
+	if now.Sub(b.lastKoD) >= l.limit.Interval {
+		b.lastKoD = now
+		return false, true
+	}
+	return false, false
+}
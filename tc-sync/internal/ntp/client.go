@@ -0,0 +1,327 @@
+package ntp
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/shiwa/timecard-mini/tc-sync/internal/timestamping"
+)
+
+// Константы алгоритмов RFC 5905 (раздел 7.2)
+const (
+	// PHI — допуск частоты часов (15 ppm), скорость роста дисперсии
+	PHI = 15e-6
+	// MaxDispersion — максимальная дисперсия (16 с), значение для пустых ячеек фильтра
+	MaxDispersion = 16 * time.Second
+	// MaxDistance — порог root distance (1.5 с), выше которого сервер непригоден
+	MaxDistance = 1500 * time.Millisecond
+	// localPrecision — log2 точности локальных часов (~1 мкс)
+	localPrecision = -20
+)
+
+// Ошибки обмена с сервером
+var (
+	ErrBogus          = errors.New("ntp: bogus packet (origin timestamp mismatch)")
+	ErrUnsynchronized = errors.New("ntp: server not synchronized")
+	ErrBadMode        = errors.New("ntp: unexpected mode in response")
+	ErrRootDistance   = errors.New("ntp: root distance exceeds limit")
+)
+
+// KissError — ответ Kiss-o'-Death (stratum 0) с кодом RATE, DENY, RSTR и т.п.
+type KissError struct {
+	Code string
+}
+
+func (e *KissError) Error() string {
+	return fmt.Sprintf("ntp: kiss-o'-death %q", e.Code)
+}
+
+// Sample — одно измерение по четырём меткам T1..T4 (RFC 5905, раздел 8).
+type Sample struct {
+	Offset         time.Duration // θ = ((T2-T1) + (T3-T4)) / 2
+	Delay          time.Duration // δ = (T4-T1) - (T3-T2)
+	Dispersion     time.Duration // ε = точность сервера + точность клиента + PHI·(T4-T1)
+	Jitter         time.Duration // заполняется фильтром (RMS разностей offset)
+	Stratum        uint8
+	Leap           LeapIndicator
+	ReferenceID    uint32
+	RootDelay      time.Duration
+	RootDispersion time.Duration
+	Poll           int8
+	Time           time.Time // локальное время приёма ответа (T4)
+	Server         net.IP    // адрес сервера, ответившего на запрос
+	// Типы меток T1 (передача запроса) и T4 (приём ответа): ядро, сетевая карта или user space
+	TxTimestampType timestamping.Type
+	RxTimestampType timestamping.Type
+	Interleaved     bool // измерение по interleaved ответу (точная метка передачи сервера)
+}
+
+// RootDistance — λ = (rootdelay + delay)/2 + rootdisp + disp + jitter (RFC 5905, раздел 11.2.1)
+func (s Sample) RootDistance() time.Duration {
+	return (s.RootDelay+s.Delay)/2 + s.RootDispersion + s.Dispersion + s.Jitter
+}
+
+// Client — NTP клиент одного сервера (режим client/server, RFC 5905).
+// Методы Client не предназначены для параллельного вызова (состояние interleaved ассоциации).
+type Client struct {
+	Host    string        // host или host:port; по умолчанию порт 123
+	Timeout time.Duration // таймаут одного обмена
+	// NTS — если задан, запросы аутентифицируются NTS (RFC 8915); адрес сервера берётся из NTS-KE
+	NTS *NTSSession
+	// Interleaved — запрашивать interleaved режим (как в chrony): сервер возвращает точную метку
+	// передачи предыдущего ответа. Сервер без поддержки отвечает в basic режиме — он и используется.
+	Interleaved bool
+	// Key — симметричный ключ (RFC 5905 MAC / RFC 8573 AES-CMAC): запрос подписывается,
+	// ответ без MAC или с неверным MAC отклоняется. Не используется вместе с NTS.
+	Key *Key
+
+	prev     *exchange // предыдущий успешный обмен (основа interleaved запроса)
+	basicRun int       // подряд basic ответов на interleaved запросы
+	stats    ClientStats
+}
+
+// ClientStats — счётчики обменов клиента
+type ClientStats struct {
+	Requests        uint64 // отправлено запросов
+	Responses       uint64 // принято ответов на наши запросы (прошедших аутентификацию)
+	Unauthenticated uint64 // ответы без MAC при заданном Key
+	AuthFailed      uint64 // ответы с чужим key ID или неверным MAC
+}
+
+// exchange — метки одного обмена, нужные для следующего interleaved запроса
+type exchange struct {
+	t1, t4         time.Time // локальные метки передачи запроса и приёма ответа
+	t1Type, t4Type timestamping.Type
+	rec            Timestamp // receive timestamp сервера (T2) из ответа
+}
+
+// interleavedMaxBasic — после стольких basic ответов подряд ассоциация переходит в basic режим
+const interleavedMaxBasic = 4
+
+// NewClient создаёт клиента; timeout <= 0 — 5 секунд.
+func NewClient(host string, timeout time.Duration) *Client {
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	return &Client{Host: host, Timeout: timeout}
+}
+
+// Address возвращает host:port сервера
+func (c *Client) Address() string {
+	if _, _, err := net.SplitHostPort(c.Host); err == nil {
+		return c.Host
+	}
+	return net.JoinHostPort(c.Host, strconv.Itoa(Port))
+}
+
+// Stats возвращает счётчики обменов
+func (c *Client) Stats() ClientStats {
+	return c.stats
+}
+
+// InterleavedActive возвращает true, пока ассоциация пытается работать в interleaved режиме
+func (c *Client) InterleavedActive() bool {
+	return c.Interleaved && c.basicRun < interleavedMaxBasic
+}
+
+// Query выполняет один обмен с сервером и возвращает измерение.
+// Отклоняет ответы с несовпадающим origin timestamp, KoD, leap=3, stratum 0/16 и чрезмерным root distance.
+// С NTS ответ принимается только при успешной проверке AEAD, с Key — при верном MAC.
+// T1 и T4 берутся из меток ядра (SO_TIMESTAMPING, error queue), если они доступны.
+//
+// Interleaved режим: запрос несёт origin = receive timestamp предыдущего ответа сервера и
+// receive = локальную метку его приёма. Сервер, узнавший запрос, отвечает origin = наш receive
+// и transmit = точной меткой передачи предыдущего ответа; измерение тогда относится к предыдущему
+// обмену (T1..T4 предыдущего обмена с точной T3).
+func (c *Client) Query() (Sample, error) {
+	prev := c.prev
+	c.prev = nil // при ошибке следующий запрос — basic
+	server := c.Address()
+	if c.NTS != nil {
+		a, err := c.NTS.ServerAddress()
+		if err != nil {
+			return Sample{}, err
+		}
+		server = a
+	}
+	addr, err := net.ResolveUDPAddr("udp", server)
+	if err != nil {
+		return Sample{}, err
+	}
+	udp, err := net.DialUDP("udp", nil, addr)
+	if err != nil {
+		return Sample{}, err
+	}
+	conn := timestamping.New(udp, timestamping.Options{TX: true})
+	defer conn.Close()
+	if err := udp.SetDeadline(time.Now().Add(c.Timeout)); err != nil {
+		return Sample{}, err
+	}
+
+	req := Packet{Version: Version, Mode: ModeClient, Poll: 4, Precision: localPrecision}
+	interleaved := prev != nil && c.InterleavedActive()
+	if interleaved {
+		req.OriginTime = prev.rec
+		req.ReceiveTime = NewTimestamp(prev.t4)
+	}
+	t1 := time.Now()
+	req.TransmitTime = NewTimestamp(t1)
+	out := req.Marshal()
+	var nts *ntsRequest
+	if c.NTS != nil {
+		if out, nts, err = c.NTS.appendRequest(out); err != nil {
+			return Sample{}, err
+		}
+	} else if c.Key != nil {
+		out = c.Key.AppendMAC(out)
+	}
+	txStamp, err := conn.WriteMsg(out, nil)
+	if err != nil {
+		return Sample{}, err
+	}
+	c.stats.Requests++
+	// Метка ядра точнее времени, записанного в пакет (и не включает подготовку NTS полей)
+	t1 = txStamp.Time
+	buf := make([]byte, 2048)
+	var authErr error // последняя ошибка аутентификации: возвращается вместо таймаута
+	for {
+		n, _, rxStamp, err := conn.ReadMsg(buf)
+		if err != nil {
+			if authErr != nil {
+				return Sample{}, authErr
+			}
+			return Sample{}, err
+		}
+		t4 := rxStamp.Time
+		resp, err := Unmarshal(buf[:n])
+		if err != nil {
+			continue
+		}
+		// Чужой/повторный ответ: ждём дальше до таймаута
+		basicResp := resp.OriginTime == req.TransmitTime
+		interleavedResp := interleaved && !req.ReceiveTime.IsZero() && resp.OriginTime == req.ReceiveTime
+		if !basicResp && !interleavedResp {
+			continue
+		}
+		if nts != nil {
+			if err := c.NTS.processResponse(buf[:n], resp, nts); err != nil {
+				if err == ErrBogus {
+					continue
+				}
+				return Sample{}, err
+			}
+		} else if c.Key != nil {
+			// Поддельный ответ не должен прерывать ожидание настоящего
+			if _, err := VerifyMAC(buf[:n], Keys{c.Key.ID: c.Key}); err != nil {
+				if errors.Is(err, ErrNoMAC) {
+					c.stats.Unauthenticated++
+				} else {
+					c.stats.AuthFailed++
+				}
+				authErr = err
+				continue
+			}
+		}
+		c.stats.Responses++
+		var s Sample
+		if interleavedResp {
+			c.basicRun = 0
+			s, err = processInterleaved(resp, prev, t4)
+			s.TxTimestampType, s.RxTimestampType = prev.t1Type, prev.t4Type
+		} else {
+			if interleaved {
+				c.basicRun++
+			}
+			s, err = ProcessResponse(resp, req.TransmitTime, t1, t4)
+			s.TxTimestampType, s.RxTimestampType = txStamp.Type, rxStamp.Type
+		}
+		s.Server = addr.IP
+		if err == nil {
+			c.prev = &exchange{t1: t1, t4: t4, t1Type: txStamp.Type, t4Type: rxStamp.Type, rec: resp.ReceiveTime}
+		}
+		return s, err
+	}
+}
+
+// ProcessResponse проверяет ответ сервера и вычисляет offset, delay и dispersion.
+// sentXmt — transmit timestamp из запроса (как он был записан в пакет), t1/t4 — локальные
+// времена отправки запроса и приёма ответа.
+func ProcessResponse(resp *Packet, sentXmt Timestamp, t1, t4 time.Time) (Sample, error) {
+	if resp.Mode != ModeServer {
+		return Sample{}, ErrBadMode
+	}
+	if resp.OriginTime != sentXmt || resp.TransmitTime.IsZero() {
+		return Sample{}, ErrBogus
+	}
+	if err := checkServer(resp); err != nil {
+		return Sample{}, err
+	}
+	return newSample(resp, t1, resp.ReceiveTime.Time(), resp.TransmitTime.Time(), t4)
+}
+
+// processInterleaved вычисляет измерение предыдущего обмена по interleaved ответу:
+// T1, T2 и T4 — из предыдущего обмена, T3 — точная метка передачи из ответа.
+// Время измерения — момент приёма этого ответа (received), а не предыдущего.
+func processInterleaved(resp *Packet, prev *exchange, received time.Time) (Sample, error) {
+	if resp.Mode != ModeServer {
+		return Sample{}, ErrBadMode
+	}
+	if resp.TransmitTime.IsZero() || prev.rec.IsZero() {
+		return Sample{}, ErrBogus
+	}
+	if err := checkServer(resp); err != nil {
+		return Sample{}, err
+	}
+	s, err := newSample(resp, prev.t1, prev.rec.Time(), resp.TransmitTime.Time(), prev.t4)
+	s.Interleaved = true
+	s.Time = received
+	return s, err
+}
+
+// checkServer отклоняет KoD и несинхронизированные серверы
+func checkServer(resp *Packet) error {
+	if code := resp.KissCode(); code != "" {
+		return &KissError{Code: code}
+	}
+	if resp.Leap == LeapNotInSync || resp.Stratum >= MaxStratum {
+		return ErrUnsynchronized
+	}
+	return nil
+}
+
+// newSample вычисляет offset/delay/dispersion по четырём меткам (RFC 5905, раздел 8)
+func newSample(resp *Packet, t1, t2, t3, t4 time.Time) (Sample, error) {
+	s := Sample{
+		Offset:         (t2.Sub(t1) + t3.Sub(t4)) / 2,
+		Delay:          t4.Sub(t1) - t3.Sub(t2),
+		Stratum:        resp.Stratum,
+		Leap:           resp.Leap,
+		ReferenceID:    resp.ReferenceID,
+		RootDelay:      ShortToDuration(resp.RootDelay),
+		RootDispersion: ShortToDuration(resp.RootDispersion),
+		Poll:           resp.Poll,
+		Time:           t4,
+	}
+	if s.Delay < 0 {
+		// Сервер «обработал» запрос дольше, чем длился обмен — метки недостоверны
+		if -s.Delay > precisionDuration(resp.Precision) {
+			return Sample{}, ErrBogus
+		}
+		s.Delay = 0
+	}
+	s.Dispersion = precisionDuration(resp.Precision) + precisionDuration(localPrecision) +
+		time.Duration(PHI*float64(t4.Sub(t1)))
+	if s.RootDelay/2+s.RootDispersion > MaxDistance {
+		return Sample{}, ErrRootDistance
+	}
+	return s, nil
+}
+
+// precisionDuration переводит log2-точность (poll/precision) в длительность
+func precisionDuration(log2 int8) time.Duration {
+	return time.Duration(math.Ldexp(1e9, int(log2)))
+}
@@ -0,0 +1,101 @@
+package ntp
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// Типы extension fields NTS (RFC 8915, раздел 5.7)
+const (
+	ExtUniqueIdentifier  uint16 = 0x0104
+	ExtNTSCookie         uint16 = 0x0204
+	ExtCookiePlaceholder uint16 = 0x0304
+	ExtNTSAuthenticator  uint16 = 0x0404
+)
+
+// minExtensionLen — минимальная длина extension field (RFC 7822), включая 4 байта заголовка
+const minExtensionLen = 16
+
+// ErrBadExtension — некорректный extension field
+var ErrBadExtension = errors.New("ntp: malformed extension field")
+
+// ExtensionField — extension field NTPv4 (RFC 7822): тип, значение и смещение в пакете
+type ExtensionField struct {
+	Type   uint16
+	Value  []byte // тело без заголовка (может содержать выравнивающие нули)
+	Offset int    // смещение начала поля в пакете
+}
+
+// AppendExtension добавляет extension field к пакету b; тело выравнивается до 4 байт,
+// общая длина — не меньше 16 байт.
+func AppendExtension(b []byte, typ uint16, value []byte) []byte {
+	length := 4 + len(value)
+	if rem := length % 4; rem != 0 {
+		length += 4 - rem
+	}
+	if length < minExtensionLen {
+		length = minExtensionLen
+	}
+	b = binary.BigEndian.AppendUint16(b, typ)
+	b = binary.BigEndian.AppendUint16(b, uint16(length))
+	b = append(b, value...)
+	return append(b, make([]byte, length-4-len(value))...)
+}
+
+// ParseExtensions разбирает extension fields после заголовка NTP до конца пакета.
+// Хвост, не являющийся корректным extension field (например MAC), возвращается как rest.
+func ParseExtensions(pkt []byte) (fields []ExtensionField, rest []byte) {
+	off := HeaderSize
+	for len(pkt)-off >= minExtensionLen {
+		// RFC 7822, 7.5: хвост длиной 20 или 24 байта — MAC (key ID + MD5/SHA1/CMAC), а не extension field
+		if n := len(pkt) - off; n == 20 || n == 24 {
+			break
+		}
+		typ := binary.BigEndian.Uint16(pkt[off:])
+		length := int(binary.BigEndian.Uint16(pkt[off+2:]))
+		if length < minExtensionLen || length%4 != 0 || off+length > len(pkt) {
+			break
+		}
+		fields = append(fields, ExtensionField{Type: typ, Value: pkt[off+4 : off+length], Offset: off})
+		off += length
+	}
+	if off < len(pkt) {
+		rest = pkt[off:]
+	}
+	return fields, rest
+}
+
+// AppendAuthenticator добавляет NTS Authenticator and Encrypted Extension Fields (RFC 8915, 5.6):
+// associated data — весь пакет до этого поля, plaintext — шифруемые extension fields.
+func AppendAuthenticator(pkt []byte, aead *SIV, nonce, plaintext []byte) []byte {
+	ct := aead.Seal(plaintext, pkt, nonce)
+	body := make([]byte, 0, 4+pad4(len(nonce))+pad4(len(ct)))
+	body = binary.BigEndian.AppendUint16(body, uint16(len(nonce)))
+	body = binary.BigEndian.AppendUint16(body, uint16(len(ct)))
+	body = append(body, nonce...)
+	body = append(body, make([]byte, pad4(len(nonce))-len(nonce))...)
+	body = append(body, ct...)
+	body = append(body, make([]byte, pad4(len(ct))-len(ct))...)
+	return AppendExtension(pkt, ExtNTSAuthenticator, body)
+}
+
+// OpenAuthenticator проверяет поле NTS Authenticator (последнее в пакете) и возвращает
+// расшифрованные extension fields. Associated data — пакет до поля.
+func OpenAuthenticator(pkt []byte, f ExtensionField, aead *SIV) ([]byte, error) {
+	v := f.Value
+	if len(v) < 4 {
+		return nil, ErrBadExtension
+	}
+	nonceLen := int(binary.BigEndian.Uint16(v[0:2]))
+	ctLen := int(binary.BigEndian.Uint16(v[2:4]))
+	if 4+pad4(nonceLen)+ctLen > len(v) {
+		return nil, ErrBadExtension
+	}
+	nonce := v[4 : 4+nonceLen]
+	ct := v[4+pad4(nonceLen) : 4+pad4(nonceLen)+ctLen]
+	return aead.Open(ct, pkt[:f.Offset], nonce)
+}
+
+func pad4(n int) int {
+	return (n + 3) &^ 3
+}
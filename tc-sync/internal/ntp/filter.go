@@ -0,0 +1,96 @@
+package ntp
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// FilterSize — число ячеек фильтра часов (RFC 5905: NSTAGE = 8)
+const FilterSize = 8
+
+// Filter — фильтр часов RFC 5905 (раздел 10): хранит последние 8 измерений и выбирает
+// измерение с минимальной задержкой (оно наименее искажено очередями в сети).
+// Дисперсия ячеек растёт со временем со скоростью PHI.
+type Filter struct {
+	stages   [FilterSize]Sample
+	count    int       // сколько ячеек заполнено
+	lastUsed time.Time // время последнего выданного измерения
+	jitter   time.Duration
+}
+
+// NewFilter создаёт пустой фильтр
+func NewFilter() *Filter {
+	return &Filter{}
+}
+
+// Add добавляет измерение и возвращает лучшее (min delay) с дисперсией и jitter фильтра.
+// ok=false, если лучшее измерение не новее уже выданного (RFC 5905: старые данные повторно не используются).
+func (f *Filter) Add(s Sample) (best Sample, ok bool) {
+	copy(f.stages[1:], f.stages[:FilterSize-1])
+	f.stages[0] = s
+	if f.count < FilterSize {
+		f.count++
+	}
+	now := s.Time
+
+	type stage struct {
+		s    Sample
+		disp time.Duration
+	}
+	list := make([]stage, 0, FilterSize)
+	for i := 0; i < f.count; i++ {
+		st := f.stages[i]
+		aged := st.Dispersion + time.Duration(PHI*float64(now.Sub(st.Time)))
+		if aged > MaxDispersion {
+			aged = MaxDispersion
+		}
+		list = append(list, stage{s: st, disp: aged})
+	}
+	sort.SliceStable(list, func(i, j int) bool {
+		return list[i].s.Delay < list[j].s.Delay
+	})
+
+	// Дисперсия фильтра: ε = Σ εi / 2^(i+1), пустые ячейки — MaxDispersion
+	var disp float64
+	for i := 0; i < FilterSize; i++ {
+		d := MaxDispersion
+		if i < len(list) {
+			d = list[i].disp
+		}
+		disp += float64(d) / math.Exp2(float64(i+1))
+	}
+	// Jitter: RMS разностей offset относительно лучшего измерения
+	var sum float64
+	for i := 1; i < len(list); i++ {
+		diff := float64(list[i].s.Offset - list[0].s.Offset)
+		sum += diff * diff
+	}
+	jitter := time.Duration(0)
+	if len(list) > 1 {
+		jitter = time.Duration(math.Sqrt(sum / float64(len(list)-1)))
+	}
+	if p := precisionDuration(localPrecision); jitter < p {
+		jitter = p
+	}
+	f.jitter = jitter
+
+	best = list[0].s
+	best.Dispersion = time.Duration(disp)
+	best.Jitter = jitter
+	if !best.Time.After(f.lastUsed) {
+		return best, false
+	}
+	f.lastUsed = best.Time
+	return best, true
+}
+
+// Jitter возвращает jitter, вычисленный при последнем Add
+func (f *Filter) Jitter() time.Duration {
+	return f.jitter
+}
+
+// Reset очищает фильтр (например после step часов)
+func (f *Filter) Reset() {
+	*f = Filter{}
+}
@@ -0,0 +1,372 @@
+package ntp
+
+import (
+	"errors"
+	"net"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/shiwa/timecard-mini/tc-sync/internal/timestamping"
+)
+
+func TestTimestamp_RoundTrip(t *testing.T) {
+	in := time.Date(2025, 6, 30, 23, 59, 59, 123456789, time.UTC)
+	got := NewTimestamp(in).Time()
+	if d := got.Sub(in); d > time.Nanosecond || d < -time.Nanosecond {
+		t.Errorf("round trip: got %v want %v", got, in)
+	}
+	// Эра 1: после 2036-02-07
+	era1 := time.Date(2040, 1, 1, 0, 0, 0, 0, time.UTC)
+	if got := NewTimestamp(era1).Time(); !got.Equal(era1) {
+		t.Errorf("era 1: got %v want %v", got, era1)
+	}
+}
+
+func TestShortFormat(t *testing.T) {
+	if got := ShortToDuration(0x00010000); got != time.Second {
+		t.Errorf("ShortToDuration(1.0) = %v", got)
+	}
+	if got := DurationToShort(500 * time.Millisecond); got != 0x00008000 {
+		t.Errorf("DurationToShort(0.5s) = %#x", got)
+	}
+}
+
+func TestPacket_MarshalUnmarshal(t *testing.T) {
+	p := Packet{
+		Leap: LeapInsert, Version: 4, Mode: ModeServer, Stratum: 1, Poll: 6, Precision: -20,
+		RootDelay: 0x10, RootDispersion: 0x20, ReferenceID: RefIDFromString("GPS"),
+		ReferenceTime: 1, OriginTime: 2, ReceiveTime: 3, TransmitTime: 4,
+	}
+	got, err := Unmarshal(p.Marshal())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *got != p {
+		t.Errorf("got %+v want %+v", *got, p)
+	}
+	if RefIDString(got.ReferenceID) != "GPS" {
+		t.Errorf("refid %q", RefIDString(got.ReferenceID))
+	}
+	if _, err := Unmarshal(make([]byte, 47)); err != ErrShortPacket {
+		t.Errorf("short packet: %v", err)
+	}
+}
+
+func TestProcessResponse(t *testing.T) {
+	t1 := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	offset := 3 * time.Millisecond
+	oneWay := 10 * time.Millisecond
+	t2 := t1.Add(oneWay).Add(offset)
+	t3 := t2.Add(time.Millisecond)
+	t4 := t3.Add(-offset).Add(oneWay)
+	xmt := NewTimestamp(t1)
+	resp := &Packet{Version: 4, Mode: ModeServer, Stratum: 2, Precision: -20,
+		OriginTime: xmt, ReceiveTime: NewTimestamp(t2), TransmitTime: NewTimestamp(t3)}
+
+	s, err := ProcessResponse(resp, xmt, t1, t4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := s.Offset - offset; d > time.Microsecond || d < -time.Microsecond {
+		t.Errorf("offset %v want %v", s.Offset, offset)
+	}
+	if d := s.Delay - 2*oneWay; d > time.Microsecond || d < -time.Microsecond {
+		t.Errorf("delay %v want %v", s.Delay, 2*oneWay)
+	}
+	if s.Stratum != 2 || s.Dispersion <= 0 {
+		t.Errorf("stratum=%d dispersion=%v", s.Stratum, s.Dispersion)
+	}
+
+	tests := []struct {
+		name string
+		mod  func(p *Packet)
+		want error
+	}{
+		{"bogus origin", func(p *Packet) { p.OriginTime++ }, ErrBogus},
+		{"leap 3", func(p *Packet) { p.Leap = LeapNotInSync }, ErrUnsynchronized},
+		{"stratum 16", func(p *Packet) { p.Stratum = 16 }, ErrUnsynchronized},
+		{"client mode", func(p *Packet) { p.Mode = ModeClient }, ErrBadMode},
+	}
+	for _, tt := range tests {
+		p := *resp
+		tt.mod(&p)
+		if _, err := ProcessResponse(&p, xmt, t1, t4); err != tt.want {
+			t.Errorf("%s: got %v want %v", tt.name, err, tt.want)
+		}
+	}
+
+	kod := *resp
+	kod.Stratum = 0
+	kod.ReferenceID = RefIDFromString("RATE")
+	_, err = ProcessResponse(&kod, xmt, t1, t4)
+	var ke *KissError
+	if !errors.As(err, &ke) || ke.Code != "RATE" {
+		t.Errorf("KoD: got %v", err)
+	}
+}
+
+func TestFilter_MinimumDelay(t *testing.T) {
+	f := NewFilter()
+	base := time.Now()
+	delays := []time.Duration{30, 10, 50, 20}
+	var best Sample
+	var ok bool
+	for i, d := range delays {
+		best, ok = f.Add(Sample{
+			Offset: time.Duration(i) * time.Millisecond,
+			Delay:  d * time.Millisecond,
+			Time:   base.Add(time.Duration(i) * time.Second),
+		})
+	}
+	// Последнее измерение (delay 20) хуже уже выданного (delay 10) — повторно не выдаётся
+	if ok {
+		t.Error("expected ok=false when best sample was already used")
+	}
+	if best.Delay != 10*time.Millisecond || best.Offset != time.Millisecond {
+		t.Errorf("best = %+v, want delay 10ms offset 1ms", best)
+	}
+	if best.Jitter <= 0 || best.Dispersion <= 0 {
+		t.Errorf("jitter=%v dispersion=%v", best.Jitter, best.Dispersion)
+	}
+	best, ok = f.Add(Sample{Offset: 5 * time.Millisecond, Delay: 5 * time.Millisecond, Time: base.Add(10 * time.Second)})
+	if !ok || best.Offset != 5*time.Millisecond {
+		t.Errorf("new minimum-delay sample: ok=%v best=%+v", ok, best)
+	}
+}
+
+func TestPoller(t *testing.T) {
+	p := NewPoller(4*time.Second, 16*time.Second)
+	for i := 0; i < pollLimit; i++ {
+		p.Update(time.Microsecond, time.Millisecond)
+	}
+	if p.Interval() != 8*time.Second {
+		t.Errorf("after stable samples interval = %v, want 8s", p.Interval())
+	}
+	p.Backoff()
+	p.Backoff()
+	if p.Interval() != 16*time.Second {
+		t.Errorf("interval must be capped at max, got %v", p.Interval())
+	}
+	for i := 0; i < pollLimit; i++ {
+		p.Update(time.Second, time.Millisecond)
+	}
+	if p.Interval() != 8*time.Second {
+		t.Errorf("after large offsets interval = %v, want 8s", p.Interval())
+	}
+}
+
+// fakeServer отвечает на запросы клиента со смещением offset
+func fakeServer(t *testing.T, offset time.Duration, stratum uint8) string {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pc.Close() })
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			req, err := Unmarshal(buf[:n])
+			if err != nil {
+				continue
+			}
+			rx := time.Now().Add(offset)
+			resp := Packet{Version: 4, Mode: ModeServer, Stratum: stratum, Precision: -20,
+				ReferenceID: RefIDFromString("GPS"), OriginTime: req.TransmitTime,
+				ReceiveTime: NewTimestamp(rx), TransmitTime: NewTimestamp(time.Now().Add(offset))}
+			_, _ = pc.WriteTo(resp.Marshal(), addr)
+		}
+	}()
+	return pc.LocalAddr().String()
+}
+
+func TestClient_QueryLoopback(t *testing.T) {
+	offset := 250 * time.Millisecond
+	c := NewClient(fakeServer(t, offset, 1), time.Second)
+	s, err := c.Query()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := s.Offset - offset; d > 5*time.Millisecond || d < -5*time.Millisecond {
+		t.Errorf("offset %v want ~%v", s.Offset, offset)
+	}
+	if s.Delay < 0 || s.Delay > 50*time.Millisecond {
+		t.Errorf("delay %v", s.Delay)
+	}
+	if s.Stratum != 1 || RefIDString(s.ReferenceID) != "GPS" {
+		t.Errorf("stratum=%d refid=%q", s.Stratum, RefIDString(s.ReferenceID))
+	}
+	// На Linux T1/T4 — метки ядра (SO_TIMESTAMPING), иначе time.Now()
+	if runtime.GOOS == "linux" && (s.TxTimestampType != timestamping.Kernel || s.RxTimestampType != timestamping.Kernel) {
+		t.Errorf("timestamps: tx=%s rx=%s, want kernel", s.TxTimestampType, s.RxTimestampType)
+	}
+
+	bad := NewClient(fakeServer(t, 0, 16), time.Second)
+	if _, err := bad.Query(); err != ErrUnsynchronized {
+		t.Errorf("stratum 16 server: got %v", err)
+	}
+}
+
+func candidate(offset, jitter time.Duration, stratum uint8) Candidate {
+	return Candidate{OK: true, Sample: Sample{
+		Offset: offset, Delay: 2 * time.Millisecond, Dispersion: time.Millisecond,
+		Jitter: jitter, Stratum: stratum, Time: time.Now(),
+	}}
+}
+
+func TestSelect_Falseticker(t *testing.T) {
+	ms := time.Millisecond
+	cands := []Candidate{
+		candidate(500*ms, ms, 1), // первый в списке, но врёт на 500 мс
+		candidate(1*ms, ms, 2),
+		candidate(2*ms, ms, 2),
+		candidate(0, ms, 2),
+		{OK: false},
+	}
+	sel, err := Select(cands)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sel.States[0] != PeerFalseticker {
+		t.Errorf("server 0 state = %v, want falseticker", sel.States[0])
+	}
+	if sel.States[4] != PeerUnreachable {
+		t.Errorf("server 4 state = %v, want unreachable", sel.States[4])
+	}
+	for i := 1; i <= 3; i++ {
+		if st := sel.States[i]; st != PeerSurvivor && st != PeerSystemPeer {
+			t.Errorf("server %d state = %v, want survivor", i, st)
+		}
+	}
+	if sel.Offset < 0 || sel.Offset > 2*ms {
+		t.Errorf("combined offset %v, want within [0, 2ms]", sel.Offset)
+	}
+	if sel.SystemPeer < 1 || sel.SystemPeer > 3 {
+		t.Errorf("system peer %d", sel.SystemPeer)
+	}
+}
+
+func TestSelect_NoMajority(t *testing.T) {
+	ms := time.Millisecond
+	_, err := Select([]Candidate{candidate(0, ms, 1), candidate(time.Second, ms, 1)})
+	if err != ErrNoMajority {
+		t.Errorf("two disjoint servers: got %v, want ErrNoMajority", err)
+	}
+	if _, err := Select(nil); err != ErrNoMajority {
+		t.Errorf("no servers: got %v", err)
+	}
+}
+
+func TestSelect_ClusterPrunesOutlier(t *testing.T) {
+	// Все интервалы пересекаются (большой root distance), но один offset далеко от остальных
+	wide := func(off time.Duration) Candidate {
+		c := candidate(off, 100*time.Microsecond, 2)
+		c.Sample.RootDispersion = 100 * time.Millisecond
+		return c
+	}
+	ms := time.Millisecond
+	sel, err := Select([]Candidate{wide(0), wide(ms / 10), wide(-ms / 10), wide(ms / 5), wide(40 * ms)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sel.States[4] != PeerTruechimer {
+		t.Errorf("outlier state = %v, want truechimer pruned by cluster", sel.States[4])
+	}
+	if sel.Offset > ms {
+		t.Errorf("combined offset %v should exclude outlier", sel.Offset)
+	}
+}
+
+func TestServer_ClockQuality(t *testing.T) {
+	srv := NewServer("127.0.0.1:0")
+	if err := srv.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	go srv.Serve()
+	c := NewClient(srv.LocalAddr().String(), time.Second)
+
+	// До первой синхронизации — leap=3, stratum 16
+	if _, err := c.Query(); err != ErrUnsynchronized {
+		t.Fatalf("before sync: got %v, want ErrUnsynchronized", err)
+	}
+
+	ref := time.Now().Add(-10 * time.Second)
+	srv.UpdateClockQuality(ClockQuality{
+		Leap: LeapInsert, Stratum: 1, ReferenceID: RefIDFromString("GPS"), ReferenceTime: ref,
+		RootDelay: 2 * time.Millisecond, RootDispersion: time.Millisecond, Precision: -20,
+	})
+	s, err := c.Query()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Stratum != 1 || RefIDString(s.ReferenceID) != "GPS" || s.Leap != LeapInsert {
+		t.Errorf("stratum=%d refid=%q leap=%d", s.Stratum, RefIDString(s.ReferenceID), s.Leap)
+	}
+	if d := s.RootDelay - 2*time.Millisecond; d > 100*time.Microsecond || d < -100*time.Microsecond {
+		t.Errorf("root delay %v", s.RootDelay)
+	}
+	// Root dispersion растёт на PHI·10 с = 150 мкс
+	if s.RootDispersion < time.Millisecond+140*time.Microsecond || s.RootDispersion > 2*time.Millisecond {
+		t.Errorf("root dispersion %v", s.RootDispersion)
+	}
+	if d := s.Offset; d > 5*time.Millisecond || d < -5*time.Millisecond {
+		t.Errorf("offset %v", s.Offset)
+	}
+
+	srv.UpdateClockQuality(Unsynchronized())
+	if _, err := c.Query(); err != ErrUnsynchronized {
+		t.Errorf("holdover: got %v, want ErrUnsynchronized", err)
+	}
+}
+
+func TestInterleaved(t *testing.T) {
+	srv := NewServer("127.0.0.1:0")
+	srv.Interleaved = true
+	if err := srv.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	go srv.Serve()
+	srv.UpdateClockQuality(ClockQuality{Stratum: 1, ReferenceID: RefIDFromString("GPS"), ReferenceTime: time.Now(), Precision: -20})
+
+	c := NewClient(srv.LocalAddr().String(), time.Second)
+	c.Interleaved = true
+	for i := 0; i < 3; i++ {
+		s, err := c.Query()
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Первый обмен — basic (нет предыдущего), далее — interleaved
+		if s.Interleaved != (i > 0) {
+			t.Errorf("query %d: interleaved=%v", i, s.Interleaved)
+		}
+		if d := s.Offset; d > 5*time.Millisecond || d < -5*time.Millisecond {
+			t.Errorf("query %d: offset %v", i, s.Offset)
+		}
+		if s.Delay < 0 || s.Delay > 50*time.Millisecond {
+			t.Errorf("query %d: delay %v", i, s.Delay)
+		}
+	}
+
+	// Сервер без interleaved (basic) — клиент получает basic измерения и прекращает попытки
+	basic := NewClient(fakeServer(t, 0, 1), time.Second)
+	basic.Interleaved = true
+	for i := 0; i <= interleavedMaxBasic; i++ {
+		s, err := basic.Query()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s.Interleaved {
+			t.Fatalf("query %d: interleaved sample from basic server", i)
+		}
+	}
+	if basic.InterleavedActive() {
+		t.Error("client still in interleaved mode after basic-only responses")
+	}
+}
@@ -0,0 +1,157 @@
+package ntp
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"errors"
+	"sync"
+)
+
+// ntsUIDLen / ntsNonceLen — длины Unique Identifier и nonce AEAD в запросе
+const (
+	ntsUIDLen   = 32
+	ntsNonceLen = 16
+)
+
+// ErrNTSNak — сервер ответил KoD NTSN (cookie не принят) — нужен новый NTS-KE
+var ErrNTSNak = errors.New("ntp: NTS negative acknowledgment (NTSN)")
+
+// NTSSession — состояние NTS ассоциации (RFC 8915): ключи C2S/S2C из NTS-KE и запас cookies.
+// NTS-KE выполняется лениво при первом запросе и повторно, когда cookies закончились или сервер
+// ответил NTSN.
+type NTSSession struct {
+	KEHost    string      // сервер NTS-KE (host или host:port, по умолчанию порт 4460)
+	TLSConfig *tls.Config // опционально: RootCAs и т.п.
+
+	mu      sync.Mutex
+	c2s     *SIV
+	s2c     *SIV
+	cookies [][]byte
+	ntpAddr string
+}
+
+// ntsRequest — данные запроса, нужные для проверки ответа
+type ntsRequest struct {
+	uid []byte
+	s2c *SIV
+}
+
+// NewNTSSession создаёт NTS сессию для сервера NTS-KE
+func NewNTSSession(keHost string, tlsConf *tls.Config) *NTSSession {
+	return &NTSSession{KEHost: keHost, TLSConfig: tlsConf}
+}
+
+// ServerAddress возвращает адрес NTP сервера (host:port), выполняя NTS-KE при необходимости
+func (s *NTSSession) ServerAddress() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.ensureLocked(); err != nil {
+		return "", err
+	}
+	return s.ntpAddr, nil
+}
+
+// Cookies возвращает число оставшихся cookies
+func (s *NTSSession) Cookies() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.cookies)
+}
+
+// Reset сбрасывает ключи и cookies — следующий запрос выполнит NTS-KE заново
+func (s *NTSSession) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.c2s, s.s2c, s.cookies = nil, nil, nil
+}
+
+func (s *NTSSession) ensureLocked() error {
+	if len(s.cookies) > 0 && s.c2s != nil {
+		return nil
+	}
+	c2s, s2c, cookies, addr, err := keyExchange(s.KEHost, s.TLSConfig)
+	if err != nil {
+		return err
+	}
+	if s.c2s, err = NewSIV(c2s); err != nil {
+		return err
+	}
+	if s.s2c, err = NewSIV(s2c); err != nil {
+		return err
+	}
+	s.cookies = cookies
+	s.ntpAddr = addr
+	return nil
+}
+
+// appendRequest добавляет к заголовку запроса NTS extension fields: Unique Identifier,
+// один cookie, placeholders (чтобы восполнить запас до 8) и Authenticator.
+func (s *NTSSession) appendRequest(pkt []byte) ([]byte, *ntsRequest, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.ensureLocked(); err != nil {
+		return nil, nil, err
+	}
+	uid := make([]byte, ntsUIDLen)
+	nonce := make([]byte, ntsNonceLen)
+	if _, err := rand.Read(uid); err != nil {
+		return nil, nil, err
+	}
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, nil, err
+	}
+	cookie := s.cookies[0]
+	s.cookies = s.cookies[1:]
+
+	pkt = AppendExtension(pkt, ExtUniqueIdentifier, uid)
+	pkt = AppendExtension(pkt, ExtNTSCookie, cookie)
+	for i := len(s.cookies) + 1; i < ntsMaxCookies; i++ {
+		pkt = AppendExtension(pkt, ExtCookiePlaceholder, make([]byte, len(cookie)))
+	}
+	pkt = AppendAuthenticator(pkt, s.c2s, nonce, nil)
+	return pkt, &ntsRequest{uid: uid, s2c: s.s2c}, nil
+}
+
+// processResponse проверяет NTS поля ответа: Unique Identifier должен совпадать с запросом,
+// Authenticator — пройти проверку AEAD ключом S2C. Новые cookies из зашифрованных полей
+// добавляются в запас. Ответ без валидного Authenticator отклоняется (ErrAuthFailed);
+// KoD NTSN с верным Unique Identifier сбрасывает сессию (ErrNTSNak).
+func (s *NTSSession) processResponse(pkt []byte, hdr *Packet, req *ntsRequest) error {
+	fields, _ := ParseExtensions(pkt)
+	var uidOK bool
+	var auth *ExtensionField
+	for i := range fields {
+		f := fields[i]
+		switch f.Type {
+		case ExtUniqueIdentifier:
+			uidOK = len(f.Value) >= ntsUIDLen && bytes.Equal(f.Value[:ntsUIDLen], req.uid)
+		case ExtNTSAuthenticator:
+			auth = &fields[i]
+		}
+	}
+	if !uidOK {
+		return ErrBogus
+	}
+	// NTSN не аутентифицирован (сервер не смог расшифровать cookie) — только сбрасываем сессию
+	if hdr.KissCode() == "NTSN" {
+		s.Reset()
+		return ErrNTSNak
+	}
+	if auth == nil {
+		return ErrAuthFailed
+	}
+	plain, err := OpenAuthenticator(pkt, *auth, req.s2c)
+	if err != nil {
+		return ErrAuthFailed
+	}
+	enc, _ := ParseExtensions(append(make([]byte, HeaderSize), plain...))
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, f := range enc {
+		if f.Type == ExtNTSCookie && len(s.cookies) < ntsMaxCookies {
+			s.cookies = append(s.cookies, append([]byte(nil), f.Value...))
+		}
+	}
+	return nil
+}
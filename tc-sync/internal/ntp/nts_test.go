@@ -0,0 +1,249 @@
+package ntp
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"math/big"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func unhex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestCMAC_RFC4493(t *testing.T) {
+	key := unhex(t, "2b7e151628aed2a6abf7158809cf4f3c")
+	cases := []struct{ msg, want string }{
+		{"", "bb1d6929e95937287fa37d129b756746"},
+		{"6bc1bee22e409f96e93d7e117393172a", "070a16b46b4d4144f79bdd9dd04a287c"},
+		{"6bc1bee22e409f96e93d7e117393172aae2d8a571e03ac9c9eb76fac45af8e5130c81c46a35ce411",
+			"dfa66747de9ae63030ca32611497c827"},
+	}
+	for _, c := range cases {
+		got, err := CMAC(key, unhex(t, c.msg))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hex.EncodeToString(got) != c.want {
+			t.Errorf("CMAC(%q) = %x, want %s", c.msg, got, c.want)
+		}
+	}
+}
+
+func TestSIV_RFC5297(t *testing.T) {
+	// RFC 5297, A.1 (deterministic authenticated encryption)
+	key := unhex(t, "fffefdfcfbfaf9f8f7f6f5f4f3f2f1f0f0f1f2f3f4f5f6f7f8f9fafbfcfdfeff")
+	ad := unhex(t, "101112131415161718191a1b1c1d1e1f2021222324252627")
+	pt := unhex(t, "112233445566778899aabbccddee")
+	want := "85632d07c6e8f37f950acd320a2ecc9340c02b9690c4dc04daef7f6afe5c"
+	s, err := NewSIV(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ct := s.Seal(pt, ad)
+	if hex.EncodeToString(ct) != want {
+		t.Fatalf("Seal = %x, want %s", ct, want)
+	}
+	got, err := s.Open(ct, ad)
+	if err != nil || !bytes.Equal(got, pt) {
+		t.Fatalf("Open = %x, %v", got, err)
+	}
+	ct[len(ct)-1] ^= 1
+	if _, err := s.Open(ct, ad); err != ErrAuthFailed {
+		t.Errorf("tampered ciphertext: got %v", err)
+	}
+}
+
+// ntsTestServer — NTS-KE (TLS) и NTS NTP (UDP) серверы в одном процессе
+type ntsTestServer struct {
+	keAddr string
+
+	mu       sync.Mutex
+	c2s, s2c *SIV
+	tamper   bool // портить ответ после подписи
+}
+
+func newNTSTestServer(t *testing.T) *ntsTestServer {
+	t.Helper()
+	srv := &ntsTestServer{}
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pc.Close() })
+	go srv.serveNTP(pc)
+
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{selfSignedCert(t)},
+		NextProtos:   []string{NTSKEALPN},
+		MinVersion:   tls.VersionTLS13,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	ntpPort := pc.LocalAddr().(*net.UDPAddr).Port
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go srv.serveKE(conn.(*tls.Conn), ntpPort)
+		}
+	}()
+	srv.keAddr = ln.Addr().String()
+	return srv
+}
+
+func (srv *ntsTestServer) serveKE(conn *tls.Conn, ntpPort int) {
+	defer conn.Close()
+	if _, err := ReadKERecords(conn); err != nil {
+		return
+	}
+	c2s, s2c, err := NTSKeys(conn.ConnectionState())
+	if err != nil {
+		return
+	}
+	srv.mu.Lock()
+	srv.c2s, _ = NewSIV(c2s)
+	srv.s2c, _ = NewSIV(s2c)
+	srv.mu.Unlock()
+
+	var out []byte
+	out = AppendKERecord(out, KERecord{Critical: true, Type: keRecordNextProto, Body: []byte{0, 0}})
+	out = AppendKERecord(out, KERecord{Type: keRecordAEAD, Body: []byte{0, byte(AEADAESSIVCMAC256)}})
+	for i := 0; i < ntsMaxCookies; i++ {
+		out = AppendKERecord(out, KERecord{Type: keRecordNewCookie, Body: bytes.Repeat([]byte{byte(i)}, 64)})
+	}
+	out = AppendKERecord(out, KERecord{Type: keRecordServer, Body: []byte("127.0.0.1")})
+	out = AppendKERecord(out, KERecord{Type: keRecordPort, Body: binary.BigEndian.AppendUint16(nil, uint16(ntpPort))})
+	out = AppendKERecord(out, KERecord{Critical: true, Type: keRecordEnd})
+	_, _ = conn.Write(out)
+}
+
+func (srv *ntsTestServer) serveNTP(pc net.PacketConn) {
+	buf := make([]byte, 2048)
+	for {
+		n, addr, err := pc.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		pkt := buf[:n]
+		req, err := Unmarshal(pkt)
+		if err != nil {
+			continue
+		}
+		srv.mu.Lock()
+		c2s, s2c, tamper := srv.c2s, srv.s2c, srv.tamper
+		srv.mu.Unlock()
+
+		var uid []byte
+		var authOK, cookie bool
+		fields, _ := ParseExtensions(pkt)
+		for _, f := range fields {
+			switch f.Type {
+			case ExtUniqueIdentifier:
+				uid = f.Value
+			case ExtNTSCookie:
+				cookie = true
+			case ExtNTSAuthenticator:
+				_, err := OpenAuthenticator(pkt, f, c2s)
+				authOK = err == nil
+			}
+		}
+		if uid == nil || !cookie || !authOK {
+			continue
+		}
+
+		now := time.Now()
+		resp := Packet{Version: 4, Mode: ModeServer, Stratum: 1, Precision: -20,
+			ReferenceID: RefIDFromString("GPS"), OriginTime: req.TransmitTime,
+			ReceiveTime: NewTimestamp(now), TransmitTime: NewTimestamp(now)}
+		out := AppendExtension(resp.Marshal(), ExtUniqueIdentifier, uid)
+		plain := AppendExtension(nil, ExtNTSCookie, bytes.Repeat([]byte{0xee}, 64))
+		nonce := make([]byte, ntsNonceLen)
+		_, _ = rand.Read(nonce)
+		out = AppendAuthenticator(out, s2c, nonce, plain)
+		if tamper {
+			out[HeaderSize-1] ^= 1 // младший байт transmit timestamp
+		}
+		_, _ = pc.WriteTo(out, addr)
+	}
+}
+
+func selfSignedCert(t *testing.T) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestClient_NTS(t *testing.T) {
+	srv := newNTSTestServer(t)
+	c := NewClient("127.0.0.1", time.Second)
+	c.NTS = NewNTSSession(srv.keAddr, &tls.Config{InsecureSkipVerify: true})
+
+	s, err := c.Query()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := s.Offset; d > 5*time.Millisecond || d < -5*time.Millisecond {
+		t.Errorf("offset %v want ~0", s.Offset)
+	}
+	// Использованный cookie восполнен новым из зашифрованного поля
+	if n := c.NTS.Cookies(); n != ntsMaxCookies {
+		t.Errorf("cookies after exchange = %d, want %d", n, ntsMaxCookies)
+	}
+	if host, port, _ := net.SplitHostPort(mustAddr(t, c.NTS)); host != "127.0.0.1" || port == strconv.Itoa(Port) {
+		t.Errorf("NTP server from NTS-KE = %s:%s", host, port)
+	}
+
+	// Изменённый в пути ответ не проходит проверку AEAD и не даёт измерения
+	srv.mu.Lock()
+	srv.tamper = true
+	srv.mu.Unlock()
+	if _, err := c.Query(); !errors.Is(err, ErrAuthFailed) {
+		t.Errorf("tampered response: got %v, want ErrAuthFailed", err)
+	}
+}
+
+func mustAddr(t *testing.T, s *NTSSession) string {
+	t.Helper()
+	a, err := s.ServerAddress()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
@@ -0,0 +1,169 @@
+package ntp
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+// NTS Key Establishment (RFC 8915, раздел 4): TLS 1.3, ALPN "ntske/1", порт 4460.
+const (
+	NTSKEPort = 4460
+	NTSKEALPN = "ntske/1"
+
+	// Типы записей NTS-KE
+	keRecordEnd         uint16 = 0
+	keRecordNextProto   uint16 = 1
+	keRecordError       uint16 = 2
+	keRecordWarning     uint16 = 3
+	keRecordAEAD        uint16 = 4
+	keRecordNewCookie   uint16 = 5
+	keRecordServer      uint16 = 6
+	keRecordPort        uint16 = 7
+	keRecordCritical    uint16 = 0x8000
+	keProtocolNTPv4     uint16 = 0
+	AEADAESSIVCMAC256   uint16 = 15 // единственный обязательный алгоритм RFC 8915
+	ntsExporterLabel           = "EXPORTER-network-time-security"
+	ntsKeyLen                  = 32
+	ntsMaxCookies              = 8
+	ntsKETimeout               = 10 * time.Second
+	ntsMaxRecordBodyLen        = 4096
+)
+
+// ErrNTSKE — ошибка согласования NTS-KE (ошибка сервера, нет общего протокола или AEAD)
+var ErrNTSKE = errors.New("ntp: NTS-KE negotiation failed")
+
+// KERecord — запись протокола NTS-KE
+type KERecord struct {
+	Critical bool
+	Type     uint16
+	Body     []byte
+}
+
+// AppendKERecord кодирует запись NTS-KE
+func AppendKERecord(b []byte, r KERecord) []byte {
+	t := r.Type
+	if r.Critical {
+		t |= keRecordCritical
+	}
+	b = binary.BigEndian.AppendUint16(b, t)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(r.Body)))
+	return append(b, r.Body...)
+}
+
+// ReadKERecords читает записи NTS-KE до End of Message
+func ReadKERecords(r io.Reader) ([]KERecord, error) {
+	var out []KERecord
+	var hdr [4]byte
+	for {
+		if _, err := io.ReadFull(r, hdr[:]); err != nil {
+			return nil, err
+		}
+		t := binary.BigEndian.Uint16(hdr[0:2])
+		n := int(binary.BigEndian.Uint16(hdr[2:4]))
+		if n > ntsMaxRecordBodyLen {
+			return nil, fmt.Errorf("%w: record too long", ErrNTSKE)
+		}
+		body := make([]byte, n)
+		if _, err := io.ReadFull(r, body); err != nil {
+			return nil, err
+		}
+		rec := KERecord{Critical: t&keRecordCritical != 0, Type: t &^ keRecordCritical, Body: body}
+		out = append(out, rec)
+		if rec.Type == keRecordEnd {
+			return out, nil
+		}
+	}
+}
+
+// NTSKeys экспортирует ключи C2S и S2C из TLS сессии (RFC 8915, 5.1)
+func NTSKeys(cs tls.ConnectionState) (c2s, s2c []byte, err error) {
+	ctx := []byte{0, byte(keProtocolNTPv4), byte(AEADAESSIVCMAC256 >> 8), byte(AEADAESSIVCMAC256), 0}
+	if c2s, err = cs.ExportKeyingMaterial(ntsExporterLabel, ctx, ntsKeyLen); err != nil {
+		return nil, nil, err
+	}
+	ctx[4] = 1
+	if s2c, err = cs.ExportKeyingMaterial(ntsExporterLabel, ctx, ntsKeyLen); err != nil {
+		return nil, nil, err
+	}
+	return c2s, s2c, nil
+}
+
+// keyExchange выполняет NTS-KE с сервером host (host или host:port, по умолчанию 4460).
+// Возвращает ключи, начальные cookies и адрес NTP сервера (host:port) из ответа.
+func keyExchange(host string, tlsConf *tls.Config) (c2s, s2c []byte, cookies [][]byte, ntpAddr string, err error) {
+	keHost, kePort, splitErr := net.SplitHostPort(host)
+	if splitErr != nil {
+		keHost, kePort = host, strconv.Itoa(NTSKEPort)
+	}
+	conf := &tls.Config{}
+	if tlsConf != nil {
+		conf = tlsConf.Clone()
+	}
+	conf.MinVersion = tls.VersionTLS13
+	conf.NextProtos = []string{NTSKEALPN}
+	if conf.ServerName == "" {
+		conf.ServerName = keHost
+	}
+	dialer := &net.Dialer{Timeout: ntsKETimeout}
+	conn, err := tls.DialWithDialer(dialer, "tcp", net.JoinHostPort(keHost, kePort), conf)
+	if err != nil {
+		return nil, nil, nil, "", err
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(ntsKETimeout))
+	if conn.ConnectionState().NegotiatedProtocol != NTSKEALPN {
+		return nil, nil, nil, "", fmt.Errorf("%w: ALPN %s not negotiated", ErrNTSKE, NTSKEALPN)
+	}
+
+	var req []byte
+	req = AppendKERecord(req, KERecord{Critical: true, Type: keRecordNextProto, Body: []byte{0, byte(keProtocolNTPv4)}})
+	req = AppendKERecord(req, KERecord{Type: keRecordAEAD, Body: []byte{byte(AEADAESSIVCMAC256 >> 8), byte(AEADAESSIVCMAC256)}})
+	req = AppendKERecord(req, KERecord{Critical: true, Type: keRecordEnd})
+	if _, err := conn.Write(req); err != nil {
+		return nil, nil, nil, "", err
+	}
+	recs, err := ReadKERecords(conn)
+	if err != nil {
+		return nil, nil, nil, "", err
+	}
+
+	server, port := keHost, strconv.Itoa(Port)
+	var protoOK, aeadOK bool
+	for _, r := range recs {
+		switch r.Type {
+		case keRecordNextProto:
+			protoOK = len(r.Body) == 2 && binary.BigEndian.Uint16(r.Body) == keProtocolNTPv4
+		case keRecordAEAD:
+			aeadOK = len(r.Body) == 2 && binary.BigEndian.Uint16(r.Body) == AEADAESSIVCMAC256
+		case keRecordNewCookie:
+			cookies = append(cookies, r.Body)
+		case keRecordServer:
+			server = string(r.Body)
+		case keRecordPort:
+			if len(r.Body) == 2 {
+				port = strconv.Itoa(int(binary.BigEndian.Uint16(r.Body)))
+			}
+		case keRecordError:
+			return nil, nil, nil, "", fmt.Errorf("%w: server error record %x", ErrNTSKE, r.Body)
+		case keRecordEnd, keRecordWarning:
+		default:
+			if r.Critical {
+				return nil, nil, nil, "", fmt.Errorf("%w: unknown critical record %d", ErrNTSKE, r.Type)
+			}
+		}
+	}
+	if !protoOK || !aeadOK || len(cookies) == 0 {
+		return nil, nil, nil, "", fmt.Errorf("%w: protocol=%v aead=%v cookies=%d", ErrNTSKE, protoOK, aeadOK, len(cookies))
+	}
+	c2s, s2c, err = NTSKeys(conn.ConnectionState())
+	if err != nil {
+		return nil, nil, nil, "", err
+	}
+	return c2s, s2c, cookies, net.JoinHostPort(server, port), nil
+}
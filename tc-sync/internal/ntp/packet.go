@@ -0,0 +1,191 @@
+// Package ntp — реализация NTPv4 (RFC 5905): формат пакета, клиент с вычислением offset/delay
+// по четырём меткам времени, фильтр часов (clock filter) и адаптивный интервал опроса.
+package ntp
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"net"
+	"time"
+)
+
+// Константы протокола (RFC 5905)
+const (
+	Port       = 123
+	Version    = 4
+	HeaderSize = 48 // размер заголовка NTP без extension fields и MAC
+	MaxStratum = 16 // stratum 16 — «не синхронизирован»
+)
+
+// Mode — режим ассоциации (поле Mode)
+type Mode uint8
+
+const (
+	ModeReserved         Mode = 0
+	ModeSymmetricActive  Mode = 1
+	ModeSymmetricPassive Mode = 2
+	ModeClient           Mode = 3
+	ModeServer           Mode = 4
+	ModeBroadcast        Mode = 5
+	ModeControl          Mode = 6
+)
+
+// LeapIndicator — индикатор секунды координации (поле LI)
+type LeapIndicator uint8
+
+const (
+	LeapNone      LeapIndicator = 0 // без предупреждения
+	LeapInsert    LeapIndicator = 1 // последняя минута суток длится 61 с
+	LeapDelete    LeapIndicator = 2 // последняя минута суток длится 59 с
+	LeapNotInSync LeapIndicator = 3 // часы сервера не синхронизированы
+)
+
+// ntpEpochOffset — секунд между 1900-01-01 (эпоха NTP) и 1970-01-01 (эпоха Unix)
+const ntpEpochOffset = 2208988800
+
+// Timestamp — 64-битная метка NTP: 32 бита секунд с 1900-01-01 и 32 бита дробной части.
+type Timestamp uint64
+
+// NewTimestamp переводит time.Time в метку NTP.
+func NewTimestamp(t time.Time) Timestamp {
+	sec := uint64(t.Unix() + ntpEpochOffset)
+	frac := (uint64(t.Nanosecond()) << 32) / 1e9
+	return Timestamp(sec<<32 | frac)
+}
+
+// Time переводит метку NTP в time.Time (UTC).
+// Эра определяется по старшему биту секунд: метки с нулевым старшим битом считаются эрой 1 (после 2036 г.).
+func (ts Timestamp) Time() time.Time {
+	sec := int64(ts >> 32)
+	if sec&0x80000000 == 0 {
+		sec += 1 << 32
+	}
+	frac := uint64(ts & 0xffffffff)
+	nsec := (frac*1e9 + 1<<31) >> 32
+	return time.Unix(sec-ntpEpochOffset, int64(nsec)).UTC()
+}
+
+// IsZero возвращает true для нулевой метки (поле не заполнено)
+func (ts Timestamp) IsZero() bool {
+	return ts == 0
+}
+
+// ShortToDuration переводит короткий формат NTP (16.16, root delay / root dispersion) в time.Duration.
+func ShortToDuration(v uint32) time.Duration {
+	return time.Duration((uint64(v)*1e9 + 1<<15) >> 16)
+}
+
+// DurationToShort переводит time.Duration в короткий формат NTP (16.16); отрицательные значения → 0.
+func DurationToShort(d time.Duration) uint32 {
+	if d <= 0 {
+		return 0
+	}
+	v := (uint64(d) << 16) / 1e9
+	if v > 0xffffffff {
+		return 0xffffffff
+	}
+	return uint32(v)
+}
+
+// Packet — заголовок NTP пакета (RFC 5905, рис. 8)
+type Packet struct {
+	Leap           LeapIndicator
+	Version        uint8
+	Mode           Mode
+	Stratum        uint8
+	Poll           int8   // log2 интервала опроса, с
+	Precision      int8   // log2 точности часов, с
+	RootDelay      uint32 // короткий формат NTP
+	RootDispersion uint32 // короткий формат NTP
+	ReferenceID    uint32
+	ReferenceTime  Timestamp
+	OriginTime     Timestamp
+	ReceiveTime    Timestamp
+	TransmitTime   Timestamp
+}
+
+// ErrShortPacket — пакет короче заголовка NTP
+var ErrShortPacket = errors.New("ntp: short packet")
+
+// Marshal кодирует заголовок в 48 байт (big-endian)
+func (p *Packet) Marshal() []byte {
+	b := make([]byte, HeaderSize)
+	p.MarshalTo(b)
+	return b
+}
+
+// MarshalTo записывает заголовок в b (len(b) >= HeaderSize)
+func (p *Packet) MarshalTo(b []byte) {
+	b[0] = byte(p.Leap&0x3)<<6 | (p.Version&0x7)<<3 | byte(p.Mode&0x7)
+	b[1] = p.Stratum
+	b[2] = byte(p.Poll)
+	b[3] = byte(p.Precision)
+	binary.BigEndian.PutUint32(b[4:8], p.RootDelay)
+	binary.BigEndian.PutUint32(b[8:12], p.RootDispersion)
+	binary.BigEndian.PutUint32(b[12:16], p.ReferenceID)
+	binary.BigEndian.PutUint64(b[16:24], uint64(p.ReferenceTime))
+	binary.BigEndian.PutUint64(b[24:32], uint64(p.OriginTime))
+	binary.BigEndian.PutUint64(b[32:40], uint64(p.ReceiveTime))
+	binary.BigEndian.PutUint64(b[40:48], uint64(p.TransmitTime))
+}
+
+// Unmarshal разбирает заголовок NTP; extension fields и MAC после 48 байт не разбираются.
+func Unmarshal(b []byte) (*Packet, error) {
+	if len(b) < HeaderSize {
+		return nil, ErrShortPacket
+	}
+	return &Packet{
+		Leap:           LeapIndicator(b[0] >> 6),
+		Version:        (b[0] >> 3) & 0x7,
+		Mode:           Mode(b[0] & 0x7),
+		Stratum:        b[1],
+		Poll:           int8(b[2]),
+		Precision:      int8(b[3]),
+		RootDelay:      binary.BigEndian.Uint32(b[4:8]),
+		RootDispersion: binary.BigEndian.Uint32(b[8:12]),
+		ReferenceID:    binary.BigEndian.Uint32(b[12:16]),
+		ReferenceTime:  Timestamp(binary.BigEndian.Uint64(b[16:24])),
+		OriginTime:     Timestamp(binary.BigEndian.Uint64(b[24:32])),
+		ReceiveTime:    Timestamp(binary.BigEndian.Uint64(b[32:40])),
+		TransmitTime:   Timestamp(binary.BigEndian.Uint64(b[40:48])),
+	}, nil
+}
+
+// KissCode возвращает код Kiss-o'-Death (RATE, DENY, RSTR...) для пакета со stratum 0, иначе "".
+func (p *Packet) KissCode() string {
+	if p.Stratum != 0 {
+		return ""
+	}
+	return RefIDString(p.ReferenceID)
+}
+
+// RefIDString переводит reference ID (ASCII код, например "GPS", "PPS") в строку без завершающих нулей.
+func RefIDString(id uint32) string {
+	b := []byte{byte(id >> 24), byte(id >> 16), byte(id >> 8), byte(id)}
+	n := len(b)
+	for n > 0 && b[n-1] == 0 {
+		n--
+	}
+	return string(b[:n])
+}
+
+// RefIDFromString кодирует до 4 ASCII символов в reference ID (stratum 0/1: "GPS", "PPS", "PTP").
+func RefIDFromString(s string) uint32 {
+	var b [4]byte
+	copy(b[:], s)
+	return binary.BigEndian.Uint32(b[:])
+}
+
+// RefIDFromIP — reference ID для stratum 2+: IPv4 адрес сервера, для IPv6 — первые 4 байта MD5 адреса
+// (RFC 5905, раздел 7.3).
+func RefIDFromIP(ip net.IP) uint32 {
+	if v4 := ip.To4(); v4 != nil {
+		return binary.BigEndian.Uint32(v4)
+	}
+	if len(ip) != net.IPv6len {
+		return 0
+	}
+	sum := md5.Sum(ip)
+	return binary.BigEndian.Uint32(sum[:4])
+}
@@ -0,0 +1,70 @@
+package ntp
+
+import "time"
+
+// Параметры адаптации интервала опроса (по мотивам RFC 5905, раздел 11.3)
+const (
+	pollGate  = 4 // |offset| < pollGate·jitter — измерение «спокойное»
+	pollLimit = 5 // сколько «очков» нужно набрать для удвоения/уменьшения интервала
+)
+
+// Poller — адаптивный интервал опроса: удваивается при стабильных измерениях
+// (offset в пределах нескольких jitter) и уменьшается вдвое при больших offset.
+// Интервал всегда в пределах [Min, Max].
+type Poller struct {
+	Min, Max time.Duration
+	cur      time.Duration
+	count    int
+}
+
+// NewPoller создаёт Poller; начальный интервал — min. max < min приравнивается к min.
+func NewPoller(min, max time.Duration) *Poller {
+	if min <= 0 {
+		min = 4 * time.Second
+	}
+	if max < min {
+		max = min
+	}
+	return &Poller{Min: min, Max: max, cur: min}
+}
+
+// Interval возвращает текущий интервал опроса
+func (p *Poller) Interval() time.Duration {
+	return p.cur
+}
+
+// Update учитывает успешное измерение (offset и jitter фильтра)
+func (p *Poller) Update(offset, jitter time.Duration) {
+	if offset < 0 {
+		offset = -offset
+	}
+	if offset < pollGate*jitter {
+		p.count++
+		if p.count >= pollLimit {
+			p.count = 0
+			p.set(p.cur * 2)
+		}
+		return
+	}
+	p.count -= 2
+	if p.count <= -pollLimit {
+		p.count = 0
+		p.set(p.cur / 2)
+	}
+}
+
+// Backoff увеличивает интервал (нет ответа или KoD RATE)
+func (p *Poller) Backoff() {
+	p.count = 0
+	p.set(p.cur * 2)
+}
+
+func (p *Poller) set(d time.Duration) {
+	if d < p.Min {
+		d = p.Min
+	}
+	if d > p.Max {
+		d = p.Max
+	}
+	p.cur = d
+}
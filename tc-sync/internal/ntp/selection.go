@@ -0,0 +1,203 @@
+package ntp
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"time"
+)
+
+// minClusterSurvivors — минимальное число выживших в алгоритме кластеризации (RFC 5905: NMIN = 3)
+const minClusterSurvivors = 3
+
+// ErrNoMajority — интервалы корректности серверов не имеют пересечения большинства (нет truechimers)
+var ErrNoMajority = errors.New("ntp: no majority of truechimers")
+
+// PeerState — результат отбора для одного сервера
+type PeerState int
+
+const (
+	PeerUnreachable PeerState = iota // нет пригодного измерения
+	PeerFalseticker                  // отброшен алгоритмом пересечения (Marzullo)
+	PeerTruechimer                   // прошёл пересечение, но отброшен кластеризацией
+	PeerSurvivor                     // участвует в комбинировании offset
+	PeerSystemPeer                   // лучший из выживших (system peer)
+)
+
+func (s PeerState) String() string {
+	switch s {
+	case PeerUnreachable:
+		return "unreachable"
+	case PeerFalseticker:
+		return "falseticker"
+	case PeerTruechimer:
+		return "truechimer"
+	case PeerSurvivor:
+		return "survivor"
+	case PeerSystemPeer:
+		return "system_peer"
+	default:
+		return "unknown"
+	}
+}
+
+// Candidate — сервер-кандидат для отбора: отфильтрованное измерение; OK=false — измерения нет.
+type Candidate struct {
+	Sample Sample
+	OK     bool
+}
+
+// Selection — результат отбора и комбинирования (RFC 5905, разделы 11.2.1–11.2.3)
+type Selection struct {
+	Offset     time.Duration // комбинированный offset (веса 1/root distance)
+	Jitter     time.Duration // jitter системы (комбинированный)
+	SystemPeer int           // индекс system peer во входном списке
+	States     []PeerState   // состояние каждого кандидата (по индексу входа)
+	Low, High  time.Duration // границы интервала пересечения
+}
+
+// Select выполняет отбор серверов: алгоритм пересечения (Marzullo) отделяет truechimers от
+// falsetickers, кластеризация отбрасывает выбросы по selection jitter, затем offset
+// выживших комбинируется с весами, обратными root distance.
+func Select(cands []Candidate) (Selection, error) {
+	sel := Selection{SystemPeer: -1, States: make([]PeerState, len(cands))}
+
+	type edge struct {
+		val time.Duration
+		typ int // -1 нижняя граница, 0 середина, +1 верхняя
+	}
+	var edges []edge
+	var valid []int
+	for i, c := range cands {
+		if !c.OK {
+			continue
+		}
+		valid = append(valid, i)
+		rd := c.Sample.RootDistance()
+		edges = append(edges,
+			edge{c.Sample.Offset - rd, -1},
+			edge{c.Sample.Offset, 0},
+			edge{c.Sample.Offset + rd, +1})
+	}
+	n := len(valid)
+	if n == 0 {
+		return sel, ErrNoMajority
+	}
+	sort.Slice(edges, func(i, j int) bool {
+		if edges[i].val != edges[j].val {
+			return edges[i].val < edges[j].val
+		}
+		return edges[i].typ < edges[j].typ
+	})
+
+	// Пересечение: ищем наименьшее число falsetickers (allow), при котором
+	// у n-allow интервалов есть общая точка, а середины «чужих» интервалов не больше allow.
+	var low, high time.Duration
+	found := false
+	for allow := 0; 2*allow < n; allow++ {
+		mids := 0
+		chime := 0
+		for _, e := range edges {
+			chime -= e.typ
+			if chime >= n-allow {
+				low = e.val
+				break
+			}
+			if e.typ == 0 {
+				mids++
+			}
+		}
+		chime = 0
+		for i := len(edges) - 1; i >= 0; i-- {
+			chime += edges[i].typ
+			if chime >= n-allow {
+				high = edges[i].val
+				break
+			}
+			if edges[i].typ == 0 {
+				mids++
+			}
+		}
+		if mids > allow {
+			continue
+		}
+		if high > low {
+			found = true
+			break
+		}
+	}
+	for _, i := range valid {
+		sel.States[i] = PeerFalseticker
+	}
+	if !found {
+		return sel, ErrNoMajority
+	}
+	sel.Low, sel.High = low, high
+
+	var survivors []int
+	for _, i := range valid {
+		off := cands[i].Sample.Offset
+		if off >= low && off <= high {
+			survivors = append(survivors, i)
+			sel.States[i] = PeerTruechimer
+		}
+	}
+	if len(survivors) == 0 {
+		return sel, ErrNoMajority
+	}
+
+	// Порядок по «заслугам»: stratum·MaxDistance + root distance
+	merit := func(i int) time.Duration {
+		s := cands[i].Sample
+		return time.Duration(s.Stratum)*MaxDistance + s.RootDistance()
+	}
+	sort.SliceStable(survivors, func(a, b int) bool { return merit(survivors[a]) < merit(survivors[b]) })
+
+	// Кластеризация: удаляем выживший с максимальным selection jitter, пока он больше
+	// минимального peer jitter и выживших больше NMIN.
+	for len(survivors) > minClusterSurvivors {
+		maxSel, maxIdx := -1.0, 0
+		minPeer := math.Inf(1)
+		for a, i := range survivors {
+			var sum float64
+			for _, j := range survivors {
+				d := float64(cands[j].Sample.Offset - cands[i].Sample.Offset)
+				sum += d * d
+			}
+			selJitter := math.Sqrt(sum / float64(len(survivors)-1))
+			if selJitter > maxSel {
+				maxSel, maxIdx = selJitter, a
+			}
+			if pj := float64(cands[i].Sample.Jitter); pj < minPeer {
+				minPeer = pj
+			}
+		}
+		if maxSel <= minPeer {
+			break
+		}
+		survivors = append(survivors[:maxIdx], survivors[maxIdx+1:]...)
+	}
+
+	// Комбинирование: веса 1/λ
+	sys := survivors[0]
+	var wsum, osum, jsum float64
+	for _, i := range survivors {
+		s := cands[i].Sample
+		rd := float64(s.RootDistance())
+		if rd <= 0 {
+			rd = 1
+		}
+		w := 1 / rd
+		wsum += w
+		osum += w * float64(s.Offset)
+		d := float64(s.Offset - cands[sys].Sample.Offset)
+		jsum += w * d * d
+		sel.States[i] = PeerSurvivor
+	}
+	sel.States[sys] = PeerSystemPeer
+	sel.SystemPeer = sys
+	sel.Offset = time.Duration(osum / wsum)
+	sysJitter := float64(cands[sys].Sample.Jitter)
+	sel.Jitter = time.Duration(math.Sqrt(jsum/wsum + sysJitter*sysJitter))
+	return sel, nil
+}
@@ -0,0 +1,320 @@
+package ntp
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/shiwa/timecard-mini/tc-sync/internal/timestamping"
+)
+
+// ClockQuality — состояние локальных часов, которое сервер сообщает клиентам (RFC 5905, system variables).
+type ClockQuality struct {
+	Leap           LeapIndicator
+	Stratum        uint8
+	ReferenceID    uint32
+	ReferenceTime  time.Time     // время последней коррекции часов по источнику
+	RootDelay      time.Duration // задержка до первичного эталона
+	RootDispersion time.Duration // дисперсия на момент ReferenceTime; растёт со скоростью PHI
+	Precision      int8
+}
+
+// Unsynchronized — состояние несинхронизированных часов: leap=3, stratum 16.
+// Клиенты (RFC 5905) такой сервер не используют.
+func Unsynchronized() ClockQuality {
+	return ClockQuality{Leap: LeapNotInSync, Stratum: MaxStratum, Precision: localPrecision}
+}
+
+// Server — NTP сервер (режим server, RFC 5905), отдающий время локальных часов.
+// Stratum, refid, leap и root delay/dispersion задаются через UpdateClockQuality;
+// до первого обновления сервер отвечает как несинхронизированный.
+// Receive timestamp берётся из метки ядра (SO_TIMESTAMPING), если она доступна.
+// Доступ ограничивается списками Access и частотой RateLimit (KoD RATE); запросы с MAC
+// проверяются ключами Keys, ответ подписывается тем же ключом. Счётчики — Stats.
+type Server struct {
+	Addr string // адрес прослушивания, например ":123"
+	// Interleaved — поддержка interleaved режима: для каждого клиента запоминаются receive timestamp
+	// последнего запроса и точная метка передачи ответа (error queue), которая отдаётся в следующем ответе.
+	// Задаётся до Listen.
+	Interleaved bool
+	// Keys — симметричные ключи (MD5/SHA1/AES-CMAC); запрос с неизвестным key ID или неверным MAC отбрасывается
+	Keys Keys
+	// RequireAuth — отвечать только на запросы с верным MAC
+	RequireAuth bool
+	// Access — списки allow/deny; nil — отвечать всем
+	Access *AccessList
+	// RateLimit — ограничение частоты запросов одного клиента; нулевое — без ограничения. Задаётся до Serve
+	RateLimit RateLimit
+
+	quality atomic.Pointer[ClockQuality]
+	stats   serverCounters
+	limiter rateLimiter
+
+	mu      sync.Mutex
+	conn    *timestamping.Conn
+	clients map[string]clientTimestamps // по IP клиента (только в Serve)
+}
+
+// ServerStats — счётчики запросов сервера
+type ServerStats struct {
+	Received        uint64 // принято пакетов
+	Sent            uint64 // отправлено ответов (включая KoD)
+	Denied          uint64 // отброшено по спискам доступа
+	RateLimited     uint64 // превышение RateLimit
+	KoD             uint64 // отправлено KoD RATE
+	Unauthenticated uint64 // запросы без MAC
+	AuthFailed      uint64 // неизвестный key ID или неверный MAC
+	Malformed       uint64 // не NTP пакет или не режим client
+}
+
+// serverCounters — счётчики ServerStats, обновляемые из Serve
+type serverCounters struct {
+	received, sent, denied, rateLimited, kod, unauthenticated, authFailed, malformed atomic.Uint64
+}
+
+// Stats возвращает счётчики запросов
+func (s *Server) Stats() ServerStats {
+	c := &s.stats
+	return ServerStats{
+		Received:        c.received.Load(),
+		Sent:            c.sent.Load(),
+		Denied:          c.denied.Load(),
+		RateLimited:     c.rateLimited.Load(),
+		KoD:             c.kod.Load(),
+		Unauthenticated: c.unauthenticated.Load(),
+		AuthFailed:      c.authFailed.Load(),
+		Malformed:       c.malformed.Load(),
+	}
+}
+
+// clientTimestamps — метки последнего обмена с клиентом для interleaved режима
+type clientTimestamps struct {
+	rx Timestamp // receive timestamp запроса, как он записан в ответ
+	tx Timestamp // точная метка передачи ответа
+}
+
+// maxInterleavedClients — размер таблицы клиентов interleaved режима
+const maxInterleavedClients = 4096
+
+// NewServer создаёт сервер; addr пустой — ":123".
+func NewServer(addr string) *Server {
+	if addr == "" {
+		addr = ":123"
+	}
+	s := &Server{Addr: addr}
+	q := Unsynchronized()
+	s.quality.Store(&q)
+	return s
+}
+
+// UpdateClockQuality задаёт состояние часов для следующих ответов
+func (s *Server) UpdateClockQuality(q ClockQuality) {
+	s.quality.Store(&q)
+}
+
+// ClockQuality возвращает текущее состояние часов сервера
+func (s *Server) ClockQuality() ClockQuality {
+	return *s.quality.Load()
+}
+
+// Listen открывает UDP сокет
+func (s *Server) Listen() error {
+	laddr, err := net.ResolveUDPAddr("udp", s.Addr)
+	if err != nil {
+		return err
+	}
+	udp, err := net.ListenUDP("udp", laddr)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.conn = timestamping.New(udp, timestamping.Options{TX: s.Interleaved})
+	s.mu.Unlock()
+	return nil
+}
+
+// LocalAddr возвращает фактический адрес сокета (после Listen)
+func (s *Server) LocalAddr() net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		return nil
+	}
+	return s.conn.LocalAddr()
+}
+
+// Serve обрабатывает запросы до Close. Если Listen не вызывался, открывает сокет сам.
+func (s *Server) Serve() error {
+	s.mu.Lock()
+	pc := s.conn
+	s.mu.Unlock()
+	if pc == nil {
+		if err := s.Listen(); err != nil {
+			return err
+		}
+		s.mu.Lock()
+		pc = s.conn
+		s.mu.Unlock()
+	}
+	s.limiter = rateLimiter{limit: s.RateLimit}
+	buf := make([]byte, 2048)
+	for {
+		n, addr, rx, err := pc.ReadMsg(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		s.stats.received.Add(1)
+		if !s.Access.Permit(addr.IP) {
+			s.stats.denied.Add(1)
+			continue
+		}
+		req, err := Unmarshal(buf[:n])
+		if err != nil || req.Mode != ModeClient {
+			s.stats.malformed.Add(1)
+			continue
+		}
+		key, ok := s.authenticate(buf[:n])
+		if !ok {
+			continue
+		}
+		client := addr.IP.String()
+		if allowed, kod := s.limiter.allow(client, rx.Time); !allowed {
+			s.stats.rateLimited.Add(1)
+			if kod {
+				if resp := s.kiss(req, "RATE"); resp != nil {
+					if key != nil {
+						resp = key.AppendMAC(resp)
+					}
+					if _, err := pc.WriteMsg(resp, addr); err == nil {
+						s.stats.sent.Add(1)
+						s.stats.kod.Add(1)
+					}
+				}
+			}
+			continue
+		}
+		var prev *clientTimestamps
+		if s.Interleaved {
+			if c, ok := s.clients[client]; ok && !req.OriginTime.IsZero() && req.OriginTime == c.rx {
+				prev = &c
+			}
+		}
+		resp := s.respond(req, rx.Time, prev)
+		if resp == nil {
+			s.stats.malformed.Add(1)
+			continue
+		}
+		if key != nil {
+			resp = key.AppendMAC(resp)
+		}
+		tx, err := pc.WriteMsg(resp, addr)
+		if err != nil {
+			continue
+		}
+		s.stats.sent.Add(1)
+		if s.Interleaved {
+			s.remember(client, clientTimestamps{rx: NewTimestamp(rx.Time), tx: NewTimestamp(tx.Time)})
+		}
+	}
+}
+
+// authenticate проверяет MAC запроса. Возвращает ключ, которым подписывать ответ (nil — без MAC),
+// и false, если запрос нужно отбросить: неверный MAC или отсутствие MAC при RequireAuth.
+func (s *Server) authenticate(pkt []byte) (*Key, bool) {
+	key, err := VerifyMAC(pkt, s.Keys)
+	switch {
+	case err == nil:
+		return key, true
+	case errors.Is(err, ErrNoMAC):
+		s.stats.unauthenticated.Add(1)
+		return nil, !s.RequireAuth
+	default:
+		s.stats.authFailed.Add(1)
+		return nil, false
+	}
+}
+
+// Close останавливает сервер
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
+
+// remember сохраняет метки обмена с клиентом; при переполнении таблицы вытесняется произвольная запись
+func (s *Server) remember(key string, ts clientTimestamps) {
+	if s.clients == nil {
+		s.clients = make(map[string]clientTimestamps)
+	}
+	if _, ok := s.clients[key]; !ok && len(s.clients) >= maxInterleavedClients {
+		for k := range s.clients {
+			delete(s.clients, k)
+			break
+		}
+	}
+	s.clients[key] = ts
+}
+
+// kiss формирует ответ Kiss-o'-Death (RFC 5905, 7.4): leap=3, stratum 0, код в refid
+func (s *Server) kiss(req *Packet, code string) []byte {
+	if req.Mode != ModeClient || req.Version < 1 || req.Version > Version {
+		return nil
+	}
+	resp := Packet{
+		Leap:         LeapNotInSync,
+		Version:      req.Version,
+		Mode:         ModeServer,
+		Poll:         req.Poll,
+		Precision:    localPrecision,
+		ReferenceID:  RefIDFromString(code),
+		OriginTime:   req.TransmitTime,
+		TransmitTime: req.TransmitTime,
+	}
+	return resp.Marshal()
+}
+
+// respond формирует ответ на запрос клиента (mode 3); остальные режимы игнорируются.
+// Root dispersion увеличивается на PHI·(время с последней коррекции), как в RFC 5905 (раздел 11.2).
+// prev != nil — interleaved ответ: origin = receive timestamp запроса, transmit = точная метка
+// передачи предыдущего ответа этому клиенту.
+func (s *Server) respond(req *Packet, rx time.Time, prev *clientTimestamps) []byte {
+	if req.Mode != ModeClient || req.Version < 1 || req.Version > Version {
+		return nil
+	}
+	q := s.ClockQuality()
+	resp := Packet{
+		Leap:        q.Leap,
+		Version:     req.Version,
+		Mode:        ModeServer,
+		Stratum:     q.Stratum,
+		Poll:        req.Poll,
+		Precision:   q.Precision,
+		ReferenceID: q.ReferenceID,
+		RootDelay:   DurationToShort(q.RootDelay),
+		OriginTime:  req.TransmitTime,
+		ReceiveTime: NewTimestamp(rx),
+	}
+	disp := q.RootDispersion
+	if !q.ReferenceTime.IsZero() {
+		resp.ReferenceTime = NewTimestamp(q.ReferenceTime)
+		disp += time.Duration(PHI * float64(rx.Sub(q.ReferenceTime)))
+	}
+	resp.RootDispersion = DurationToShort(disp)
+	if prev != nil {
+		resp.OriginTime = req.ReceiveTime
+		resp.TransmitTime = prev.tx
+	} else {
+		resp.TransmitTime = NewTimestamp(time.Now())
+	}
+	return resp.Marshal()
+}
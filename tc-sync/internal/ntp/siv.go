@@ -0,0 +1,149 @@
+package ntp
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/subtle"
+	"errors"
+)
+
+// AES-CMAC (RFC 4493) и AES-SIV (RFC 5297). Нужны для NTS: AEAD_AES_SIV_CMAC_256
+// (алгоритм 15, ключ 32 байта = ключ CMAC + ключ CTR) в стандартной библиотеке Go отсутствует.
+
+// ErrAuthFailed — проверка AEAD/MAC не прошла
+var ErrAuthFailed = errors.New("ntp: authentication failed")
+
+const sivBlock = aes.BlockSize
+
+// dbl — умножение на x в GF(2^128) (сдвиг влево с редукцией 0x87)
+func dbl(b *[sivBlock]byte) {
+	carry := b[0] >> 7
+	for i := 0; i < sivBlock-1; i++ {
+		b[i] = b[i]<<1 | b[i+1]>>7
+	}
+	b[sivBlock-1] <<= 1
+	if carry != 0 {
+		b[sivBlock-1] ^= 0x87
+	}
+}
+
+// cmacSum вычисляет AES-CMAC (RFC 4493) сообщения msg
+func cmacSum(c cipher.Block, msg []byte) [sivBlock]byte {
+	var k1 [sivBlock]byte
+	c.Encrypt(k1[:], k1[:])
+	dbl(&k1)
+	k2 := k1
+	dbl(&k2)
+
+	var x [sivBlock]byte
+	n := (len(msg) + sivBlock - 1) / sivBlock
+	complete := n > 0 && len(msg)%sivBlock == 0
+	if n == 0 {
+		n = 1
+	}
+	for i := 0; i < n-1; i++ {
+		subtle.XORBytes(x[:], x[:], msg[i*sivBlock:(i+1)*sivBlock])
+		c.Encrypt(x[:], x[:])
+	}
+	var last [sivBlock]byte
+	tail := msg[(n-1)*sivBlock:]
+	copy(last[:], tail)
+	if complete {
+		subtle.XORBytes(last[:], last[:], k1[:])
+	} else {
+		last[len(tail)] = 0x80
+		subtle.XORBytes(last[:], last[:], k2[:])
+	}
+	subtle.XORBytes(x[:], x[:], last[:])
+	c.Encrypt(x[:], x[:])
+	return x
+}
+
+// CMAC вычисляет AES-CMAC (RFC 4493) с ключом 16/24/32 байта
+func CMAC(key, msg []byte) ([]byte, error) {
+	c, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	sum := cmacSum(c, msg)
+	return sum[:], nil
+}
+
+// s2v — функция S2V из RFC 5297 (вектор строк → синтетический IV)
+func s2v(c cipher.Block, ad [][]byte, plaintext []byte) [sivBlock]byte {
+	d := cmacSum(c, make([]byte, sivBlock))
+	for _, s := range ad {
+		dbl(&d)
+		m := cmacSum(c, s)
+		subtle.XORBytes(d[:], d[:], m[:])
+	}
+	var t []byte
+	if len(plaintext) >= sivBlock {
+		t = append([]byte(nil), plaintext...)
+		subtle.XORBytes(t[len(t)-sivBlock:], t[len(t)-sivBlock:], d[:])
+	} else {
+		dbl(&d)
+		var pad [sivBlock]byte
+		copy(pad[:], plaintext)
+		pad[len(plaintext)] = 0x80
+		subtle.XORBytes(pad[:], pad[:], d[:])
+		t = pad[:]
+	}
+	return cmacSum(c, t)
+}
+
+// SIV — AEAD AES-SIV-CMAC (RFC 5297). Ключ 32 байта (AES-SIV-CMAC-256) или 64 байта (-512).
+// Вывод Seal: SIV (16 байт) || шифротекст.
+type SIV struct {
+	mac, ctr cipher.Block
+}
+
+// NewSIV создаёт AES-SIV из ключа; первая половина — ключ S2V/CMAC, вторая — ключ CTR.
+func NewSIV(key []byte) (*SIV, error) {
+	if len(key) != 32 && len(key) != 48 && len(key) != 64 {
+		return nil, errors.New("ntp: invalid AES-SIV key length")
+	}
+	half := len(key) / 2
+	mac, err := aes.NewCipher(key[:half])
+	if err != nil {
+		return nil, err
+	}
+	ctr, err := aes.NewCipher(key[half:])
+	if err != nil {
+		return nil, err
+	}
+	return &SIV{mac: mac, ctr: ctr}, nil
+}
+
+// Seal шифрует plaintext; ad — компоненты associated data (для NTS: AD, затем nonce).
+func (s *SIV) Seal(plaintext []byte, ad ...[]byte) []byte {
+	v := s2v(s.mac, ad, plaintext)
+	out := make([]byte, sivBlock+len(plaintext))
+	copy(out, v[:])
+	s.xorCTR(out[sivBlock:], plaintext, v)
+	return out
+}
+
+// Open расшифровывает и проверяет SIV; при несовпадении — ErrAuthFailed.
+func (s *SIV) Open(ciphertext []byte, ad ...[]byte) ([]byte, error) {
+	if len(ciphertext) < sivBlock {
+		return nil, ErrAuthFailed
+	}
+	var v [sivBlock]byte
+	copy(v[:], ciphertext[:sivBlock])
+	plaintext := make([]byte, len(ciphertext)-sivBlock)
+	s.xorCTR(plaintext, ciphertext[sivBlock:], v)
+	want := s2v(s.mac, ad, plaintext)
+	if subtle.ConstantTimeCompare(want[:], v[:]) != 1 {
+		return nil, ErrAuthFailed
+	}
+	return plaintext, nil
+}
+
+// xorCTR — AES-CTR с начальным счётчиком Q = V с обнулёнными битами 63 и 31 (RFC 5297, раздел 2.5)
+func (s *SIV) xorCTR(dst, src []byte, v [sivBlock]byte) {
+	q := v
+	q[8] &= 0x7f
+	q[12] &= 0x7f
+	cipher.NewCTR(s.ctr, q[:]).XORKeyStream(dst, src)
+}
@@ -0,0 +1,192 @@
+package ntp
+
+import (
+	"bufio"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// KeyType — алгоритм симметричного ключа NTP
+type KeyType int
+
+const (
+	KeyMD5        KeyType = iota + 1 // MAC = MD5(ключ || пакет), RFC 5905
+	KeySHA1                          // MAC = SHA1(ключ || пакет)
+	KeyAES128CMAC                    // MAC = AES-CMAC(ключ, пакет), RFC 8573
+)
+
+func (t KeyType) String() string {
+	switch t {
+	case KeyMD5:
+		return "MD5"
+	case KeySHA1:
+		return "SHA1"
+	case KeyAES128CMAC:
+		return "AES128CMAC"
+	default:
+		return "unknown"
+	}
+}
+
+// ParseKeyType разбирает тип ключа из keys файла (MD5, SHA1, AES128, AES128CMAC, AES-128-CMAC; регистр не важен)
+func ParseKeyType(s string) (KeyType, error) {
+	switch strings.ToUpper(strings.ReplaceAll(s, "-", "")) {
+	case "M", "MD5":
+		return KeyMD5, nil
+	case "SHA1":
+		return KeySHA1, nil
+	case "AES128", "AES128CMAC", "CMAC":
+		return KeyAES128CMAC, nil
+	}
+	return 0, fmt.Errorf("ntp: unsupported key type %q", s)
+}
+
+// Key — симметричный ключ (key ID, алгоритм, секрет)
+type Key struct {
+	ID     uint32
+	Type   KeyType
+	Secret []byte
+}
+
+// Keys — ключи по key ID
+type Keys map[uint32]*Key
+
+// Ошибки проверки MAC
+var (
+	ErrNoMAC      = errors.New("ntp: packet is not authenticated")
+	ErrUnknownKey = errors.New("ntp: unknown key id")
+	ErrBadMAC     = errors.New("ntp: MAC mismatch")
+)
+
+// digestSize — длина дайджеста MAC без key ID
+func (k *Key) digestSize() int {
+	if k.Type == KeySHA1 {
+		return sha1.Size
+	}
+	return md5.Size // MD5 и AES-CMAC — 16 байт
+}
+
+// digest вычисляет дайджест MAC по данным пакета (заголовок и extension fields)
+func (k *Key) digest(data []byte) []byte {
+	switch k.Type {
+	case KeySHA1:
+		h := sha1.New()
+		h.Write(k.Secret)
+		h.Write(data)
+		return h.Sum(nil)
+	case KeyAES128CMAC:
+		sum, err := CMAC(k.Secret, data)
+		if err != nil {
+			return nil
+		}
+		return sum
+	default:
+		h := md5.New()
+		h.Write(k.Secret)
+		h.Write(data)
+		return h.Sum(nil)
+	}
+}
+
+// AppendMAC добавляет к пакету MAC: 4 байта key ID и дайджест (RFC 5905, рис. 8)
+func (k *Key) AppendMAC(pkt []byte) []byte {
+	d := k.digest(pkt)
+	pkt = binary.BigEndian.AppendUint32(pkt, k.ID)
+	return append(pkt, d...)
+}
+
+// VerifyMAC проверяет MAC в конце пакета ключом из keys и возвращает этот ключ.
+// Пакет без MAC — ErrNoMAC; key ID не из keys — ErrUnknownKey; неверный дайджест — ErrBadMAC.
+func VerifyMAC(pkt []byte, keys Keys) (*Key, error) {
+	_, rest := ParseExtensions(pkt)
+	if len(rest) < 4 {
+		return nil, ErrNoMAC
+	}
+	id := binary.BigEndian.Uint32(rest)
+	k := keys[id]
+	if k == nil {
+		return nil, ErrUnknownKey
+	}
+	mac := rest[4:]
+	if len(mac) != k.digestSize() {
+		return nil, ErrBadMAC
+	}
+	if subtle.ConstantTimeCompare(mac, k.digest(pkt[:len(pkt)-len(rest)])) != 1 {
+		return nil, ErrBadMAC
+	}
+	return k, nil
+}
+
+// LoadKeys читает keys файл (формат ntpd/chrony), см. ParseKeys
+func LoadKeys(path string) (Keys, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseKeys(f)
+}
+
+// ParseKeys разбирает keys файл: строки «key_id тип ключ», # — комментарий.
+// Ключ: HEX:… — шестнадцатеричный, ASCII:… — текстовый; без префикса — как в ntpd:
+// до 20 символов — текст, длиннее — шестнадцатеричная строка. Ключ AES128 — ровно 16 байт.
+func ParseKeys(r io.Reader) (Keys, error) {
+	keys := make(Keys)
+	sc := bufio.NewScanner(r)
+	line := 0
+	for sc.Scan() {
+		line++
+		text := sc.Text()
+		if i := strings.IndexByte(text, '#'); i >= 0 {
+			text = text[:i]
+		}
+		f := strings.Fields(text)
+		if len(f) == 0 {
+			continue
+		}
+		if len(f) != 3 {
+			return nil, fmt.Errorf("ntp keys: line %d: want \"id type key\"", line)
+		}
+		id, err := strconv.ParseUint(f[0], 10, 32)
+		if err != nil || id == 0 {
+			return nil, fmt.Errorf("ntp keys: line %d: bad key id %q", line, f[0])
+		}
+		typ, err := ParseKeyType(f[1])
+		if err != nil {
+			return nil, fmt.Errorf("ntp keys: line %d: %w", line, err)
+		}
+		secret, err := parseSecret(f[2])
+		if err != nil {
+			return nil, fmt.Errorf("ntp keys: line %d: %w", line, err)
+		}
+		if typ == KeyAES128CMAC && len(secret) != 16 {
+			return nil, fmt.Errorf("ntp keys: line %d: AES128 key must be 16 bytes, got %d", line, len(secret))
+		}
+		keys[uint32(id)] = &Key{ID: uint32(id), Type: typ, Secret: secret}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+func parseSecret(s string) ([]byte, error) {
+	switch {
+	case strings.HasPrefix(s, "HEX:"):
+		return hex.DecodeString(s[4:])
+	case strings.HasPrefix(s, "ASCII:"):
+		return []byte(s[6:]), nil
+	case len(s) > 20:
+		return hex.DecodeString(s)
+	}
+	return []byte(s), nil
+}
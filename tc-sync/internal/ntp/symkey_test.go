@@ -0,0 +1,226 @@
+package ntp
+
+import (
+	"crypto/md5"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testKeys = `
+# id type key
+1 MD5 secret-md5
+2 SHA1 HEX:0102030405060708090a0b0c0d0e0f1011121314
+3 AES128 HEX:000102030405060708090a0b0c0d0e0f
+4 sha1 0102030405060708090a0b0c0d0e0f1011121314   # ntpd: длинный ключ — hex
+`
+
+func TestParseKeys(t *testing.T) {
+	keys, err := ParseKeys(strings.NewReader(testKeys))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 4 {
+		t.Fatalf("got %d keys", len(keys))
+	}
+	if k := keys[1]; k.Type != KeyMD5 || string(k.Secret) != "secret-md5" {
+		t.Errorf("key 1: %+v", k)
+	}
+	if k := keys[3]; k.Type != KeyAES128CMAC || len(k.Secret) != 16 {
+		t.Errorf("key 3: %+v", k)
+	}
+	if k := keys[4]; k.Type != KeySHA1 || len(k.Secret) != 20 {
+		t.Errorf("key 4: %+v", k)
+	}
+	for _, bad := range []string{"0 MD5 x", "1 DES x", "1 AES128 short", "1 MD5"} {
+		if _, err := ParseKeys(strings.NewReader(bad)); err == nil {
+			t.Errorf("%q: expected error", bad)
+		}
+	}
+}
+
+func TestMAC(t *testing.T) {
+	keys, err := ParseKeys(strings.NewReader(testKeys))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := (&Packet{Version: 4, Mode: ModeClient, TransmitTime: NewTimestamp(time.Now())}).Marshal()
+
+	// MD5: key ID + MD5(ключ || пакет)
+	pkt := keys[1].AppendMAC(append([]byte(nil), req...))
+	if len(pkt) != HeaderSize+20 {
+		t.Fatalf("md5 packet len %d", len(pkt))
+	}
+	want := md5.Sum(append([]byte("secret-md5"), req...))
+	if string(pkt[HeaderSize+4:]) != string(want[:]) {
+		t.Error("md5 digest mismatch")
+	}
+
+	for id, size := range map[uint32]int{1: 20, 2: 24, 3: 20} {
+		pkt := keys[id].AppendMAC(append([]byte(nil), req...))
+		if len(pkt) != HeaderSize+size {
+			t.Errorf("key %d: packet len %d", id, len(pkt))
+		}
+		k, err := VerifyMAC(pkt, keys)
+		if err != nil || k.ID != id {
+			t.Errorf("key %d: verify: %v", id, err)
+		}
+		pkt[10] ^= 1
+		if _, err := VerifyMAC(pkt, keys); !errors.Is(err, ErrBadMAC) {
+			t.Errorf("key %d: tampered packet: %v", id, err)
+		}
+	}
+	if _, err := VerifyMAC(req, keys); !errors.Is(err, ErrNoMAC) {
+		t.Errorf("no MAC: %v", err)
+	}
+	other := &Key{ID: 9, Type: KeyMD5, Secret: []byte("x")}
+	if _, err := VerifyMAC(other.AppendMAC(append([]byte(nil), req...)), keys); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("unknown key: %v", err)
+	}
+}
+
+func TestAccessList(t *testing.T) {
+	a, err := ParseAccessList([]string{"10.0.0.0/8", "192.168.1.5", "2001:db8::/32"}, []string{"10.1.0.0/16"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for ip, want := range map[string]bool{
+		"10.2.3.4":    true,
+		"10.1.2.3":    false, // deny приоритетнее allow
+		"192.168.1.5": true,
+		"192.168.1.6": false,
+		"2001:db8::1": true,
+		"2001:db9::1": false,
+	} {
+		if got := a.Permit(net.ParseIP(ip)); got != want {
+			t.Errorf("%s: permit=%v want %v", ip, got, want)
+		}
+	}
+	open, _ := ParseAccessList(nil, []string{"127.0.0.1"})
+	if open.Permit(net.ParseIP("127.0.0.1")) || !open.Permit(net.ParseIP("8.8.8.8")) {
+		t.Error("deny-only list")
+	}
+	if !(*AccessList)(nil).Permit(net.ParseIP("8.8.8.8")) {
+		t.Error("nil list must permit")
+	}
+	if _, err := ParseAccessList([]string{"10.0.0.0/33"}, nil); err == nil {
+		t.Error("bad CIDR accepted")
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	l := rateLimiter{limit: RateLimit{Interval: time.Second, Burst: 2}}
+	now := time.Unix(1000, 0)
+	for i := 0; i < 2; i++ {
+		if ok, _ := l.allow("a", now); !ok {
+			t.Fatalf("request %d within burst limited", i)
+		}
+	}
+	if ok, kod := l.allow("a", now); ok || !kod {
+		t.Errorf("over burst: ok=%v kod=%v, want KoD", ok, kod)
+	}
+	if ok, kod := l.allow("a", now.Add(100*time.Millisecond)); ok || kod {
+		t.Errorf("second excess: ok=%v kod=%v, want silent drop", ok, kod)
+	}
+	if ok, _ := l.allow("b", now); !ok {
+		t.Error("other client limited")
+	}
+	if ok, _ := l.allow("a", now.Add(1200*time.Millisecond)); !ok {
+		t.Error("token not refilled after interval")
+	}
+}
+
+func TestServer_Auth(t *testing.T) {
+	keys, err := ParseKeys(strings.NewReader(testKeys))
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := NewServer("127.0.0.1:0")
+	srv.Keys = keys
+	srv.RequireAuth = true
+	if err := srv.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	go srv.Serve()
+	srv.UpdateClockQuality(ClockQuality{Stratum: 1, ReferenceID: RefIDFromString("GPS"), ReferenceTime: time.Now(), Precision: -20})
+	addr := srv.LocalAddr().String()
+
+	for _, id := range []uint32{1, 2, 3} {
+		c := NewClient(addr, time.Second)
+		c.Key = keys[id]
+		if _, err := c.Query(); err != nil {
+			t.Errorf("key %d: %v", id, err)
+		}
+		if st := c.Stats(); st.Responses != 1 || st.AuthFailed != 0 {
+			t.Errorf("key %d: client stats %+v", id, st)
+		}
+	}
+
+	// Без MAC и с неверным ключом сервер не отвечает
+	plain := NewClient(addr, 200*time.Millisecond)
+	if _, err := plain.Query(); err == nil {
+		t.Error("unauthenticated request answered")
+	}
+	wrong := NewClient(addr, 200*time.Millisecond)
+	wrong.Key = &Key{ID: 1, Type: KeyMD5, Secret: []byte("wrong")}
+	if _, err := wrong.Query(); err == nil {
+		t.Error("request with wrong key answered")
+	}
+	st := srv.Stats()
+	if st.Sent != 3 || st.Unauthenticated != 1 || st.AuthFailed != 1 {
+		t.Errorf("server stats %+v", st)
+	}
+
+	// Клиент с ключом отклоняет ответ без MAC
+	c := NewClient(fakeServer(t, 0, 1), 200*time.Millisecond)
+	c.Key = keys[1]
+	if _, err := c.Query(); !errors.Is(err, ErrNoMAC) {
+		t.Errorf("unauthenticated response: %v", err)
+	}
+	if st := c.Stats(); st.Unauthenticated != 1 || st.Responses != 0 {
+		t.Errorf("client stats %+v", st)
+	}
+}
+
+func TestServer_AccessAndRate(t *testing.T) {
+	srv := NewServer("127.0.0.1:0")
+	srv.RateLimit = RateLimit{Interval: time.Hour, Burst: 1}
+	if err := srv.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	go srv.Serve()
+	srv.UpdateClockQuality(ClockQuality{Stratum: 1, ReferenceID: RefIDFromString("GPS"), ReferenceTime: time.Now(), Precision: -20})
+	c := NewClient(srv.LocalAddr().String(), 200*time.Millisecond)
+	if _, err := c.Query(); err != nil {
+		t.Fatal(err)
+	}
+	var kod *KissError
+	if _, err := c.Query(); !errors.As(err, &kod) || kod.Code != "RATE" {
+		t.Errorf("second query: %v, want KoD RATE", err)
+	}
+	if _, err := c.Query(); err == nil || errors.As(err, &kod) {
+		t.Errorf("third query: %v, want timeout", err)
+	}
+	if st := srv.Stats(); st.RateLimited != 2 || st.KoD != 1 || st.Sent != 2 {
+		t.Errorf("stats %+v", st)
+	}
+
+	// Loopback не входит в allow — запрос отбрасывается
+	closed := NewServer("127.0.0.1:0")
+	closed.Access, _ = ParseAccessList([]string{"192.0.2.0/24"}, nil)
+	if err := closed.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	defer closed.Close()
+	go closed.Serve()
+	if _, err := NewClient(closed.LocalAddr().String(), 200*time.Millisecond).Query(); err == nil {
+		t.Error("request from denied network answered")
+	}
+	if st := closed.Stats(); st.Denied != 1 || st.Sent != 0 {
+		t.Errorf("stats %+v", st)
+	}
+}
@@ -0,0 +1,186 @@
+package ptp
+
+import (
+	"math"
+	"math/rand"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// admissionTau — постоянная времени оценки входящей частоты (экспоненциальное среднее)
+	admissionTau = time.Second
+	// maxAdmissionClients — размер таблицы клиентов допуска
+	maxAdmissionClients = 4096
+	// admissionClientExpiry — клиент без запросов дольше этого срока удаляется из таблицы
+	admissionClientExpiry = time.Minute
+)
+
+// AdmissionStats — состояние допуска входящих запросов сервера (max_packets_per_second)
+type AdmissionStats struct {
+	MaxPPS   int     // порог; 0 — без ограничения
+	Rate     float64 // оценка входящей частоты Delay_Req и Signaling, пакетов в секунду
+	Accepted uint64
+	Dropped  uint64
+	Clients  []ClientAdmission // по убыванию числа отброшенных
+}
+
+// ClientAdmission — учёт запросов одного клиента (по IP)
+type ClientAdmission struct {
+	Addr     string
+	Rate     float64
+	Accepted uint64
+	Dropped  uint64
+	LastSeen time.Time
+}
+
+// ewmaRate — оценка частоты событий экспоненциальным средним с постоянной admissionTau:
+// при каждом событии r = r·exp(−Δt/τ) + 1/τ
+type ewmaRate struct {
+	value float64
+	last  time.Time
+}
+
+func (r *ewmaRate) at(now time.Time) float64 {
+	if r.last.IsZero() {
+		return 0
+	}
+	dt := now.Sub(r.last)
+	if dt <= 0 {
+		return r.value
+	}
+	return r.value * math.Exp(-float64(dt)/float64(admissionTau))
+}
+
+func (r *ewmaRate) add(now time.Time) float64 {
+	r.value = r.at(now) + 1/admissionTau.Seconds()
+	if now.After(r.last) {
+		r.last = now
+	}
+	return r.value
+}
+
+type admissionClient struct {
+	rate     ewmaRate
+	accepted uint64
+	dropped  uint64
+	lastSeen time.Time
+}
+
+// admission — допуск входящих Delay_Req и Signaling (WRED). Пока оценка общей частоты не выше
+// max, запросы принимаются; выше — отбрасываются с вероятностью (rate − max) / max (все при
+// rate ≥ 2·max), умноженной на вес клиента: отношение его частоты к справедливой доле
+// (rate / число активных клиентов). Клиент, создающий поток, теряет запросы раньше остальных.
+type admission struct {
+	max    float64
+	random func() float64
+
+	mu       sync.Mutex
+	rate     ewmaRate
+	accepted uint64
+	dropped  uint64
+	clients  map[string]*admissionClient
+	// Число активных клиентов: таблица пересчитывается не чаще раза в τ, а не на каждый запрос
+	activeN  int
+	activeAt time.Time
+}
+
+func newAdmission(maxPPS int) *admission {
+	return &admission{max: float64(maxPPS), random: rand.Float64, clients: make(map[string]*admissionClient)}
+}
+
+// allow учитывает запрос от src и решает, обслуживать ли его
+func (a *admission) allow(src net.Addr, now time.Time) bool {
+	if a.max <= 0 {
+		return true
+	}
+	key := "-"
+	if ip := ipOf(src); ip != nil {
+		key = ip.String()
+	} else if src != nil {
+		key = src.String()
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	c := a.client(key, now)
+	c.lastSeen = now
+	total := a.rate.add(now)
+	own := c.rate.add(now)
+	ok := true
+	if total > a.max {
+		p := (total - a.max) / a.max
+		if active := a.activeCount(now); active > 1 {
+			p *= own * float64(active) / total
+		}
+		ok = p < 1 && a.random() >= p
+	}
+	if ok {
+		a.accepted++
+		c.accepted++
+	} else {
+		a.dropped++
+		c.dropped++
+	}
+	return ok
+}
+
+func (a *admission) client(key string, now time.Time) *admissionClient {
+	if c := a.clients[key]; c != nil {
+		return c
+	}
+	if len(a.clients) >= maxAdmissionClients {
+		for k, c := range a.clients {
+			if now.Sub(c.lastSeen) > admissionClientExpiry {
+				delete(a.clients, k)
+			}
+		}
+		// Таблица заполнена активными клиентами — место освобождает произвольный
+		for k := range a.clients {
+			if len(a.clients) < maxAdmissionClients {
+				break
+			}
+			delete(a.clients, k)
+		}
+	}
+	c := &admissionClient{}
+	a.clients[key] = c
+	return c
+}
+
+// activeCount — число клиентов с запросами за последние 2τ на момент последнего пересчёта
+// (не старше τ)
+func (a *admission) activeCount(now time.Time) int {
+	if d := now.Sub(a.activeAt); a.activeAt.IsZero() || d < 0 || d >= admissionTau {
+		a.activeN, a.activeAt = 0, now
+		for _, c := range a.clients {
+			if now.Sub(c.lastSeen) <= 2*admissionTau {
+				a.activeN++
+			}
+		}
+	}
+	return a.activeN
+}
+
+// snapshot возвращает состояние допуска; клиенты без запросов дольше admissionClientExpiry
+// удаляются
+func (a *admission) snapshot(now time.Time) AdmissionStats {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	st := AdmissionStats{MaxPPS: int(a.max), Rate: a.rate.at(now), Accepted: a.accepted, Dropped: a.dropped}
+	for k, c := range a.clients {
+		if now.Sub(c.lastSeen) > admissionClientExpiry {
+			delete(a.clients, k)
+			continue
+		}
+		st.Clients = append(st.Clients, ClientAdmission{Addr: k, Rate: c.rate.at(now), Accepted: c.accepted, Dropped: c.dropped, LastSeen: c.lastSeen})
+	}
+	sort.Slice(st.Clients, func(i, j int) bool {
+		if st.Clients[i].Dropped != st.Clients[j].Dropped {
+			return st.Clients[i].Dropped > st.Clients[j].Dropped
+		}
+		return st.Clients[i].Addr < st.Clients[j].Addr
+	})
+	return st
+}
@@ -0,0 +1,320 @@
+package ptp
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shiwa/timecard-mini/tc-sync/internal/timestamping"
+)
+
+// TLVAuthentication — TLV AUTHENTICATION (IEEE 1588-2019, 16.14.3)
+const TLVAuthentication uint16 = 0x8009
+
+// authHeaderSize — SPP, secParamIndicator и keyID перед ICV (немедленная проверка: без disclosedKey,
+// sequenceNo и RES)
+const authHeaderSize = 6
+
+// keyRolloverGrace — сколько ключ принимается до начала и после окончания срока действия: расписание
+// смены ключей сверяется по часам узлов, которые могут расходиться
+const keyRolloverGrace = time.Hour
+
+// MinAuthKeySize — наименьшая длина ключа HMAC
+const MinAuthKeySize = 16
+
+// ICVAlgorithm — алгоритм ICV TLV AUTHENTICATION
+type ICVAlgorithm int
+
+const (
+	HMACSHA256     ICVAlgorithm = iota + 1 // HMAC-SHA256, ICV 32 байта
+	HMACSHA256_128                         // HMAC-SHA256, усечённый до 16 байт
+)
+
+func (a ICVAlgorithm) String() string {
+	switch a {
+	case HMACSHA256:
+		return "HMAC-SHA256"
+	case HMACSHA256_128:
+		return "HMAC-SHA256-128"
+	}
+	return "unknown"
+}
+
+// ICVSize — длина ICV в байтах
+func (a ICVAlgorithm) ICVSize() int {
+	if a == HMACSHA256_128 {
+		return 16
+	}
+	return sha256.Size
+}
+
+// ParseICVAlgorithm разбирает алгоритм из файла ключей (HMAC-SHA256, HMAC-SHA256-128; префикс HMAC-
+// и регистр не важны)
+func ParseICVAlgorithm(s string) (ICVAlgorithm, error) {
+	switch strings.TrimPrefix(strings.ToUpper(s), "HMAC-") {
+	case "SHA256", "SHA256-256":
+		return HMACSHA256, nil
+	case "SHA256-128":
+		return HMACSHA256_128, nil
+	}
+	return 0, fmt.Errorf("ptp: unsupported ICV algorithm %q", s)
+}
+
+// SecurityKey — ключ security association: keyID, алгоритм, секрет и срок действия (нулевые From,
+// Until — без ограничения)
+type SecurityKey struct {
+	ID        uint32
+	Algorithm ICVAlgorithm
+	Secret    []byte
+	From      time.Time
+	Until     time.Time
+}
+
+// validAt возвращает true, если now в сроке действия ключа, расширенном на grace
+func (k *SecurityKey) validAt(now time.Time, grace time.Duration) bool {
+	return (k.From.IsZero() || !now.Before(k.From.Add(-grace))) && (k.Until.IsZero() || now.Before(k.Until.Add(grace)))
+}
+
+func (k *SecurityKey) icv(data []byte) []byte {
+	h := hmac.New(sha256.New, k.Secret)
+	h.Write(data)
+	return h.Sum(nil)[:k.Algorithm.ICVSize()]
+}
+
+// SecurityAssociation — параметры аутентификации порта (IEEE 1588-2019, приложение P): SPP, который
+// несут TLV AUTHENTICATION, и ключи с расписанием смены. Сообщения подписываются ключом, действующим
+// сейчас и начавшим действовать последним; принимаются сообщения с любым ключом в сроке действия
+// (± keyRolloverGrace). Проверка немедленная: ICV вычисляется по всему сообщению до ICV
+// с нулевым correctionField (его меняют transparent clocks).
+type SecurityAssociation struct {
+	SPP  uint8
+	Keys []SecurityKey
+}
+
+// Ошибки проверки TLV AUTHENTICATION
+var (
+	ErrNotAuthenticated = errors.New("ptp: message is not authenticated")
+	ErrUnknownKey       = errors.New("ptp: unknown security parameters or key id")
+	ErrBadICV           = errors.New("ptp: ICV mismatch")
+	ErrNoValidKey       = errors.New("ptp: no valid authentication key")
+)
+
+// Validate проверяет ключи: есть хотя бы один, keyID не повторяются, секрет не короче MinAuthKeySize
+func (sa *SecurityAssociation) Validate() error {
+	if len(sa.Keys) == 0 {
+		return errors.New("ptp: security association has no keys")
+	}
+	seen := make(map[uint32]bool, len(sa.Keys))
+	for _, k := range sa.Keys {
+		if seen[k.ID] {
+			return fmt.Errorf("ptp: duplicate key id %d", k.ID)
+		}
+		seen[k.ID] = true
+		if len(k.Secret) < MinAuthKeySize {
+			return fmt.Errorf("ptp: key %d: secret must be at least %d bytes", k.ID, MinAuthKeySize)
+		}
+		if !k.From.IsZero() && !k.Until.IsZero() && !k.From.Before(k.Until) {
+			return fmt.Errorf("ptp: key %d: valid from %v is not before %v", k.ID, k.From, k.Until)
+		}
+	}
+	return nil
+}
+
+// SendKey возвращает ключ для подписи в момент now: действующий, с наибольшим From (при равных —
+// с наибольшим keyID); nil — действующего ключа нет
+func (sa *SecurityAssociation) SendKey(now time.Time) *SecurityKey {
+	var best *SecurityKey
+	for i := range sa.Keys {
+		k := &sa.Keys[i]
+		if !k.validAt(now, 0) {
+			continue
+		}
+		if best == nil || k.From.After(best.From) || k.From.Equal(best.From) && k.ID > best.ID {
+			best = k
+		}
+	}
+	return best
+}
+
+func (sa *SecurityAssociation) key(id uint32, now time.Time) *SecurityKey {
+	for i := range sa.Keys {
+		if k := &sa.Keys[i]; k.ID == id && k.validAt(now, keyRolloverGrace) {
+			return k
+		}
+	}
+	return nil
+}
+
+// Sign возвращает сообщение b с TLV AUTHENTICATION в конце (minorVersionPTP 1 — IEEE 1588-2019)
+func (sa *SecurityAssociation) Sign(b []byte, now time.Time) ([]byte, error) {
+	k := sa.SendKey(now)
+	if k == nil {
+		return nil, ErrNoValidKey
+	}
+	if len(b) < HeaderSize {
+		return nil, ErrShortMessage
+	}
+	n := int(binary.BigEndian.Uint16(b[2:4]))
+	if n < HeaderSize || n > len(b) {
+		return nil, ErrShortMessage
+	}
+	size := k.Algorithm.ICVSize()
+	out := make([]byte, n+4+authHeaderSize+size)
+	copy(out, b[:n])
+	out[1] = 1<<4 | out[1]&0x0F
+	binary.BigEndian.PutUint16(out[2:4], uint16(len(out)))
+	tlv := out[n:]
+	binary.BigEndian.PutUint16(tlv[0:2], TLVAuthentication)
+	binary.BigEndian.PutUint16(tlv[2:4], uint16(authHeaderSize+size))
+	tlv[4] = sa.SPP
+	tlv[5] = 0 // secParamIndicator: немедленная проверка
+	binary.BigEndian.PutUint32(tlv[6:10], k.ID)
+	copy(out[len(out)-size:], k.icv(icvInput(out[:len(out)-size])))
+	return out, nil
+}
+
+// Verify проверяет последний TLV сообщения m, разобранного из b: TLV AUTHENTICATION с SPP
+// ассоциации, ключом в сроке действия и верным ICV
+func (sa *SecurityAssociation) Verify(b []byte, m *Message, now time.Time) error {
+	if len(m.TLVs) == 0 {
+		return ErrNotAuthenticated
+	}
+	t := m.TLVs[len(m.TLVs)-1]
+	if t.Type != TLVAuthentication || len(t.Value) < authHeaderSize {
+		return ErrNotAuthenticated
+	}
+	if t.Value[0] != sa.SPP || t.Value[1] != 0 {
+		return ErrUnknownKey
+	}
+	k := sa.key(binary.BigEndian.Uint32(t.Value[2:6]), now)
+	if k == nil {
+		return ErrUnknownKey
+	}
+	size := k.Algorithm.ICVSize()
+	n := int(m.Length)
+	if len(t.Value) != authHeaderSize+size || n > len(b) {
+		return ErrBadICV
+	}
+	if !hmac.Equal(t.Value[authHeaderSize:], k.icv(icvInput(b[:n-size]))) {
+		return ErrBadICV
+	}
+	return nil
+}
+
+// icvInput — данные для ICV: копия сообщения до ICV с нулевым correctionField
+func icvInput(b []byte) []byte {
+	data := append([]byte(nil), b...)
+	for i := 8; i < 16; i++ {
+		data[i] = 0
+	}
+	return data
+}
+
+// LoadSecurityKeys читает файл ключей, см. ParseSecurityKeys
+func LoadSecurityKeys(path string) ([]SecurityKey, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseSecurityKeys(f)
+}
+
+// ParseSecurityKeys разбирает файл ключей: строки «key_id алгоритм ключ [начало [конец]]», # —
+// комментарий. Ключ — как в ntp_keys: HEX:…, ASCII:… или B64:…; без префикса — до 20 символов текст,
+// длиннее — шестнадцатеричная строка. Начало и конец срока действия — RFC 3339, «-» — без ограничения.
+// Ключи возвращаются по возрастанию начала срока.
+func ParseSecurityKeys(r io.Reader) ([]SecurityKey, error) {
+	var keys []SecurityKey
+	sc := bufio.NewScanner(r)
+	line := 0
+	for sc.Scan() {
+		line++
+		text := sc.Text()
+		if i := strings.IndexByte(text, '#'); i >= 0 {
+			text = text[:i]
+		}
+		f := strings.Fields(text)
+		if len(f) == 0 {
+			continue
+		}
+		if len(f) < 3 || len(f) > 5 {
+			return nil, fmt.Errorf("ptp keys: line %d: want \"id algorithm key [from [until]]\"", line)
+		}
+		id, err := strconv.ParseUint(f[0], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("ptp keys: line %d: bad key id %q", line, f[0])
+		}
+		alg, err := ParseICVAlgorithm(f[1])
+		if err != nil {
+			return nil, fmt.Errorf("ptp keys: line %d: %w", line, err)
+		}
+		secret, err := parseKeySecret(f[2])
+		if err != nil {
+			return nil, fmt.Errorf("ptp keys: line %d: %w", line, err)
+		}
+		k := SecurityKey{ID: uint32(id), Algorithm: alg, Secret: secret}
+		for i, dst := range []*time.Time{&k.From, &k.Until} {
+			if len(f) <= 3+i || f[3+i] == "-" {
+				continue
+			}
+			if *dst, err = time.Parse(time.RFC3339, f[3+i]); err != nil {
+				return nil, fmt.Errorf("ptp keys: line %d: %w", line, err)
+			}
+		}
+		keys = append(keys, k)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(keys, func(i, j int) bool { return keys[i].From.Before(keys[j].From) })
+	return keys, nil
+}
+
+func parseKeySecret(s string) ([]byte, error) {
+	switch {
+	case strings.HasPrefix(s, "HEX:"):
+		return hex.DecodeString(s[4:])
+	case strings.HasPrefix(s, "ASCII:"):
+		return []byte(s[6:]), nil
+	case strings.HasPrefix(s, "B64:"):
+		return base64.StdEncoding.DecodeString(s[4:])
+	case len(s) > 20:
+		return hex.DecodeString(s)
+	}
+	return []byte(s), nil
+}
+
+// authTransport подписывает отправляемые сообщения порта TLV AUTHENTICATION
+type authTransport struct {
+	Transport
+	sa *SecurityAssociation
+}
+
+func (t authTransport) SendEvent(b []byte, dst net.Addr) (timestamping.Stamp, error) {
+	b, err := t.sa.Sign(b, time.Now())
+	if err != nil {
+		return timestamping.Stamp{}, err
+	}
+	return t.Transport.SendEvent(b, dst)
+}
+
+func (t authTransport) SendGeneral(b []byte, dst net.Addr) error {
+	b, err := t.sa.Sign(b, time.Now())
+	if err != nil {
+		return err
+	}
+	return t.Transport.SendGeneral(b, dst)
+}
@@ -0,0 +1,211 @@
+package ptp
+
+import (
+	"bytes"
+	"net"
+	"time"
+)
+
+// Dataset — набор данных для сравнения часов в BMCA (IEEE 1588-2008, 9.3.4)
+type Dataset struct {
+	Priority1           uint8
+	Quality             ClockQuality
+	Priority2           uint8
+	GrandmasterIdentity ClockIdentity
+	StepsRemoved        uint16
+	LocalPriority       uint8        // localPriority альтернативного BMCA G.8275 (по умолчанию 128)
+	Sender              PortIdentity // порт, от которого получен Announce
+	Receiver            PortIdentity // наш порт, принявший Announce
+}
+
+// DatasetFromAnnounce строит набор данных из принятого Announce
+func DatasetFromAnnounce(m *Message, receiver PortIdentity) Dataset {
+	a := &m.Announce
+	return Dataset{
+		Priority1:           a.GrandmasterPriority1,
+		Quality:             a.GrandmasterQuality,
+		Priority2:           a.GrandmasterPriority2,
+		GrandmasterIdentity: a.GrandmasterIdentity,
+		StepsRemoved:        a.StepsRemoved,
+		LocalPriority:       DefaultLocalPriority,
+		Sender:              m.Source,
+		Receiver:            receiver,
+	}
+}
+
+// DefaultLocalPriority — localPriority мастера и собственного порта (G.8275, 6.3)
+const DefaultLocalPriority = 128
+
+// BMCA — алгоритм выбора мастера
+type BMCA int
+
+const (
+	BMCADefault BMCA = iota // IEEE 1588-2008, 9.3.4
+	BMCAG8275               // альтернативный BMCA ITU-T G.8275 (G.8275.1/G.8275.2): без priority1, с localPriority
+	BMCAG8265               // выбор мастера G.8265.1: clockClass (QL), затем localPriority
+)
+
+func (b BMCA) String() string {
+	switch b {
+	case BMCAG8275:
+		return "G.8275"
+	case BMCAG8265:
+		return "G.8265.1"
+	}
+	return "default"
+}
+
+// Compare сравнивает наборы данных по правилам алгоритма: < 0 — a лучше, > 0 — b лучше
+func (b BMCA) Compare(a, c Dataset) int {
+	switch b {
+	case BMCAG8275:
+		return compareG8275(a, c)
+	case BMCAG8265:
+		return compareG8265(a, c)
+	}
+	return CompareDatasets(a, c)
+}
+
+// compareG8275 — альтернативный BMCA G.8275 (приложение A): priority1 не учитывается; при clockClass
+// обоих мастеров ≤ 127 (синхронизированы с PRTC) accuracy, variance и priority2 пропускаются.
+// Далее localPriority, stepsRemoved и идентификаторы.
+func compareG8275(a, b Dataset) int {
+	if a.Quality.Class != b.Quality.Class {
+		return int(a.Quality.Class) - int(b.Quality.Class)
+	}
+	if a.Quality.Class > 127 {
+		switch {
+		case a.Quality.Accuracy != b.Quality.Accuracy:
+			return int(a.Quality.Accuracy) - int(b.Quality.Accuracy)
+		case a.Quality.Variance != b.Quality.Variance:
+			return int(a.Quality.Variance) - int(b.Quality.Variance)
+		case a.Priority2 != b.Priority2:
+			return int(a.Priority2) - int(b.Priority2)
+		}
+	}
+	if a.LocalPriority != b.LocalPriority {
+		return int(a.LocalPriority) - int(b.LocalPriority)
+	}
+	if a.StepsRemoved != b.StepsRemoved {
+		return int(a.StepsRemoved) - int(b.StepsRemoved)
+	}
+	if c := bytes.Compare(a.GrandmasterIdentity[:], b.GrandmasterIdentity[:]); c != 0 {
+		return c
+	}
+	if c := bytes.Compare(a.Sender.Clock[:], b.Sender.Clock[:]); c != 0 {
+		return c
+	}
+	return int(a.Sender.Port) - int(b.Sender.Port)
+}
+
+// compareG8265 — выбор мастера G.8265.1 (6.7.3): лучший QL (меньший clockClass), затем localPriority;
+// priority1/priority2 и accuracy не используются
+func compareG8265(a, b Dataset) int {
+	if a.Quality.Class != b.Quality.Class {
+		return int(a.Quality.Class) - int(b.Quality.Class)
+	}
+	if a.LocalPriority != b.LocalPriority {
+		return int(a.LocalPriority) - int(b.LocalPriority)
+	}
+	if c := bytes.Compare(a.Sender.Clock[:], b.Sender.Clock[:]); c != 0 {
+		return c
+	}
+	return int(a.Sender.Port) - int(b.Sender.Port)
+}
+
+// CompareDatasets сравнивает наборы данных: < 0 — a лучше, > 0 — b лучше, 0 — одинаковы.
+// Разные grandmaster: priority1, clockClass, clockAccuracy, offsetScaledLogVariance, priority2, identity.
+// Один grandmaster: меньше stepsRemoved, затем identity отправителя.
+func CompareDatasets(a, b Dataset) int {
+	if a.GrandmasterIdentity != b.GrandmasterIdentity {
+		switch {
+		case a.Priority1 != b.Priority1:
+			return int(a.Priority1) - int(b.Priority1)
+		case a.Quality.Class != b.Quality.Class:
+			return int(a.Quality.Class) - int(b.Quality.Class)
+		case a.Quality.Accuracy != b.Quality.Accuracy:
+			return int(a.Quality.Accuracy) - int(b.Quality.Accuracy)
+		case a.Quality.Variance != b.Quality.Variance:
+			return int(a.Quality.Variance) - int(b.Quality.Variance)
+		case a.Priority2 != b.Priority2:
+			return int(a.Priority2) - int(b.Priority2)
+		}
+		return bytes.Compare(a.GrandmasterIdentity[:], b.GrandmasterIdentity[:])
+	}
+	if a.StepsRemoved != b.StepsRemoved {
+		return int(a.StepsRemoved) - int(b.StepsRemoved)
+	}
+	if c := bytes.Compare(a.Sender.Clock[:], b.Sender.Clock[:]); c != 0 {
+		return c
+	}
+	return int(a.Sender.Port) - int(b.Sender.Port)
+}
+
+// foreignMasterThreshold — сколько Announce за окно нужно, чтобы мастер считался квалифицированным
+const foreignMasterThreshold = 2
+
+// announceReceiptTimeout — сколько интервалов Announce без сообщений до потери мастера
+const announceReceiptTimeout = 3
+
+// ForeignMaster — мастер, от которого принимаются Announce
+type ForeignMaster struct {
+	Dataset
+	Addr         net.Addr
+	Announce     *Message  // последний Announce
+	LastAnnounce time.Time // локальное время приёма последнего Announce
+	count        int       // Announce в текущем окне квалификации
+}
+
+// Interval — интервал Announce мастера (по logMessageInterval, по умолчанию 2 с)
+func (f *ForeignMaster) Interval() time.Duration {
+	if f.Announce == nil || f.Announce.LogMsgInterval == LogIntervalUnset {
+		return 2 * time.Second
+	}
+	return LogInterval(f.Announce.LogMsgInterval)
+}
+
+// expired возвращает true, если Announce не приходили дольше announceReceiptTimeout интервалов
+func (f *ForeignMaster) expired(now time.Time) bool {
+	return now.Sub(f.LastAnnounce) > announceReceiptTimeout*f.Interval()
+}
+
+// foreignMasters — таблица мастеров по PortIdentity
+type foreignMasters map[PortIdentity]*ForeignMaster
+
+// add учитывает Announce; возвращает запись мастера
+func (fm foreignMasters) add(m *Message, src net.Addr, receiver PortIdentity, now time.Time) *ForeignMaster {
+	f := fm[m.Source]
+	if f == nil {
+		f = &ForeignMaster{}
+		fm[m.Source] = f
+	}
+	if f.expired(now) {
+		f.count = 0
+	}
+	if f.count < foreignMasterThreshold {
+		f.count++
+	}
+	f.Dataset = DatasetFromAnnounce(m, receiver)
+	f.Addr = src
+	f.Announce = m
+	f.LastAnnounce = now
+	return f
+}
+
+// best удаляет устаревшие записи и возвращает лучшего квалифицированного мастера по алгоритму bmca (nil — нет)
+func (fm foreignMasters) best(now time.Time, bmca BMCA) *ForeignMaster {
+	var best *ForeignMaster
+	for id, f := range fm {
+		if f.expired(now) {
+			delete(fm, id)
+			continue
+		}
+		if f.count < foreignMasterThreshold || f.StepsRemoved >= 255 {
+			continue
+		}
+		if best == nil || bmca.Compare(f.Dataset, best.Dataset) < 0 {
+			best = f
+		}
+	}
+	return best
+}
@@ -0,0 +1,86 @@
+package ptp
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DiscoveredDomain — домен, в котором обнаружен мастер multicast
+type DiscoveredDomain struct {
+	Domain  uint8
+	Best    ForeignMaster // лучший мастер домена (BMCA IEEE 1588)
+	Masters int           // число мастеров, от которых приходят Announce
+}
+
+// Discovery обнаруживает мастеров multicast по Announce (auto_discover_enabled): для каждого домена
+// ведётся таблица мастеров; домен пропадает, когда Announce всех его мастеров прекратились
+// (announceReceiptTimeout интервалов).
+type Discovery struct {
+	tr     Transport
+	ignore ClockIdentity // собственные часы (порт master на том же интерфейсе)
+
+	mu      sync.Mutex
+	domains map[uint8]foreignMasters
+}
+
+// NewDiscovery создаёт обнаружение на транспорте tr; Announce от часов ignore не учитываются
+func NewDiscovery(tr Transport, ignore ClockIdentity) *Discovery {
+	return &Discovery{tr: tr, ignore: ignore, domains: make(map[uint8]foreignMasters)}
+}
+
+// Run принимает Announce до отмены ctx или закрытия транспорта
+func (d *Discovery) Run(ctx context.Context) error {
+	packets := d.tr.Packets()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case p, ok := <-packets:
+			if !ok {
+				return nil
+			}
+			d.handle(p, time.Now())
+		}
+	}
+}
+
+func (d *Discovery) handle(p Packet, now time.Time) {
+	if p.Event {
+		return
+	}
+	m, err := Unmarshal(p.Data)
+	// majorSdoId 1 — gPTP: такие домены обслуживаются только профилем gptp
+	if err != nil || m.Type != MsgAnnounce || m.SdoID != 0 || m.Source.Clock == d.ignore {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	fm := d.domains[m.Domain]
+	if fm == nil {
+		fm = make(foreignMasters)
+		d.domains[m.Domain] = fm
+	}
+	fm.add(m, p.Src, PortIdentity{}, now)
+}
+
+// Domains возвращает домены с квалифицированным мастером (по возрастанию номера); устаревшие
+// записи удаляются
+func (d *Discovery) Domains(now time.Time) []DiscoveredDomain {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var out []DiscoveredDomain
+	for domain, fm := range d.domains {
+		best := fm.best(now, BMCADefault)
+		if len(fm) == 0 {
+			delete(d.domains, domain)
+		}
+		if best == nil {
+			continue
+		}
+		out = append(out, DiscoveredDomain{Domain: domain, Best: *best, Masters: len(fm)})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Domain < out[j].Domain })
+	return out
+}
@@ -0,0 +1,198 @@
+package ptp
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/shiwa/timecard-mini/tc-sync/internal/timestamping"
+)
+
+// EtherType PTP (IEEE 1588-2008, приложение F)
+const EtherType = 0x88F7
+
+// Адреса multicast Ethernet (IEEE 1588-2008, приложение F)
+var (
+	MACPrimary   = net.HardwareAddr{0x01, 0x1B, 0x19, 0x00, 0x00, 0x00} // все сообщения, кроме peer delay
+	MACPeerDelay = net.HardwareAddr{0x01, 0x80, 0xC2, 0x00, 0x00, 0x0E} // peer delay; в G.8275.1 — все сообщения (не пересылается мостами)
+)
+
+// L2Addr — MAC адрес отправителя или получателя в транспорте Ethernet
+type L2Addr struct {
+	MAC net.HardwareAddr
+}
+
+// Network возвращает "ethernet"
+func (a *L2Addr) Network() string {
+	return "ethernet"
+}
+
+func (a *L2Addr) String() string {
+	return a.MAC.String()
+}
+
+// L2Config — параметры транспорта Ethernet
+type L2Config struct {
+	Interface string           // сетевой интерфейс (обязателен)
+	DstMAC    net.HardwareAddr // multicast адрес сообщений, кроме peer delay; nil — MACPrimary
+	Hardware  bool             // аппаратные метки; без поддержки сетевой картой — метки ядра
+}
+
+// L2Transport — PTP поверх Ethernet (IEEE 1588-2008, приложение F): один сокет AF_PACKET
+// с EtherType 0x88F7 для event и general сообщений, метки времени приёма и передачи.
+// Обработка сообщений та же, что у UDP; адреса отправителей — *L2Addr.
+type L2Transport struct {
+	cfg     L2Config
+	ifi     *net.Interface
+	file    *os.File
+	raw     syscall.RawConn
+	ts      *timestamping.Socket
+	packets chan Packet
+	mu      sync.Mutex // отправка: метка передачи относится к последнему кадру сокета
+
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+// NewL2Transport открывает сокет на интерфейсе и подписывается на адреса multicast PTP
+func NewL2Transport(cfg L2Config) (*L2Transport, error) {
+	if cfg.Interface == "" {
+		return nil, errors.New("ptp: layer 2 transport requires an interface")
+	}
+	if cfg.DstMAC == nil {
+		cfg.DstMAC = MACPrimary
+	}
+	ifi, err := net.InterfaceByName(cfg.Interface)
+	if err != nil {
+		return nil, err
+	}
+	f, err := openPacketSocket(ifi, cfg.DstMAC, MACPeerDelay)
+	if err != nil {
+		return nil, fmt.Errorf("ptp: layer 2 on %s: %w", cfg.Interface, err)
+	}
+	raw, err := f.SyscallConn()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	t := &L2Transport{
+		cfg:     cfg,
+		ifi:     ifi,
+		file:    f,
+		raw:     raw,
+		ts:      timestamping.NewSocket(raw, timestamping.Options{TX: true, Hardware: cfg.Hardware, Interface: cfg.Interface, Packet: true}),
+		packets: make(chan Packet, 64),
+		done:    make(chan struct{}),
+	}
+	go t.read()
+	return t, nil
+}
+
+// read читает кадры до закрытия сокета и передаёт сообщения в канал
+func (t *L2Transport) read() {
+	defer close(t.packets)
+	buf := make([]byte, 1500)
+	oob := make([]byte, 512)
+	var delay time.Duration
+	for {
+		n, oobn, src, err := recvFrame(t.raw, buf, oob)
+		if err != nil {
+			if errors.Is(err, os.ErrClosed) {
+				return
+			}
+			var ok bool
+			if delay, ok = readBackoff("l2 "+t.cfg.Interface, err, delay, t.done); !ok {
+				return
+			}
+			continue
+		}
+		delay = 0
+		if src == nil || n < 1 {
+			continue // собственный исходящий кадр
+		}
+		ts := t.ts.RXStamp(oob[:oobn], time.Now())
+		select {
+		case t.packets <- Packet{
+			Data:  append([]byte(nil), buf[:n]...),
+			Src:   &L2Addr{MAC: src},
+			Stamp: ts,
+			Event: MessageType(buf[0] & 0x0f).IsEvent(),
+		}:
+		case <-t.done:
+			return
+		}
+	}
+}
+
+// Packets возвращает канал принятых сообщений
+func (t *L2Transport) Packets() <-chan Packet {
+	return t.packets
+}
+
+// LocalAddr возвращает MAC адрес интерфейса
+func (t *L2Transport) LocalAddr() net.Addr {
+	return &L2Addr{MAC: t.ifi.HardwareAddr}
+}
+
+// TimestampType возвращает тип меток (худший из приёма и передачи)
+func (t *L2Transport) TimestampType() timestamping.Type {
+	if tx := t.ts.TXType(); tx < t.ts.RXType() {
+		return tx
+	}
+	return t.ts.RXType()
+}
+
+// dest вычисляет MAC получателя: dst == nil — multicast (для peer delay — MACPeerDelay)
+func (t *L2Transport) dest(b []byte, dst net.Addr) (net.HardwareAddr, error) {
+	switch a := dst.(type) {
+	case nil:
+		if len(b) > 0 && isPeerDelay(MessageType(b[0]&0x0f)) {
+			return MACPeerDelay, nil
+		}
+		return t.cfg.DstMAC, nil
+	case *L2Addr:
+		return a.MAC, nil
+	}
+	return nil, fmt.Errorf("ptp: unsupported address %v", dst)
+}
+
+// SendEvent отправляет event сообщение и возвращает метку передачи
+func (t *L2Transport) SendEvent(b []byte, dst net.Addr) (timestamping.Stamp, error) {
+	mac, err := t.dest(b, dst)
+	if err != nil {
+		return timestamping.Stamp{}, err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.ts.Write(func() error {
+		return sendFrame(t.raw, t.ifi.Index, mac, b)
+	})
+}
+
+// SendGeneral отправляет general сообщение
+func (t *L2Transport) SendGeneral(b []byte, dst net.Addr) error {
+	mac, err := t.dest(b, dst)
+	if err != nil {
+		return err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err := sendFrame(t.raw, t.ifi.Index, mac, b); err != nil {
+		return err
+	}
+	t.ts.Sent()
+	return nil
+}
+
+// Close закрывает сокет; канал Packets закрывается после завершения чтения
+func (t *L2Transport) Close() error {
+	t.closeOnce.Do(func() {
+		close(t.done)
+		t.file.Close()
+	})
+	return nil
+}
@@ -0,0 +1,74 @@
+//go:build linux
+
+package ptp
+
+import (
+	"net"
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// htons — EtherType в сетевом порядке байт для sockaddr_ll
+func htons(v uint16) uint16 {
+	return v<<8 | v>>8
+}
+
+// openPacketSocket открывает неблокирующий сокет AF_PACKET/SOCK_DGRAM (заголовок Ethernet
+// формирует ядро), привязанный к EtherType PTP на интерфейсе, и подписывает его на адреса macs
+func openPacketSocket(ifi *net.Interface, macs ...net.HardwareAddr) (*os.File, error) {
+	proto := htons(EtherType)
+	fd, err := unix.Socket(unix.AF_PACKET, unix.SOCK_DGRAM|unix.SOCK_NONBLOCK|unix.SOCK_CLOEXEC, int(proto))
+	if err != nil {
+		return nil, os.NewSyscallError("socket", err)
+	}
+	if err := unix.Bind(fd, &unix.SockaddrLinklayer{Protocol: proto, Ifindex: ifi.Index}); err != nil {
+		unix.Close(fd)
+		return nil, os.NewSyscallError("bind", err)
+	}
+	for _, mac := range macs {
+		mreq := &unix.PacketMreq{Ifindex: int32(ifi.Index), Type: unix.PACKET_MR_MULTICAST, Alen: uint16(len(mac))}
+		copy(mreq.Address[:], mac)
+		if err := unix.SetsockoptPacketMreq(fd, unix.SOL_PACKET, unix.PACKET_ADD_MEMBERSHIP, mreq); err != nil {
+			unix.Close(fd)
+			return nil, os.NewSyscallError("setsockopt PACKET_ADD_MEMBERSHIP", err)
+		}
+	}
+	return os.NewFile(uintptr(fd), "ptp-l2:"+ifi.Name), nil
+}
+
+// recvFrame читает кадр (ждёт через poller Go). src == nil — копия собственного исходящего кадра.
+func recvFrame(raw syscall.RawConn, buf, oob []byte) (n, oobn int, src net.HardwareAddr, err error) {
+	var from unix.Sockaddr
+	var opErr error
+	err = raw.Read(func(fd uintptr) bool {
+		n, oobn, _, from, opErr = unix.Recvmsg(int(fd), buf, oob, 0)
+		return opErr != unix.EAGAIN
+	})
+	if err == nil {
+		err = opErr
+	}
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	if ll, ok := from.(*unix.SockaddrLinklayer); ok && ll.Pkttype != unix.PACKET_OUTGOING {
+		src = append(net.HardwareAddr(nil), ll.Addr[:ll.Halen]...)
+	}
+	return n, oobn, src, nil
+}
+
+// sendFrame отправляет кадр с EtherType PTP на адрес mac
+func sendFrame(raw syscall.RawConn, ifindex int, mac net.HardwareAddr, b []byte) error {
+	sa := &unix.SockaddrLinklayer{Protocol: htons(EtherType), Ifindex: ifindex, Halen: uint8(len(mac))}
+	copy(sa.Addr[:], mac)
+	var opErr error
+	err := raw.Write(func(fd uintptr) bool {
+		opErr = unix.Sendto(int(fd), b, 0, sa)
+		return opErr != unix.EAGAIN
+	})
+	if err != nil {
+		return err
+	}
+	return opErr
+}
@@ -0,0 +1,25 @@
+//go:build !linux
+
+package ptp
+
+import (
+	"errors"
+	"net"
+	"os"
+	"syscall"
+)
+
+var errNoL2 = errors.New("layer 2 transport is supported only on linux")
+
+// openPacketSocket — сокеты AF_PACKET есть только на Linux
+func openPacketSocket(ifi *net.Interface, macs ...net.HardwareAddr) (*os.File, error) {
+	return nil, errNoL2
+}
+
+func recvFrame(raw syscall.RawConn, buf, oob []byte) (n, oobn int, src net.HardwareAddr, err error) {
+	return 0, 0, nil, errNoL2
+}
+
+func sendFrame(raw syscall.RawConn, ifindex int, mac net.HardwareAddr, b []byte) error {
+	return errNoL2
+}
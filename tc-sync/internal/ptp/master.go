@@ -0,0 +1,560 @@
+package ptp
+
+import (
+	"context"
+	"math"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/shiwa/timecard-mini/tc-sync/internal/timestamping"
+)
+
+// CurrentUTCOffset — TAI − UTC в секундах (с 2017-01-01)
+const CurrentUTCOffset = 37
+
+// Значения timeSource (IEEE 1588-2008, 7.6.2.6)
+const (
+	TimeSourceAtomicClock        uint8 = 0x10
+	TimeSourceGPS                uint8 = 0x20
+	TimeSourceTerrestrialRadio   uint8 = 0x30
+	TimeSourcePTP                uint8 = 0x40
+	TimeSourceNTP                uint8 = 0x50
+	TimeSourceHandSet            uint8 = 0x60
+	TimeSourceOther              uint8 = 0x90
+	TimeSourceInternalOscillator uint8 = 0xA0
+)
+
+// Значения clockClass (IEEE 1588-2008, 7.6.2.4)
+const (
+	ClockClassPrimary   uint8 = 6   // синхронизирован с первичным эталоном (GNSS)
+	ClockClassHoldover  uint8 = 7   // эталон потерян, удержание в пределах спецификации
+	ClockClassDefault   uint8 = 248 // не синхронизирован
+	ClockClassSlaveOnly uint8 = 255
+)
+
+// clockClass удержания вне спецификации, категории 1–3 (G.8275.1, таблица 2)
+const (
+	ClockClassOutOfSpec1 uint8 = 140
+	ClockClassOutOfSpec2 uint8 = 150
+	ClockClassOutOfSpec3 uint8 = 160
+)
+
+// AccuracyUnknown — clockAccuracy «неизвестно»
+const AccuracyUnknown uint8 = 0xFE
+
+// accuracySteps — верхние границы clockAccuracy 0x20 (25 нс) … 0x31 (> 10 с)
+var accuracySteps = []time.Duration{
+	25 * time.Nanosecond, 100 * time.Nanosecond, 250 * time.Nanosecond,
+	time.Microsecond, 2500 * time.Nanosecond, 10 * time.Microsecond,
+	25 * time.Microsecond, 100 * time.Microsecond, 250 * time.Microsecond,
+	time.Millisecond, 2500 * time.Microsecond, 10 * time.Millisecond,
+	25 * time.Millisecond, 100 * time.Millisecond, 250 * time.Millisecond,
+	time.Second, 10 * time.Second,
+}
+
+// AccuracyFor возвращает наименьший код clockAccuracy, покрывающий ошибку d
+func AccuracyFor(d time.Duration) uint8 {
+	if d < 0 {
+		d = -d
+	}
+	for i, limit := range accuracySteps {
+		if d <= limit {
+			return 0x20 + uint8(i)
+		}
+	}
+	return 0x31
+}
+
+// VarianceFor возвращает offsetScaledLogVariance (IEEE 1588-2008, 7.6.3) по вариации Аллана avar
+// при интервале tau: дисперсия PTP σ²PTP = τ²·σ²y(τ)/3, значение — log2(σ²PTP в с²) в единицах 2^-8
+// со смещением 0x8000
+func VarianceFor(avar float64, tau time.Duration) uint16 {
+	v := tau.Seconds() * tau.Seconds() * avar / 3
+	if v <= 0 {
+		return 0
+	}
+	scaled := math.Round(math.Log2(v)*256) + 0x8000
+	switch {
+	case scaled < 0:
+		return 0
+	case scaled > 0xFFFE:
+		return 0xFFFE
+	}
+	return uint16(scaled)
+}
+
+// TimeProperties — качество часов grandmaster и свойства шкалы, объявляемые в Announce
+type TimeProperties struct {
+	Quality            ClockQuality
+	TimeSource         uint8
+	CurrentUTCOffset   int16
+	UTCOffsetValid     bool
+	Leap61             bool
+	Leap59             bool
+	TimeTraceable      bool
+	FrequencyTraceable bool
+}
+
+// DefaultTimeProperties — свойства несинхронизированных часов (clockClass 248, внутренний генератор)
+func DefaultTimeProperties() TimeProperties {
+	return TimeProperties{
+		Quality:          ClockQuality{Class: ClockClassDefault, Accuracy: AccuracyUnknown, Variance: 0xFFFF},
+		TimeSource:       TimeSourceInternalOscillator,
+		CurrentUTCOffset: CurrentUTCOffset,
+	}
+}
+
+// flags — биты flagField октета 1 для Announce (шкала всегда PTP/TAI)
+func (tp TimeProperties) flags() uint16 {
+	f := FlagPTPTimescale
+	if tp.UTCOffsetValid {
+		f |= FlagCurrentUTCOffsetValid
+	}
+	if tp.Leap61 {
+		f |= FlagLeap61
+	}
+	if tp.Leap59 {
+		f |= FlagLeap59
+	}
+	if tp.TimeTraceable {
+		f |= FlagTimeTraceable
+	}
+	if tp.FrequencyTraceable {
+		f |= FlagFrequencyTraceable
+	}
+	return f
+}
+
+// MasterConfig — параметры порта master
+type MasterConfig struct {
+	Domain    uint8
+	Identity  PortIdentity // нулевой clockIdentity — DefaultClockIdentity(""), порт 1
+	Priority1 uint8
+	Priority2 uint8
+	// Интервалы в log2 секунд: Announce, Sync и logMinDelayReqInterval, объявляемый в Delay_Resp
+	LogAnnounceInterval    int8
+	LogSyncInterval        int8
+	LogMinDelayReqInterval int8
+	Multicast              bool // Announce/Sync на 224.0.1.129, ответы на multicast и unicast (hybrid E2E) Delay_Req
+	Unicast                bool // согласование unicast передачи (Signaling) и ответы на unicast Delay_Req
+	ServerOnly             bool // не уступать лучшему мастеру: без BMCA порт всегда master
+	// MaxUnicastSubscribers — предел числа unicast клиентов с разрешениями; 0 — без ограничения
+	MaxUnicastSubscribers int
+	// MaxPacketsPerSecond — порог входящей частоты Delay_Req и Signaling, выше которого запросы
+	// отбрасываются по WRED (max_packets_per_second); 0 — без ограничения
+	MaxPacketsPerSecond int
+	// BMCA — алгоритм сравнения с другими мастерами домена; ClockClasses — clockClass по состоянию
+	// синхронизации (нулевое значение — DefaultClockClasses)
+	BMCA         BMCA
+	ClockClasses ClockClasses
+	// DelayMechanism — DelayE2E ("") или DelayP2P: порт отвечает на Pdelay_Req и сам измеряет задержку
+	// линии, Delay_Req игнорируются. PeerDelay.GPTP — режим 802.1AS: majorSdoId 1, Announce с path
+	// trace, Follow_Up с TLV Follow_Up information, Announce и Sync передаются только при asCapable.
+	DelayMechanism string
+	PeerDelay      PeerDelayConfig
+	// TxQueue — очередь передачи интерфейса (synchronise_tx); nil — отправка напрямую
+	TxQueue *TxQueue
+	// Auth — аутентификация (TLV AUTHENTICATION), как у SlaveConfig: запросы клиентов без верного
+	// TLV отбрасываются
+	Auth *SecurityAssociation
+	// PHC — устройство PHC сетевой карты: аппаратные метки пересчитываются из шкалы PHC
+	// в системное время; пусто — метки уже в системном времени (ядро)
+	PHC string
+}
+
+// Master — порт ordinary clock в роли master (grandmaster, E2E, two-step): рассылает Announce,
+// Sync и Follow_Up и отвечает на Delay_Req. Время — системные часы в шкале PTP (UTC + currentUtcOffset).
+// Без ServerOnly порт слушает Announce других мастеров и переходит в passive, если в домене есть лучший.
+type Master struct {
+	tr    Transport
+	cfg   MasterConfig
+	stats *portStats
+	admit *admission
+
+	// Состояние (только в Run)
+	foreign      foreignMasters
+	announceSeq  uint16
+	syncSeq      uint16
+	signalingSeq uint16
+	sdoID        uint8
+	pdelay       *peerDelay // P2P; nil — E2E
+
+	mu    sync.Mutex
+	props TimeProperties
+	state PortState
+	subs  subscriptions
+}
+
+// NewMaster создаёт порт master поверх транспорта
+func NewMaster(tr Transport, cfg MasterConfig) *Master {
+	if cfg.Identity.Clock == (ClockIdentity{}) {
+		cfg.Identity.Clock = DefaultClockIdentity("")
+	}
+	if cfg.Identity.Port == 0 {
+		cfg.Identity.Port = 1
+	}
+	if cfg.ClockClasses == (ClockClasses{}) {
+		cfg.ClockClasses = DefaultClockClasses
+	}
+	stats := newPortStats()
+	if cfg.Multicast {
+		stats.setDesiredSync(cfg.LogSyncInterval)
+	}
+	if cfg.TxQueue != nil {
+		tr = cfg.TxQueue.Transport(tr)
+	}
+	if cfg.Auth != nil {
+		tr = authTransport{tr, cfg.Auth}
+	}
+	tr = countingTransport{tr, stats}
+	m := &Master{tr: tr, cfg: cfg, stats: stats, admit: newAdmission(cfg.MaxPacketsPerSecond), foreign: make(foreignMasters), props: DefaultTimeProperties(), state: StateMaster,
+		subs: subscriptions{max: cfg.MaxUnicastSubscribers}}
+	if cfg.PeerDelay.GPTP {
+		m.sdoID = SdoIDGPTP
+	}
+	if cfg.DelayMechanism == DelayP2P {
+		m.pdelay = newPeerDelay(tr, cfg.Identity, cfg.Domain, m.sdoID, cfg.PeerDelay)
+	}
+	return m
+}
+
+// Identity возвращает идентификатор порта
+func (m *Master) Identity() PortIdentity {
+	return m.cfg.Identity
+}
+
+// State возвращает состояние порта: master или passive
+func (m *Master) State() PortState {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.state
+}
+
+// TimeProperties возвращает объявляемые качество часов и свойства шкалы
+func (m *Master) TimeProperties() TimeProperties {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.props
+}
+
+// SetTimeProperties задаёт качество часов и свойства шкалы для следующих Announce
+func (m *Master) SetTimeProperties(tp TimeProperties) {
+	m.mu.Lock()
+	m.props = tp
+	m.mu.Unlock()
+}
+
+// ClockClasses возвращает clockClass, объявляемые по состоянию синхронизации (по профилю)
+func (m *Master) ClockClasses() ClockClasses {
+	return m.cfg.ClockClasses
+}
+
+// Stats возвращает счётчики сообщений порта (по типам, по отправителям)
+func (m *Master) Stats() PortStats {
+	return m.stats.snapshot()
+}
+
+// Admission возвращает учёт входящих Delay_Req и Signaling (max_packets_per_second)
+func (m *Master) Admission() AdmissionStats {
+	return m.admit.snapshot(time.Now())
+}
+
+// PeerDelay возвращает состояние измерения задержки линии (P2P); ok=false — механизм E2E
+func (m *Master) PeerDelay() (PeerDelayStatus, bool) {
+	if m.pdelay == nil {
+		return PeerDelayStatus{}, false
+	}
+	return m.pdelay.Status(), true
+}
+
+// asCapable — gPTP: Announce и Sync передаются только при asCapable; вне gPTP всегда true
+func (m *Master) asCapable() bool {
+	return !m.cfg.PeerDelay.GPTP || m.pdelay.Status().ASCapable
+}
+
+// Subscriptions возвращает таблицу разрешений unicast передачи (отсортирована по клиенту и типу)
+func (m *Master) Subscriptions() []Subscription {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.subs.list()
+}
+
+// Dataset возвращает собственный набор данных для BMCA
+func (m *Master) Dataset() Dataset {
+	tp := m.TimeProperties()
+	return Dataset{
+		Priority1:           m.cfg.Priority1,
+		Quality:             tp.Quality,
+		Priority2:           m.cfg.Priority2,
+		GrandmasterIdentity: m.cfg.Identity.Clock,
+		LocalPriority:       DefaultLocalPriority,
+		Sender:              m.cfg.Identity,
+		Receiver:            m.cfg.Identity,
+	}
+}
+
+// Run рассылает Announce/Sync и обрабатывает сообщения до отмены ctx или закрытия транспорта
+func (m *Master) Run(ctx context.Context) error {
+	announce := time.NewTicker(LogInterval(m.cfg.LogAnnounceInterval))
+	defer announce.Stop()
+	syncTick := time.NewTicker(LogInterval(m.cfg.LogSyncInterval))
+	defer syncTick.Stop()
+	unicast := time.NewTimer(time.Hour)
+	defer unicast.Stop()
+	var pdelayTick <-chan time.Time
+	if m.pdelay != nil {
+		t := time.NewTicker(LogInterval(m.cfg.PeerDelay.LogInterval))
+		defer t.Stop()
+		pdelayTick = t.C
+		m.pdelay.tick(time.Now())
+	}
+	packets := m.tr.Packets()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case p, ok := <-packets:
+			if !ok {
+				return nil
+			}
+			m.handle(p, time.Now())
+		case now := <-announce.C:
+			m.updateState(now)
+			if m.cfg.Multicast && m.State() == StateMaster && m.asCapable() {
+				m.announceSeq++
+				m.sendAnnounce(nil, 0, m.announceSeq, m.cfg.LogAnnounceInterval)
+			}
+		case <-syncTick.C:
+			if m.cfg.Multicast && m.State() == StateMaster && m.asCapable() {
+				m.syncSeq++
+				m.sendSync(nil, 0, m.syncSeq, m.cfg.LogSyncInterval)
+			}
+		case now := <-unicast.C:
+			m.serveUnicast(now)
+		case now := <-pdelayTick:
+			m.pdelay.tick(now)
+		}
+		m.scheduleUnicast(unicast)
+	}
+}
+
+// scheduleUnicast взводит таймер на ближайшую передачу по разрешениям
+func (m *Master) scheduleUnicast(t *time.Timer) {
+	m.mu.Lock()
+	next := m.subs.nextDue()
+	m.mu.Unlock()
+	d := time.Until(next)
+	if next.IsZero() {
+		d = time.Second // проверка истечения разрешений
+	}
+	if !t.Stop() {
+		select {
+		case <-t.C:
+		default:
+		}
+	}
+	t.Reset(d)
+}
+
+// serveUnicast удаляет истёкшие разрешения и передаёт Announce/Sync клиентам, которым пора
+func (m *Master) serveUnicast(now time.Time) {
+	m.mu.Lock()
+	m.subs.expire(now)
+	due := m.subs.due(now)
+	type send struct {
+		addr     net.Addr
+		typ      MessageType
+		seq      uint16
+		interval int8
+	}
+	sends := make([]send, len(due))
+	for i, s := range due {
+		sends[i] = send{s.Addr, s.Type, s.seq, s.LogInterval}
+	}
+	master := m.state == StateMaster
+	m.mu.Unlock()
+	if !master {
+		return
+	}
+	for _, s := range sends {
+		if s.typ == MsgAnnounce {
+			m.sendAnnounce(s.addr, FlagUnicast, s.seq, s.interval)
+		} else {
+			m.sendSync(s.addr, FlagUnicast, s.seq, s.interval)
+		}
+	}
+}
+
+// handle обрабатывает Announce других мастеров (BMCA) и Delay_Req
+func (m *Master) handle(p Packet, now time.Time) {
+	msg, err := Unmarshal(p.Data)
+	if err != nil || msg.Domain != m.cfg.Domain || msg.SdoID != m.sdoID || msg.Source.Clock == m.cfg.Identity.Clock {
+		return
+	}
+	if m.cfg.Auth != nil && m.cfg.Auth.Verify(p.Data, msg, now) != nil {
+		m.stats.unauthenticated(msg, p.Src, now)
+		return
+	}
+	m.stats.received(msg, p.Src, now)
+	if m.pdelay != nil && m.pdelay.handle(p, msg) {
+		return
+	}
+	switch msg.Type {
+	case MsgAnnounce:
+		if m.cfg.ServerOnly {
+			return
+		}
+		m.foreign.add(msg, p.Src, m.cfg.Identity, now)
+		m.updateState(now)
+	case MsgDelayReq:
+		if p.Event && m.pdelay == nil && m.State() == StateMaster && m.admit.allow(p.Src, now) {
+			m.handleDelayReq(msg, p)
+		}
+	case MsgSignaling:
+		if m.cfg.Unicast && (msg.Port == AllPorts || msg.Port == m.cfg.Identity) && m.admit.allow(p.Src, now) {
+			m.handleSignaling(msg, p.Src, now)
+		}
+	}
+}
+
+// handleSignaling отвечает на REQUEST (GRANT или отказ по max_unicast_subscribers) и CANCEL
+// (ACKNOWLEDGE_CANCEL) одним сообщением Signaling
+func (m *Master) handleSignaling(msg *Message, src net.Addr, now time.Time) {
+	var tlvs []TLV
+	m.mu.Lock()
+	for _, t := range msg.TLVs {
+		u, ok := ParseUnicastTLV(t)
+		if !ok {
+			continue
+		}
+		switch u.Kind {
+		case TLVRequestUnicast:
+			tlvs = append(tlvs, m.subs.grant(u, msg.Source, src, now).TLV())
+		case TLVCancelUnicast:
+			m.subs.cancel(msg.Source, u.Type)
+			tlvs = append(tlvs, UnicastTLV{Kind: TLVAckCancelUnicast, Type: u.Type}.TLV())
+		}
+	}
+	m.mu.Unlock()
+	if len(tlvs) == 0 {
+		return
+	}
+	m.signalingSeq++
+	resp := Message{
+		Header: m.header(MsgSignaling, m.signalingSeq, LogIntervalUnset, FlagUnicast),
+		Port:   msg.Source,
+		TLVs:   tlvs,
+	}
+	_ = m.tr.SendGeneral(resp.Marshal(), src)
+}
+
+// updateState — BMCA: passive, если есть квалифицированный мастер лучше собственного набора данных
+func (m *Master) updateState(now time.Time) {
+	state := StateMaster
+	if !m.cfg.ServerOnly {
+		if best := m.foreign.best(now, m.cfg.BMCA); best != nil && m.cfg.BMCA.Compare(best.Dataset, m.Dataset()) < 0 {
+			state = StatePassive
+		}
+	}
+	m.mu.Lock()
+	m.state = state
+	m.mu.Unlock()
+}
+
+// ptpTime переводит метку в шкалу PTP: PHC → системное время, затем + currentUtcOffset
+func (m *Master) ptpTime(st timestamping.Stamp, utcOffset int16) (Timestamp, bool) {
+	t := st.Time
+	if st.Type == timestamping.Hardware && m.cfg.PHC != "" {
+		phcSys, err := PHCSystemOffset(m.cfg.PHC)
+		if err != nil {
+			return Timestamp{}, false
+		}
+		t = t.Add(-phcSys)
+	}
+	return NewTimestamp(t.Add(time.Duration(utcOffset) * time.Second)), true
+}
+
+// header заполняет общие поля сообщения мастера
+func (m *Master) header(t MessageType, seq uint16, logInterval int8, flags uint16) Header {
+	return Header{
+		SdoID:          m.sdoID,
+		Type:           t,
+		Domain:         m.cfg.Domain,
+		Flags:          flags,
+		Source:         m.cfg.Identity,
+		Sequence:       seq,
+		LogMsgInterval: logInterval,
+	}
+}
+
+// sendAnnounce отправляет Announce; dst == nil — multicast. flags — дополнительные флаги (unicast).
+func (m *Master) sendAnnounce(dst net.Addr, flags uint16, seq uint16, logInterval int8) {
+	tp := m.TimeProperties()
+	msg := Message{
+		Header:    m.header(MsgAnnounce, seq, logInterval, tp.flags()|flags),
+		Timestamp: NewTimestamp(time.Now().Add(time.Duration(tp.CurrentUTCOffset) * time.Second)),
+		Announce: AnnounceBody{
+			CurrentUTCOffset:     tp.CurrentUTCOffset,
+			GrandmasterPriority1: m.cfg.Priority1,
+			GrandmasterQuality:   tp.Quality,
+			GrandmasterPriority2: m.cfg.Priority2,
+			GrandmasterIdentity:  m.cfg.Identity.Clock,
+			TimeSource:           tp.TimeSource,
+		},
+	}
+	if m.cfg.PeerDelay.GPTP {
+		msg.TLVs = []TLV{pathTraceTLV(m.cfg.Identity.Clock)}
+	}
+	_ = m.tr.SendGeneral(msg.Marshal(), dst)
+}
+
+// sendSync отправляет Sync (two-step) и Follow_Up с точной меткой передачи
+func (m *Master) sendSync(dst net.Addr, flags uint16, seq uint16, logInterval int8) {
+	tp := m.TimeProperties()
+	sync := Message{
+		Header:    m.header(MsgSync, seq, logInterval, FlagTwoStep|flags),
+		Timestamp: NewTimestamp(time.Now().Add(time.Duration(tp.CurrentUTCOffset) * time.Second)),
+	}
+	tx, err := m.tr.SendEvent(sync.Marshal(), dst)
+	if err != nil {
+		return
+	}
+	origin, ok := m.ptpTime(tx, tp.CurrentUTCOffset)
+	if !ok {
+		return
+	}
+	fu := Message{
+		Header:    m.header(MsgFollowUp, seq, logInterval, flags),
+		Timestamp: origin,
+	}
+	if m.cfg.PeerDelay.GPTP {
+		fu.TLVs = []TLV{followUpInfoTLV()}
+	}
+	_ = m.tr.SendGeneral(fu.Marshal(), dst)
+}
+
+// handleDelayReq отвечает Delay_Resp с меткой приёма Delay_Req; correctionField копируется из запроса
+func (m *Master) handleDelayReq(req *Message, p Packet) {
+	// Unicast Delay_Req (по разрешению или hybrid E2E при multicast Sync) — unicast Delay_Resp
+	unicast := req.Flags&FlagUnicast != 0
+	if !unicast && !m.cfg.Multicast {
+		return
+	}
+	t4, ok := m.ptpTime(p.Stamp, m.TimeProperties().CurrentUTCOffset)
+	if !ok {
+		return
+	}
+	var dst net.Addr
+	var flags uint16
+	if unicast {
+		dst, flags = p.Src, FlagUnicast
+	}
+	resp := Message{
+		Header:    m.header(MsgDelayResp, req.Sequence, m.cfg.LogMinDelayReqInterval, flags),
+		Timestamp: t4,
+		Port:      req.Source,
+	}
+	resp.Correction = req.Correction
+	_ = m.tr.SendGeneral(resp.Marshal(), dst)
+}
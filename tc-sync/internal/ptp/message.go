@@ -0,0 +1,431 @@
+// Package ptp — реализация IEEE 1588-2008 (PTPv2): формат сообщений, транспорты UDP/IPv4 и UDP/IPv6 (порты 319/320)
+// и Ethernet (EtherType 0x88F7) с метками времени ядра или сетевой карты и ordinary clock в ролях slave
+// и master (Sync/Follow_Up/Delay_Req/Delay_Resp).
+package ptp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// Константы протокола
+const (
+	Version     = 2
+	HeaderSize  = 34
+	EventPort   = 319 // Sync, Delay_Req, Pdelay_Req, Pdelay_Resp — с метками времени
+	GeneralPort = 320 // Announce, Follow_Up, Delay_Resp, Signaling, Management
+)
+
+// Адреса multicast (IEEE 1588-2008, приложения D и E)
+var (
+	MulticastPrimary   = net.IPv4(224, 0, 1, 129) // все сообщения, кроме peer delay
+	MulticastPeerDelay = net.IPv4(224, 0, 0, 107) // Pdelay_Req/Resp/Resp_Follow_Up
+	// UDP/IPv6: основной адрес с глобальной областью (ff0e::181), peer delay — link-local (ff02::6b)
+	MulticastPrimaryIPv6   = net.ParseIP("ff0e::181")
+	MulticastPeerDelayIPv6 = net.ParseIP("ff02::6b")
+)
+
+// MessageType — тип сообщения (младшие 4 бита первого байта)
+type MessageType uint8
+
+const (
+	MsgSync               MessageType = 0x0
+	MsgDelayReq           MessageType = 0x1
+	MsgPdelayReq          MessageType = 0x2
+	MsgPdelayResp         MessageType = 0x3
+	MsgFollowUp           MessageType = 0x8
+	MsgDelayResp          MessageType = 0x9
+	MsgPdelayRespFollowUp MessageType = 0xA
+	MsgAnnounce           MessageType = 0xB
+	MsgSignaling          MessageType = 0xC
+	MsgManagement         MessageType = 0xD
+)
+
+func (t MessageType) String() string {
+	switch t {
+	case MsgSync:
+		return "Sync"
+	case MsgDelayReq:
+		return "Delay_Req"
+	case MsgPdelayReq:
+		return "Pdelay_Req"
+	case MsgPdelayResp:
+		return "Pdelay_Resp"
+	case MsgFollowUp:
+		return "Follow_Up"
+	case MsgDelayResp:
+		return "Delay_Resp"
+	case MsgPdelayRespFollowUp:
+		return "Pdelay_Resp_Follow_Up"
+	case MsgAnnounce:
+		return "Announce"
+	case MsgSignaling:
+		return "Signaling"
+	case MsgManagement:
+		return "Management"
+	default:
+		return fmt.Sprintf("type(%d)", uint8(t))
+	}
+}
+
+// IsEvent возвращает true для event сообщений (передаются на порт 319 и получают метки времени)
+func (t MessageType) IsEvent() bool {
+	return t < 0x8
+}
+
+// isPeerDelay возвращает true для сообщений peer delay (отдельный адрес multicast, не пересылаются мостами)
+func isPeerDelay(t MessageType) bool {
+	return t == MsgPdelayReq || t == MsgPdelayResp || t == MsgPdelayRespFollowUp
+}
+
+// controlField — значение поля controlField (устаревшее, но заполняется для совместимости с PTPv1)
+func (t MessageType) controlField() uint8 {
+	switch t {
+	case MsgSync:
+		return 0
+	case MsgDelayReq:
+		return 1
+	case MsgFollowUp:
+		return 2
+	case MsgDelayResp:
+		return 3
+	case MsgManagement:
+		return 4
+	default:
+		return 5
+	}
+}
+
+// Флаги flagField (старший байт — октет 0)
+const (
+	FlagAlternateMaster       uint16 = 0x0100
+	FlagTwoStep               uint16 = 0x0200
+	FlagUnicast               uint16 = 0x0400
+	FlagProfileSpecific1      uint16 = 0x2000
+	FlagProfileSpecific2      uint16 = 0x4000
+	FlagLeap61                uint16 = 0x0001
+	FlagLeap59                uint16 = 0x0002
+	FlagCurrentUTCOffsetValid uint16 = 0x0004
+	FlagPTPTimescale          uint16 = 0x0008
+	FlagTimeTraceable         uint16 = 0x0010
+	FlagFrequencyTraceable    uint16 = 0x0020
+)
+
+// LogIntervalUnset — logMessageInterval 0x7F: интервал не задан (Delay_Req, Signaling, Management)
+const LogIntervalUnset int8 = 0x7F
+
+// ClockIdentity — EUI-64 идентификатор часов
+type ClockIdentity [8]byte
+
+func (c ClockIdentity) String() string {
+	return fmt.Sprintf("%02x%02x%02x.%02x%02x.%02x%02x%02x", c[0], c[1], c[2], c[3], c[4], c[5], c[6], c[7])
+}
+
+// ClockIdentityFromMAC строит EUI-64 из MAC-адреса (вставка FF FE в середину, как linuxptp)
+func ClockIdentityFromMAC(mac net.HardwareAddr) ClockIdentity {
+	var c ClockIdentity
+	if len(mac) == 8 {
+		copy(c[:], mac)
+		return c
+	}
+	if len(mac) == 6 {
+		copy(c[0:3], mac[0:3])
+		c[3], c[4] = 0xFF, 0xFE
+		copy(c[5:8], mac[3:6])
+	}
+	return c
+}
+
+// PortIdentity — clockIdentity + номер порта
+type PortIdentity struct {
+	Clock ClockIdentity
+	Port  uint16
+}
+
+func (p PortIdentity) String() string {
+	return fmt.Sprintf("%s-%d", p.Clock, p.Port)
+}
+
+// Timestamp — метка времени PTP: 48 бит секунд и наносекунды (шкала PTP — TAI)
+type Timestamp struct {
+	Seconds     uint64
+	Nanoseconds uint32
+}
+
+// NewTimestamp переводит time.Time в метку PTP (без смены шкалы)
+func NewTimestamp(t time.Time) Timestamp {
+	return Timestamp{Seconds: uint64(t.Unix()) & 0xFFFFFFFFFFFF, Nanoseconds: uint32(t.Nanosecond())}
+}
+
+// Time переводит метку PTP в time.Time (без смены шкалы)
+func (ts Timestamp) Time() time.Time {
+	return time.Unix(int64(ts.Seconds), int64(ts.Nanoseconds)).UTC()
+}
+
+// IsZero возвращает true для нулевой метки
+func (ts Timestamp) IsZero() bool {
+	return ts.Seconds == 0 && ts.Nanoseconds == 0
+}
+
+// Correction — correctionField: наносекунды × 2^16
+type Correction int64
+
+// NewCorrection переводит time.Duration в correctionField
+func NewCorrection(d time.Duration) Correction {
+	return Correction(int64(d) << 16)
+}
+
+// Duration переводит correctionField в time.Duration (дробные наносекунды отбрасываются)
+func (c Correction) Duration() time.Duration {
+	return time.Duration(int64(c) >> 16)
+}
+
+// ClockQuality — качество часов grandmaster (clockClass, clockAccuracy, offsetScaledLogVariance)
+type ClockQuality struct {
+	Class    uint8
+	Accuracy uint8
+	Variance uint16
+}
+
+// AnnounceBody — тело Announce
+type AnnounceBody struct {
+	CurrentUTCOffset     int16
+	GrandmasterPriority1 uint8
+	GrandmasterQuality   ClockQuality
+	GrandmasterPriority2 uint8
+	GrandmasterIdentity  ClockIdentity
+	StepsRemoved         uint16
+	TimeSource           uint8
+}
+
+// TLV — type-length-value после тела сообщения (Announce, Signaling, Management)
+type TLV struct {
+	Type  uint16
+	Value []byte
+}
+
+// Header — общий заголовок сообщения PTP (34 байта)
+type Header struct {
+	SdoID          uint8 // majorSdoId (transportSpecific), 4 бита
+	Type           MessageType
+	Version        uint8 // 2
+	MinorVersion   uint8
+	Length         uint16 // заполняется Marshal
+	Domain         uint8
+	MinorSdoID     uint8
+	Flags          uint16
+	Correction     Correction
+	TypeSpecific   uint32
+	Source         PortIdentity
+	Sequence       uint16
+	LogMsgInterval int8
+}
+
+// Message — сообщение PTP. Заполняются поля, относящиеся к Type:
+// Timestamp — originTimestamp (Sync, Delay_Req, Pdelay_Req, Announce), preciseOriginTimestamp (Follow_Up),
+// receiveTimestamp (Delay_Resp), requestReceiptTimestamp (Pdelay_Resp), responseOriginTimestamp
+// (Pdelay_Resp_Follow_Up); Port — requestingPortIdentity (Delay_Resp, Pdelay_Resp*) или
+// targetPortIdentity (Signaling, Management).
+type Message struct {
+	Header
+	Timestamp Timestamp
+	Port      PortIdentity
+	Announce  AnnounceBody
+	// Management: startingBoundaryHops, boundaryHops, actionField
+	StartingBoundaryHops uint8
+	BoundaryHops         uint8
+	Action               uint8
+	TLVs                 []TLV
+}
+
+// Ошибки разбора
+var (
+	ErrShortMessage = errors.New("ptp: short message")
+	ErrVersion      = errors.New("ptp: unsupported version")
+	ErrBadTLV       = errors.New("ptp: malformed TLV")
+)
+
+// bodySize — размер тела сообщения без заголовка и TLV
+func bodySize(t MessageType) int {
+	switch t {
+	case MsgSync, MsgDelayReq, MsgFollowUp:
+		return 10
+	case MsgDelayResp, MsgPdelayReq, MsgPdelayResp, MsgPdelayRespFollowUp:
+		return 20
+	case MsgAnnounce:
+		return 30
+	case MsgSignaling:
+		return 10
+	case MsgManagement:
+		return 14
+	}
+	return 0
+}
+
+func putTimestamp(b []byte, ts Timestamp) {
+	binary.BigEndian.PutUint16(b[0:2], uint16(ts.Seconds>>32))
+	binary.BigEndian.PutUint32(b[2:6], uint32(ts.Seconds))
+	binary.BigEndian.PutUint32(b[6:10], ts.Nanoseconds)
+}
+
+func getTimestamp(b []byte) Timestamp {
+	return Timestamp{
+		Seconds:     uint64(binary.BigEndian.Uint16(b[0:2]))<<32 | uint64(binary.BigEndian.Uint32(b[2:6])),
+		Nanoseconds: binary.BigEndian.Uint32(b[6:10]),
+	}
+}
+
+func putPortIdentity(b []byte, p PortIdentity) {
+	copy(b[0:8], p.Clock[:])
+	binary.BigEndian.PutUint16(b[8:10], p.Port)
+}
+
+func getPortIdentity(b []byte) PortIdentity {
+	var p PortIdentity
+	copy(p.Clock[:], b[0:8])
+	p.Port = binary.BigEndian.Uint16(b[8:10])
+	return p
+}
+
+// Marshal кодирует сообщение; длина в заголовке вычисляется по типу и TLV
+func (m *Message) Marshal() []byte {
+	n := HeaderSize + bodySize(m.Type)
+	for _, t := range m.TLVs {
+		n += 4 + len(t.Value)
+	}
+	b := make([]byte, n)
+	version := m.Version
+	if version == 0 {
+		version = Version
+	}
+	b[0] = m.SdoID<<4 | byte(m.Type&0xF)
+	b[1] = m.MinorVersion<<4 | version&0xF
+	binary.BigEndian.PutUint16(b[2:4], uint16(n))
+	b[4] = m.Domain
+	b[5] = m.MinorSdoID
+	binary.BigEndian.PutUint16(b[6:8], m.Flags)
+	binary.BigEndian.PutUint64(b[8:16], uint64(m.Correction))
+	binary.BigEndian.PutUint32(b[16:20], m.TypeSpecific)
+	putPortIdentity(b[20:30], m.Source)
+	binary.BigEndian.PutUint16(b[30:32], m.Sequence)
+	b[32] = m.Type.controlField()
+	b[33] = byte(m.LogMsgInterval)
+
+	body := b[HeaderSize:]
+	switch m.Type {
+	case MsgSync, MsgDelayReq, MsgFollowUp, MsgPdelayReq:
+		putTimestamp(body, m.Timestamp)
+	case MsgDelayResp, MsgPdelayResp, MsgPdelayRespFollowUp:
+		putTimestamp(body, m.Timestamp)
+		putPortIdentity(body[10:20], m.Port)
+	case MsgAnnounce:
+		a := &m.Announce
+		putTimestamp(body, m.Timestamp)
+		binary.BigEndian.PutUint16(body[10:12], uint16(a.CurrentUTCOffset))
+		body[13] = a.GrandmasterPriority1
+		body[14] = a.GrandmasterQuality.Class
+		body[15] = a.GrandmasterQuality.Accuracy
+		binary.BigEndian.PutUint16(body[16:18], a.GrandmasterQuality.Variance)
+		body[18] = a.GrandmasterPriority2
+		copy(body[19:27], a.GrandmasterIdentity[:])
+		binary.BigEndian.PutUint16(body[27:29], a.StepsRemoved)
+		body[29] = a.TimeSource
+	case MsgSignaling:
+		putPortIdentity(body, m.Port)
+	case MsgManagement:
+		putPortIdentity(body, m.Port)
+		body[10] = m.StartingBoundaryHops
+		body[11] = m.BoundaryHops
+		body[12] = m.Action & 0xF
+	}
+	off := HeaderSize + bodySize(m.Type)
+	for _, t := range m.TLVs {
+		binary.BigEndian.PutUint16(b[off:], t.Type)
+		binary.BigEndian.PutUint16(b[off+2:], uint16(len(t.Value)))
+		copy(b[off+4:], t.Value)
+		off += 4 + len(t.Value)
+	}
+	return b
+}
+
+// Unmarshal разбирает сообщение PTPv2. Байты после messageLength (например, паддинг Ethernet) игнорируются.
+func Unmarshal(b []byte) (*Message, error) {
+	if len(b) < HeaderSize {
+		return nil, ErrShortMessage
+	}
+	m := &Message{}
+	m.SdoID = b[0] >> 4
+	m.Type = MessageType(b[0] & 0xF)
+	m.Version = b[1] & 0xF
+	m.MinorVersion = b[1] >> 4
+	if m.Version != Version {
+		return nil, ErrVersion
+	}
+	m.Length = binary.BigEndian.Uint16(b[2:4])
+	m.Domain = b[4]
+	m.MinorSdoID = b[5]
+	m.Flags = binary.BigEndian.Uint16(b[6:8])
+	m.Correction = Correction(binary.BigEndian.Uint64(b[8:16]))
+	m.TypeSpecific = binary.BigEndian.Uint32(b[16:20])
+	m.Source = getPortIdentity(b[20:30])
+	m.Sequence = binary.BigEndian.Uint16(b[30:32])
+	m.LogMsgInterval = int8(b[33])
+
+	n := int(m.Length)
+	if n < HeaderSize+bodySize(m.Type) || n > len(b) {
+		return nil, ErrShortMessage
+	}
+	b = b[:n]
+	body := b[HeaderSize:]
+	switch m.Type {
+	case MsgSync, MsgDelayReq, MsgFollowUp, MsgPdelayReq:
+		m.Timestamp = getTimestamp(body)
+	case MsgDelayResp, MsgPdelayResp, MsgPdelayRespFollowUp:
+		m.Timestamp = getTimestamp(body)
+		m.Port = getPortIdentity(body[10:20])
+	case MsgAnnounce:
+		m.Timestamp = getTimestamp(body)
+		a := &m.Announce
+		a.CurrentUTCOffset = int16(binary.BigEndian.Uint16(body[10:12]))
+		a.GrandmasterPriority1 = body[13]
+		a.GrandmasterQuality = ClockQuality{Class: body[14], Accuracy: body[15], Variance: binary.BigEndian.Uint16(body[16:18])}
+		a.GrandmasterPriority2 = body[18]
+		copy(a.GrandmasterIdentity[:], body[19:27])
+		a.StepsRemoved = binary.BigEndian.Uint16(body[27:29])
+		a.TimeSource = body[29]
+	case MsgSignaling:
+		m.Port = getPortIdentity(body)
+	case MsgManagement:
+		m.Port = getPortIdentity(body)
+		m.StartingBoundaryHops = body[10]
+		m.BoundaryHops = body[11]
+		m.Action = body[12] & 0xF
+	}
+	rest := b[HeaderSize+bodySize(m.Type):]
+	for len(rest) > 0 {
+		if len(rest) < 4 {
+			return nil, ErrBadTLV
+		}
+		l := int(binary.BigEndian.Uint16(rest[2:4]))
+		if 4+l > len(rest) {
+			return nil, ErrBadTLV
+		}
+		m.TLVs = append(m.TLVs, TLV{Type: binary.BigEndian.Uint16(rest[0:2]), Value: rest[4 : 4+l]})
+		rest = rest[4+l:]
+	}
+	return m, nil
+}
+
+// LogInterval переводит log2 интервала в time.Duration (0x7F — 0)
+func LogInterval(l int8) time.Duration {
+	if l == LogIntervalUnset {
+		return 0
+	}
+	if l >= 0 {
+		return time.Second << uint(l)
+	}
+	return time.Second >> uint(-l)
+}
@@ -0,0 +1,53 @@
+//go:build linux
+
+package ptp
+
+import (
+	"net"
+
+	"golang.org/x/sys/unix"
+)
+
+// joinMulticast подписывает сокет на группы multicast (IPv4 или IPv6) на интерфейсе iface;
+// setIf — направлять исходящий multicast через этот интерфейс
+func joinMulticast(c *net.UDPConn, iface string, setIf bool, groups ...net.IP) error {
+	ifi, err := net.InterfaceByName(iface)
+	if err != nil {
+		return err
+	}
+	raw, err := c.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var opErr error
+	err = raw.Control(func(fd uintptr) {
+		ipv6 := false
+		for _, g := range groups {
+			if g.To4() == nil {
+				ipv6 = true
+				mreq := &unix.IPv6Mreq{Interface: uint32(ifi.Index)}
+				copy(mreq.Multiaddr[:], g.To16())
+				opErr = unix.SetsockoptIPv6Mreq(int(fd), unix.IPPROTO_IPV6, unix.IPV6_JOIN_GROUP, mreq)
+			} else {
+				mreq := &unix.IPMreqn{Ifindex: int32(ifi.Index)}
+				copy(mreq.Multiaddr[:], g.To4())
+				opErr = unix.SetsockoptIPMreqn(int(fd), unix.IPPROTO_IP, unix.IP_ADD_MEMBERSHIP, mreq)
+			}
+			if opErr != nil {
+				return
+			}
+		}
+		if !setIf {
+			return
+		}
+		if ipv6 {
+			opErr = unix.SetsockoptInt(int(fd), unix.IPPROTO_IPV6, unix.IPV6_MULTICAST_IF, ifi.Index)
+		} else {
+			opErr = unix.SetsockoptIPMreqn(int(fd), unix.IPPROTO_IP, unix.IP_MULTICAST_IF, &unix.IPMreqn{Ifindex: int32(ifi.Index)})
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return opErr
+}
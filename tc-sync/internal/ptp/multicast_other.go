@@ -0,0 +1,13 @@
+//go:build !linux
+
+package ptp
+
+import (
+	"errors"
+	"net"
+)
+
+// joinMulticast — подписка на multicast реализована только для Linux
+func joinMulticast(c *net.UDPConn, iface string, setIf bool, groups ...net.IP) error {
+	return errors.New("multicast is supported only on linux")
+}
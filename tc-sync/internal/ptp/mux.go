@@ -0,0 +1,175 @@
+package ptp
+
+import (
+	"net"
+	"sync"
+
+	"github.com/shiwa/timecard-mini/tc-sync/internal/timestamping"
+)
+
+// muxQueueLen — очередь принятых сообщений порта; при переполнении сообщения порту не доставляются
+const muxQueueLen = 64
+
+// Mux разделяет транспорт между несколькими портами одного интерфейса (slave разных доменов,
+// master, обнаружение мастеров): каждый порт получает все принятые сообщения и сам фильтрует их
+// по домену, передача сериализуется (метка передачи берётся из общей error queue сокета).
+// Транспорт закрывается вместе с последним портом.
+type Mux struct {
+	tr      Transport
+	onClose func()
+
+	mu     sync.Mutex
+	ports  map[*MuxPort]struct{}
+	closed bool // закрыт последний порт или транспорт
+	send   sync.Mutex
+}
+
+// NewMux запускает раздачу сообщений транспорта tr; onClose (может быть nil) вызывается
+// после закрытия транспорта
+func NewMux(tr Transport, onClose func()) *Mux {
+	m := &Mux{tr: tr, onClose: onClose, ports: make(map[*MuxPort]struct{})}
+	go m.dispatch()
+	return m
+}
+
+// Port открывает новый порт мультиплексора; nil — транспорт уже закрыт
+func (m *Mux) Port() *MuxPort {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return nil
+	}
+	p := &MuxPort{mux: m, packets: make(chan Packet, muxQueueLen)}
+	m.ports[p] = struct{}{}
+	return p
+}
+
+// Transport возвращает разделяемый транспорт
+func (m *Mux) Transport() Transport {
+	return m.tr
+}
+
+// dispatch раздаёт сообщения портам до закрытия транспорта
+func (m *Mux) dispatch() {
+	for pkt := range m.tr.Packets() {
+		m.mu.Lock()
+		for p := range m.ports {
+			select {
+			case p.packets <- pkt:
+			default:
+			}
+		}
+		m.mu.Unlock()
+	}
+	m.mu.Lock()
+	m.closed = true
+	for p := range m.ports {
+		p.closeOnce.Do(func() { close(p.packets) })
+		delete(m.ports, p)
+	}
+	m.mu.Unlock()
+	if m.onClose != nil {
+		m.onClose()
+	}
+}
+
+// remove закрывает порт; после последнего порта закрывается транспорт
+func (m *Mux) remove(p *MuxPort) {
+	m.mu.Lock()
+	_, ok := m.ports[p]
+	if ok {
+		delete(m.ports, p)
+		p.closeOnce.Do(func() { close(p.packets) })
+	}
+	last := ok && len(m.ports) == 0 && !m.closed
+	if last {
+		m.closed = true
+	}
+	m.mu.Unlock()
+	if last {
+		m.tr.Close()
+	}
+}
+
+// MuxPort — порт мультиплексора; реализует Transport
+type MuxPort struct {
+	mux       *Mux
+	packets   chan Packet
+	closeOnce sync.Once
+}
+
+// Packets возвращает канал сообщений порта
+func (p *MuxPort) Packets() <-chan Packet {
+	return p.packets
+}
+
+// SendEvent отправляет event сообщение через разделяемый транспорт
+func (p *MuxPort) SendEvent(b []byte, dst net.Addr) (timestamping.Stamp, error) {
+	p.mux.send.Lock()
+	defer p.mux.send.Unlock()
+	return p.mux.tr.SendEvent(b, dst)
+}
+
+// SendGeneral отправляет general сообщение через разделяемый транспорт
+func (p *MuxPort) SendGeneral(b []byte, dst net.Addr) error {
+	p.mux.send.Lock()
+	defer p.mux.send.Unlock()
+	return p.mux.tr.SendGeneral(b, dst)
+}
+
+// TimestampType возвращает тип меток разделяемого транспорта
+func (p *MuxPort) TimestampType() timestamping.Type {
+	return p.mux.tr.TimestampType()
+}
+
+// LocalAddr возвращает локальный адрес разделяемого транспорта (nil, если он его не сообщает)
+func (p *MuxPort) LocalAddr() net.Addr {
+	if a, ok := p.mux.tr.(interface{ LocalAddr() net.Addr }); ok {
+		return a.LocalAddr()
+	}
+	return nil
+}
+
+// Close закрывает порт (транспорт — вместе с последним портом)
+func (p *MuxPort) Close() error {
+	p.mux.remove(p)
+	return nil
+}
+
+// shared — транспорты, открытые OpenTransport, по интерфейсу и типу транспорта
+var shared = struct {
+	sync.Mutex
+	muxes map[string]*Mux
+}{muxes: make(map[string]*Mux)}
+
+// sharedPort возвращает порт разделяемого транспорта key; open открывает транспорт, если его
+// ещё нет, prepare (может быть nil) вызывается для существующего (например, подписка на multicast)
+func sharedPort(key string, open func() (Transport, error), prepare func(Transport) error) (Transport, error) {
+	shared.Lock()
+	defer shared.Unlock()
+	if m := shared.muxes[key]; m != nil {
+		if p := m.Port(); p != nil {
+			if prepare != nil {
+				if err := prepare(m.tr); err != nil {
+					p.Close()
+					return nil, err
+				}
+			}
+			return p, nil
+		}
+	}
+	tr, err := open()
+	if err != nil {
+		return nil, err
+	}
+	var m *Mux
+	m = NewMux(tr, func() {
+		shared.Lock()
+		if shared.muxes[key] == m {
+			delete(shared.muxes, key)
+		}
+		shared.Unlock()
+	})
+	shared.muxes[key] = m
+	return m.Port(), nil
+}
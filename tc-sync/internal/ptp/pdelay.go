@@ -0,0 +1,315 @@
+package ptp
+
+import (
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Параметры IEEE 802.1AS (gPTP)
+const (
+	// SdoIDGPTP — majorSdoId (transportSpecific) сообщений 802.1AS
+	SdoIDGPTP = 1
+	// DefaultNeighborPropDelayThresh — предел задержки линии, выше которого порт не asCapable (802.1AS, 11.2.2)
+	DefaultNeighborPropDelayThresh = 800 * time.Nanosecond
+	// allowedLostResponses — сколько Pdelay_Req подряд без ответа допускается (802.1AS, 11.5.3)
+	allowedLostResponses = 3
+	// rateRatioWindow — число обменов Pdelay, по которым оценивается neighborRateRatio
+	rateRatioWindow = 8
+	// maxRateOffset — |neighborRateRatio − 1|, выше которого оценка отбрасывается (±1000 ppm)
+	maxRateOffset = 1e-3
+)
+
+// TLV 802.1AS
+const (
+	TLVOrganizationExtension uint16 = 0x0003
+	TLVPathTrace             uint16 = 0x0008
+)
+
+// PeerDelayConfig — механизм peer delay (P2P) порта
+type PeerDelayConfig struct {
+	LogInterval int8 // logMinPdelayReqInterval
+	// GPTP — режим 802.1AS: majorSdoId 1, neighborRateRatio и asCapable
+	GPTP bool
+	// NeighborPropDelayThresh — предел задержки линии для asCapable (0 — DefaultNeighborPropDelayThresh)
+	NeighborPropDelayThresh time.Duration
+}
+
+// PeerDelayStatus — состояние измерения задержки линии до соседа
+type PeerDelayStatus struct {
+	Valid             bool // есть измерение и сосед отвечает
+	Peer              PortIdentity
+	MeanLinkDelay     time.Duration
+	NeighborRateRatio float64 // частота соседа / собственная (1 вне режима gPTP)
+	// ASCapable — 802.1AS: сосед отвечает, задержка не выше NeighborPropDelayThresh, ответчик один;
+	// вне режима gPTP совпадает с Valid
+	ASCapable bool
+}
+
+// pdelaySample — пара меток обмена Pdelay для оценки neighborRateRatio: T3 соседа и T4 свои
+type pdelaySample struct {
+	t3, t4 time.Time
+}
+
+// peerDelay — запросчик и ответчик Pdelay_Req/Pdelay_Resp/Pdelay_Resp_Follow_Up (IEEE 1588-2008, 11.4;
+// two-step). Используется портами slave и master в режиме P2P.
+type peerDelay struct {
+	tr       Transport
+	identity PortIdentity
+	domain   uint8
+	sdoID    uint8
+	cfg      PeerDelayConfig
+
+	// Состояние запросчика (только в Run порта)
+	seq      uint16
+	next     time.Time
+	pending  bool // Pdelay_Req отправлен, обмен не завершён
+	t1       time.Time
+	t2, t4   time.Time
+	corr     time.Duration
+	peer     PortIdentity
+	answered bool // на текущий запрос пришёл Pdelay_Resp
+	waiting  bool // Pdelay_Resp принят, ждём Follow_Up
+	early    earlyFollowUp
+	multiple bool // на один запрос ответили разные порты
+	lost     int
+	delays   []time.Duration
+	samples  []pdelaySample
+
+	mu     sync.Mutex
+	status PeerDelayStatus
+}
+
+func newPeerDelay(tr Transport, identity PortIdentity, domain, sdoID uint8, cfg PeerDelayConfig) *peerDelay {
+	if cfg.NeighborPropDelayThresh <= 0 {
+		cfg.NeighborPropDelayThresh = DefaultNeighborPropDelayThresh
+	}
+	return &peerDelay{tr: tr, identity: identity, domain: domain, sdoID: sdoID, cfg: cfg}
+}
+
+// Status возвращает последнее состояние измерения
+func (pd *peerDelay) Status() PeerDelayStatus {
+	pd.mu.Lock()
+	defer pd.mu.Unlock()
+	return pd.status
+}
+
+func (pd *peerDelay) setStatus(st PeerDelayStatus) {
+	pd.mu.Lock()
+	pd.status = st
+	pd.mu.Unlock()
+}
+
+// tick отправляет Pdelay_Req, когда подошёл интервал; неотвеченные запросы считаются потерянными
+func (pd *peerDelay) tick(now time.Time) {
+	if now.Before(pd.next) {
+		return
+	}
+	if pd.pending {
+		pd.lost++
+		if pd.lost > allowedLostResponses {
+			pd.reset()
+		}
+	}
+	pd.seq++
+	req := Message{Header: Header{
+		SdoID:          pd.sdoID,
+		Type:           MsgPdelayReq,
+		Domain:         pd.domain,
+		Source:         pd.identity,
+		Sequence:       pd.seq,
+		LogMsgInterval: pd.cfg.LogInterval,
+	}}
+	pd.next = now.Add(LogInterval(pd.cfg.LogInterval))
+	pd.pending, pd.answered, pd.waiting, pd.multiple = false, false, false, false
+	pd.early.valid = false
+	ts, err := pd.tr.SendEvent(req.Marshal(), nil)
+	if err != nil {
+		return
+	}
+	pd.t1, pd.pending = ts.Time, true
+}
+
+// reset сбрасывает измерения после потери соседа
+func (pd *peerDelay) reset() {
+	pd.delays = pd.delays[:0]
+	pd.samples = pd.samples[:0]
+	pd.lost = 0
+	pd.setStatus(PeerDelayStatus{NeighborRateRatio: 1})
+}
+
+// handle обрабатывает сообщения peer delay; false — сообщение другого типа
+func (pd *peerDelay) handle(p Packet, m *Message) bool {
+	switch m.Type {
+	case MsgPdelayReq:
+		if p.Event {
+			pd.respond(p, m)
+		}
+	case MsgPdelayResp:
+		if !p.Event || m.Port != pd.identity || m.Sequence != pd.seq {
+			return true
+		}
+		if pd.answered {
+			if m.Source != pd.peer {
+				pd.multipleResponders()
+			}
+			return true
+		}
+		if !pd.pending {
+			return true
+		}
+		pd.answered = true
+		pd.t2, pd.t4, pd.corr, pd.peer = m.Timestamp.Time(), p.Stamp.Time, m.Correction.Duration(), m.Source
+		switch {
+		case m.Flags&FlagTwoStep == 0:
+			// one-step: время обработки ответчиком (T3 − T2) уже в correctionField
+			pd.complete(pd.t2, 0)
+		case pd.early.valid && pd.early.seq == m.Sequence:
+			pd.complete(pd.early.ts.Time(), pd.early.corr)
+		default:
+			pd.waiting = true
+		}
+	case MsgPdelayRespFollowUp:
+		if !pd.pending || m.Port != pd.identity || m.Sequence != pd.seq {
+			return true
+		}
+		if pd.waiting && m.Source == pd.peer {
+			pd.complete(m.Timestamp.Time(), m.Correction.Duration())
+			return true
+		}
+		// Follow_Up раньше Pdelay_Resp (event и general сокеты читаются независимо)
+		pd.early = earlyFollowUp{seq: m.Sequence, ts: m.Timestamp, corr: m.Correction.Duration(), valid: true}
+	default:
+		return false
+	}
+	return true
+}
+
+// multipleResponders — на запрос ответили разные порты (линия не точка-точка): в gPTP порт не asCapable
+func (pd *peerDelay) multipleResponders() {
+	pd.multiple = true
+	if pd.cfg.GPTP {
+		pd.mu.Lock()
+		pd.status.ASCapable = false
+		pd.mu.Unlock()
+	}
+}
+
+// complete — известны T1…T4: meanLinkDelay = (r·(T4 − T1) − (T3 − T2) − corrections) / 2,
+// r — neighborRateRatio (gPTP) или 1
+func (pd *peerDelay) complete(t3 time.Time, fuCorr time.Duration) {
+	pd.pending, pd.waiting, pd.lost = false, false, 0
+	ratio := 1.0
+	if pd.cfg.GPTP {
+		ratio = pd.rateRatio(t3, pd.t4)
+	}
+	turnaround := t3.Sub(pd.t2) + pd.corr + fuCorr
+	d := time.Duration((ratio*float64(pd.t4.Sub(pd.t1)) - float64(turnaround)) / 2)
+	if d < 0 {
+		// Отрицательная задержка — асимметрия меток; измерение отбрасывается
+		return
+	}
+	pd.delays = append(pd.delays, d)
+	if len(pd.delays) > delayFilterLen {
+		pd.delays = pd.delays[1:]
+	}
+	sorted := append([]time.Duration(nil), pd.delays...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	mean := sorted[len(sorted)/2]
+	if len(sorted)%2 == 0 {
+		mean = (sorted[len(sorted)/2-1] + mean) / 2
+	}
+	st := PeerDelayStatus{Valid: true, Peer: pd.peer, MeanLinkDelay: mean, NeighborRateRatio: ratio, ASCapable: true}
+	if pd.cfg.GPTP {
+		st.ASCapable = !pd.multiple && mean <= pd.cfg.NeighborPropDelayThresh
+	}
+	pd.setStatus(st)
+}
+
+// rateRatio учитывает пару (T3, T4) и возвращает neighborRateRatio по самой старой паре окна
+// (802.1AS, 11.2.15.2.3); до накопления двух пар и при неправдоподобной оценке — 1 или прежняя оценка
+func (pd *peerDelay) rateRatio(t3, t4 time.Time) float64 {
+	pd.samples = append(pd.samples, pdelaySample{t3, t4})
+	if len(pd.samples) > rateRatioWindow {
+		pd.samples = pd.samples[1:]
+	}
+	prev := pd.Status().NeighborRateRatio
+	if prev == 0 {
+		prev = 1
+	}
+	first := pd.samples[0]
+	dt4 := t4.Sub(first.t4)
+	if len(pd.samples) < 2 || dt4 <= 0 {
+		return prev
+	}
+	r := float64(t3.Sub(first.t3)) / float64(dt4)
+	if r < 1-maxRateOffset || r > 1+maxRateOffset {
+		return prev
+	}
+	return r
+}
+
+// respond отвечает на Pdelay_Req: Pdelay_Resp с меткой приёма запроса (T2) и Pdelay_Resp_Follow_Up
+// с меткой передачи ответа (T3); correctionField запроса копируется в Follow_Up
+func (pd *peerDelay) respond(p Packet, req *Message) {
+	var dst net.Addr
+	var flags uint16
+	if req.Flags&FlagUnicast != 0 {
+		dst, flags = p.Src, FlagUnicast
+	}
+	resp := Message{
+		Header: Header{
+			SdoID:          pd.sdoID,
+			Type:           MsgPdelayResp,
+			Domain:         pd.domain,
+			Flags:          FlagTwoStep | flags,
+			Source:         pd.identity,
+			Sequence:       req.Sequence,
+			LogMsgInterval: LogIntervalUnset,
+		},
+		Timestamp: NewTimestamp(p.Stamp.Time),
+		Port:      req.Source,
+	}
+	t3, err := pd.tr.SendEvent(resp.Marshal(), dst)
+	if err != nil {
+		return
+	}
+	fu := resp
+	fu.Type = MsgPdelayRespFollowUp
+	fu.Flags = flags
+	fu.Timestamp = NewTimestamp(t3.Time)
+	fu.Correction = req.Correction
+	_ = pd.tr.SendGeneral(fu.Marshal(), dst)
+}
+
+// followUpInfoTLV — TLV Follow_Up information (802.1AS, 11.4.4.3): организация 00-80-C2, подтип 1;
+// cumulativeScaledRateOffset, gmTimeBaseIndicator, lastGmPhaseChange и scaledLastGmFreqChange — нули
+// (порт — grandmaster)
+func followUpInfoTLV() TLV {
+	v := make([]byte, 28)
+	copy(v[0:3], []byte{0x00, 0x80, 0xC2})
+	v[5] = 1
+	return TLV{Type: TLVOrganizationExtension, Value: v}
+}
+
+// pathTraceTLV — TLV path trace (IEEE 1588-2008, 16.2) с clockIdentity часов на пути от grandmaster
+func pathTraceTLV(path ...ClockIdentity) TLV {
+	v := make([]byte, 0, 8*len(path))
+	for _, c := range path {
+		v = append(v, c[:]...)
+	}
+	return TLV{Type: TLVPathTrace, Value: v}
+}
+
+// ParsePathTrace возвращает clockIdentity из TLV path trace; ok=false — TLV другого типа
+func ParsePathTrace(t TLV) ([]ClockIdentity, bool) {
+	if t.Type != TLVPathTrace || len(t.Value)%8 != 0 {
+		return nil, false
+	}
+	path := make([]ClockIdentity, len(t.Value)/8)
+	for i := range path {
+		copy(path[i][:], t.Value[8*i:])
+	}
+	return path, true
+}
@@ -0,0 +1,59 @@
+//go:build linux
+
+package ptp
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// clockFD — FD_TO_CLOCKID(fd) = (~fd << 3) | CLOCKFD (include/linux/posix-timers.h)
+const clockFD = 3
+
+// PHCDevice возвращает PHC сетевой карты (/dev/ptpN) по ETHTOOL_GET_TS_INFO
+func PHCDevice(iface string) (string, error) {
+	fd, err := unix.Socket(unix.AF_INET, unix.SOCK_DGRAM, 0)
+	if err != nil {
+		return "", err
+	}
+	defer unix.Close(fd)
+	info, err := unix.IoctlGetEthtoolTsInfo(fd, iface)
+	if err != nil {
+		return "", err
+	}
+	if info.Phc_index < 0 {
+		return "", fmt.Errorf("ptp: %s has no PHC", iface)
+	}
+	return fmt.Sprintf("/dev/ptp%d", info.Phc_index), nil
+}
+
+// PHCSystemOffset измеряет PHC − CLOCK_REALTIME: чтение PHC между двумя чтениями системных часов,
+// из нескольких попыток берётся самое короткое окно.
+func PHCSystemOffset(device string) (time.Duration, error) {
+	f, err := os.OpenFile(device, os.O_RDONLY, 0)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	clockid := int32((^int(f.Fd()))<<3 | clockFD)
+	var best time.Duration
+	bestWindow := time.Duration(-1)
+	for i := 0; i < 5; i++ {
+		var ts unix.Timespec
+		sys1 := time.Now()
+		if err := unix.ClockGettime(clockid, &ts); err != nil {
+			return 0, err
+		}
+		sys2 := time.Now()
+		window := sys2.Sub(sys1)
+		if bestWindow < 0 || window < bestWindow {
+			bestWindow = window
+			mid := sys1.Add(window / 2)
+			best = time.Unix(ts.Unix()).Sub(mid)
+		}
+	}
+	return best, nil
+}
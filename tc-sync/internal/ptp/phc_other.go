@@ -0,0 +1,20 @@
+//go:build !linux
+
+package ptp
+
+import (
+	"errors"
+	"time"
+)
+
+var errNoPHC = errors.New("ptp: PHC is supported only on linux")
+
+// PHCDevice — PHC доступны только на Linux
+func PHCDevice(iface string) (string, error) {
+	return "", errNoPHC
+}
+
+// PHCSystemOffset — PHC доступны только на Linux
+func PHCSystemOffset(device string) (time.Duration, error) {
+	return 0, errNoPHC
+}
@@ -0,0 +1,58 @@
+package ptp
+
+import (
+	"crypto/rand"
+	"net"
+)
+
+// PortState — состояние порта (IEEE 1588-2008, 9.2.5; подмножество для ordinary clock)
+type PortState int
+
+const (
+	StateListening    PortState = iota // мастер не выбран
+	StateUncalibrated                  // мастер выбран, измерений ещё нет
+	StateSlave                         // синхронизация с мастером
+	StateMaster                        // порт рассылает Announce/Sync
+	StatePassive                       // есть лучший мастер в сети, порт молчит
+)
+
+func (s PortState) String() string {
+	switch s {
+	case StateListening:
+		return "listening"
+	case StateUncalibrated:
+		return "uncalibrated"
+	case StateSlave:
+		return "slave"
+	case StateMaster:
+		return "master"
+	case StatePassive:
+		return "passive"
+	default:
+		return "unknown"
+	}
+}
+
+// DefaultClockIdentity — EUI-64 из MAC интерфейса; без интерфейса или MAC — случайный идентификатор
+func DefaultClockIdentity(iface string) ClockIdentity {
+	if iface != "" {
+		if ifi, err := net.InterfaceByName(iface); err == nil && len(ifi.HardwareAddr) >= 6 {
+			return ClockIdentityFromMAC(ifi.HardwareAddr)
+		}
+	}
+	var c ClockIdentity
+	_, _ = rand.Read(c[:])
+	c[0] |= 0x02 // локально администрируемый адрес
+	return c
+}
+
+// ipOf возвращает IP адреса отправителя (UDP) или nil
+func ipOf(a net.Addr) net.IP {
+	switch v := a.(type) {
+	case *net.UDPAddr:
+		return v.IP
+	case *net.IPAddr:
+		return v.IP
+	}
+	return nil
+}
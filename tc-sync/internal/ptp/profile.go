@@ -0,0 +1,352 @@
+package ptp
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+)
+
+// Механизмы измерения задержки (delay_mechanism)
+const (
+	DelayE2E = "e2e" // Delay_Req/Delay_Resp
+	DelayP2P = "p2p" // Pdelay_Req/Pdelay_Resp
+)
+
+// ClockState — состояние синхронизации часов мастера
+type ClockState int
+
+const (
+	ClockFreerun  ClockState = iota // не синхронизирован
+	ClockLocked                     // синхронизирован с первичным эталоном
+	ClockHoldover                   // эталон потерян, удержание в пределах holdover_limit
+	ClockDegraded                   // удержание дольше holdover_limit (вне спецификации, категория 1)
+)
+
+// Категории 2 и 3 удержания вне спецификации (G.8275.1): погрешность удержания растёт дальше
+const (
+	ClockDegraded2 ClockState = ClockDegraded + 1 + iota
+	ClockDegraded3
+)
+
+// ClockClasses — clockClass, объявляемые мастером в каждом состоянии синхронизации
+type ClockClasses struct {
+	Locked   uint8
+	Holdover uint8
+	Degraded uint8
+	Freerun  uint8
+}
+
+// DefaultClockClasses — IEEE 1588-2008, таблица 5
+var DefaultClockClasses = ClockClasses{
+	Locked:   ClockClassPrimary,
+	Holdover: ClockClassHoldover,
+	Degraded: ClockClassDefault,
+	Freerun:  ClockClassDefault,
+}
+
+// Class возвращает clockClass для состояния
+func (c ClockClasses) Class(st ClockState) uint8 {
+	switch st {
+	case ClockLocked:
+		return c.Locked
+	case ClockHoldover:
+		return c.Holdover
+	case ClockDegraded:
+		return c.Degraded
+	case ClockDegraded2, ClockDegraded3:
+		// Категории 2 и 3 определены только в G.8275.1 (140, 150, 160); в остальных таблицах — Degraded
+		if c.Degraded == ClockClassOutOfSpec1 {
+			if st == ClockDegraded2 {
+				return ClockClassOutOfSpec2
+			}
+			return ClockClassOutOfSpec3
+		}
+		return c.Degraded
+	}
+	return c.Freerun
+}
+
+// CastMode — допустимые режимы передачи профиля
+type CastMode int
+
+const (
+	CastAny       CastMode = iota // multicast, unicast или оба (hybrid)
+	CastMulticast                 // только multicast
+	CastUnicast                   // только unicast с согласованием
+)
+
+// IntervalRange — интервал сообщений профиля (log2 секунд): значение по умолчанию и допустимый диапазон
+type IntervalRange struct {
+	Default, Min, Max int
+}
+
+// Profile — профиль PTP: значения по умолчанию и ограничения параметров порта
+type Profile struct {
+	Name           string
+	Transport      string           // единственный допустимый транспорт
+	DstMAC         net.HardwareAddr // адрес multicast для Ethernet; nil — MACPrimary
+	DelayMechanism string
+	Cast           CastMode
+	Domain         int // домен по умолчанию
+	DomainMin      int
+	DomainMax      int
+	Announce       IntervalRange
+	Sync           IntervalRange
+	DelayReq       IntervalRange // logMinDelayReqInterval (для P2P — logMinPdelayReqInterval)
+	Priority1      int           // по умолчанию (0 — 128)
+	Priority1Fixed bool          // priority1 не настраивается
+	Priority2      int           // по умолчанию
+	BMCA           BMCA
+	ClockClasses   ClockClasses
+	// GPTP — IEEE 802.1AS: majorSdoId 1, neighborRateRatio, asCapable, TLV path trace и Follow_Up information
+	GPTP bool
+}
+
+// Профили (ключ — имя в нижнем регистре)
+var profiles = map[string]*Profile{
+	// ITU-T G.8275.1: фаза/время, полная поддержка сети (все узлы — T-BC), Ethernet multicast
+	"g.8275.1": {
+		Name:           "G.8275.1",
+		Transport:      TransportL2,
+		DstMAC:         MACPeerDelay,
+		DelayMechanism: DelayE2E,
+		Cast:           CastMulticast,
+		Domain:         24, DomainMin: 24, DomainMax: 43,
+		Announce:       IntervalRange{-3, -3, -3},
+		Sync:           IntervalRange{-4, -4, -4},
+		DelayReq:       IntervalRange{-4, -4, -4},
+		Priority1:      128,
+		Priority1Fixed: true,
+		Priority2:      128,
+		BMCA:           BMCAG8275,
+		ClockClasses:   ClockClasses{Locked: 6, Holdover: 7, Degraded: 140, Freerun: 248},
+	},
+	// ITU-T G.8275.2: фаза/время, частичная поддержка сети, UDP unicast с согласованием
+	"g.8275.2": {
+		Name:           "G.8275.2",
+		Transport:      TransportUDP,
+		DelayMechanism: DelayE2E,
+		Cast:           CastUnicast,
+		Domain:         44, DomainMin: 44, DomainMax: 63,
+		Announce:       IntervalRange{0, -3, 0},
+		Sync:           IntervalRange{-4, -7, 0},
+		DelayReq:       IntervalRange{-4, -7, 0},
+		Priority1:      128,
+		Priority1Fixed: true,
+		Priority2:      128,
+		BMCA:           BMCAG8275,
+		ClockClasses:   ClockClasses{Locked: 6, Holdover: 7, Degraded: 140, Freerun: 248},
+	},
+	// ITU-T G.8265.1: частота, UDP unicast с согласованием; clockClass — QL SSM
+	// (PRC 84, SSU-A 90, SEC 104, DNU 110)
+	"g.8265.1": {
+		Name:           "G.8265.1",
+		Transport:      TransportUDP,
+		DelayMechanism: DelayE2E,
+		Cast:           CastUnicast,
+		Domain:         4, DomainMin: 4, DomainMax: 23,
+		Announce:       IntervalRange{1, -3, 4},
+		Sync:           IntervalRange{-4, -7, 4},
+		DelayReq:       IntervalRange{-4, -7, 4},
+		Priority1:      128,
+		Priority1Fixed: true,
+		Priority2:      128,
+		BMCA:           BMCAG8265,
+		ClockClasses:   ClockClasses{Locked: 84, Holdover: 90, Degraded: 104, Freerun: 110},
+	},
+	// draft-ietf-tictoc-ptp-enterprise-profile: UDP, Announce/Sync multicast, Delay_Req multicast или unicast
+	"enterprise-draft": {
+		Name:           "enterprise-draft",
+		Transport:      TransportUDP,
+		DelayMechanism: DelayE2E,
+		Cast:           CastAny,
+		Domain:         0, DomainMin: 0, DomainMax: 127,
+		Announce:     IntervalRange{1, 0, 3},
+		Sync:         IntervalRange{0, -7, 1},
+		DelayReq:     IntervalRange{0, -7, 6},
+		Priority2:    128,
+		BMCA:         BMCADefault,
+		ClockClasses: DefaultClockClasses,
+	},
+	// IEC/IEEE 61850-9-3 (Power Utility Profile): Ethernet multicast, peer delay, интервалы 1 с
+	"iec-ieee-61850-9-3": {
+		Name:           "IEC/IEEE 61850-9-3",
+		Transport:      TransportL2,
+		DelayMechanism: DelayP2P,
+		Cast:           CastMulticast,
+		Domain:         0, DomainMin: 0, DomainMax: 255,
+		Announce:     IntervalRange{0, 0, 0},
+		Sync:         IntervalRange{0, 0, 0},
+		DelayReq:     IntervalRange{0, 0, 0},
+		Priority2:    128,
+		BMCA:         BMCADefault,
+		ClockClasses: ClockClasses{Locked: 6, Holdover: 7, Degraded: 187, Freerun: 248},
+	},
+	// IEEE 802.1AS (gPTP): Ethernet 01-80-C2-00-00-0E, peer delay; priority1 246 —
+	// grandmaster-capable система (802.1AS, 8.6.2.1)
+	"gptp": {
+		Name:           "gPTP",
+		Transport:      TransportL2,
+		DstMAC:         MACPeerDelay,
+		DelayMechanism: DelayP2P,
+		Cast:           CastMulticast,
+		Domain:         0, DomainMin: 0, DomainMax: 127,
+		Announce:     IntervalRange{0, -3, 3},
+		Sync:         IntervalRange{-3, -7, 0},
+		DelayReq:     IntervalRange{0, -3, 3},
+		Priority1:    246,
+		Priority2:    248,
+		BMCA:         BMCADefault,
+		ClockClasses: DefaultClockClasses,
+		GPTP:         true,
+	},
+}
+
+// LookupProfile возвращает профиль по имени ("G.8275.1", "g.8275.2", "enterprise-draft",
+// "IEC/IEEE 61850-9-3", "61850-9-3", "gptp", "802.1AS" …); пустое имя — без профиля (nil)
+func LookupProfile(name string) (*Profile, error) {
+	key := strings.ToLower(strings.TrimSpace(name))
+	if key == "" {
+		return nil, nil
+	}
+	key = strings.NewReplacer("/", "-", "_", "-", " ", "-").Replace(key)
+	switch key {
+	case "61850-9-3", "iec-61850-9-3":
+		key = "iec-ieee-61850-9-3"
+	case "802.1as", "ieee-802.1as":
+		key = "gptp"
+	}
+	if p := profiles[key]; p != nil {
+		return p, nil
+	}
+	names := make([]string, 0, len(profiles))
+	for _, p := range profiles {
+		names = append(names, p.Name)
+	}
+	sort.Strings(names)
+	return nil, fmt.Errorf("ptp: unknown profile %q (want one of %s)", name, strings.Join(names, ", "))
+}
+
+// PortOptions — параметры порта из конфига. ApplyProfile заменяет нулевые значения значениями
+// профиля и проверяет остальные.
+type PortOptions struct {
+	Transport            string           // "" — транспорт профиля или UDP
+	DstMAC               net.HardwareAddr // Ethernet: multicast адрес (nil — профиля или MACPrimary)
+	DelayMechanism       string           // "" — профиля или E2E
+	Domain               int
+	AnnounceInterval     int
+	SyncInterval         int
+	DelayRequestInterval int
+	Priority1            int // 0 — 128 (или значение профиля)
+	Priority2            int
+	Multicast            bool          // Announce/Sync в multicast
+	Unicast              bool          // unicast с согласованием (unicast_master_table или serve_unicast)
+	HybridE2E            bool          // multicast Sync, unicast Delay_Req (hybrid_e2e)
+	Socket               SocketOptions // UDP: DSCP, TTL multicast, общие сокеты
+}
+
+// ApplyProfile подставляет в o значения профиля name (пустое — без профиля: только проверка
+// диапазонов) и возвращает итоговые параметры и профиль. Явно заданные значения сохраняются,
+// если профиль их допускает.
+func ApplyProfile(name string, o PortOptions) (PortOptions, *Profile, error) {
+	p, err := LookupProfile(name)
+	if err != nil {
+		return o, nil, err
+	}
+	if p != nil {
+		if err := p.apply(&o); err != nil {
+			return o, p, fmt.Errorf("ptp: profile %s: %w", p.Name, err)
+		}
+	}
+	if o.Transport == "" {
+		o.Transport = TransportUDP
+	}
+	if o.Transport != TransportUDP && o.Transport != TransportUDP6 && o.Transport != TransportL2 {
+		return o, p, fmt.Errorf("ptp: unknown transport %q (want udp, udp6 or l2)", o.Transport)
+	}
+	if o.DelayMechanism == "" {
+		o.DelayMechanism = DelayE2E
+	}
+	if o.DelayMechanism != DelayE2E && o.DelayMechanism != DelayP2P {
+		return o, p, fmt.Errorf("ptp: unknown delay_mechanism %q (want e2e or p2p)", o.DelayMechanism)
+	}
+	if o.Domain < 0 || o.Domain > 255 {
+		return o, p, fmt.Errorf("ptp: domain %d out of range", o.Domain)
+	}
+	prio := []*int{&o.Priority1, &o.Priority2}
+	for i, v := range prio {
+		if *v == 0 {
+			*v = 128
+		}
+		if *v < 0 || *v > 255 {
+			return o, p, fmt.Errorf("ptp: priority%d %d out of range", i+1, *v)
+		}
+	}
+	for _, l := range []int{o.AnnounceInterval, o.SyncInterval, o.DelayRequestInterval} {
+		if l < -7 || l > 7 {
+			return o, p, fmt.Errorf("ptp: log interval %d out of range -7..7", l)
+		}
+	}
+	return o, p, nil
+}
+
+// apply подставляет значения профиля и проверяет ограничения
+func (p *Profile) apply(o *PortOptions) error {
+	if o.Transport == "" {
+		o.Transport = p.Transport
+	}
+	if o.Transport != p.Transport && !(p.Transport == TransportUDP && o.Transport == TransportUDP6) {
+		return fmt.Errorf("transport %s not allowed (profile requires %s)", o.Transport, p.Transport)
+	}
+	if o.DstMAC == nil {
+		o.DstMAC = p.DstMAC
+	}
+	if o.DelayMechanism == "" {
+		o.DelayMechanism = p.DelayMechanism
+	}
+	if o.DelayMechanism != p.DelayMechanism {
+		return fmt.Errorf("delay_mechanism %s not allowed (profile requires %s)", o.DelayMechanism, p.DelayMechanism)
+	}
+	switch {
+	case p.Cast == CastMulticast && (o.Unicast || o.HybridE2E):
+		return fmt.Errorf("unicast is not allowed (multicast only)")
+	case p.Cast == CastUnicast && o.Multicast:
+		return fmt.Errorf("multicast is not allowed (unicast negotiation only)")
+	case p.Cast == CastUnicast && !o.Unicast:
+		return fmt.Errorf("unicast negotiation required (unicast_master_table or serve_unicast)")
+	}
+	if o.Domain == 0 {
+		o.Domain = p.Domain
+	}
+	if o.Domain < p.DomainMin || o.Domain > p.DomainMax {
+		return fmt.Errorf("domain %d out of range %d..%d", o.Domain, p.DomainMin, p.DomainMax)
+	}
+	intervals := []struct {
+		name string
+		v    *int
+		r    IntervalRange
+	}{
+		{"announce_interval", &o.AnnounceInterval, p.Announce},
+		{"sync_interval", &o.SyncInterval, p.Sync},
+		{"delayrequest_interval", &o.DelayRequestInterval, p.DelayReq},
+	}
+	for _, it := range intervals {
+		if *it.v == 0 {
+			*it.v = it.r.Default
+		}
+		if *it.v < it.r.Min || *it.v > it.r.Max {
+			return fmt.Errorf("%s %d out of range %d..%d", it.name, *it.v, it.r.Min, it.r.Max)
+		}
+	}
+	if p.Priority1Fixed && o.Priority1 != 0 && o.Priority1 != p.Priority1 {
+		return fmt.Errorf("priority1 is fixed at %d", p.Priority1)
+	}
+	if o.Priority1 == 0 {
+		o.Priority1 = p.Priority1
+	}
+	if o.Priority2 == 0 {
+		o.Priority2 = p.Priority2
+	}
+	return nil
+}
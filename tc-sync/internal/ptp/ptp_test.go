@@ -0,0 +1,1263 @@
+package ptp
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"math"
+	"math/rand"
+	"net"
+	"os"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/shiwa/timecard-mini/tc-sync/internal/timestamping"
+)
+
+func TestMessage_MarshalUnmarshal(t *testing.T) {
+	src := PortIdentity{Clock: ClockIdentity{1, 2, 3, 0xff, 0xfe, 4, 5, 6}, Port: 1}
+	ts := Timestamp{Seconds: 0x123456789A, Nanoseconds: 999999999}
+	msgs := []Message{
+		{Header: Header{Type: MsgSync, Domain: 24, Flags: FlagTwoStep, Correction: NewCorrection(1500 * time.Nanosecond), Source: src, Sequence: 7, LogMsgInterval: -3}, Timestamp: ts},
+		{Header: Header{Type: MsgDelayResp, Source: src, Sequence: 9, LogMsgInterval: 0}, Timestamp: ts, Port: PortIdentity{Clock: ClockIdentity{9}, Port: 2}},
+		{Header: Header{Type: MsgAnnounce, Source: src, Flags: FlagPTPTimescale | FlagCurrentUTCOffsetValid, LogMsgInterval: 1}, Timestamp: ts,
+			Announce: AnnounceBody{CurrentUTCOffset: 37, GrandmasterPriority1: 128, GrandmasterQuality: ClockQuality{Class: 6, Accuracy: 0x21, Variance: 0x4E5D},
+				GrandmasterPriority2: 127, GrandmasterIdentity: src.Clock, StepsRemoved: 1, TimeSource: 0x20}},
+		{Header: Header{Type: MsgSignaling, Source: src}, Port: PortIdentity{Clock: ClockIdentity{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, Port: 0xffff},
+			TLVs: []TLV{{Type: 4, Value: []byte{0xB0, 1, 0, 0, 0, 60}}}},
+	}
+	sizes := []int{44, 54, 64, 54}
+	for i := range msgs {
+		b := msgs[i].Marshal()
+		if len(b) != sizes[i] {
+			t.Errorf("%s: len %d want %d", msgs[i].Type, len(b), sizes[i])
+		}
+		got, err := Unmarshal(append(b, 0, 0)) // паддинг после messageLength игнорируется
+		if err != nil {
+			t.Fatalf("%s: %v", msgs[i].Type, err)
+		}
+		want := msgs[i]
+		want.Version = Version
+		want.Length = uint16(len(b))
+		if got.Header != want.Header || got.Timestamp != want.Timestamp || got.Port != want.Port || got.Announce != want.Announce ||
+			len(got.TLVs) != len(want.TLVs) {
+			t.Errorf("%s: got %+v\nwant %+v", want.Type, got, want)
+		}
+	}
+	if got := NewCorrection(-2500 * time.Nanosecond).Duration(); got != -2500*time.Nanosecond {
+		t.Errorf("correction %v", got)
+	}
+	if _, err := Unmarshal(make([]byte, 20)); err != ErrShortMessage {
+		t.Errorf("short: %v", err)
+	}
+}
+
+func TestCompareDatasets(t *testing.T) {
+	gm := Dataset{Priority1: 128, Quality: ClockQuality{Class: 6, Accuracy: 0x21, Variance: 0x4E5D}, Priority2: 128, GrandmasterIdentity: ClockIdentity{1}}
+	worse := gm
+	worse.GrandmasterIdentity = ClockIdentity{2}
+	worse.Quality.Class = 248
+	if CompareDatasets(gm, worse) >= 0 || CompareDatasets(worse, gm) <= 0 {
+		t.Error("clockClass 6 must win over 248")
+	}
+	prio := worse
+	prio.Priority1 = 100
+	if CompareDatasets(prio, gm) >= 0 {
+		t.Error("priority1 must be compared before clockClass")
+	}
+	// Тот же grandmaster: меньше stepsRemoved
+	far := gm
+	far.StepsRemoved = 2
+	if CompareDatasets(gm, far) >= 0 {
+		t.Error("fewer steps removed must win")
+	}
+}
+
+// testMaster — мастер для тестов: шлёт Announce и Sync (one-step/two-step) на адрес slave
+// и отвечает на Delay_Req; время мастера = локальное + offset в шкале TAI (UTC + 37 с).
+func TestBMCA_Alternate(t *testing.T) {
+	gm := Dataset{Priority1: 128, Quality: ClockQuality{Class: 6, Accuracy: 0x21, Variance: 0x4E5D}, Priority2: 128,
+		GrandmasterIdentity: ClockIdentity{1}, LocalPriority: DefaultLocalPriority}
+	prio := gm
+	prio.GrandmasterIdentity = ClockIdentity{2}
+	prio.Priority1, prio.Quality.Class = 1, 7
+	if BMCADefault.Compare(prio, gm) >= 0 || BMCAG8275.Compare(gm, prio) >= 0 || BMCAG8265.Compare(gm, prio) >= 0 {
+		t.Error("G.8275/G.8265.1 must ignore priority1")
+	}
+	// clockClass ≤ 127 у обоих: accuracy и priority2 не сравниваются, решает localPriority
+	other := gm
+	other.GrandmasterIdentity = ClockIdentity{3}
+	other.Quality.Accuracy, other.Priority2, other.LocalPriority = 0x31, 200, 10
+	if BMCAG8275.Compare(other, gm) >= 0 || BMCADefault.Compare(gm, other) >= 0 {
+		t.Error("G.8275: localPriority must decide between PRTC-locked masters")
+	}
+	// clockClass > 127: priority2 сравнивается раньше localPriority
+	free, freeOther := gm, other
+	free.Quality.Class, freeOther.Quality.Class = 248, 248
+	free.Quality.Accuracy = 0x31
+	if BMCAG8275.Compare(free, freeOther) >= 0 {
+		t.Error("G.8275: priority2 must be compared for clockClass > 127")
+	}
+	if BMCAG8265.Compare(freeOther, free) >= 0 {
+		t.Error("G.8265.1: localPriority after clockClass")
+	}
+}
+
+func TestApplyProfile(t *testing.T) {
+	for _, c := range []struct {
+		profile string
+		in      PortOptions
+		want    PortOptions
+		bmca    BMCA
+		classes ClockClasses
+	}{
+		{"", PortOptions{Domain: 3, SyncInterval: -3, Multicast: true},
+			PortOptions{Transport: TransportUDP, DelayMechanism: DelayE2E, Domain: 3, SyncInterval: -3, Priority1: 128, Priority2: 128, Multicast: true},
+			BMCADefault, DefaultClockClasses},
+		{"G.8275.1", PortOptions{Multicast: true},
+			PortOptions{Transport: TransportL2, DstMAC: MACPeerDelay, DelayMechanism: DelayE2E, Domain: 24, AnnounceInterval: -3, SyncInterval: -4,
+				DelayRequestInterval: -4, Priority1: 128, Priority2: 128, Multicast: true},
+			BMCAG8275, ClockClasses{6, 7, 140, 248}},
+		{"g.8275.1", PortOptions{DstMAC: MACPrimary, Domain: 43, Priority2: 20, Multicast: true},
+			PortOptions{Transport: TransportL2, DstMAC: MACPrimary, DelayMechanism: DelayE2E, Domain: 43, AnnounceInterval: -3, SyncInterval: -4,
+				DelayRequestInterval: -4, Priority1: 128, Priority2: 20, Multicast: true},
+			BMCAG8275, ClockClasses{6, 7, 140, 248}},
+		{"G.8275.2", PortOptions{Unicast: true},
+			PortOptions{Transport: TransportUDP, DelayMechanism: DelayE2E, Domain: 44, SyncInterval: -4, DelayRequestInterval: -4,
+				Priority1: 128, Priority2: 128, Unicast: true},
+			BMCAG8275, ClockClasses{6, 7, 140, 248}},
+		{"G.8275.2", PortOptions{Domain: 50, AnnounceInterval: -2, SyncInterval: -7, Unicast: true},
+			PortOptions{Transport: TransportUDP, DelayMechanism: DelayE2E, Domain: 50, AnnounceInterval: -2, SyncInterval: -7,
+				DelayRequestInterval: -4, Priority1: 128, Priority2: 128, Unicast: true},
+			BMCAG8275, ClockClasses{6, 7, 140, 248}},
+		{"G.8265.1", PortOptions{Unicast: true},
+			PortOptions{Transport: TransportUDP, DelayMechanism: DelayE2E, Domain: 4, AnnounceInterval: 1, SyncInterval: -4,
+				DelayRequestInterval: -4, Priority1: 128, Priority2: 128, Unicast: true},
+			BMCAG8265, ClockClasses{84, 90, 104, 110}},
+		{"enterprise-draft", PortOptions{Multicast: true, Unicast: true},
+			PortOptions{Transport: TransportUDP, DelayMechanism: DelayE2E, AnnounceInterval: 1, Priority1: 128, Priority2: 128,
+				Multicast: true, Unicast: true},
+			BMCADefault, DefaultClockClasses},
+		{"enterprise-draft", PortOptions{Domain: 5, Priority1: 64, SyncInterval: -5, Multicast: true},
+			PortOptions{Transport: TransportUDP, DelayMechanism: DelayE2E, Domain: 5, AnnounceInterval: 1, SyncInterval: -5,
+				Priority1: 64, Priority2: 128, Multicast: true},
+			BMCADefault, DefaultClockClasses},
+		{"IEC/IEEE 61850-9-3", PortOptions{Multicast: true},
+			PortOptions{Transport: TransportL2, DelayMechanism: DelayP2P, Priority1: 128, Priority2: 128, Multicast: true},
+			BMCADefault, ClockClasses{6, 7, 187, 248}},
+		{"61850-9-3", PortOptions{Domain: 93, Priority1: 100, Multicast: true},
+			PortOptions{Transport: TransportL2, DelayMechanism: DelayP2P, Domain: 93, Priority1: 100, Priority2: 128, Multicast: true},
+			BMCADefault, ClockClasses{6, 7, 187, 248}},
+		{"gptp", PortOptions{Multicast: true},
+			PortOptions{Transport: TransportL2, DstMAC: MACPeerDelay, DelayMechanism: DelayP2P, SyncInterval: -3, Priority1: 246, Priority2: 248, Multicast: true},
+			BMCADefault, DefaultClockClasses},
+		{"IEEE 802.1AS", PortOptions{Priority1: 100, DelayRequestInterval: 1, Multicast: true},
+			PortOptions{Transport: TransportL2, DstMAC: MACPeerDelay, DelayMechanism: DelayP2P, SyncInterval: -3, DelayRequestInterval: 1,
+				Priority1: 100, Priority2: 248, Multicast: true},
+			BMCADefault, DefaultClockClasses},
+	} {
+		got, p, err := ApplyProfile(c.profile, c.in)
+		if err != nil {
+			t.Errorf("%s %+v: %v", c.profile, c.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s:\ngot  %+v\nwant %+v", c.profile, got, c.want)
+		}
+		bmca, classes := BMCADefault, DefaultClockClasses
+		if p != nil {
+			bmca, classes = p.BMCA, p.ClockClasses
+		}
+		if bmca != c.bmca || classes != c.classes {
+			t.Errorf("%s: bmca %s classes %+v", c.profile, bmca, classes)
+		}
+	}
+}
+
+func TestApplyProfile_Errors(t *testing.T) {
+	for _, c := range []struct {
+		profile string
+		in      PortOptions
+	}{
+		{"G.8275.3", PortOptions{}},
+		{"", PortOptions{Transport: "tcp"}},
+		{"", PortOptions{DelayMechanism: "e2e-hybrid"}},
+		{"G.8275.1", PortOptions{Transport: TransportUDP, Multicast: true}},
+		{"G.8275.1", PortOptions{Unicast: true}},
+		{"G.8275.1", PortOptions{Domain: 44, Multicast: true}},
+		{"G.8275.1", PortOptions{SyncInterval: -3, Multicast: true}},
+		{"G.8275.1", PortOptions{Priority1: 100, Multicast: true}},
+		{"G.8275.1", PortOptions{DelayMechanism: DelayP2P, Multicast: true}},
+		{"G.8275.2", PortOptions{Multicast: true}},
+		{"G.8275.2", PortOptions{AnnounceInterval: 1, Unicast: true}},
+		{"G.8275.2", PortOptions{Transport: TransportL2, Unicast: true}},
+		{"G.8265.1", PortOptions{Domain: 24, Unicast: true}},
+		{"enterprise-draft", PortOptions{Domain: 128, Multicast: true}},
+		{"IEC/IEEE 61850-9-3", PortOptions{DelayMechanism: DelayE2E, Multicast: true}},
+		{"IEC/IEEE 61850-9-3", PortOptions{SyncInterval: -4, Multicast: true}},
+		{"gptp", PortOptions{DelayMechanism: DelayE2E, Multicast: true}},
+		{"gptp", PortOptions{Transport: TransportUDP, Multicast: true}},
+		{"gptp", PortOptions{SyncInterval: 1, Multicast: true}},
+		{"G.8275.1", PortOptions{HybridE2E: true, Multicast: true}},
+	} {
+		if _, _, err := ApplyProfile(c.profile, c.in); err == nil {
+			t.Errorf("%q %+v: expected error", c.profile, c.in)
+		}
+	}
+}
+
+type testMaster struct {
+	tr         *UDPTransport
+	slave      net.Addr
+	domain     uint8
+	offset     time.Duration
+	twoStep    bool
+	correction time.Duration // добавляется в correctionField Sync и вычитается из originTimestamp
+}
+
+const testUTCOffset = 37
+
+func (m *testMaster) masterTime(t time.Time) Timestamp {
+	return NewTimestamp(t.Add(m.offset + testUTCOffset*time.Second))
+}
+
+func (m *testMaster) run(ctx context.Context) {
+	id := PortIdentity{Clock: ClockIdentity{0xAA, 0xBB, 0xCC, 0xFF, 0xFE, 0, 0, 1}, Port: 1}
+	tick := time.NewTicker(50 * time.Millisecond)
+	defer tick.Stop()
+	var seq uint16
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case p, ok := <-m.tr.Packets():
+			if !ok {
+				return
+			}
+			req, err := Unmarshal(p.Data)
+			if err != nil || req.Type != MsgDelayReq {
+				continue
+			}
+			resp := Message{Header: Header{Type: MsgDelayResp, Domain: m.domain, Source: id, Sequence: req.Sequence,
+				Correction: NewCorrection(m.correction), LogMsgInterval: -3},
+				Timestamp: m.masterTime(p.Stamp.Time.Add(m.correction)), Port: req.Source}
+			_ = m.tr.SendGeneral(resp.Marshal(), m.slave)
+		case <-tick.C:
+			seq++
+			ann := Message{Header: Header{Type: MsgAnnounce, Domain: m.domain, Source: id, Sequence: seq,
+				Flags: FlagPTPTimescale | FlagCurrentUTCOffsetValid, LogMsgInterval: -3},
+				Announce: AnnounceBody{CurrentUTCOffset: testUTCOffset, GrandmasterPriority1: 128,
+					GrandmasterQuality: ClockQuality{Class: 6, Accuracy: 0x21, Variance: 0x4E5D}, GrandmasterPriority2: 128,
+					GrandmasterIdentity: id.Clock, TimeSource: 0x20}}
+			_ = m.tr.SendGeneral(ann.Marshal(), m.slave)
+			sync := Message{Header: Header{Type: MsgSync, Domain: m.domain, Source: id, Sequence: seq,
+				Correction: NewCorrection(m.correction), LogMsgInterval: -4}}
+			if m.twoStep {
+				sync.Flags |= FlagTwoStep
+				tx, err := m.tr.SendEvent(sync.Marshal(), m.slave)
+				if err != nil {
+					continue
+				}
+				fu := Message{Header: Header{Type: MsgFollowUp, Domain: m.domain, Source: id, Sequence: seq, LogMsgInterval: -4},
+					Timestamp: m.masterTime(tx.Time.Add(-m.correction))}
+				_ = m.tr.SendGeneral(fu.Marshal(), m.slave)
+			} else {
+				sync.Timestamp = m.masterTime(time.Now().Add(-m.correction))
+				_, _ = m.tr.SendEvent(sync.Marshal(), m.slave)
+			}
+		}
+	}
+}
+
+// loopbackPair открывает транспорты мастера (127.0.0.1) и slave (127.0.0.2) на одной паре портов
+func loopbackPair(t *testing.T) (master, slave *UDPTransport) {
+	t.Helper()
+	trs := loopbackTransports(t, 2)
+	return trs[0], trs[1]
+}
+
+// loopbackTransports открывает n транспортов на 127.0.0.1, 127.0.0.2, … с одной парой портов
+func loopbackTransports(t *testing.T, n int) []*UDPTransport {
+	t.Helper()
+	for i := 0; i < 20; i++ {
+		probe, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		port := probe.LocalAddr().(*net.UDPAddr).Port
+		probe.Close()
+		if port+1 > 65535 {
+			continue
+		}
+		var trs []*UDPTransport
+		for j := 1; j <= n; j++ {
+			tr, err := NewUDPTransport(UDPConfig{Address: net.IPv4(127, 0, 0, byte(j)).String(), EventPort: port, GeneralPort: port + 1})
+			if err != nil {
+				break
+			}
+			trs = append(trs, tr)
+		}
+		if len(trs) < n {
+			for _, tr := range trs {
+				tr.Close()
+			}
+			continue
+		}
+		t.Cleanup(func() {
+			for _, tr := range trs {
+				tr.Close()
+			}
+		})
+		return trs
+	}
+	t.Fatal("no free port pair")
+	return nil
+}
+
+func waitMeasurement(t *testing.T, s *Slave, n int) Measurement {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	var last Measurement
+	seen := 0
+	for time.Now().Before(deadline) {
+		if m, ok := s.Last(); ok && m.Time != last.Time {
+			last = m
+			if seen++; seen >= n {
+				return last
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("no measurement, state %s", s.State())
+	return last
+}
+
+func TestSlave_Loopback(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		twoStep    bool
+		correction time.Duration
+	}{
+		{"one-step", false, 0},
+		{"two-step", true, 0},
+		{"two-step correction", true, 3 * time.Millisecond},
+		{"one-step correction", false, 2 * time.Millisecond},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mtr, str := loopbackPair(t)
+			offset := 1500 * time.Millisecond
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			m := &testMaster{tr: mtr, slave: str.LocalAddr(), domain: 5, offset: offset, twoStep: tc.twoStep, correction: tc.correction}
+			go m.run(ctx)
+			s := NewSlave(str, SlaveConfig{Domain: 5, Masters: []net.IP{net.IPv4(127, 0, 0, 1)}})
+			go s.Run(ctx)
+
+			meas := waitMeasurement(t, s, 3)
+			if d := meas.OffsetFromMaster + offset; d > time.Millisecond || d < -time.Millisecond {
+				t.Errorf("offsetFromMaster %v, want ~%v", meas.OffsetFromMaster, -offset)
+			}
+			if meas.MeanPathDelay < 0 || meas.MeanPathDelay > time.Millisecond {
+				t.Errorf("meanPathDelay %v", meas.MeanPathDelay)
+			}
+			if meas.UTCOffset != testUTCOffset*time.Second || meas.Quality.Class != 6 {
+				t.Errorf("utc offset %v, class %d", meas.UTCOffset, meas.Quality.Class)
+			}
+			if s.State() != StateSlave {
+				t.Errorf("state %s", s.State())
+			}
+		})
+	}
+}
+
+func TestSlave_DomainMismatch(t *testing.T) {
+	mtr, str := loopbackPair(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go (&testMaster{tr: mtr, slave: str.LocalAddr(), domain: 0, twoStep: true}).run(ctx)
+	s := NewSlave(str, SlaveConfig{Domain: 1})
+	go s.Run(ctx)
+	time.Sleep(400 * time.Millisecond)
+	if _, ok := s.Last(); ok || s.State() != StateListening {
+		t.Errorf("slave synchronized to another domain, state %s", s.State())
+	}
+}
+
+// multicastTo — транспорт, отправляющий «multicast» (dst == nil) на заданный адрес: на loopback
+// нет multicast, мастер в тестах рассылает Announce/Sync прямо slave
+type multicastTo struct {
+	*UDPTransport
+	to net.Addr
+}
+
+func (t multicastTo) SendEvent(b []byte, dst net.Addr) (timestamping.Stamp, error) {
+	if dst == nil {
+		dst = t.to
+	}
+	return t.UDPTransport.SendEvent(b, dst)
+}
+
+func (t multicastTo) SendGeneral(b []byte, dst net.Addr) error {
+	if dst == nil {
+		dst = t.to
+	}
+	return t.UDPTransport.SendGeneral(b, dst)
+}
+
+func TestMaster_SlaveLoopback(t *testing.T) {
+	mtr, str := loopbackPair(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	m := NewMaster(multicastTo{mtr, str.LocalAddr()}, MasterConfig{Domain: 3, Priority1: 128, Priority2: 128,
+		LogAnnounceInterval: -3, LogSyncInterval: -4, LogMinDelayReqInterval: -4, Multicast: true, Unicast: true, ServerOnly: true})
+	tp := DefaultTimeProperties()
+	tp.Quality = ClockQuality{Class: ClockClassPrimary, Accuracy: AccuracyFor(80 * time.Nanosecond), Variance: 0x4E5D}
+	tp.TimeSource, tp.UTCOffsetValid, tp.TimeTraceable = TimeSourceGPS, true, true
+	m.SetTimeProperties(tp)
+	go m.Run(ctx)
+	s := NewSlave(str, SlaveConfig{Domain: 3, Masters: []net.IP{net.IPv4(127, 0, 0, 1)}})
+	go s.Run(ctx)
+
+	meas := waitMeasurement(t, s, 3)
+	// Мастер и slave на одних системных часах: offset ~0, шкала TAI пересчитана по currentUtcOffset
+	if meas.OffsetFromMaster > time.Millisecond || meas.OffsetFromMaster < -time.Millisecond {
+		t.Errorf("offsetFromMaster %v", meas.OffsetFromMaster)
+	}
+	if meas.UTCOffset != CurrentUTCOffset*time.Second {
+		t.Errorf("utc offset %v", meas.UTCOffset)
+	}
+	if meas.Quality != tp.Quality || meas.Grandmaster != m.Identity().Clock {
+		t.Errorf("quality %+v, grandmaster %s", meas.Quality, meas.Grandmaster)
+	}
+	if meas.SyncInterval != LogInterval(-4) {
+		t.Errorf("sync interval %v", meas.SyncInterval)
+	}
+}
+
+func TestMaster_PassiveWithBetterMaster(t *testing.T) {
+	mtr, str := loopbackPair(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// testMaster объявляет clockClass 6; наш мастер — 248 и без ServerOnly уступает
+	go (&testMaster{tr: str, slave: mtr.LocalAddr(), domain: 0, twoStep: true}).run(ctx)
+	m := NewMaster(mtr, MasterConfig{Priority1: 128, Priority2: 128, LogAnnounceInterval: -3, LogSyncInterval: -3})
+	go m.Run(ctx)
+	deadline := time.Now().Add(2 * time.Second)
+	for m.State() != StatePassive && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if m.State() != StatePassive {
+		t.Fatalf("state %s, want passive", m.State())
+	}
+
+	only := NewMaster(mtr, MasterConfig{ServerOnly: true})
+	only.handle(Packet{Data: (&Message{Header: Header{Type: MsgAnnounce, Source: PortIdentity{Clock: ClockIdentity{1}, Port: 1}},
+		Announce: AnnounceBody{GrandmasterQuality: ClockQuality{Class: 6}}}).Marshal()}, time.Now())
+	if only.State() != StateMaster || len(only.foreign) != 0 {
+		t.Errorf("server_only master must ignore Announce")
+	}
+}
+
+func TestAccuracyFor(t *testing.T) {
+	for _, tc := range []struct {
+		d    time.Duration
+		want uint8
+	}{
+		{0, 0x20}, {25 * time.Nanosecond, 0x20}, {26 * time.Nanosecond, 0x21}, {-900 * time.Nanosecond, 0x23},
+		{time.Millisecond, 0x29}, {5 * time.Second, 0x30}, {time.Minute, 0x31},
+	} {
+		if got := AccuracyFor(tc.d); got != tc.want {
+			t.Errorf("AccuracyFor(%v) = %#x, want %#x", tc.d, got, tc.want)
+		}
+	}
+}
+
+func TestVarianceFor(t *testing.T) {
+	// σ²PTP = τ²·σ²y/3 = 2^-50 с² — log2 −50 в единицах 2^-8 со смещением 0x8000
+	if got := VarianceFor(3*math.Exp2(-50), time.Second); got != 0x4E00 {
+		t.Errorf("VarianceFor(2^-50) = %#x, want 0x4e00", got)
+	}
+	if got := VarianceFor(0, time.Second); got != 0 {
+		t.Errorf("VarianceFor(0) = %#x", got)
+	}
+	if got := VarianceFor(1e300, time.Hour); got != 0xFFFE {
+		t.Errorf("VarianceFor(huge) = %#x", got)
+	}
+
+	// Категории удержания вне спецификации: G.8275.1 — 140/150/160, IEEE 1588 — 248
+	telecom, _ := LookupProfile("G.8275.1")
+	for st, want := range map[ClockState]uint8{ClockDegraded: 140, ClockDegraded2: 150, ClockDegraded3: 160} {
+		if got := telecom.ClockClasses.Class(st); got != want {
+			t.Errorf("G.8275.1 state %d: class %d, want %d", st, got, want)
+		}
+		if got := DefaultClockClasses.Class(st); got != ClockClassDefault {
+			t.Errorf("default state %d: class %d", st, got)
+		}
+	}
+}
+
+func TestUnicastTLV(t *testing.T) {
+	for _, u := range []UnicastTLV{
+		{Kind: TLVRequestUnicast, Type: MsgSync, LogInterval: -4, Duration: 300},
+		{Kind: TLVGrantUnicast, Type: MsgAnnounce, LogInterval: 1, Duration: 60, Renewal: true},
+		{Kind: TLVGrantUnicast, Type: MsgDelayResp, LogInterval: -7},
+		{Kind: TLVCancelUnicast, Type: MsgSync},
+		{Kind: TLVAckCancelUnicast, Type: MsgAnnounce},
+	} {
+		msg := Message{Header: Header{Type: MsgSignaling}, Port: AllPorts, TLVs: []TLV{u.TLV()}}
+		got, err := Unmarshal(msg.Marshal())
+		if err != nil {
+			t.Fatal(err)
+		}
+		p, ok := ParseUnicastTLV(got.TLVs[0])
+		if !ok || p != u {
+			t.Errorf("got %+v, want %+v", p, u)
+		}
+	}
+	if _, ok := ParseUnicastTLV(TLV{Type: TLVGrantUnicast, Value: make([]byte, 6)}); ok {
+		t.Error("short GRANT accepted")
+	}
+}
+
+func TestSubscriptions(t *testing.T) {
+	now := time.Now()
+	a := PortIdentity{Clock: ClockIdentity{1}, Port: 1}
+	b := PortIdentity{Clock: ClockIdentity{2}, Port: 1}
+	subs := subscriptions{max: 1}
+	req := UnicastTLV{Kind: TLVRequestUnicast, Type: MsgSync, LogInterval: -3, Duration: 5000}
+	if g := subs.grant(req, a, nil, now); g.Duration != uint32(maxGrantDuration/time.Second) || !g.Renewal {
+		t.Errorf("grant %+v", g)
+	}
+	req.Type = MsgAnnounce
+	if g := subs.grant(req, a, nil, now); g.Duration == 0 {
+		t.Error("second message type of the same client must be granted")
+	}
+	if g := subs.grant(req, b, nil, now); g.Duration != 0 {
+		t.Error("max_unicast_subscribers exceeded, grant must be denied")
+	}
+	if g := subs.grant(UnicastTLV{Kind: TLVRequestUnicast, Type: MsgFollowUp, Duration: 60}, a, nil, now); g.Duration != 0 {
+		t.Error("Follow_Up cannot be requested")
+	}
+	if n := len(subs.due(now)); n != 2 {
+		t.Errorf("due %d, want 2", n)
+	}
+	if n := len(subs.due(now.Add(50 * time.Millisecond))); n != 0 {
+		t.Errorf("due before interval: %d", n)
+	}
+	if next := subs.nextDue(); !next.Equal(now.Add(LogInterval(-3))) {
+		t.Errorf("next due %v", next.Sub(now))
+	}
+	subs.cancel(a, MsgAnnounce)
+	if l := subs.list(); len(l) != 1 || l[0].Type != MsgSync {
+		t.Errorf("after cancel: %+v", l)
+	}
+	subs.expire(now.Add(maxGrantDuration + time.Second))
+	if subs.clients() != 0 {
+		t.Error("expired grant not removed")
+	}
+	if g := subs.grant(req, b, nil, now); g.Duration == 0 {
+		t.Error("grant after expiry must succeed")
+	}
+}
+
+func TestMaster_UnicastNegotiation(t *testing.T) {
+	trs := loopbackTransports(t, 3)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	m := NewMaster(trs[0], MasterConfig{Priority1: 128, Priority2: 128, LogAnnounceInterval: 1, LogSyncInterval: 1,
+		Unicast: true, ServerOnly: true, MaxUnicastSubscribers: 1})
+	go m.Run(ctx)
+	masters := []net.IP{net.IPv4(127, 0, 0, 1)}
+	cfg := SlaveConfig{Masters: masters, Negotiate: true, LogAnnounceInterval: -3, LogSyncInterval: -4, LogDelayRespInterval: -4, GrantDuration: time.Minute}
+	s := NewSlave(trs[1], cfg)
+	go s.Run(ctx)
+
+	meas := waitMeasurement(t, s, 3)
+	if meas.OffsetFromMaster > time.Millisecond || meas.OffsetFromMaster < -time.Millisecond {
+		t.Errorf("offsetFromMaster %v", meas.OffsetFromMaster)
+	}
+	if meas.SyncInterval != LogInterval(-4) {
+		t.Errorf("sync interval %v: granted interval not used", meas.SyncInterval)
+	}
+	subs := m.Subscriptions()
+	if len(subs) != 3 {
+		t.Fatalf("subscriptions %+v", subs)
+	}
+	for i, typ := range []MessageType{MsgSync, MsgDelayResp, MsgAnnounce} {
+		if subs[i].Client != s.Identity() || subs[i].Type != typ || subs[i].Expires.Sub(subs[i].Granted) != time.Minute {
+			t.Errorf("subscription %d: %+v", i, subs[i])
+		}
+	}
+
+	// Второй клиент сверх max_unicast_subscribers получает отказ
+	other := NewSlave(trs[2], cfg)
+	go other.Run(ctx)
+	time.Sleep(300 * time.Millisecond)
+	for _, sub := range m.Subscriptions() {
+		if sub.Client == other.Identity() {
+			t.Errorf("grant beyond max_unicast_subscribers: %+v", sub)
+		}
+	}
+	if other.State() != StateListening {
+		t.Errorf("denied slave state %s", other.State())
+	}
+}
+
+// l2Loopback открывает транспорт Ethernet на lo; без CAP_NET_RAW тест пропускается
+func l2Loopback(t *testing.T) *L2Transport {
+	t.Helper()
+	if runtime.GOOS != "linux" {
+		t.Skip("layer 2 transport is linux only")
+	}
+	tr, err := NewL2Transport(L2Config{Interface: "lo"})
+	if errors.Is(err, os.ErrPermission) {
+		t.Skipf("no CAP_NET_RAW: %v", err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { tr.Close() })
+	return tr
+}
+
+func TestL2Transport_Dest(t *testing.T) {
+	tr := &L2Transport{cfg: L2Config{DstMAC: MACPeerDelay}}
+	announce := (&Message{Header: Header{Type: MsgAnnounce}}).Marshal()
+	pdelay := (&Message{Header: Header{Type: MsgPdelayReq}}).Marshal()
+	unicast := net.HardwareAddr{0x02, 0, 0, 0, 0, 1}
+	for _, c := range []struct {
+		b    []byte
+		dst  net.Addr
+		want net.HardwareAddr
+	}{
+		{announce, nil, MACPeerDelay},
+		{pdelay, nil, MACPeerDelay},
+		{announce, &L2Addr{MAC: unicast}, unicast},
+	} {
+		got, err := tr.dest(c.b, c.dst)
+		if err != nil || got.String() != c.want.String() {
+			t.Errorf("dest(%v, %v) = %v, %v; want %v", MessageType(c.b[0]&0x0f), c.dst, got, err, c.want)
+		}
+	}
+	if _, err := tr.dest(announce, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}); err == nil {
+		t.Error("UDP address accepted by layer 2 transport")
+	}
+}
+
+func TestL2Transport_MasterSlave(t *testing.T) {
+	mtr, str := l2Loopback(t), l2Loopback(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	m := NewMaster(mtr, MasterConfig{Domain: 5, Priority1: 128, Priority2: 128,
+		LogAnnounceInterval: -3, LogSyncInterval: -4, LogMinDelayReqInterval: -4, Multicast: true, ServerOnly: true})
+	go m.Run(ctx)
+	s := NewSlave(str, SlaveConfig{Domain: 5})
+	go s.Run(ctx)
+
+	meas := waitMeasurement(t, s, 3)
+	if meas.OffsetFromMaster > time.Millisecond || meas.OffsetFromMaster < -time.Millisecond {
+		t.Errorf("offsetFromMaster %v", meas.OffsetFromMaster)
+	}
+	if meas.Grandmaster != m.Identity().Clock || meas.TimestampType != str.TimestampType() {
+		t.Errorf("grandmaster %s, timestamps %s", meas.Grandmaster, meas.TimestampType)
+	}
+}
+
+func TestMaster_SlaveP2P(t *testing.T) {
+	mtr, str := loopbackPair(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	m := NewMaster(multicastTo{mtr, str.LocalAddr()}, MasterConfig{Domain: 3, Priority1: 128, Priority2: 128,
+		LogAnnounceInterval: -3, LogSyncInterval: -4, Multicast: true, ServerOnly: true,
+		DelayMechanism: DelayP2P, PeerDelay: PeerDelayConfig{LogInterval: -4}})
+	go m.Run(ctx)
+	s := NewSlave(multicastTo{str, mtr.LocalAddr()}, SlaveConfig{Domain: 3, Identity: PortIdentity{Clock: ClockIdentity{2}, Port: 1},
+		DelayMechanism: DelayP2P, PeerDelay: PeerDelayConfig{LogInterval: -4}})
+	go s.Run(ctx)
+
+	meas := waitMeasurement(t, s, 3)
+	if meas.OffsetFromMaster > time.Millisecond || meas.OffsetFromMaster < -time.Millisecond {
+		t.Errorf("offsetFromMaster %v", meas.OffsetFromMaster)
+	}
+	st, ok := s.PeerDelay()
+	if !ok || !st.Valid || st.Peer != m.Identity() || st.NeighborRateRatio != 1 || meas.MeanPathDelay != st.MeanLinkDelay {
+		t.Errorf("slave peer delay %+v, mean path delay %v", st, meas.MeanPathDelay)
+	}
+	// Мастер тоже измеряет задержку линии до slave
+	deadline := time.Now().Add(2 * time.Second)
+	for st, _ = m.PeerDelay(); !st.Valid && time.Now().Before(deadline); st, _ = m.PeerDelay() {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !st.Valid || st.Peer != s.cfg.Identity {
+		t.Errorf("master peer delay %+v", st)
+	}
+}
+
+func TestL2Transport_GPTP(t *testing.T) {
+	mtr, str := l2Loopback(t), l2Loopback(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// Программные метки на lo — единицы микросекунд: порог asCapable увеличен
+	pd := PeerDelayConfig{LogInterval: -4, GPTP: true, NeighborPropDelayThresh: 10 * time.Millisecond}
+	m := NewMaster(mtr, MasterConfig{Identity: PortIdentity{Clock: ClockIdentity{1}, Port: 1}, Priority1: 246, Priority2: 248,
+		LogAnnounceInterval: -3, LogSyncInterval: -4, Multicast: true, ServerOnly: true, DelayMechanism: DelayP2P, PeerDelay: pd})
+	go m.Run(ctx)
+	s := NewSlave(str, SlaveConfig{Identity: PortIdentity{Clock: ClockIdentity{2}, Port: 1}, DelayMechanism: DelayP2P, PeerDelay: pd})
+	go s.Run(ctx)
+
+	meas := waitMeasurement(t, s, 3)
+	if meas.Grandmaster != m.Identity().Clock {
+		t.Errorf("grandmaster %s", meas.Grandmaster)
+	}
+	st, _ := s.PeerDelay()
+	if !st.ASCapable || st.NeighborRateRatio < 1-maxRateOffset || st.NeighborRateRatio > 1+maxRateOffset {
+		t.Errorf("slave peer delay %+v", st)
+	}
+}
+
+func TestPeerDelay_RateRatio(t *testing.T) {
+	pd := newPeerDelay(nil, PortIdentity{}, 0, SdoIDGPTP, PeerDelayConfig{GPTP: true})
+	if pd.cfg.NeighborPropDelayThresh != DefaultNeighborPropDelayThresh {
+		t.Errorf("thresh %v", pd.cfg.NeighborPropDelayThresh)
+	}
+	// Частота соседа на 100 ppm выше; задержка линии 500 нс, обработка запроса соседом 10 мкс
+	t0, r := time.Unix(1000, 0), 1.0001
+	for i := 0; i < 10; i++ {
+		pd.pending = true
+		pd.t1 = t0.Add(time.Duration(i) * time.Second)
+		pd.t2 = t0.Add(time.Duration(r * float64(time.Duration(i)*time.Second+500*time.Nanosecond)))
+		t3 := pd.t2.Add(10 * time.Microsecond)
+		pd.t4 = pd.t1.Add(time.Duration(float64(10*time.Microsecond)/r) + time.Microsecond)
+		pd.complete(t3, 0)
+	}
+	st := pd.Status()
+	if r := st.NeighborRateRatio; r < 1.0000999 || r > 1.0001001 {
+		t.Errorf("neighborRateRatio %.9f", r)
+	}
+	if d := st.MeanLinkDelay - 500*time.Nanosecond; d < -2 || d > 2 || !st.ASCapable {
+		t.Errorf("peer delay %+v", st)
+	}
+	pd.multipleResponders()
+	if pd.Status().ASCapable {
+		t.Error("asCapable with multiple responders")
+	}
+	// Задержка выше порога: не asCapable
+	pd.reset()
+	pd.pending, pd.multiple = true, false
+	pd.t1, pd.t2 = t0, t0
+	pd.t4 = t0.Add(2 * time.Microsecond)
+	pd.complete(t0, 0)
+	if st := pd.Status(); !st.Valid || st.ASCapable {
+		t.Errorf("above threshold: %+v", st)
+	}
+}
+
+func TestMaster_SlaveHybridE2E(t *testing.T) {
+	for _, relax := range []bool{false, true} {
+		mtr, str := loopbackPair(t)
+		ctx, cancel := context.WithCancel(context.Background())
+		// Мастер только multicast: unicast Delay_Req slave (на loopback multicast не доходит) — hybrid
+		m := NewMaster(multicastTo{mtr, str.LocalAddr()}, MasterConfig{Domain: 3, Priority1: 128, Priority2: 128,
+			LogAnnounceInterval: -3, LogSyncInterval: -4, LogMinDelayReqInterval: -4, Multicast: true, ServerOnly: true})
+		go m.Run(ctx)
+		s := NewSlave(str, SlaveConfig{Domain: 3, HybridE2E: true, RelaxDelayRequests: relax})
+		go s.Run(ctx)
+
+		start := time.Now()
+		meas := waitMeasurement(t, s, 1)
+		if meas.OffsetFromMaster > time.Millisecond || meas.OffsetFromMaster < -time.Millisecond {
+			t.Errorf("relax %v: offsetFromMaster %v", relax, meas.OffsetFromMaster)
+		}
+		if relax && meas.Time.Sub(start) < relaxDelayMin {
+			t.Errorf("relax: first measurement after %v", meas.Time.Sub(start))
+		}
+		cancel()
+	}
+}
+
+func TestMux(t *testing.T) {
+	mtr, str := loopbackPair(t)
+	closed := make(chan struct{})
+	mux := NewMux(str, func() { close(closed) })
+	a, b := mux.Port(), mux.Port()
+	msg := (&Message{Header: Header{Type: MsgAnnounce, Domain: 5}}).Marshal()
+	if err := mtr.SendGeneral(msg, str.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []*MuxPort{a, b} {
+		select {
+		case pkt := <-p.Packets():
+			if pkt.Event || len(pkt.Data) != len(msg) {
+				t.Errorf("packet %+v", pkt)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("port did not receive packet")
+		}
+	}
+	// Транспорт закрывается вместе с последним портом
+	a.Close()
+	select {
+	case <-closed:
+		t.Fatal("transport closed with a port still open")
+	case <-time.After(50 * time.Millisecond):
+	}
+	b.Close()
+	select {
+	case <-closed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("transport not closed after last port")
+	}
+	if mux.Port() != nil {
+		t.Error("port of closed mux")
+	}
+}
+
+func TestDiscovery(t *testing.T) {
+	mtr, str := loopbackPair(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	m := NewMaster(multicastTo{mtr, str.LocalAddr()}, MasterConfig{Domain: 7, Priority1: 128, Priority2: 128,
+		LogAnnounceInterval: -3, LogSyncInterval: -3, Multicast: true, ServerOnly: true})
+	mctx, stop := context.WithCancel(ctx)
+	go m.Run(mctx)
+	d := NewDiscovery(str, ClockIdentity{9})
+	go d.Run(ctx)
+
+	deadline := time.Now().Add(3 * time.Second)
+	var found []DiscoveredDomain
+	for len(found) == 0 && time.Now().Before(deadline) {
+		time.Sleep(20 * time.Millisecond)
+		found = d.Domains(time.Now())
+	}
+	if len(found) != 1 || found[0].Domain != 7 || found[0].Best.GrandmasterIdentity != m.Identity().Clock || found[0].Masters != 1 {
+		t.Fatalf("domains %+v", found)
+	}
+
+	// Announce прекратились — домен пропадает
+	stop()
+	if found := d.Domains(time.Now().Add(10 * time.Second)); len(found) != 0 {
+		t.Errorf("expired domains %+v", found)
+	}
+
+	// Собственные Announce не учитываются
+	own := NewDiscovery(nil, m.Identity().Clock)
+	own.handle(Packet{Data: (&Message{Header: Header{Type: MsgAnnounce, Source: m.Identity()}}).Marshal()}, time.Now())
+	if len(own.domains) != 0 {
+		t.Error("own Announce accepted")
+	}
+}
+
+func TestPortStats(t *testing.T) {
+	st := newPortStats()
+	peer := PortIdentity{Clock: ClockIdentity{1}, Port: 1}
+	now := time.Now()
+	// Sync 8 в секунду: 1, 2, 4 (пропуск 3), 4 (повтор), 5
+	for i, seq := range []uint16{1, 2, 4, 4, 5} {
+		m := &Message{Header: Header{Type: MsgSync, Source: peer, Sequence: seq, LogMsgInterval: -3}}
+		st.received(m, &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 319}, now.Add(time.Duration(i)*125*time.Millisecond))
+	}
+	// Delay_Resp копирует sequenceId запроса — не проверяется
+	st.received(&Message{Header: Header{Type: MsgDelayResp, Source: peer, Sequence: 100}}, nil, now)
+	st.received(&Message{Header: Header{Type: MsgDelayResp, Source: peer, Sequence: 7}}, nil, now)
+
+	st.sent((&Message{Header: Header{Type: MsgDelayReq}}).Marshal(), true, timestamping.Stamp{Type: timestamping.Software}, timestamping.Hardware, nil)
+	st.sent((&Message{Header: Header{Type: MsgDelayReq}}).Marshal(), true, timestamping.Stamp{Type: timestamping.Hardware}, timestamping.Hardware, nil)
+	st.sent((&Message{Header: Header{Type: MsgSignaling}}).Marshal(), false, timestamping.Stamp{}, timestamping.Software, errors.New("send"))
+
+	s := st.snapshot()
+	if s.In[MsgSync] != 5 || s.In[MsgDelayResp] != 2 || s.Out[MsgDelayReq] != 2 || s.SendErrors != 1 || s.TXTimestampMisses != 1 {
+		t.Errorf("port stats %+v", s)
+	}
+	if s.SequenceGaps != 1 || s.OutOfOrder != 1 || len(s.Peers) != 1 {
+		t.Fatalf("sequence: %+v", s)
+	}
+	p := s.Peers[0]
+	if p.Peer != peer || p.Addr != "10.0.0.1:319" || p.DesiredSyncPPS != 8 || p.ActualSyncPPS < 7.99 || p.ActualSyncPPS > 8.01 {
+		t.Errorf("peer %+v", p)
+	}
+	if got := s.In.String(); got != "Sync 5, Delay_Resp 2" {
+		t.Errorf("counts %q", got)
+	}
+}
+
+func TestMaster_SlaveStats(t *testing.T) {
+	mtr, str := loopbackPair(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	q := NewTxQueue(20 * time.Microsecond)
+	go q.Run(ctx)
+	m := NewMaster(multicastTo{mtr, str.LocalAddr()}, MasterConfig{Domain: 3, Priority1: 128, Priority2: 128,
+		LogAnnounceInterval: -3, LogSyncInterval: -4, LogMinDelayReqInterval: -4, Multicast: true, ServerOnly: true, TxQueue: q})
+	go m.Run(ctx)
+	s := NewSlave(str, SlaveConfig{Domain: 3, HybridE2E: true})
+	go s.Run(ctx)
+	waitMeasurement(t, s, 3)
+
+	ss, ms := s.Stats(), m.Stats()
+	if ss.In[MsgSync] == 0 || ss.In[MsgAnnounce] == 0 || ss.Out[MsgDelayReq] == 0 || len(ss.Peers) != 1 || ss.Peers[0].Peer != m.Identity() {
+		t.Errorf("slave stats %+v", ss)
+	}
+	if ms.Out[MsgSync] == 0 || ms.Out[MsgFollowUp] == 0 || ms.In[MsgDelayReq] == 0 || ms.DesiredSyncPPS != 16 {
+		t.Errorf("master stats %+v", ms)
+	}
+	if qs := q.Stats(); qs.Sent == 0 || qs.Errors != 0 {
+		t.Errorf("tx queue %+v", qs)
+	}
+}
+
+func TestParseDSCP(t *testing.T) {
+	for in, want := range map[string]int{"": 0, "be": 0, "46": 46, "ef": 46, "EF": 46, "af33": 30, "af11": 10, "cs6": 48, "va": 44, "0": 0} {
+		if got, err := ParseDSCP(in); err != nil || got != want {
+			t.Errorf("ParseDSCP(%q) = %d, %v; want %d", in, got, err, want)
+		}
+	}
+	for _, bad := range []string{"64", "-1", "af44", "af51", "cs8", "xx"} {
+		if _, err := ParseDSCP(bad); err == nil {
+			t.Errorf("ParseDSCP(%q): expected error", bad)
+		}
+	}
+}
+
+func TestUDPTransport_IPv6(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("socket options are linux only")
+	}
+	probe, err := net.ListenUDP("udp6", &net.UDPAddr{IP: net.IPv6loopback})
+	if err != nil {
+		t.Skipf("no IPv6: %v", err)
+	}
+	port := probe.LocalAddr().(*net.UDPAddr).Port
+	probe.Close()
+	tr, err := NewUDPTransport(UDPConfig{Address: "::1", IPv6: true, EventPort: port, GeneralPort: port + 1,
+		Socket: SocketOptions{DSCPEvent: 46, DSCPGeneral: 34, MulticastTTL: 4}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tr.Close()
+	msg := (&Message{Header: Header{Type: MsgSync, Sequence: 7}}).Marshal()
+	if _, err := tr.SendEvent(msg, tr.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case p := <-tr.Packets():
+		if !p.Event || !ipOf(p.Src).Equal(net.IPv6loopback) {
+			t.Errorf("packet from %v, event %v", p.Src, p.Event)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no packet")
+	}
+
+	// Multicast по умолчанию: ff0e::181, peer delay — ff02::6b на интерфейсе транспорта
+	tr6 := &UDPTransport{cfg: UDPConfig{Interface: "eth1", IPv6: true}}
+	if a, _ := tr6.dest(msg, nil, EventPort); !a.IP.Equal(MulticastPrimaryIPv6) || a.Zone != "eth1" {
+		t.Errorf("multicast %v", a)
+	}
+	pdelay := (&Message{Header: Header{Type: MsgPdelayReq}}).Marshal()
+	if a, _ := tr6.dest(pdelay, nil, EventPort); !a.IP.Equal(MulticastPeerDelayIPv6) {
+		t.Errorf("peer delay %v", a)
+	}
+}
+
+func TestAdmission(t *testing.T) {
+	src := func(last byte) net.Addr { return &net.UDPAddr{IP: net.IPv4(10, 0, 0, last), Port: 319} }
+	now := time.Now()
+	if a := newAdmission(0); !a.allow(src(1), now) || a.snapshot(now).MaxPPS != 0 {
+		t.Error("max_packets_per_second 0: expected no limit")
+	}
+
+	// Порог 100 пакетов/с: клиент .1 — 150 запросов/с, клиент .2 — 20 запросов/с, 3 секунды
+	a := newAdmission(100)
+	a.random = rand.New(rand.NewSource(1)).Float64
+	for i := 0; i < 3*150; i++ {
+		t0 := now.Add(time.Duration(i) * time.Second / 150)
+		a.allow(src(1), t0)
+		if i%15 == 0 {
+			a.allow(src(2), t0)
+		}
+	}
+	end := now.Add(3 * time.Second)
+	st := a.snapshot(end)
+	if st.MaxPPS != 100 || st.Accepted+st.Dropped != 3*150+3*10 || st.Dropped == 0 || len(st.Clients) != 2 {
+		t.Fatalf("admission %+v", st)
+	}
+	flood, quiet := st.Clients[0], st.Clients[1]
+	if flood.Addr != "10.0.0.1" || quiet.Addr != "10.0.0.2" {
+		t.Fatalf("clients %+v", st.Clients)
+	}
+	ratio := func(c ClientAdmission) float64 { return float64(c.Dropped) / float64(c.Accepted+c.Dropped) }
+	if ratio(quiet) > 0.3 || ratio(flood) <= ratio(quiet) {
+		t.Errorf("drop ratio: flood %.2f, quiet %.2f", ratio(flood), ratio(quiet))
+	}
+	if st.Rate < 150 || st.Rate > 190 {
+		t.Errorf("rate %.1f", st.Rate)
+	}
+	// Клиенты без запросов удаляются из таблицы
+	if st := a.snapshot(end.Add(2 * admissionClientExpiry)); len(st.Clients) != 0 || st.Dropped == 0 {
+		t.Errorf("expired %+v", st)
+	}
+}
+
+// Число активных клиентов пересчитывается не чаще раза в τ
+func TestAdmissionActiveCount(t *testing.T) {
+	src := func(last byte) net.Addr { return &net.UDPAddr{IP: net.IPv4(10, 0, 0, last), Port: 319} }
+	now := time.Now()
+	a := newAdmission(1)
+	a.random = func() float64 { return 1 }
+	a.allow(src(1), now)
+	a.allow(src(2), now)
+	if n := a.activeCount(now); n != 2 {
+		t.Fatalf("active %d, want 2", n)
+	}
+	a.allow(src(3), now.Add(admissionTau/2))
+	if n := a.activeCount(now.Add(admissionTau / 2)); n != 2 {
+		t.Errorf("active within τ %d, want cached 2", n)
+	}
+	if n := a.activeCount(now.Add(admissionTau)); n != 3 {
+		t.Errorf("active after τ %d, want 3", n)
+	}
+	if n := a.activeCount(now.Add(4 * admissionTau)); n != 0 {
+		t.Errorf("active after 4τ %d, want 0", n)
+	}
+}
+
+// burstTransport — сетевая карта, теряющая метку передачи, если пакет отправлен раньше чем через
+// minGap после предыдущего
+type burstTransport struct {
+	minGap time.Duration
+	mu     sync.Mutex
+	sends  []time.Time
+}
+
+func (t *burstTransport) SendEvent(b []byte, dst net.Addr) (timestamping.Stamp, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	st := timestamping.Stamp{Time: now, Type: timestamping.Hardware}
+	if n := len(t.sends); n > 0 && now.Sub(t.sends[n-1]) < t.minGap {
+		st.Type = timestamping.Software
+	}
+	t.sends = append(t.sends, now)
+	return st, nil
+}
+
+func (t *burstTransport) SendGeneral(b []byte, dst net.Addr) error {
+	t.mu.Lock()
+	t.sends = append(t.sends, time.Now())
+	t.mu.Unlock()
+	return nil
+}
+
+func (t *burstTransport) Packets() <-chan Packet           { return nil }
+func (t *burstTransport) TimestampType() timestamping.Type { return timestamping.Hardware }
+func (t *burstTransport) Close() error                     { return nil }
+
+func TestTxQueue(t *testing.T) {
+	msg := (&Message{Header: Header{Type: MsgSync}}).Marshal()
+	// Без очереди: отправки подряд теряют метки
+	direct := &burstTransport{minGap: 50 * time.Microsecond}
+	misses := 0
+	for i := 0; i < 20; i++ {
+		if st, _ := direct.SendEvent(msg, nil); st.Type == timestamping.Software {
+			misses++
+		}
+	}
+	if misses == 0 {
+		t.Skip("sends too slow to reproduce back-to-back timestamp loss")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	q := NewTxQueue(100 * time.Microsecond)
+	go q.Run(ctx)
+	spaced := &burstTransport{minGap: 50 * time.Microsecond}
+	tr := q.Transport(spaced)
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 10; i++ {
+				if st, err := tr.SendEvent(msg, nil); err != nil || st.Type != timestamping.Hardware {
+					t.Errorf("queued send: %v %v", st.Type, err)
+				}
+				if err := tr.SendGeneral(msg, nil); err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	for i := 1; i < len(spaced.sends); i++ {
+		if d := spaced.sends[i].Sub(spaced.sends[i-1]); d < 100*time.Microsecond {
+			t.Fatalf("send %d: gap %v", i, d)
+		}
+	}
+	st := q.Stats()
+	if st.Gap != 100*time.Microsecond || st.Sent != 80 || st.Delayed == 0 || st.Events+st.SpacedEvents != 40 || st.Misses+st.SpacedMisses != 0 {
+		t.Errorf("stats %+v", st)
+	}
+	if loss, spacedLoss := st.LossRate(); loss != 0 || spacedLoss != 0 {
+		t.Errorf("loss rate %v %v", loss, spacedLoss)
+	}
+
+	cancel()
+	deadline := time.Now().Add(time.Second)
+	for {
+		_, err := tr.SendEvent(msg, nil)
+		if err == ErrTxQueueClosed {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("send after Run: expected ErrTxQueueClosed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// Отмена во время ожидания интервала: Run завершается, ожидающее сообщение не отправляется
+func TestTxQueue_CancelDuringGap(t *testing.T) {
+	msg := (&Message{Header: Header{Type: MsgSync}}).Marshal()
+	ctx, cancel := context.WithCancel(context.Background())
+	q := NewTxQueue(time.Hour)
+	done := make(chan struct{})
+	go func() {
+		q.Run(ctx)
+		close(done)
+	}()
+	spaced := &burstTransport{}
+	tr := q.Transport(spaced)
+	if _, err := tr.SendEvent(msg, nil); err != nil {
+		t.Fatal(err)
+	}
+	errc := make(chan error, 1)
+	go func() {
+		_, err := tr.SendEvent(msg, nil)
+		errc <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Run not stopped while waiting for the gap")
+	}
+	if err := <-errc; err != ErrTxQueueClosed {
+		t.Errorf("pending send: %v, want ErrTxQueueClosed", err)
+	}
+	if len(spaced.sends) != 1 {
+		t.Errorf("%d sends, want 1", len(spaced.sends))
+	}
+}
+
+const testSecurityKeys = `
+# id algorithm key [from [until]]
+1 HMAC-SHA256 HEX:000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f - 2026-06-01T00:00:00Z
+2 HMAC-SHA256-128 ASCII:rollover-key-0002 2026-05-01T00:00:00Z
+`
+
+func TestSecurityAssociation(t *testing.T) {
+	keys, err := ParseSecurityKeys(strings.NewReader(testSecurityKeys))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sa := &SecurityAssociation{SPP: 3, Keys: keys}
+	if err := sa.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	// Расписание: до 1 мая — ключ 1, с 1 мая — ключ 2 (начал действовать последним), ключ 1 принимается до 1 июня
+	before, after := time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC), time.Date(2026, 5, 15, 0, 0, 0, 0, time.UTC)
+	if k := sa.SendKey(before); k == nil || k.ID != 1 {
+		t.Errorf("key before rollover: %+v", k)
+	}
+	if k := sa.SendKey(after); k == nil || k.ID != 2 || k.Algorithm.ICVSize() != 16 {
+		t.Errorf("key after rollover: %+v", k)
+	}
+
+	msg := (&Message{Header: Header{Type: MsgSync, Domain: 24, Sequence: 7, Correction: NewCorrection(time.Microsecond)},
+		Timestamp: NewTimestamp(before)}).Marshal()
+	signed, err := sa.Sign(msg, before)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(signed) != len(msg)+4+6+32 {
+		t.Fatalf("signed length %d", len(signed))
+	}
+	m, err := Unmarshal(signed)
+	if err != nil || m.MinorVersion != 1 || len(m.TLVs) != 1 || m.TLVs[0].Type != TLVAuthentication {
+		t.Fatalf("signed message %+v, %v", m, err)
+	}
+	if err := sa.Verify(signed, m, after); err != nil {
+		t.Errorf("verify with previous key during rollover: %v", err)
+	}
+	// correctionField меняют transparent clocks — в ICV не входит
+	binary.BigEndian.PutUint64(signed[8:16], uint64(NewCorrection(3*time.Microsecond)))
+	if err := sa.Verify(signed, m, before); err != nil {
+		t.Errorf("verify with changed correction: %v", err)
+	}
+	signed[HeaderSize+2]++ // originTimestamp
+	if err := sa.Verify(signed, m, before); err != ErrBadICV {
+		t.Errorf("tampered message: %v", err)
+	}
+	if err := sa.Verify(signed, m, time.Date(2026, 7, 1, 0, 0, 0, 0, time.UTC)); err != ErrUnknownKey {
+		t.Errorf("expired key: %v", err)
+	}
+	if err := (&SecurityAssociation{SPP: 4, Keys: keys}).Verify(signed, m, before); err != ErrUnknownKey {
+		t.Errorf("other SPP: %v", err)
+	}
+	plain, _ := Unmarshal(msg)
+	if err := sa.Verify(msg, plain, before); err != ErrNotAuthenticated {
+		t.Errorf("unauthenticated message: %v", err)
+	}
+	if _, err := sa.Sign(msg, time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)); err != nil {
+		t.Errorf("key 1 without start: %v", err)
+	}
+
+	for _, bad := range []string{"1 MD5 0102", "x HMAC-SHA256 abc", "1 HMAC-SHA256 abc yesterday", "1 HMAC-SHA256"} {
+		if _, err := ParseSecurityKeys(strings.NewReader(bad)); err == nil {
+			t.Errorf("%q: expected error", bad)
+		}
+	}
+	for _, bad := range []SecurityAssociation{{}, {Keys: []SecurityKey{{ID: 1, Algorithm: HMACSHA256, Secret: []byte("short")}}},
+		{Keys: []SecurityKey{{ID: 1, Secret: make([]byte, 16)}, {ID: 1, Secret: make([]byte, 16)}}}} {
+		if err := bad.Validate(); err == nil {
+			t.Errorf("%+v: expected error", bad)
+		}
+	}
+}
+
+func TestMaster_SlaveAuthentication(t *testing.T) {
+	sa := &SecurityAssociation{SPP: 1, Keys: []SecurityKey{{ID: 5, Algorithm: HMACSHA256, Secret: []byte("0123456789abcdef")}}}
+	run := func(t *testing.T, masterAuth *SecurityAssociation) *Slave {
+		mtr, str := loopbackPair(t)
+		ctx, cancel := context.WithCancel(context.Background())
+		t.Cleanup(cancel)
+		m := NewMaster(multicastTo{mtr, str.LocalAddr()}, MasterConfig{Domain: 3, Priority1: 128, Priority2: 128,
+			LogAnnounceInterval: -3, LogSyncInterval: -4, LogMinDelayReqInterval: -4, Multicast: true, ServerOnly: true, Auth: masterAuth})
+		go m.Run(ctx)
+		s := NewSlave(str, SlaveConfig{Domain: 3, HybridE2E: true, Auth: sa})
+		go s.Run(ctx)
+		return s
+	}
+	t.Run("authenticated", func(t *testing.T) {
+		s := run(t, sa)
+		waitMeasurement(t, s, 3)
+		if st := s.Stats(); st.Unauthenticated != 0 {
+			t.Errorf("unauthenticated %d", st.Unauthenticated)
+		}
+	})
+	// Мастер без ключа (подмена): сообщения отбрасываются и учитываются по отправителю
+	t.Run("spoofed", func(t *testing.T) {
+		s := run(t, &SecurityAssociation{SPP: 1, Keys: []SecurityKey{{ID: 5, Algorithm: HMACSHA256, Secret: []byte("fedcba9876543210")}}})
+		time.Sleep(400 * time.Millisecond)
+		st := s.Stats()
+		if _, ok := s.Last(); ok || s.State() != StateListening || st.In[MsgAnnounce] != 0 {
+			t.Errorf("slave accepted spoofed master, state %s", s.State())
+		}
+		if st.Unauthenticated == 0 || len(st.Peers) != 1 || st.Peers[0].Unauthenticated != st.Unauthenticated {
+			t.Errorf("stats %+v", st)
+		}
+	})
+}
@@ -0,0 +1,551 @@
+package ptp
+
+import (
+	"context"
+	"math/rand"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/shiwa/timecard-mini/tc-sync/internal/timestamping"
+)
+
+// SlaveConfig — параметры порта slave
+type SlaveConfig struct {
+	Domain   uint8
+	Identity PortIdentity // нулевой clockIdentity — DefaultClockIdentity(""), порт 1
+	// Masters — unicast мастера: Announce принимаются только от них, Delay_Req отправляется
+	// на адрес мастера. Пусто — multicast.
+	Masters []net.IP
+	// DelayReqInterval — интервал Delay_Req; 0 — logMessageInterval из Delay_Resp (по умолчанию 1 с)
+	DelayReqInterval time.Duration
+	// HybridE2E — multicast Sync/Announce, Delay_Req unicast на адрес мастера из Announce
+	// (enterprise profile); мастер отвечает unicast Delay_Resp
+	HybridE2E bool
+	// RelaxDelayRequests — Delay_Req через случайные 200–800 мс после Sync, а не сразу
+	RelaxDelayRequests bool
+	// Negotiate — согласование unicast передачи с Masters (Signaling, G.8265.1/G.8275.2):
+	// Announce запрашивается у всех мастеров, Sync и Delay_Resp — у выбранного BMCA
+	Negotiate bool
+	// Интервалы (log2 секунд), запрашиваемые у мастеров, и срок разрешения (0 — DefaultGrantDuration)
+	LogAnnounceInterval  int8
+	LogSyncInterval      int8
+	LogDelayRespInterval int8
+	GrantDuration        time.Duration
+	// BMCA — алгоритм выбора мастера (профили G.8275.x и G.8265.1 — альтернативные)
+	BMCA BMCA
+	// DelayMechanism — DelayE2E ("") или DelayP2P: задержка линии по Pdelay_Req/Pdelay_Resp вместо
+	// Delay_Req; PeerDelay — интервал Pdelay_Req и режим 802.1AS (gPTP: Sync принимается только
+	// при asCapable, Announce с собственным clockIdentity в path trace отбрасываются)
+	DelayMechanism string
+	PeerDelay      PeerDelayConfig
+	// Auth — аутентификация (IEEE 1588-2019, TLV AUTHENTICATION): отправляемые сообщения
+	// подписываются, принятые без верного TLV отбрасываются; nil — без аутентификации
+	Auth *SecurityAssociation
+}
+
+// Measurement — результат обмена Sync/Delay_Req с мастером
+type Measurement struct {
+	// OffsetFromMaster — локальное время минус время мастера в шкале UTC (IEEE 1588 offsetFromMaster
+	// с учётом currentUtcOffset); для аппаратных меток — относительно PHC
+	OffsetFromMaster time.Duration
+	MeanPathDelay    time.Duration
+	Time             time.Time // системное время вычисления
+	Master           PortIdentity
+	Grandmaster      ClockIdentity
+	Quality          ClockQuality
+	StepsRemoved     uint16
+	UTCOffset        time.Duration // currentUtcOffset мастера (0 для шкалы ARB)
+	SyncInterval     time.Duration
+	TimestampType    timestamping.Type
+}
+
+// delayFilterLen — окно медианного фильтра meanPathDelay (как moving_median в linuxptp)
+const delayFilterLen = 8
+
+// delayReqTimeout — сколько ждать Delay_Resp, прежде чем отправить следующий Delay_Req
+const delayReqTimeout = 2 * time.Second
+
+// Задержка Delay_Req после Sync с RelaxDelayRequests
+const (
+	relaxDelayMin = 200 * time.Millisecond
+	relaxDelayMax = 800 * time.Millisecond
+)
+
+// pendingSync — Sync, ожидающий Follow_Up (two-step) или уже обработанный
+type pendingSync struct {
+	seq      uint16
+	t2       time.Time
+	corr     time.Duration
+	interval time.Duration
+	waiting  bool // two-step: ждём Follow_Up
+}
+
+// earlyFollowUp — Follow_Up, принятый раньше своего Sync (event и general сокеты читаются независимо)
+type earlyFollowUp struct {
+	seq   uint16
+	ts    Timestamp
+	corr  time.Duration
+	valid bool
+}
+
+// Slave — порт ordinary clock в роли slave (E2E): выбирает мастера по Announce (BMCA),
+// измеряет offsetFromMaster по Sync/Follow_Up и meanPathDelay по Delay_Req/Delay_Resp.
+type Slave struct {
+	tr    Transport
+	cfg   SlaveConfig
+	stats *portStats
+
+	// Состояние обмена (только в Run)
+	foreign      foreignMasters
+	master       *ForeignMaster
+	sync         pendingSync
+	followUp     earlyFollowUp
+	t1, t2       time.Time     // последняя пара меток Sync
+	cSync        time.Duration // correctionField Sync (+ Follow_Up)
+	haveSync     bool
+	delays       []time.Duration
+	delayReqSeq  uint16
+	delayReqT3   time.Time
+	delayReqSent time.Time
+	delayPending bool
+	nextDelayReq time.Time
+	delayLogInt  int8
+	unicast      []*unicastMaster
+	signalingSeq uint16
+	sdoID        uint8
+	pdelay       *peerDelay  // P2P; nil — E2E
+	relax        *time.Timer // отложенный Delay_Req (RelaxDelayRequests)
+	relaxPending bool
+
+	mu    sync.Mutex
+	state PortState
+	last  Measurement
+	have  bool
+}
+
+// NewSlave создаёт порт slave поверх транспорта
+func NewSlave(tr Transport, cfg SlaveConfig) *Slave {
+	if cfg.Identity.Clock == (ClockIdentity{}) {
+		cfg.Identity.Clock = DefaultClockIdentity("")
+	}
+	if cfg.Identity.Port == 0 {
+		cfg.Identity.Port = 1
+	}
+	if cfg.GrantDuration <= 0 {
+		cfg.GrantDuration = DefaultGrantDuration
+	}
+	stats := newPortStats()
+	if cfg.Auth != nil {
+		tr = authTransport{tr, cfg.Auth}
+	}
+	tr = countingTransport{tr, stats}
+	s := &Slave{tr: tr, cfg: cfg, stats: stats, foreign: make(foreignMasters), delayLogInt: LogIntervalUnset, relax: time.NewTimer(time.Hour)}
+	s.relax.Stop()
+	if cfg.PeerDelay.GPTP {
+		s.sdoID = SdoIDGPTP
+	}
+	if cfg.DelayMechanism == DelayP2P {
+		s.pdelay = newPeerDelay(tr, cfg.Identity, cfg.Domain, s.sdoID, cfg.PeerDelay)
+	}
+	if cfg.Negotiate {
+		for _, ip := range cfg.Masters {
+			s.unicast = append(s.unicast, &unicastMaster{addr: &net.UDPAddr{IP: ip}, grants: make(map[MessageType]*clientGrant)})
+		}
+	}
+	return s
+}
+
+// Identity возвращает идентификатор порта
+func (s *Slave) Identity() PortIdentity {
+	return s.cfg.Identity
+}
+
+// State возвращает состояние порта
+func (s *Slave) State() PortState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.state
+}
+
+// Stats возвращает счётчики сообщений порта (по типам, по отправителям)
+func (s *Slave) Stats() PortStats {
+	return s.stats.snapshot()
+}
+
+// Last возвращает последнее измерение
+func (s *Slave) Last() (Measurement, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.last, s.have
+}
+
+// PeerDelay возвращает состояние измерения задержки линии (P2P); ok=false — механизм E2E
+func (s *Slave) PeerDelay() (PeerDelayStatus, bool) {
+	if s.pdelay == nil {
+		return PeerDelayStatus{}, false
+	}
+	return s.pdelay.Status(), true
+}
+
+// Run обрабатывает сообщения до отмены ctx или закрытия транспорта
+func (s *Slave) Run(ctx context.Context) error {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	packets := s.tr.Packets()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case p, ok := <-packets:
+			if !ok {
+				return nil
+			}
+			s.handle(p, time.Now())
+		case now := <-ticker.C:
+			s.selectMaster(now)
+			s.negotiate(now)
+			if s.pdelay != nil {
+				s.pdelay.tick(now)
+			}
+			if !s.cfg.RelaxDelayRequests {
+				s.maybeSendDelayReq(now)
+			}
+		case now := <-s.relax.C:
+			s.relaxPending = false
+			s.maybeSendDelayReq(now)
+		}
+	}
+}
+
+// handle разбирает сообщение и передаёт обработчику по типу
+func (s *Slave) handle(p Packet, now time.Time) {
+	m, err := Unmarshal(p.Data)
+	if err != nil || m.Domain != s.cfg.Domain || m.SdoID != s.sdoID || m.Source.Clock == s.cfg.Identity.Clock {
+		return
+	}
+	if s.cfg.Auth != nil && s.cfg.Auth.Verify(p.Data, m, now) != nil {
+		s.stats.unauthenticated(m, p.Src, now)
+		return
+	}
+	s.stats.received(m, p.Src, now)
+	if s.pdelay != nil && s.pdelay.handle(p, m) {
+		return
+	}
+	switch m.Type {
+	case MsgAnnounce:
+		if !s.acceptMaster(p.Src) || s.cfg.PeerDelay.GPTP && s.inPathTrace(m) {
+			return
+		}
+		s.foreign.add(m, p.Src, s.cfg.Identity, now)
+		s.selectMaster(now)
+	case MsgSync:
+		if s.fromMaster(m) && p.Event && s.asCapable() {
+			s.handleSync(m, p.Stamp.Time, now)
+		}
+	case MsgFollowUp:
+		if !s.fromMaster(m) {
+			return
+		}
+		if s.sync.waiting && m.Sequence == s.sync.seq {
+			s.sync.waiting = false
+			s.syncComplete(m.Timestamp, s.sync.corr+m.Correction.Duration(), now)
+			return
+		}
+		s.followUp = earlyFollowUp{seq: m.Sequence, ts: m.Timestamp, corr: m.Correction.Duration(), valid: true}
+	case MsgDelayResp:
+		if s.fromMaster(m) && m.Port == s.cfg.Identity && s.delayPending && m.Sequence == s.delayReqSeq {
+			s.delayPending = false
+			s.delayLogInt = m.LogMsgInterval
+			s.handleDelayResp(m, now)
+		}
+	case MsgSignaling:
+		if m.Port == AllPorts || m.Port == s.cfg.Identity {
+			s.handleSignaling(m, p.Src, now)
+		}
+	}
+}
+
+// acceptMaster проверяет адрес отправителя Announce по списку unicast мастеров
+func (s *Slave) acceptMaster(src net.Addr) bool {
+	if len(s.cfg.Masters) == 0 {
+		return true
+	}
+	ip := ipOf(src)
+	for _, m := range s.cfg.Masters {
+		if m.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// inPathTrace возвращает true, если Announce уже прошёл через эти часы (802.1AS, 10.3.10.2)
+func (s *Slave) inPathTrace(m *Message) bool {
+	for _, t := range m.TLVs {
+		path, ok := ParsePathTrace(t)
+		if !ok {
+			continue
+		}
+		for _, c := range path {
+			if c == s.cfg.Identity.Clock {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// asCapable — gPTP: Sync принимаются только при asCapable; вне gPTP всегда true
+func (s *Slave) asCapable() bool {
+	return !s.cfg.PeerDelay.GPTP || s.pdelay.Status().ASCapable
+}
+
+func (s *Slave) fromMaster(m *Message) bool {
+	return s.master != nil && m.Source == s.master.Sender
+}
+
+// selectMaster выбирает лучшего мастера (BMCA); при смене мастера измерения сбрасываются
+func (s *Slave) selectMaster(now time.Time) {
+	best := s.foreign.best(now, s.cfg.BMCA)
+	if best == s.master {
+		return
+	}
+	s.master = best
+	s.sync = pendingSync{}
+	s.followUp = earlyFollowUp{}
+	s.haveSync = false
+	s.delays = s.delays[:0]
+	s.delayPending = false
+	s.nextDelayReq = time.Time{}
+	s.relax.Stop()
+	s.relaxPending = false
+	s.mu.Lock()
+	s.have = false
+	s.state = StateListening
+	if best != nil {
+		s.state = StateUncalibrated
+	}
+	s.mu.Unlock()
+}
+
+func (s *Slave) handleSync(m *Message, t2 time.Time, now time.Time) {
+	s.sync = pendingSync{
+		seq:      m.Sequence,
+		t2:       t2,
+		corr:     m.Correction.Duration(),
+		interval: LogInterval(m.LogMsgInterval),
+		waiting:  m.Flags&FlagTwoStep != 0,
+	}
+	switch {
+	case !s.sync.waiting:
+		s.syncComplete(m.Timestamp, s.sync.corr, now)
+	case s.followUp.valid && s.followUp.seq == m.Sequence:
+		s.sync.waiting = false
+		s.syncComplete(s.followUp.ts, s.sync.corr+s.followUp.corr, now)
+	}
+	s.followUp.valid = false
+}
+
+// syncComplete — известны T1 (origin/preciseOrigin) и T2: вычисляется offset, отправляется Delay_Req
+// (в P2P задержка линии уже измерена Pdelay)
+func (s *Slave) syncComplete(t1 Timestamp, corr time.Duration, now time.Time) {
+	s.t1, s.t2, s.cSync = t1.Time(), s.sync.t2, corr
+	s.haveSync = true
+	if s.pdelay != nil {
+		if s.pdelay.Status().Valid {
+			s.publish(now)
+		}
+		return
+	}
+	if len(s.delays) > 0 {
+		s.publish(now)
+	}
+	if s.cfg.RelaxDelayRequests {
+		s.relaxDelayReq(now)
+		return
+	}
+	s.maybeSendDelayReq(now)
+}
+
+// relaxDelayReq откладывает Delay_Req на случайные 200–800 мс после Sync (relax_delay_requests),
+// чтобы запросы клиентов одного мастера не приходили пачкой сразу за multicast Sync
+func (s *Slave) relaxDelayReq(now time.Time) {
+	if s.relaxPending || !s.delayReqDue(now) {
+		return
+	}
+	s.relaxPending = true
+	s.relax.Reset(relaxDelayMin + time.Duration(rand.Int63n(int64(relaxDelayMax-relaxDelayMin))))
+}
+
+// handleDelayResp — известны T3 и T4: meanPathDelay = ((T2−T1) + (T4−T3) − corrections) / 2
+func (s *Slave) handleDelayResp(m *Message, now time.Time) {
+	if !s.haveSync {
+		return
+	}
+	t4 := m.Timestamp.Time()
+	d := (s.t2.Sub(s.t1) + t4.Sub(s.delayReqT3) - s.cSync - m.Correction.Duration()) / 2
+	if d < 0 {
+		// Отрицательная задержка — асимметрия меток или несвязанные пары; измерение отбрасывается
+		return
+	}
+	s.delays = append(s.delays, d)
+	if len(s.delays) > delayFilterLen {
+		s.delays = s.delays[1:]
+	}
+}
+
+// meanPathDelay — медиана окна измерений задержки
+func (s *Slave) meanPathDelay() time.Duration {
+	d := append([]time.Duration(nil), s.delays...)
+	sort.Slice(d, func(i, j int) bool { return d[i] < d[j] })
+	if len(d)%2 == 1 {
+		return d[len(d)/2]
+	}
+	return (d[len(d)/2-1] + d[len(d)/2]) / 2
+}
+
+// utcOffset — currentUtcOffset мастера, если его шкала — PTP (TAI)
+func (s *Slave) utcOffset() time.Duration {
+	a := s.master.Announce
+	if a == nil || a.Flags&FlagPTPTimescale == 0 {
+		return 0
+	}
+	return time.Duration(a.Announce.CurrentUTCOffset) * time.Second
+}
+
+// publish сохраняет измерение: offsetFromMaster = T2 − T1 − meanPathDelay − correction (+ UTC offset).
+// В P2P meanPathDelay — задержка линии до соседа, задержки выше по пути — в correctionField Sync.
+func (s *Slave) publish(now time.Time) {
+	var delay time.Duration
+	if s.pdelay != nil {
+		delay = s.pdelay.Status().MeanLinkDelay
+	} else {
+		delay = s.meanPathDelay()
+	}
+	utc := s.utcOffset()
+	meas := Measurement{
+		OffsetFromMaster: s.t2.Sub(s.t1) - delay - s.cSync + utc,
+		MeanPathDelay:    delay,
+		Time:             now,
+		Master:           s.master.Sender,
+		Grandmaster:      s.master.GrandmasterIdentity,
+		Quality:          s.master.Quality,
+		StepsRemoved:     s.master.StepsRemoved,
+		UTCOffset:        utc,
+		SyncInterval:     s.sync.interval,
+		TimestampType:    s.tr.TimestampType(),
+	}
+	s.mu.Lock()
+	s.last, s.have = meas, true
+	s.state = StateSlave
+	s.mu.Unlock()
+}
+
+// delayReqDue возвращает true, если есть пара Sync, подошёл интервал Delay_Req и предыдущий
+// запрос получил ответ или истёк
+func (s *Slave) delayReqDue(now time.Time) bool {
+	if s.pdelay != nil || s.master == nil || !s.haveSync || now.Before(s.nextDelayReq) {
+		return false
+	}
+	return !s.delayPending || now.Sub(s.delayReqSent) >= delayReqTimeout
+}
+
+// maybeSendDelayReq отправляет Delay_Req, если подошёл интервал
+func (s *Slave) maybeSendDelayReq(now time.Time) {
+	if !s.delayReqDue(now) {
+		return
+	}
+	s.delayReqSeq++
+	req := Message{Header: Header{
+		SdoID:          s.sdoID,
+		Type:           MsgDelayReq,
+		Domain:         s.cfg.Domain,
+		Source:         s.cfg.Identity,
+		Sequence:       s.delayReqSeq,
+		LogMsgInterval: LogIntervalUnset,
+	}}
+	var dst net.Addr
+	if len(s.cfg.Masters) > 0 || s.cfg.HybridE2E {
+		req.Flags |= FlagUnicast
+		dst = s.master.Addr
+	}
+	ts, err := s.tr.SendEvent(req.Marshal(), dst)
+	if err != nil {
+		return
+	}
+	s.delayReqT3, s.delayReqSent, s.delayPending = ts.Time, now, true
+	interval := s.cfg.DelayReqInterval
+	if interval <= 0 {
+		interval = time.Second
+		if s.delayLogInt != LogIntervalUnset {
+			interval = LogInterval(s.delayLogInt)
+		}
+	}
+	s.nextDelayReq = now.Add(interval)
+}
+
+// negotiate запрашивает и продлевает разрешения unicast передачи; у мастера, переставшего быть
+// выбранным, Sync и Delay_Resp отменяются
+func (s *Slave) negotiate(now time.Time) {
+	for _, um := range s.unicast {
+		want := map[MessageType]int8{MsgAnnounce: s.cfg.LogAnnounceInterval}
+		if s.master != nil && um.addr.IP.Equal(ipOf(s.master.Addr)) {
+			want[MsgSync] = s.cfg.LogSyncInterval
+			want[MsgDelayResp] = s.cfg.LogDelayRespInterval
+		}
+		if tlvs := um.requests(want, s.cfg.GrantDuration, now); len(tlvs) > 0 {
+			s.sendSignaling(um.addr, AllPorts, tlvs)
+		}
+	}
+}
+
+// handleSignaling учитывает GRANT мастера и подтверждает CANCEL
+func (s *Slave) handleSignaling(m *Message, src net.Addr, now time.Time) {
+	ip := ipOf(src)
+	for _, um := range s.unicast {
+		if !um.addr.IP.Equal(ip) {
+			continue
+		}
+		var acks []TLV
+		for _, t := range m.TLVs {
+			u, ok := ParseUnicastTLV(t)
+			if !ok {
+				continue
+			}
+			switch u.Kind {
+			case TLVGrantUnicast:
+				um.granted(u, now)
+			case TLVCancelUnicast:
+				if g := um.grants[u.Type]; g != nil {
+					g.expires, g.requested = time.Time{}, now
+				}
+				acks = append(acks, UnicastTLV{Kind: TLVAckCancelUnicast, Type: u.Type}.TLV())
+			}
+		}
+		if len(acks) > 0 {
+			s.sendSignaling(um.addr, m.Source, acks)
+		}
+		return
+	}
+}
+
+// sendSignaling отправляет Signaling с TLV согласования мастеру
+func (s *Slave) sendSignaling(dst net.Addr, target PortIdentity, tlvs []TLV) {
+	s.signalingSeq++
+	msg := Message{
+		Header: Header{
+			SdoID:          s.sdoID,
+			Type:           MsgSignaling,
+			Domain:         s.cfg.Domain,
+			Flags:          FlagUnicast,
+			Source:         s.cfg.Identity,
+			Sequence:       s.signalingSeq,
+			LogMsgInterval: LogIntervalUnset,
+		},
+		Port: target,
+		TLVs: tlvs,
+	}
+	_ = s.tr.SendGeneral(msg.Marshal(), dst)
+}
@@ -0,0 +1,46 @@
+package ptp
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// SocketOptions — параметры сокетов UDP (clock_sync.advanced.ptp_tuning), PortOptions.Socket
+type SocketOptions struct {
+	// DSCPEvent, DSCPGeneral — DSCP сообщений event (319) и general (320); 0 — не задавать
+	DSCPEvent   int
+	DSCPGeneral int
+	// MulticastTTL — TTL (IPv4) или hop limit (IPv6) исходящего multicast; 0 — по умолчанию ядра (1)
+	MulticastTTL int
+	// GlobalSockets — одна пара сокетов на все интерфейсы (enable_ptp_global_sockets): без
+	// SO_BINDTODEVICE, multicast через первый подписанный интерфейс, метки времени — ядра
+	GlobalSockets bool
+}
+
+// ParseDSCP разбирает DSCP: число 0–63 или имя класса (RFC 4594): ef, va, afXY (X 1–4, Y 1–3),
+// csN (N 0–7), be/default; пусто — 0
+func ParseDSCP(s string) (int, error) {
+	name := strings.ToLower(strings.TrimSpace(s))
+	switch name {
+	case "", "be", "default":
+		return 0, nil
+	case "ef":
+		return 46, nil
+	case "va":
+		return 44, nil
+	}
+	if n, err := strconv.Atoi(name); err == nil {
+		if n < 0 || n > 63 {
+			return 0, fmt.Errorf("ptp: dscp %d out of range 0..63", n)
+		}
+		return n, nil
+	}
+	if len(name) == 3 && strings.HasPrefix(name, "cs") && name[2] >= '0' && name[2] <= '7' {
+		return int(name[2]-'0') * 8, nil
+	}
+	if len(name) == 4 && strings.HasPrefix(name, "af") && name[2] >= '1' && name[2] <= '4' && name[3] >= '1' && name[3] <= '3' {
+		return int(name[2]-'0')*8 + int(name[3]-'0')*2, nil
+	}
+	return 0, fmt.Errorf("ptp: unknown dscp %q (want 0..63, ef, va, afXY, csN)", s)
+}
@@ -0,0 +1,53 @@
+//go:build linux
+
+package ptp
+
+import (
+	"fmt"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// setSocketOptions вызывается до bind: SO_BINDTODEVICE (с SO_REUSEADDR — сокеты разных
+// интерфейсов на одних портах), DSCP и TTL multicast
+func setSocketOptions(c syscall.RawConn, cfg UDPConfig, dscp int) error {
+	var opErr error
+	err := c.Control(func(fd uintptr) {
+		s := int(fd)
+		if cfg.BindToDevice && cfg.Interface != "" {
+			if opErr = unix.SetsockoptInt(s, unix.SOL_SOCKET, unix.SO_REUSEADDR, 1); opErr != nil {
+				return
+			}
+			if opErr = unix.BindToDevice(s, cfg.Interface); opErr != nil {
+				opErr = fmt.Errorf("SO_BINDTODEVICE %s: %w", cfg.Interface, opErr)
+				return
+			}
+		}
+		if dscp != 0 {
+			if cfg.IPv6 {
+				opErr = unix.SetsockoptInt(s, unix.IPPROTO_IPV6, unix.IPV6_TCLASS, dscp<<2)
+			} else {
+				opErr = unix.SetsockoptInt(s, unix.IPPROTO_IP, unix.IP_TOS, dscp<<2)
+			}
+			if opErr != nil {
+				opErr = fmt.Errorf("dscp %d: %w", dscp, opErr)
+				return
+			}
+		}
+		if ttl := cfg.Socket.MulticastTTL; ttl != 0 {
+			if cfg.IPv6 {
+				opErr = unix.SetsockoptInt(s, unix.IPPROTO_IPV6, unix.IPV6_MULTICAST_HOPS, ttl)
+			} else {
+				opErr = unix.SetsockoptInt(s, unix.IPPROTO_IP, unix.IP_MULTICAST_TTL, ttl)
+			}
+			if opErr != nil {
+				opErr = fmt.Errorf("multicast_ttl %d: %w", ttl, opErr)
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return opErr
+}
@@ -0,0 +1,16 @@
+//go:build !linux
+
+package ptp
+
+import (
+	"errors"
+	"syscall"
+)
+
+// setSocketOptions — привязка к интерфейсу, DSCP и TTL multicast реализованы только для Linux
+func setSocketOptions(c syscall.RawConn, cfg UDPConfig, dscp int) error {
+	if cfg.BindToDevice && cfg.Interface != "" || dscp != 0 || cfg.Socket.MulticastTTL != 0 {
+		return errors.New("ptp: dscp, multicast_ttl and interface binding are supported only on linux")
+	}
+	return nil
+}
@@ -0,0 +1,304 @@
+package ptp
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shiwa/timecard-mini/tc-sync/internal/timestamping"
+)
+
+const (
+	// rateWindow — число последних Sync, по которым оценивается фактическая частота
+	rateWindow = 16
+	// maxPeerStats — предел числа отслеживаемых отправителей порта (unicast клиенты сервера)
+	maxPeerStats = 256
+	// peerStatsExpiry — отправитель без сообщений дольше этого срока удаляется из статистики
+	peerStatsExpiry = 10 * time.Minute
+)
+
+// MessageCounts — число сообщений по типам
+type MessageCounts map[MessageType]uint64
+
+// String — счётчики по возрастанию типа: "Sync 96, Follow_Up 96, Announce 12"
+func (c MessageCounts) String() string {
+	types := make([]MessageType, 0, len(c))
+	for t := range c {
+		types = append(types, t)
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+	parts := make([]string, len(types))
+	for i, t := range types {
+		parts[i] = fmt.Sprintf("%s %d", t, c[t])
+	}
+	return strings.Join(parts, ", ")
+}
+
+// PeerStats — сообщения от одного отправителя (мастер, сосед, unicast клиент)
+type PeerStats struct {
+	Peer         PortIdentity
+	Addr         string
+	In           MessageCounts
+	SequenceGaps uint64 // пропущенные sequenceId (Sync, Announce, Delay_Req, Pdelay_Req, Signaling)
+	OutOfOrder   uint64 // sequenceId не больше предыдущего (повтор или перестановка)
+	// ActualSyncPPS — частота принятых Sync по последним rateWindow сообщениям; DesiredSyncPPS — по
+	// logMessageInterval последнего Sync
+	ActualSyncPPS  float64
+	DesiredSyncPPS float64
+	// Unauthenticated — сообщения, отброшенные без верного TLV AUTHENTICATION (в In не входят)
+	Unauthenticated uint64
+	LastSeen        time.Time
+}
+
+// PortStats — счётчики сообщений порта
+type PortStats struct {
+	In  MessageCounts // принятые сообщения своего домена
+	Out MessageCounts // отправленные сообщения
+	// SendErrors — ошибки отправки; TXTimestampMisses — event сообщения, для которых не получена метка
+	// передачи типа транспорта (взято время до отправки), из TXEvents, для которых она ожидалась
+	SendErrors        uint64
+	TXTimestampMisses uint64
+	TXEvents          uint64
+	SequenceGaps      uint64 // сумма по отправителям
+	OutOfOrder        uint64
+	Unauthenticated   uint64 // отброшено без верного TLV AUTHENTICATION
+	// ActualSyncPPS — частота отправленных Sync; DesiredSyncPPS — по интервалу Sync порта (master)
+	ActualSyncPPS  float64
+	DesiredSyncPPS float64
+	Peers          []PeerStats // по возрастанию идентификатора порта
+}
+
+// rateMeter оценивает частоту событий по последним rateWindow отметкам
+type rateMeter struct {
+	times [rateWindow]time.Time
+	n     int // число отметок (не больше rateWindow)
+	next  int
+}
+
+func (r *rateMeter) add(t time.Time) {
+	r.times[r.next] = t
+	r.next = (r.next + 1) % rateWindow
+	if r.n < rateWindow {
+		r.n++
+	}
+}
+
+// pps — событий в секунду; 0 — меньше двух отметок
+func (r *rateMeter) pps() float64 {
+	if r.n < 2 {
+		return 0
+	}
+	first := r.times[(r.next-r.n+rateWindow)%rateWindow]
+	last := r.times[(r.next-1+rateWindow)%rateWindow]
+	span := last.Sub(first)
+	if span <= 0 {
+		return 0
+	}
+	return float64(r.n-1) / span.Seconds()
+}
+
+// seqState — последний sequenceId отправителя для одного типа сообщения
+type seqState struct {
+	last  uint16
+	valid bool
+}
+
+type peerCounters struct {
+	stats PeerStats
+	seq   map[MessageType]*seqState
+	sync  rateMeter
+}
+
+// sequenced — типы, sequenceId которых ведёт отправитель (в ответах он копируется из запроса)
+func sequenced(t MessageType) bool {
+	switch t {
+	case MsgSync, MsgAnnounce, MsgDelayReq, MsgPdelayReq, MsgSignaling:
+		return true
+	}
+	return false
+}
+
+// portStats — счётчики порта slave или master; обновляются из Run порта и из отправки
+type portStats struct {
+	mu         sync.Mutex
+	in, out    MessageCounts
+	sendErrors uint64
+	unauth     uint64
+	txMisses   uint64
+	txEvents   uint64
+	outSync    rateMeter
+	desiredOut float64
+	peers      map[PortIdentity]*peerCounters
+}
+
+func newPortStats() *portStats {
+	return &portStats{in: make(MessageCounts), out: make(MessageCounts), peers: make(map[PortIdentity]*peerCounters)}
+}
+
+// received учитывает принятое сообщение своего домена
+func (s *portStats) received(m *Message, src net.Addr, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.in[m.Type]++
+	pc := s.peer(m.Source, now)
+	if pc == nil {
+		return
+	}
+	pc.stats.In[m.Type]++
+	pc.stats.LastSeen = now
+	if src != nil {
+		pc.stats.Addr = src.String()
+	}
+	if m.Type == MsgSync {
+		pc.sync.add(now)
+		if m.LogMsgInterval != LogIntervalUnset {
+			pc.stats.DesiredSyncPPS = 1 / LogInterval(m.LogMsgInterval).Seconds()
+		}
+	}
+	if !sequenced(m.Type) {
+		return
+	}
+	st := pc.seq[m.Type]
+	if st == nil {
+		st = &seqState{}
+		pc.seq[m.Type] = st
+	}
+	if st.valid {
+		switch d := int16(m.Sequence - st.last); {
+		case d <= 0:
+			pc.stats.OutOfOrder++
+			return
+		case d > 1:
+			pc.stats.SequenceGaps += uint64(d - 1)
+		}
+	}
+	st.last, st.valid = m.Sequence, true
+}
+
+// unauthenticated учитывает сообщение своего домена, отброшенное при проверке аутентификации
+func (s *portStats) unauthenticated(m *Message, src net.Addr, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.unauth++
+	pc := s.peer(m.Source, now)
+	if pc == nil {
+		return
+	}
+	pc.stats.Unauthenticated++
+	pc.stats.LastSeen = now
+	if src != nil {
+		pc.stats.Addr = src.String()
+	}
+}
+
+// peer возвращает счётчики отправителя; nil — таблица заполнена
+func (s *portStats) peer(id PortIdentity, now time.Time) *peerCounters {
+	if pc := s.peers[id]; pc != nil {
+		return pc
+	}
+	if len(s.peers) >= maxPeerStats {
+		for k, pc := range s.peers {
+			if now.Sub(pc.stats.LastSeen) > peerStatsExpiry {
+				delete(s.peers, k)
+			}
+		}
+		if len(s.peers) >= maxPeerStats {
+			return nil
+		}
+	}
+	pc := &peerCounters{stats: PeerStats{Peer: id, In: make(MessageCounts)}, seq: make(map[MessageType]*seqState)}
+	s.peers[id] = pc
+	return pc
+}
+
+// sent учитывает отправку сообщения b; ts и err — результат отправки
+func (s *portStats) sent(b []byte, event bool, ts timestamping.Stamp, want timestamping.Type, err error) {
+	if len(b) == 0 {
+		return
+	}
+	t := MessageType(b[0] & 0x0F)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		s.sendErrors++
+		return
+	}
+	s.out[t]++
+	if t == MsgSync {
+		s.outSync.add(time.Now())
+	}
+	if event && want != timestamping.Software {
+		s.txEvents++
+		if ts.Type == timestamping.Software {
+			s.txMisses++
+		}
+	}
+}
+
+// setDesiredSync задаёт интервал Sync порта для DesiredSyncPPS
+func (s *portStats) setDesiredSync(logInterval int8) {
+	s.mu.Lock()
+	s.desiredOut = 1 / LogInterval(logInterval).Seconds()
+	s.mu.Unlock()
+}
+
+// snapshot возвращает копию счётчиков
+func (s *portStats) snapshot() PortStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := PortStats{
+		In:                copyCounts(s.in),
+		Out:               copyCounts(s.out),
+		SendErrors:        s.sendErrors,
+		Unauthenticated:   s.unauth,
+		TXTimestampMisses: s.txMisses,
+		TXEvents:          s.txEvents,
+		ActualSyncPPS:     s.outSync.pps(),
+		DesiredSyncPPS:    s.desiredOut,
+	}
+	for _, pc := range s.peers {
+		ps := pc.stats
+		ps.In = copyCounts(ps.In)
+		ps.ActualSyncPPS = pc.sync.pps()
+		out.SequenceGaps += ps.SequenceGaps
+		out.OutOfOrder += ps.OutOfOrder
+		out.Peers = append(out.Peers, ps)
+	}
+	sort.Slice(out.Peers, func(i, j int) bool {
+		a, b := out.Peers[i].Peer, out.Peers[j].Peer
+		if a.Clock != b.Clock {
+			return string(a.Clock[:]) < string(b.Clock[:])
+		}
+		return a.Port < b.Port
+	})
+	return out
+}
+
+func copyCounts(c MessageCounts) MessageCounts {
+	out := make(MessageCounts, len(c))
+	for t, n := range c {
+		out[t] = n
+	}
+	return out
+}
+
+// countingTransport учитывает отправленные сообщения порта в portStats
+type countingTransport struct {
+	Transport
+	stats *portStats
+}
+
+func (t countingTransport) SendEvent(b []byte, dst net.Addr) (timestamping.Stamp, error) {
+	ts, err := t.Transport.SendEvent(b, dst)
+	t.stats.sent(b, true, ts, t.Transport.TimestampType(), err)
+	return ts, err
+}
+
+func (t countingTransport) SendGeneral(b []byte, dst net.Addr) error {
+	err := t.Transport.SendGeneral(b, dst)
+	t.stats.sent(b, false, timestamping.Stamp{}, timestamping.Software, err)
+	return err
+}
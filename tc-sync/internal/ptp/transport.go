@@ -0,0 +1,102 @@
+package ptp
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/shiwa/timecard-mini/tc-sync/internal/logger"
+	"github.com/shiwa/timecard-mini/tc-sync/internal/timestamping"
+)
+
+// Packet — принятое сообщение с адресом отправителя и меткой времени приёма
+type Packet struct {
+	Data  []byte
+	Src   net.Addr
+	Stamp timestamping.Stamp // для event сообщений — метка ядра/сетевой карты
+	Event bool               // принято на порту событий (319)
+}
+
+// Transport — транспорт сообщений PTP. dst == nil — адрес multicast по умолчанию;
+// иначе адрес получателя (для UDP — IP, порт выбирается по типу сообщения; для Ethernet — *L2Addr).
+type Transport interface {
+	// Packets возвращает канал принятых сообщений; закрывается при Close
+	Packets() <-chan Packet
+	// SendEvent отправляет event сообщение и возвращает метку времени передачи
+	SendEvent(b []byte, dst net.Addr) (timestamping.Stamp, error)
+	// SendGeneral отправляет general сообщение
+	SendGeneral(b []byte, dst net.Addr) error
+	// TimestampType — тип меток приёма/передачи event сообщений
+	TimestampType() timestamping.Type
+	// Close закрывает сокеты
+	Close() error
+}
+
+// Транспорты в конфиге (transport:)
+const (
+	TransportUDP  = "udp"  // UDP/IPv4, порты 319/320 (по умолчанию)
+	TransportUDP6 = "udp6" // UDP/IPv6, multicast ff0e::181 и ff02::6b
+	TransportL2   = "l2"   // Ethernet, EtherType 0x88F7
+)
+
+// OpenTransport открывает транспорт o.Transport ("" — UDP) на интерфейсе iface с аппаратными
+// метками, если сетевая карта их поддерживает. o.Multicast — подписка на адреса multicast (для UDP);
+// транспорт Ethernet подписывается на них всегда, multicast адрес — o.DstMAC.
+// Порты одного интерфейса (slave разных доменов, master, обнаружение мастеров) разделяют сокеты
+// через Mux: возвращается порт разделяемого транспорта. Сокеты UDP привязываются к интерфейсу и
+// получают DSCP и TTL multicast из o.Socket (у разделяемого транспорта — порта, открывшего его);
+// с o.Socket.GlobalSockets одна пара сокетов (для IPv4 и IPv6 — своя) обслуживает все интерфейсы.
+func OpenTransport(o PortOptions, iface string) (Transport, error) {
+	switch o.Transport {
+	case "", TransportUDP, TransportUDP6:
+		network := TransportUDP
+		if o.Transport == TransportUDP6 {
+			network = TransportUDP6
+		}
+		cfg := UDPConfig{Interface: iface, Multicast: o.Multicast, Hardware: true, IPv6: network == TransportUDP6, BindToDevice: true, Socket: o.Socket}
+		key := network + "/" + iface
+		if o.Socket.GlobalSockets {
+			cfg.Hardware, cfg.BindToDevice = false, false
+			key = network
+		}
+		open := func() (Transport, error) {
+			return NewUDPTransport(cfg)
+		}
+		join := func(tr Transport) error {
+			if !o.Multicast {
+				return nil
+			}
+			return tr.(*UDPTransport).JoinMulticast(iface)
+		}
+		return sharedPort(key, open, join)
+	case TransportL2:
+		open := func() (Transport, error) {
+			return NewL2Transport(L2Config{Interface: iface, DstMAC: o.DstMAC, Hardware: true})
+		}
+		return sharedPort(TransportL2+"/"+iface+"/"+o.DstMAC.String(), open, nil)
+	}
+	return nil, fmt.Errorf("ptp: unknown transport %q (want udp, udp6 or l2)", o.Transport)
+}
+
+// Пауза перед повтором чтения сокета после ошибки: удваивается до readBackoffMax
+const (
+	readBackoffMin = 10 * time.Millisecond
+	readBackoffMax = time.Second
+)
+
+// readBackoff ждёт перед повтором чтения после ошибки err. delay — текущая пауза (0 — первая
+// ошибка подряд, она выводится в лог); возвращает следующую паузу и false, если закрыт done.
+func readBackoff(name string, err error, delay time.Duration, done <-chan struct{}) (time.Duration, bool) {
+	if delay == 0 {
+		logger.Error("ptp: %s read: %v", name, err)
+		delay = readBackoffMin
+	}
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case <-done:
+		return delay, false
+	case <-t.C:
+	}
+	return min(2*delay, readBackoffMax), true
+}
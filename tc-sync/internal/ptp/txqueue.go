@@ -0,0 +1,200 @@
+package ptp
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/shiwa/timecard-mini/tc-sync/internal/timestamping"
+)
+
+// txQueueSize — число сообщений, ожидающих отправки; при заполнении Enqueue ждёт
+const txQueueSize = 256
+
+// ErrTxQueueClosed — очередь передачи остановлена (Run завершён)
+var ErrTxQueueClosed = errors.New("ptp: tx queue closed")
+
+// TxQueueStats — счётчики очереди передачи. Пропуск метки — event сообщение, для которого вместо
+// метки типа транспорта получено время до отправки.
+type TxQueueStats struct {
+	Gap      time.Duration // минимальный интервал между отправками
+	Sent     uint64
+	Errors   uint64
+	Delayed  uint64 // сообщения, ожидавшие интервала после предыдущей отправки
+	MaxDepth int    // наибольшее число ожидающих сообщений
+	// Events, Misses — event сообщения, отправленные без ожидания, и пропуски меток среди них;
+	// SpacedEvents, SpacedMisses — отправленные после ожидания интервала
+	Events       uint64
+	Misses       uint64
+	SpacedEvents uint64
+	SpacedMisses uint64
+}
+
+// LossRate — доля пропусков меток без ожидания и после ожидания интервала (0 — нет таких сообщений)
+func (s TxQueueStats) LossRate() (unspaced, spaced float64) {
+	if s.Events > 0 {
+		unspaced = float64(s.Misses) / float64(s.Events)
+	}
+	if s.SpacedEvents > 0 {
+		spaced = float64(s.SpacedMisses) / float64(s.SpacedEvents)
+	}
+	return unspaced, spaced
+}
+
+// txRequest — сообщение в очереди; stamp и err заполняются при отправке, затем закрывается done
+type txRequest struct {
+	tr    Transport
+	b     []byte
+	dst   net.Addr
+	event bool
+	done  chan struct{}
+	stamp timestamping.Stamp
+	err   error
+}
+
+// TxQueue — очередь передачи интерфейса (synchronise_tx): сообщения всех портов интерфейса
+// отправляются по одному с интервалом не меньше gap. Некоторые сетевые карты теряют аппаратные
+// метки передачи, если пакеты PTP идут подряд; метка каждого сообщения забирается до отправки
+// следующего, поэтому относится именно к нему.
+type TxQueue struct {
+	gap    time.Duration
+	queue  chan *txRequest
+	closed chan struct{}
+	once   sync.Once
+	last   time.Time // окончание предыдущей отправки (только в Dequeue)
+
+	mu    sync.Mutex
+	stats TxQueueStats
+}
+
+// NewTxQueue создаёт очередь с интервалом gap между отправками; отправляет Run
+func NewTxQueue(gap time.Duration) *TxQueue {
+	return &TxQueue{gap: gap, queue: make(chan *txRequest, txQueueSize), closed: make(chan struct{}), stats: TxQueueStats{Gap: gap}}
+}
+
+// Transport возвращает транспорт, отправка через который идёт через очередь
+func (q *TxQueue) Transport(tr Transport) Transport {
+	return queuedTransport{tr, q}
+}
+
+// Enqueue ставит сообщение в очередь и ждёт его отправки через tr; для event сообщения
+// возвращается метка передачи
+func (q *TxQueue) Enqueue(tr Transport, b []byte, dst net.Addr, event bool) (timestamping.Stamp, error) {
+	r := &txRequest{tr: tr, b: b, dst: dst, event: event, done: make(chan struct{})}
+	select {
+	case q.queue <- r:
+	case <-q.closed:
+		return timestamping.Stamp{}, ErrTxQueueClosed
+	}
+	q.mu.Lock()
+	if n := len(q.queue); n > q.stats.MaxDepth {
+		q.stats.MaxDepth = n
+	}
+	q.mu.Unlock()
+	select {
+	case <-r.done:
+	case <-q.closed:
+		select {
+		case <-r.done:
+		default:
+			return timestamping.Stamp{}, ErrTxQueueClosed
+		}
+	}
+	return r.stamp, r.err
+}
+
+// Dequeue отправляет следующее сообщение очереди, выдержав интервал после предыдущей отправки
+// (таймером: интервал — нижняя граница, точность time.Sleep — десятки микросекунд);
+// false — ctx отменён, в том числе во время ожидания (сообщение не отправляется)
+func (q *TxQueue) Dequeue(ctx context.Context) bool {
+	var r *txRequest
+	select {
+	case <-ctx.Done():
+		return false
+	case r = <-q.queue:
+	}
+	spaced := false
+	if !q.last.IsZero() {
+		if wait := q.gap - time.Since(q.last); wait > 0 {
+			spaced = true
+			t := time.NewTimer(wait)
+			select {
+			case <-ctx.Done():
+				t.Stop()
+				r.err = ErrTxQueueClosed
+				close(r.done)
+				return false
+			case <-t.C:
+			}
+		}
+	}
+	if r.event {
+		r.stamp, r.err = r.tr.SendEvent(r.b, r.dst)
+	} else {
+		r.err = r.tr.SendGeneral(r.b, r.dst)
+	}
+	q.last = time.Now()
+	q.count(r, spaced)
+	close(r.done)
+	return true
+}
+
+func (q *TxQueue) count(r *txRequest, spaced bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if r.err != nil {
+		q.stats.Errors++
+		return
+	}
+	q.stats.Sent++
+	if spaced {
+		q.stats.Delayed++
+	}
+	if !r.event || r.tr.TimestampType() == timestamping.Software {
+		return
+	}
+	miss := r.stamp.Type == timestamping.Software
+	if spaced {
+		q.stats.SpacedEvents++
+		if miss {
+			q.stats.SpacedMisses++
+		}
+		return
+	}
+	q.stats.Events++
+	if miss {
+		q.stats.Misses++
+	}
+}
+
+// Run отправляет сообщения до отмены ctx; затем Enqueue возвращает ErrTxQueueClosed
+func (q *TxQueue) Run(ctx context.Context) error {
+	for q.Dequeue(ctx) {
+	}
+	q.once.Do(func() { close(q.closed) })
+	return ctx.Err()
+}
+
+// Stats возвращает счётчики очереди
+func (q *TxQueue) Stats() TxQueueStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.stats
+}
+
+// queuedTransport отправляет сообщения через очередь передачи
+type queuedTransport struct {
+	Transport
+	q *TxQueue
+}
+
+func (t queuedTransport) SendEvent(b []byte, dst net.Addr) (timestamping.Stamp, error) {
+	return t.q.Enqueue(t.Transport, b, dst, true)
+}
+
+func (t queuedTransport) SendGeneral(b []byte, dst net.Addr) error {
+	_, err := t.q.Enqueue(t.Transport, b, dst, false)
+	return err
+}
@@ -0,0 +1,225 @@
+package ptp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/shiwa/timecard-mini/tc-sync/internal/timestamping"
+)
+
+// UDPConfig — параметры транспорта UDP/IPv4 или UDP/IPv6
+type UDPConfig struct {
+	Interface   string // сетевой интерфейс: multicast и аппаратные метки
+	Address     string // локальный IP; пусто — все адреса
+	EventPort   int    // 0 — 319
+	GeneralPort int    // 0 — 320
+	Multicast   bool   // подписаться на 224.0.1.129 и 224.0.0.107 (IPv6 — ff0e::181 и ff02::6b; нужен Interface)
+	Hardware    bool   // аппаратные метки (SIOCSHWTSTAMP); без поддержки сетевой картой — метки ядра
+	IPv6        bool   // UDP/IPv6 (приложение E)
+	// BindToDevice — привязать сокеты к Interface (SO_BINDTODEVICE): порты разных интерфейсов
+	// открываются на одних номерах портов
+	BindToDevice bool
+	Socket       SocketOptions // DSCP и TTL multicast
+}
+
+// UDPTransport — PTP поверх UDP/IPv4 или UDP/IPv6 (IEEE 1588-2008, приложения D и E): event сокет
+// с метками времени приёма и передачи, general сокет — без меток.
+type UDPTransport struct {
+	cfg     UDPConfig
+	event   *timestamping.Conn
+	general *timestamping.Conn
+	packets chan Packet
+
+	mu     sync.Mutex
+	joined map[string]bool // интерфейсы, на которых сокеты подписаны на группы multicast
+
+	closeOnce sync.Once
+	done      chan struct{} // закрывается в Close: чтение не ждёт места в packets
+	wg        sync.WaitGroup
+}
+
+// NewUDPTransport открывает сокеты event и general портов
+func NewUDPTransport(cfg UDPConfig) (*UDPTransport, error) {
+	if cfg.EventPort == 0 {
+		cfg.EventPort = EventPort
+	}
+	if cfg.GeneralPort == 0 {
+		cfg.GeneralPort = GeneralPort
+	}
+	ev, err := listenUDP(cfg, cfg.EventPort, cfg.Socket.DSCPEvent)
+	if err != nil {
+		return nil, err
+	}
+	gen, err := listenUDP(cfg, cfg.GeneralPort, cfg.Socket.DSCPGeneral)
+	if err != nil {
+		ev.Close()
+		return nil, err
+	}
+	t := &UDPTransport{
+		cfg:     cfg,
+		event:   timestamping.New(ev, timestamping.Options{TX: true, Hardware: cfg.Hardware, Interface: cfg.Interface}),
+		general: timestamping.New(gen, timestamping.Options{}),
+		packets: make(chan Packet, 64),
+		joined:  make(map[string]bool),
+		done:    make(chan struct{}),
+	}
+	if cfg.Multicast {
+		if err := t.JoinMulticast(cfg.Interface); err != nil {
+			t.Close()
+			return nil, err
+		}
+	}
+	t.wg.Add(2)
+	go t.read(t.event, true)
+	go t.read(t.general, false)
+	go func() {
+		t.wg.Wait()
+		close(t.packets)
+	}()
+	return t, nil
+}
+
+// listenUDP открывает сокет порта port с параметрами cfg.Socket (dscp — DSCP сообщений порта)
+func listenUDP(cfg UDPConfig, port int, dscp int) (*net.UDPConn, error) {
+	network := "udp4"
+	if cfg.IPv6 {
+		network = "udp6"
+	}
+	lc := net.ListenConfig{Control: func(_, _ string, c syscall.RawConn) error {
+		return setSocketOptions(c, cfg, dscp)
+	}}
+	pc, err := lc.ListenPacket(context.Background(), network, net.JoinHostPort(cfg.Address, strconv.Itoa(port)))
+	if err != nil {
+		return nil, err
+	}
+	return pc.(*net.UDPConn), nil
+}
+
+// multicastGroups — основной адрес и адрес peer delay семейства транспорта
+func (t *UDPTransport) multicastGroups() (primary, peerDelay net.IP) {
+	if t.cfg.IPv6 {
+		return MulticastPrimaryIPv6, MulticastPeerDelayIPv6
+	}
+	return MulticastPrimary, MulticastPeerDelay
+}
+
+// JoinMulticast подписывает сокеты на группы multicast на интерфейсе iface; исходящий multicast
+// идёт через первый подписанный интерфейс. Повторный вызов для интерфейса ничего не делает.
+func (t *UDPTransport) JoinMulticast(iface string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.joined[iface] {
+		return nil
+	}
+	primary, peerDelay := t.multicastGroups()
+	for _, c := range []*timestamping.Conn{t.event, t.general} {
+		if err := joinMulticast(c.UDPConn(), iface, len(t.joined) == 0, primary, peerDelay); err != nil {
+			return fmt.Errorf("ptp: multicast on %s: %w", iface, err)
+		}
+	}
+	t.joined[iface] = true
+	return nil
+}
+
+// read читает сокет до закрытия и передаёт сообщения в канал
+func (t *UDPTransport) read(c *timestamping.Conn, event bool) {
+	defer t.wg.Done()
+	name := "udp " + t.cfg.Interface
+	buf := make([]byte, 1500)
+	var delay time.Duration
+	for {
+		n, addr, ts, err := c.ReadMsg(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			var ok bool
+			if delay, ok = readBackoff(name, err, delay, t.done); !ok {
+				return
+			}
+			continue
+		}
+		delay = 0
+		select {
+		case t.packets <- Packet{Data: append([]byte(nil), buf[:n]...), Src: addr, Stamp: ts, Event: event}:
+		case <-t.done:
+			return
+		}
+	}
+}
+
+// Packets возвращает канал принятых сообщений
+func (t *UDPTransport) Packets() <-chan Packet {
+	return t.packets
+}
+
+// LocalAddr возвращает адрес event сокета
+func (t *UDPTransport) LocalAddr() net.Addr {
+	return t.event.LocalAddr()
+}
+
+// TimestampType возвращает тип меток event сокета (худший из приёма и передачи)
+func (t *UDPTransport) TimestampType() timestamping.Type {
+	if tx := t.event.TXType(); tx < t.event.RXType() {
+		return tx
+	}
+	return t.event.RXType()
+}
+
+// dest вычисляет адрес получателя: dst == nil — multicast 224.0.1.129 (для peer delay — 224.0.0.107;
+// IPv6 — ff0e::181 и ff02::6b на интерфейсе транспорта)
+func (t *UDPTransport) dest(b []byte, dst net.Addr, port int) (*net.UDPAddr, error) {
+	if dst == nil {
+		primary, peerDelay := t.multicastGroups()
+		var zone string
+		if t.cfg.IPv6 {
+			zone = t.cfg.Interface
+		}
+		if len(b) > 0 && isPeerDelay(MessageType(b[0]&0x0f)) {
+			return &net.UDPAddr{IP: peerDelay, Port: port, Zone: zone}, nil
+		}
+		return &net.UDPAddr{IP: primary, Port: port, Zone: zone}, nil
+	}
+	switch a := dst.(type) {
+	case *net.UDPAddr:
+		return &net.UDPAddr{IP: a.IP, Port: port, Zone: a.Zone}, nil
+	case *net.IPAddr:
+		return &net.UDPAddr{IP: a.IP, Port: port, Zone: a.Zone}, nil
+	}
+	return nil, fmt.Errorf("ptp: unsupported address %v", dst)
+}
+
+// SendEvent отправляет event сообщение на порт 319 и возвращает метку передачи
+func (t *UDPTransport) SendEvent(b []byte, dst net.Addr) (timestamping.Stamp, error) {
+	addr, err := t.dest(b, dst, t.cfg.EventPort)
+	if err != nil {
+		return timestamping.Stamp{}, err
+	}
+	return t.event.WriteMsg(b, addr)
+}
+
+// SendGeneral отправляет general сообщение на порт 320
+func (t *UDPTransport) SendGeneral(b []byte, dst net.Addr) error {
+	addr, err := t.dest(b, dst, t.cfg.GeneralPort)
+	if err != nil {
+		return err
+	}
+	_, err = t.general.WriteMsg(b, addr)
+	return err
+}
+
+// Close закрывает сокеты; канал Packets закрывается после завершения чтения
+func (t *UDPTransport) Close() error {
+	t.closeOnce.Do(func() {
+		close(t.done)
+		t.event.Close()
+		t.general.Close()
+	})
+	return nil
+}
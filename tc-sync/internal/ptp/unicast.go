@@ -0,0 +1,282 @@
+package ptp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"sort"
+	"time"
+)
+
+// Типы TLV согласования unicast передачи (IEEE 1588-2008, 16.1)
+const (
+	TLVRequestUnicast   uint16 = 0x0004
+	TLVGrantUnicast     uint16 = 0x0005
+	TLVCancelUnicast    uint16 = 0x0006
+	TLVAckCancelUnicast uint16 = 0x0007
+)
+
+// DefaultGrantDuration — срок разрешения, запрашиваемый slave (как в G.8265.1)
+const DefaultGrantDuration = 300 * time.Second
+
+// Ограничения мастера: наибольший срок разрешения и допустимые интервалы
+const (
+	maxGrantDuration           = 1000 * time.Second
+	minUnicastLogInterval int8 = -7
+	maxUnicastLogInterval int8 = 7
+)
+
+// AllPorts — targetPortIdentity «все порты» (все единицы)
+var AllPorts = PortIdentity{Clock: ClockIdentity{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, Port: 0xffff}
+
+// UnicastTLV — TLV REQUEST/GRANT/CANCEL/ACKNOWLEDGE_CANCEL_UNICAST_TRANSMISSION.
+// LogInterval и Duration — только для REQUEST и GRANT; Renewal — только для GRANT.
+type UnicastTLV struct {
+	Kind        uint16 // TLVRequestUnicast … TLVAckCancelUnicast
+	Type        MessageType
+	LogInterval int8
+	Duration    uint32 // секунды; 0 в GRANT — отказ
+	Renewal     bool   // GRANT: мастер примет продление
+}
+
+// TLV кодирует значение TLV
+func (u UnicastTLV) TLV() TLV {
+	switch u.Kind {
+	case TLVRequestUnicast, TLVGrantUnicast:
+		v := make([]byte, 6, 8)
+		v[0] = byte(u.Type) << 4
+		v[1] = byte(u.LogInterval)
+		binary.BigEndian.PutUint32(v[2:6], u.Duration)
+		if u.Kind == TLVGrantUnicast {
+			v = append(v, 0, 0)
+			if u.Renewal {
+				v[7] = 1
+			}
+		}
+		return TLV{Type: u.Kind, Value: v}
+	}
+	return TLV{Type: u.Kind, Value: []byte{byte(u.Type) << 4, 0}}
+}
+
+// ParseUnicastTLV разбирает TLV согласования; ok=false — TLV другого типа или неверной длины
+func ParseUnicastTLV(t TLV) (UnicastTLV, bool) {
+	u := UnicastTLV{Kind: t.Type}
+	switch t.Type {
+	case TLVRequestUnicast, TLVGrantUnicast:
+		if len(t.Value) < 6 || t.Type == TLVGrantUnicast && len(t.Value) < 8 {
+			return u, false
+		}
+		u.LogInterval = int8(t.Value[1])
+		u.Duration = binary.BigEndian.Uint32(t.Value[2:6])
+		u.Renewal = t.Type == TLVGrantUnicast && t.Value[7]&1 != 0
+	case TLVCancelUnicast, TLVAckCancelUnicast:
+		if len(t.Value) < 2 {
+			return u, false
+		}
+	default:
+		return u, false
+	}
+	u.Type = MessageType(t.Value[0] >> 4)
+	return u, true
+}
+
+// Subscription — разрешение unicast передачи клиенту, выданное мастером
+type Subscription struct {
+	Client      PortIdentity
+	Addr        net.Addr
+	Type        MessageType // Announce, Sync или Delay_Resp
+	LogInterval int8
+	Granted     time.Time // время последнего GRANT (выдача или продление)
+	Expires     time.Time
+	seq         uint16
+	next        time.Time // следующая передача (Announce, Sync)
+}
+
+type subscriptionKey struct {
+	client PortIdentity
+	typ    MessageType
+}
+
+// subscriptions — таблица разрешений мастера. max — предел числа клиентов (0 — без ограничения).
+type subscriptions struct {
+	max   int
+	table map[subscriptionKey]*Subscription
+}
+
+// hasClient возвращает true, если у клиента есть хотя бы одно разрешение
+func (t *subscriptions) hasClient(client PortIdentity) bool {
+	for k := range t.table {
+		if k.client == client {
+			return true
+		}
+	}
+	return false
+}
+
+// clients — число клиентов с разрешениями
+func (t *subscriptions) clients() int {
+	seen := make(map[PortIdentity]bool)
+	for k := range t.table {
+		seen[k.client] = true
+	}
+	return len(seen)
+}
+
+// grant обрабатывает REQUEST и возвращает GRANT (Duration 0 — отказ)
+func (t *subscriptions) grant(req UnicastTLV, client PortIdentity, addr net.Addr, now time.Time) UnicastTLV {
+	resp := UnicastTLV{Kind: TLVGrantUnicast, Type: req.Type, LogInterval: req.LogInterval}
+	switch req.Type {
+	case MsgAnnounce, MsgSync, MsgDelayResp:
+	default:
+		return resp
+	}
+	if req.Duration == 0 || req.LogInterval < minUnicastLogInterval || req.LogInterval > maxUnicastLogInterval {
+		return resp
+	}
+	if t.max > 0 && !t.hasClient(client) && t.clients() >= t.max {
+		return resp
+	}
+	d := time.Duration(req.Duration) * time.Second
+	if d > maxGrantDuration {
+		d = maxGrantDuration
+	}
+	if t.table == nil {
+		t.table = make(map[subscriptionKey]*Subscription)
+	}
+	key := subscriptionKey{client, req.Type}
+	s := t.table[key]
+	if s == nil {
+		s = &Subscription{Client: client, Type: req.Type, next: now}
+		t.table[key] = s
+	}
+	if s.LogInterval != req.LogInterval {
+		s.next = now
+	}
+	s.Addr, s.LogInterval, s.Granted, s.Expires = addr, req.LogInterval, now, now.Add(d)
+	resp.Duration, resp.Renewal = uint32(d/time.Second), true
+	return resp
+}
+
+// cancel удаляет разрешение клиента
+func (t *subscriptions) cancel(client PortIdentity, typ MessageType) {
+	delete(t.table, subscriptionKey{client, typ})
+}
+
+// expire удаляет истёкшие разрешения
+func (t *subscriptions) expire(now time.Time) {
+	for k, s := range t.table {
+		if now.After(s.Expires) {
+			delete(t.table, k)
+		}
+	}
+}
+
+// due возвращает разрешения Announce/Sync, по которым пора передавать, и сдвигает время следующей передачи
+func (t *subscriptions) due(now time.Time) []*Subscription {
+	var out []*Subscription
+	for _, s := range t.table {
+		if s.Type == MsgDelayResp || now.Before(s.next) {
+			continue
+		}
+		s.seq++
+		interval := LogInterval(s.LogInterval)
+		s.next = s.next.Add(interval)
+		if s.next.Before(now) {
+			s.next = now.Add(interval)
+		}
+		out = append(out, s)
+	}
+	return out
+}
+
+// nextDue — ближайшая передача по разрешениям; нулевое время — передавать нечего
+func (t *subscriptions) nextDue() time.Time {
+	var next time.Time
+	for _, s := range t.table {
+		if s.Type != MsgDelayResp && (next.IsZero() || s.next.Before(next)) {
+			next = s.next
+		}
+	}
+	return next
+}
+
+// list возвращает копию таблицы, отсортированную по клиенту и типу сообщения
+func (t *subscriptions) list() []Subscription {
+	out := make([]Subscription, 0, len(t.table))
+	for _, s := range t.table {
+		out = append(out, *s)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		a, b := out[i], out[j]
+		if c := bytes.Compare(a.Client.Clock[:], b.Client.Clock[:]); c != 0 {
+			return c < 0
+		}
+		if a.Client.Port != b.Client.Port {
+			return a.Client.Port < b.Client.Port
+		}
+		return a.Type < b.Type
+	})
+	return out
+}
+
+// unicastRetry — повтор REQUEST после отказа или без ответа
+const unicastRetry = 5 * time.Second
+
+// clientGrant — разрешение, запрошенное slave у мастера
+type clientGrant struct {
+	requested time.Time // последний REQUEST
+	expires   time.Time // нулевое — разрешения нет
+	renewAt   time.Time
+}
+
+// unicastMaster — мастер из unicast_master_table и разрешения, полученные от него
+type unicastMaster struct {
+	addr   *net.UDPAddr
+	grants map[MessageType]*clientGrant
+}
+
+// requests возвращает TLV REQUEST/CANCEL для мастера: want — нужные типы с интервалами
+func (um *unicastMaster) requests(want map[MessageType]int8, duration time.Duration, now time.Time) []TLV {
+	var tlvs []TLV
+	for _, typ := range []MessageType{MsgAnnounce, MsgSync, MsgDelayResp} {
+		g := um.grants[typ]
+		interval, ok := want[typ]
+		if !ok {
+			if g != nil && !g.expires.IsZero() && now.Before(g.expires) {
+				tlvs = append(tlvs, UnicastTLV{Kind: TLVCancelUnicast, Type: typ}.TLV())
+			}
+			delete(um.grants, typ)
+			continue
+		}
+		if g == nil {
+			g = &clientGrant{}
+			um.grants[typ] = g
+		}
+		if !g.expires.IsZero() && now.After(g.expires) {
+			g.expires = time.Time{}
+		}
+		pending := g.expires.IsZero() || !now.Before(g.renewAt)
+		if !pending || !g.requested.IsZero() && now.Sub(g.requested) < unicastRetry {
+			continue
+		}
+		g.requested = now
+		tlvs = append(tlvs, UnicastTLV{Kind: TLVRequestUnicast, Type: typ, LogInterval: interval,
+			Duration: uint32(duration / time.Second)}.TLV())
+	}
+	return tlvs
+}
+
+// granted учитывает GRANT мастера; продление запрашивается на половине срока разрешения
+func (um *unicastMaster) granted(u UnicastTLV, now time.Time) {
+	g := um.grants[u.Type]
+	if g == nil {
+		return
+	}
+	if u.Duration == 0 {
+		g.expires = time.Time{}
+		return
+	}
+	d := time.Duration(u.Duration) * time.Second
+	g.expires, g.renewAt = now.Add(d), now.Add(d/2)
+	g.requested = time.Time{}
+}
@@ -0,0 +1,305 @@
+package ptp4l
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/shiwa/timecard-mini/tc-sync/internal/ptp"
+)
+
+// Option — строка «ключ значение» секции конфига linuxptp
+type Option struct {
+	Key, Value string
+}
+
+// Section — секция конфига linuxptp: [global], порт ([eth0]), [unicast_master_table], …
+// Ключи могут повторяться (адреса таблицы unicast мастеров), порядок сохраняется.
+type Section struct {
+	Name    string
+	Options []Option
+}
+
+// Conf — конфиг ptp4l, phc2sys или ts2phc (формат linuxptp, -f)
+type Conf struct {
+	Sections []Section
+}
+
+// Section возвращает секцию name, добавляя её в конец, если её нет
+func (c *Conf) Section(name string) *Section {
+	for i := range c.Sections {
+		if c.Sections[i].Name == name {
+			return &c.Sections[i]
+		}
+	}
+	c.Sections = append(c.Sections, Section{Name: name})
+	return &c.Sections[len(c.Sections)-1]
+}
+
+// Add добавляет строку в секцию; value — строка, число или bool (1/0)
+func (s *Section) Add(key string, value any) {
+	var v string
+	switch x := value.(type) {
+	case string:
+		v = x
+	case bool:
+		v = "0"
+		if x {
+			v = "1"
+		}
+	default:
+		v = fmt.Sprint(x)
+	}
+	s.Options = append(s.Options, Option{Key: key, Value: v})
+}
+
+// WriteTo выводит конфиг: секции через пустую строку, ключи выровнены, как в configs/ linuxptp
+func (c *Conf) WriteTo(w io.Writer) (int64, error) {
+	var b bytes.Buffer
+	for i, s := range c.Sections {
+		if i > 0 {
+			b.WriteByte('\n')
+		}
+		fmt.Fprintf(&b, "[%s]\n", s.Name)
+		for _, o := range s.Options {
+			fmt.Fprintf(&b, "%-31s %s\n", o.Key, o.Value)
+		}
+	}
+	return b.WriteTo(w)
+}
+
+// String возвращает текст конфига
+func (c *Conf) String() string {
+	var b bytes.Buffer
+	c.WriteTo(&b)
+	return b.String()
+}
+
+// Ptp4lOptions — параметры записи ptp tc-sync для конфига ptp4l
+type Ptp4lOptions struct {
+	Interface            string
+	Domain               int
+	Profile              string // как profile записи (ptp.LookupProfile)
+	Transport            string // udp (по умолчанию), udp6, l2
+	DelayMechanism       string // e2e (по умолчанию), p2p
+	AnnounceInterval     int
+	SyncInterval         int
+	DelayRequestInterval int
+	Priority1            int
+	Priority2            int
+	// UnicastMasters — unicast_master_table записи: адреса мастеров (UDP) или MAC (l2)
+	UnicastMasters []string
+	HybridE2E      bool
+	// Сервер: ServerOnly — всегда master (serverOnly); ServeUnicast — unicast_listen;
+	// ServeMulticast без ServeUnicast или оба — multicast. Без них — slave (slaveOnly).
+	ServerOnly     bool
+	ServeUnicast   bool
+	ServeMulticast bool
+	// NeighborPropDelayThresh — P2P: предел задержки линии, нс (0 — значение ptp4l)
+	NeighborPropDelayThresh int64
+	// ClockQuality — качество часов в роли grandmaster (clock_quality без auto); nil — значения ptp4l
+	ClockQuality *ptp.ClockQuality
+	TimeSource   uint8 // timeSource; 0 — значение ptp4l (0xA0)
+	UDSAddress   string
+}
+
+// server — запись описывает сервер (server_only/serve_*)
+func (o Ptp4lOptions) server() bool {
+	return o.ServerOnly || o.ServeUnicast || o.ServeMulticast
+}
+
+// transportName — network_transport ptp4l
+var transportName = map[string]string{
+	ptp.TransportUDP:  "UDPv4",
+	ptp.TransportUDP6: "UDPv6",
+	ptp.TransportL2:   "L2",
+}
+
+// RenderPtp4l строит конфиг ptp4l: [global] — часы и профиль, [<interface>] — порт,
+// [unicast_master_table] — unicast мастера с согласованием. Значения по умолчанию и проверка —
+// как у native slave и сервера (ptp.ApplyProfile).
+func RenderPtp4l(o Ptp4lOptions) (*Conf, error) {
+	if o.Interface == "" {
+		return nil, fmt.Errorf("ptp4l: interface required")
+	}
+	server := o.server()
+	unicast := len(o.UnicastMasters) > 0
+	if server {
+		unicast = o.ServeUnicast
+	}
+	opts, profile, err := ptp.ApplyProfile(o.Profile, ptp.PortOptions{
+		Transport:            o.Transport,
+		DelayMechanism:       o.DelayMechanism,
+		Domain:               o.Domain,
+		AnnounceInterval:     o.AnnounceInterval,
+		SyncInterval:         o.SyncInterval,
+		DelayRequestInterval: o.DelayRequestInterval,
+		Priority1:            o.Priority1,
+		Priority2:            o.Priority2,
+		Multicast:            (server && (o.ServeMulticast || !o.ServeUnicast)) || (!server && !unicast),
+		Unicast:              unicast,
+		HybridE2E:            o.HybridE2E && !unicast,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("ptp4l %s: %w", o.Interface, err)
+	}
+	if !server && unicast && opts.DelayMechanism == ptp.DelayP2P {
+		return nil, fmt.Errorf("ptp4l %s: delay_mechanism p2p does not support unicast_master_table", o.Interface)
+	}
+
+	var c Conf
+	g := c.Section("global")
+	g.Add("domainNumber", opts.Domain)
+	g.Add("priority1", opts.Priority1)
+	g.Add("priority2", opts.Priority2)
+	if !server {
+		g.Add("slaveOnly", true)
+	}
+	if q := o.ClockQuality; q != nil {
+		g.Add("clockClass", q.Class)
+		g.Add("clockAccuracy", fmt.Sprintf("0x%02X", q.Accuracy))
+		g.Add("offsetScaledLogVariance", fmt.Sprintf("0x%04X", q.Variance))
+	}
+	if o.TimeSource != 0 {
+		g.Add("timeSource", fmt.Sprintf("0x%02X", o.TimeSource))
+	}
+	if profile != nil {
+		switch profile.BMCA {
+		case ptp.BMCAG8275:
+			g.Add("dataset_comparison", "G.8275.x")
+			g.Add("G.8275.defaultDS.localPriority", 128)
+			g.Add("maxStepsRemoved", 255)
+		}
+		if profile.GPTP {
+			g.Add("gmCapable", true)
+			g.Add("path_trace_enabled", true)
+			g.Add("follow_up_info", true)
+			g.Add("assume_two_step", true)
+			g.Add("transportSpecific", "0x1")
+		}
+	}
+	if o.UDSAddress != "" {
+		g.Add("uds_address", o.UDSAddress)
+	}
+
+	p := c.Section(o.Interface)
+	p.Add("network_transport", transportName[opts.Transport])
+	if opts.DelayMechanism == ptp.DelayP2P {
+		p.Add("delay_mechanism", "P2P")
+	} else {
+		p.Add("delay_mechanism", "E2E")
+	}
+	if opts.Transport == ptp.TransportL2 && opts.DstMAC != nil {
+		p.Add("ptp_dst_mac", opts.DstMAC.String())
+	}
+	p.Add("logAnnounceInterval", opts.AnnounceInterval)
+	p.Add("logSyncInterval", opts.SyncInterval)
+	if opts.DelayMechanism == ptp.DelayP2P {
+		p.Add("logMinPdelayReqInterval", opts.DelayRequestInterval)
+		if o.NeighborPropDelayThresh > 0 {
+			p.Add("neighborPropDelayThresh", o.NeighborPropDelayThresh)
+		}
+	} else {
+		p.Add("logMinDelayReqInterval", opts.DelayRequestInterval)
+	}
+	if profile != nil && profile.BMCA == ptp.BMCAG8275 {
+		p.Add("G.8275.portDS.localPriority", 128)
+	}
+	if opts.HybridE2E {
+		p.Add("hybrid_e2e", true)
+	}
+	if server {
+		p.Add("serverOnly", o.ServerOnly)
+		if o.ServeUnicast {
+			p.Add("unicast_listen", true)
+			if !o.ServeMulticast {
+				p.Add("inhibit_multicast_service", true)
+			}
+		}
+	}
+	if !server && unicast {
+		p.Add("unicast_master_table", 1)
+		t := c.Section("unicast_master_table")
+		t.Add("table_id", 1)
+		t.Add("logQueryInterval", 2)
+		for _, m := range o.UnicastMasters {
+			t.Add(transportName[opts.Transport], m)
+		}
+	}
+	return &c, nil
+}
+
+// Phc2sysOptions — синхронизация часов phc2sys: Source → Sink (интерфейс с PHC или CLOCK_REALTIME)
+type Phc2sysOptions struct {
+	Source     string // интерфейс, PHC или CLOCK_REALTIME
+	Sink       string
+	Domain     int
+	UDSAddress string // сокет ptp4l для -w (ожидание синхронизации и UTC offset); пусто — значение phc2sys
+	// Wait — ждать синхронизации ptp4l (-w); без него смещение TAI−UTC — Offset
+	Wait   bool
+	Offset int // -O: сдвиг Sink относительно Source, с (без Wait)
+}
+
+// RenderPhc2sys строит конфиг phc2sys ([global]) и аргументы -s/-c/-w/-O
+func RenderPhc2sys(o Phc2sysOptions) (*Conf, []string, error) {
+	if o.Source == "" || o.Sink == "" {
+		return nil, nil, fmt.Errorf("phc2sys: source and sink required")
+	}
+	if o.Source == o.Sink {
+		return nil, nil, fmt.Errorf("phc2sys: source and sink are the same clock %s", o.Source)
+	}
+	var c Conf
+	g := c.Section("global")
+	g.Add("domainNumber", o.Domain)
+	if o.UDSAddress != "" {
+		g.Add("uds_address", o.UDSAddress)
+	}
+	args := []string{"-s", o.Source, "-c", o.Sink}
+	if o.Wait {
+		args = append(args, "-w")
+	} else {
+		args = append(args, "-O", strconv.Itoa(o.Offset))
+	}
+	return &c, args, nil
+}
+
+// Ts2phcOptions — синхронизация PHC интерфейса с PPS на входе (pin) сетевой карты через ts2phc
+type Ts2phcOptions struct {
+	Interface  string // интерфейс с PHC, на вход которого заведён PPS
+	Pin        int    // ts2phc.pin_index (канал EXTTS 0)
+	CableDelay int    // ts2phc.extts_correction, нс
+	// NMEASerialPort — порт GNSS (linked_device): секунда из NMEA RMC (-s nmea); пусто — время
+	// секунды по системным часам (-s generic)
+	NMEASerialPort string
+	NMEABaud       int
+}
+
+// RenderTs2phc строит конфиг ts2phc ([global], [<interface>]) и аргумент -s
+func RenderTs2phc(o Ts2phcOptions) (*Conf, []string, error) {
+	if o.Interface == "" {
+		return nil, nil, fmt.Errorf("ts2phc: interface required")
+	}
+	if o.Pin < 0 {
+		return nil, nil, fmt.Errorf("ts2phc %s: negative pin %d", o.Interface, o.Pin)
+	}
+	var c Conf
+	g := c.Section("global")
+	g.Add("ts2phc.pulsewidth", 100000000)
+	source := "generic"
+	if o.NMEASerialPort != "" {
+		// Только в [global]: любую другую секцию ts2phc считает интерфейсом
+		source = "nmea"
+		g.Add("ts2phc.nmea_serialport", o.NMEASerialPort)
+		if o.NMEABaud != 0 {
+			g.Add("ts2phc.nmea_baudrate", o.NMEABaud)
+		}
+	}
+	p := c.Section(o.Interface)
+	p.Add("ts2phc.extts_polarity", "rising")
+	p.Add("ts2phc.pin_index", o.Pin)
+	if o.CableDelay != 0 {
+		p.Add("ts2phc.extts_correction", o.CableDelay)
+	}
+	return &c, []string{"-s", source}, nil
+}
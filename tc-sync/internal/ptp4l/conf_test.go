@@ -0,0 +1,100 @@
+package ptp4l
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/shiwa/timecard-mini/tc-sync/internal/ptp"
+)
+
+var update = flag.Bool("update", false, "перезаписать testdata/*.conf")
+
+// golden сравнивает конфиг с testdata/<name>.conf (go test -update — перезаписать)
+func golden(t *testing.T, name string, c *Conf) {
+	t.Helper()
+	path := filepath.Join("testdata", name+".conf")
+	got := c.String()
+	if *update {
+		if err := os.WriteFile(path, []byte(got), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != string(want) {
+		t.Errorf("%s:\n%s\nwant:\n%s", name, got, want)
+	}
+}
+
+func TestRenderPtp4l(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		o    Ptp4lOptions
+	}{
+		{"ptp4l-slave", Ptp4lOptions{Interface: "eth0"}},
+		{"ptp4l-g8275.1", Ptp4lOptions{Interface: "eth1", Profile: "G.8275.1", UDSAddress: "/var/run/ptp4l-eth1"}},
+		{"ptp4l-g8275.2", Ptp4lOptions{Interface: "eth2", Profile: "G.8275.2", Domain: 44, SyncInterval: -5,
+			UnicastMasters: []string{"10.0.0.1", "10.0.0.2"}}},
+		{"ptp4l-server", Ptp4lOptions{Interface: "eth3", Profile: "enterprise-draft", ServerOnly: true, ServeUnicast: true, ServeMulticast: true,
+			Priority1: 100, ClockQuality: &ptp.ClockQuality{Class: 6, Accuracy: 0x21, Variance: 0x4E5D}, TimeSource: 0x20}},
+		{"ptp4l-gptp", Ptp4lOptions{Interface: "eth4", Profile: "gptp", ServeMulticast: true, NeighborPropDelayThresh: 500}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c, err := RenderPtp4l(tc.o)
+			if err != nil {
+				t.Fatal(err)
+			}
+			golden(t, tc.name, c)
+		})
+	}
+
+	// Ограничения профиля — как у native slave
+	for _, o := range []Ptp4lOptions{
+		{Interface: "eth2", Profile: "G.8275.2"},            // без unicast_master_table
+		{Interface: "eth1", Profile: "G.8275.1", Domain: 5}, // домен вне 24..43
+		{Interface: "eth0", DelayMechanism: "p2p", UnicastMasters: []string{"10.0.0.1"}},
+		{Profile: "G.8275.1"},
+	} {
+		if _, err := RenderPtp4l(o); err == nil {
+			t.Errorf("%+v: no error", o)
+		}
+	}
+}
+
+func TestRenderPhc2sysTs2phc(t *testing.T) {
+	c, args, err := RenderPhc2sys(Phc2sysOptions{Source: "CLOCK_REALTIME", Sink: "eth3", Domain: 24, UDSAddress: "/var/run/ptp4l-eth3", Wait: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	golden(t, "phc2sys", c)
+	if want := "-s CLOCK_REALTIME -c eth3 -w"; strings.Join(args, " ") != want {
+		t.Errorf("phc2sys args %q", args)
+	}
+	if _, args, _ := RenderPhc2sys(Phc2sysOptions{Source: "eth0", Sink: "CLOCK_REALTIME", Offset: -37}); strings.Join(args, " ") != "-s eth0 -c CLOCK_REALTIME -O -37" {
+		t.Errorf("phc2sys args without wait %q", args)
+	}
+	if _, _, err := RenderPhc2sys(Phc2sysOptions{Source: "eth0", Sink: "eth0"}); err == nil {
+		t.Error("phc2sys with the same clock: no error")
+	}
+
+	c, args, err = RenderTs2phc(Ts2phcOptions{Interface: "eth5", Pin: 1, CableDelay: 45, NMEASerialPort: "/dev/ttyS0", NMEABaud: 115200})
+	if err != nil {
+		t.Fatal(err)
+	}
+	golden(t, "ts2phc", c)
+	if len(c.Sections) != 2 || c.Sections[1].Name != "eth5" {
+		t.Errorf("ts2phc sections %+v", c.Sections)
+	}
+	if strings.Join(args, " ") != "-s nmea" {
+		t.Errorf("ts2phc args %q", args)
+	}
+	if _, args, _ := RenderTs2phc(Ts2phcOptions{Interface: "eth5"}); strings.Join(args, " ") != "-s generic" {
+		t.Errorf("ts2phc generic args %q", args)
+	}
+}
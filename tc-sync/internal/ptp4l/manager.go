@@ -1,76 +1,91 @@
-// Package ptp4l запускает ptp4l (linuxptp) как дочерние процессы и останавливает их при выходе.
+// Package ptp4l запускает ptp4l, phc2sys и ts2phc (linuxptp) как дочерние процессы под наблюдением
+// (перезапуск, разбор вывода, состояние синхронизации) с конфигами, построенными из записей tc-sync,
+// и останавливает их при выходе.
 package ptp4l
 
 import (
-	"log"
-	"os/exec"
-	"strconv"
+	"context"
+	"path/filepath"
 	"sync"
+)
 
-	"github.com/shiwa/timecard-mini/tc-sync/internal/logger"
+// Программы linuxptp
+const (
+	ProgramPtp4l   = "ptp4l"
+	ProgramPhc2sys = "phc2sys"
+	ProgramTs2phc  = "ts2phc"
 )
 
-// Job — один запуск ptp4l (один интерфейс).
+// DefaultConfigDir — каталог конфигов, построенных для заданий (Job.Config)
+const DefaultConfigDir = "/run/tc-sync"
+
+// Job — один запуск программы linuxptp (один интерфейс).
 type Job struct {
+	Program   string   // ptp4l (по умолчанию), phc2sys, ts2phc
 	Interface string   // -i eth0
 	Domain    int      // опционально -d N
-	Path      string   // путь к ptp4l (по умолчанию "ptp4l")
+	Path      string   // путь к программе (по умолчанию — её имя)
 	Args      []string // доп. аргументы, например ["-m", "-s"]
+	// Config — конфиг, записываемый перед каждым запуском в ConfigPath() и передаваемый в -f;
+	// интерфейс и домен тогда задаются в нём. nil — ptp4l запускается с -i и -d.
+	Config    *Conf
+	ConfigDir string // каталог конфига; пусто — DefaultConfigDir
+}
+
+// Key — ключ задания: программа и интерфейс («ptp4l eth0»)
+func Key(program, iface string) string {
+	if program == "" {
+		program = ProgramPtp4l
+	}
+	return program + " " + iface
+}
+
+// Key — ключ задания в Start
+func (j Job) Key() string {
+	return Key(j.Program, j.Interface)
 }
 
-// Start запускает ptp4l для каждого job. Один интерфейс — один процесс (дубликаты по interface отбрасываются).
-// Возвращает функцию stop(), которую нужно вызвать при выходе (останавливает все процессы).
-func Start(jobs []Job, quiet bool) (stop func()) {
-	if len(jobs) == 0 {
-		return func() {}
+// ConfigPath — путь конфига задания: <ConfigDir>/<программа>-<интерфейс>.conf
+func (j Job) ConfigPath() string {
+	dir := j.ConfigDir
+	if dir == "" {
+		dir = DefaultConfigDir
+	}
+	program := j.Program
+	if program == "" {
+		program = ProgramPtp4l
 	}
-	byIface := make(map[string]Job)
+	return filepath.Join(dir, program+"-"+j.Interface+".conf")
+}
+
+// Start запускает программу каждого job под Supervisor. Программа на интерфейсе — один процесс
+// (дубликаты по Job.Key отбрасываются). Возвращает наблюдателей по Job.Key и функцию stop(), которую
+// нужно вызвать при выходе: процессы получают SIGTERM, stop ждёт их завершения.
+func Start(jobs []Job) (sups map[string]*Supervisor, stop func()) {
+	sups = make(map[string]*Supervisor)
 	for _, j := range jobs {
 		if j.Interface == "" {
 			continue
 		}
-		if _, ok := byIface[j.Interface]; !ok {
-			byIface[j.Interface] = j
+		if _, ok := sups[j.Key()]; !ok {
+			sups[j.Key()] = NewSupervisor(j)
 		}
 	}
-	var cmds []*exec.Cmd
-	for _, j := range byIface {
-		path := j.Path
-		if path == "" {
-			path = "ptp4l"
-		}
-		args := make([]string, 0, 6+len(j.Args))
-		args = append(args, "-i", j.Interface)
-		args = append(args, "-d", strconv.Itoa(j.Domain))
-		// Стандартные аргументы, если не заданы: slave + вывод в stdout
-		if len(j.Args) == 0 {
-			args = append(args, "-m", "-s")
-		} else {
-			args = append(args, j.Args...)
-		}
-		cmd := exec.Command(path, args...)
-		cmd.Stdout = nil
-		cmd.Stderr = nil
-		if !quiet {
-			cmd.Stdout = log.Writer()
-			cmd.Stderr = log.Writer()
-		}
-		if err := cmd.Start(); err != nil {
-			logger.Info("ptp4l start %s: %v", j.Interface, err)
-			continue
-		}
-		cmds = append(cmds, cmd)
-		logger.Info("ptp4l started: %s -i %s", path, j.Interface)
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	for _, s := range sups {
+		wg.Add(1)
+		go func(s *Supervisor) {
+			defer wg.Done()
+			s.Run(ctx)
+		}(s)
 	}
 	var once sync.Once
 	stop = func() {
 		once.Do(func() {
-			for _, cmd := range cmds {
-				if cmd.Process != nil {
-					_ = cmd.Process.Kill()
-				}
-			}
+			cancel()
+			wg.Wait()
 		})
 	}
-	return stop
+	return sups, stop
 }
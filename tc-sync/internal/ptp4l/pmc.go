@@ -0,0 +1,514 @@
+package ptp4l
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/shiwa/timecard-mini/tc-sync/internal/ptp"
+)
+
+// DefaultSocket — UNIX сокет управления ptp4l по умолчанию (uds_address)
+const DefaultSocket = "/var/run/ptp4l"
+
+// pmcTimeout — ожидание ответа ptp4l по умолчанию
+const pmcTimeout = time.Second
+
+// portsWindow — после первого ответа на PORT_DATA_SET столько ждать ответов других портов
+const portsWindow = 20 * time.Millisecond
+
+// TLV управления (IEEE 1588-2008, 15.5.2)
+const (
+	TLVManagement            uint16 = 0x0001
+	TLVManagementErrorStatus uint16 = 0x0002
+)
+
+// actionField сообщения Management
+const (
+	actionGet      = 0
+	actionResponse = 2
+)
+
+// ManagementID — managementId TLV MANAGEMENT
+type ManagementID uint16
+
+// Наборы данных, запрашиваемые у ptp4l (IEEE 1588-2008, 15.5.3; _NP — расширения linuxptp)
+const (
+	IDCurrentDataSet        ManagementID = 0x2001
+	IDParentDataSet         ManagementID = 0x2002
+	IDPortDataSet           ManagementID = 0x2004
+	IDTimeStatusNP          ManagementID = 0xC000
+	IDGrandmasterSettingsNP ManagementID = 0xC001
+)
+
+var managementNames = map[ManagementID]string{
+	IDCurrentDataSet:        "CURRENT_DATA_SET",
+	IDParentDataSet:         "PARENT_DATA_SET",
+	IDPortDataSet:           "PORT_DATA_SET",
+	IDTimeStatusNP:          "TIME_STATUS_NP",
+	IDGrandmasterSettingsNP: "GRANDMASTER_SETTINGS_NP",
+}
+
+func (id ManagementID) String() string {
+	if s, ok := managementNames[id]; ok {
+		return s
+	}
+	return fmt.Sprintf("0x%04X", uint16(id))
+}
+
+// ParseManagementID разбирает имя набора данных, как в pmc (TIME_STATUS_NP, PORT_DATA_SET, …)
+func ParseManagementID(s string) (ManagementID, error) {
+	for id, name := range managementNames {
+		if name == s {
+			return id, nil
+		}
+	}
+	return 0, fmt.Errorf("pmc: unsupported management id %q", s)
+}
+
+// ManagementError — ответ MANAGEMENT_ERROR_STATUS
+type ManagementError struct {
+	ID      ManagementID
+	Code    uint16 // managementErrorId: 1 RESPONSE_TOO_BIG, 2 NO_SUCH_ID, 3 WRONG_LENGTH, …
+	Display string
+}
+
+func (e *ManagementError) Error() string {
+	s := fmt.Sprintf("pmc: %s: management error 0x%04X", e.ID, e.Code)
+	if e.Display != "" {
+		s += " (" + e.Display + ")"
+	}
+	return s
+}
+
+// ErrShortDataSet — данные TLV MANAGEMENT короче набора данных
+var ErrShortDataSet = errors.New("pmc: short management data")
+
+// PortState — portState PORT_DATA_SET (IEEE 1588-2008, 8.2.5.3.1); String — как в выводе ptp4l
+type PortState uint8
+
+const (
+	PortInitializing PortState = iota + 1
+	PortFaulty
+	PortDisabled
+	PortListening
+	PortPreMaster
+	PortMaster
+	PortPassive
+	PortUncalibrated
+	PortSlave
+)
+
+func (s PortState) String() string {
+	switch s {
+	case PortInitializing:
+		return "INITIALIZING"
+	case PortFaulty:
+		return "FAULTY"
+	case PortDisabled:
+		return "DISABLED"
+	case PortListening:
+		return "LISTENING"
+	case PortPreMaster:
+		return "PRE_MASTER"
+	case PortMaster:
+		return "MASTER"
+	case PortPassive:
+		return "PASSIVE"
+	case PortUncalibrated:
+		return "UNCALIBRATED"
+	case PortSlave:
+		return "SLAVE"
+	}
+	return fmt.Sprintf("STATE_%d", uint8(s))
+}
+
+// CurrentDataSet — CURRENT_DATA_SET
+type CurrentDataSet struct {
+	StepsRemoved     uint16
+	OffsetFromMaster time.Duration
+	MeanPathDelay    time.Duration
+}
+
+// ParentDataSet — PARENT_DATA_SET
+type ParentDataSet struct {
+	ParentPort                            ptp.PortIdentity
+	ParentStats                           bool
+	ObservedParentOffsetScaledLogVariance uint16
+	ObservedParentClockPhaseChangeRate    int32
+	GrandmasterPriority1                  uint8
+	GrandmasterQuality                    ptp.ClockQuality
+	GrandmasterPriority2                  uint8
+	GrandmasterIdentity                   ptp.ClockIdentity
+}
+
+// PortDataSet — PORT_DATA_SET
+type PortDataSet struct {
+	Port                    ptp.PortIdentity
+	State                   PortState
+	LogMinDelayReqInterval  int8
+	PeerMeanPathDelay       time.Duration
+	LogAnnounceInterval     int8
+	AnnounceReceiptTimeout  uint8
+	LogSyncInterval         int8
+	DelayMechanism          uint8 // 1 E2E, 2 P2P, 0xFE disabled
+	LogMinPdelayReqInterval int8
+	Version                 uint8
+}
+
+// TimeStatusNP — TIME_STATUS_NP linuxptp: сдвиг от мастера и наличие grandmaster
+type TimeStatusNP struct {
+	MasterOffset               time.Duration
+	IngressTime                time.Time
+	CumulativeScaledRateOffset int32
+	ScaledLastGmPhaseChange    int32
+	GmTimeBaseIndicator        uint16
+	LastGmPhaseChange          time.Duration // nanoseconds_lsb (старшие 16 бит и доли наносекунды отбрасываются)
+	GmPresent                  bool
+	GmIdentity                 ptp.ClockIdentity
+}
+
+// GrandmasterSettingsNP — GRANDMASTER_SETTINGS_NP linuxptp: качество и свойства времени,
+// объявляемые ptp4l в роли grandmaster
+type GrandmasterSettingsNP struct {
+	Quality    ptp.ClockQuality
+	UTCOffset  int16
+	TimeFlags  uint8 // leap61, leap59, currentUtcOffsetValid, ptpTimescale, timeTraceable, frequencyTraceable
+	TimeSource uint8
+}
+
+// timeInterval — TimeInterval: наносекунды × 2^16
+func timeInterval(b []byte) time.Duration {
+	return time.Duration(int64(binary.BigEndian.Uint64(b)) >> 16)
+}
+
+func getPortIdentity(b []byte) ptp.PortIdentity {
+	var p ptp.PortIdentity
+	copy(p.Clock[:], b[:8])
+	p.Port = binary.BigEndian.Uint16(b[8:10])
+	return p
+}
+
+// ParseCurrentDataSet разбирает данные CURRENT_DATA_SET
+func ParseCurrentDataSet(b []byte) (CurrentDataSet, error) {
+	if len(b) < 18 {
+		return CurrentDataSet{}, ErrShortDataSet
+	}
+	return CurrentDataSet{StepsRemoved: binary.BigEndian.Uint16(b[0:2]), OffsetFromMaster: timeInterval(b[2:10]), MeanPathDelay: timeInterval(b[10:18])}, nil
+}
+
+// ParseParentDataSet разбирает данные PARENT_DATA_SET
+func ParseParentDataSet(b []byte) (ParentDataSet, error) {
+	if len(b) < 32 {
+		return ParentDataSet{}, ErrShortDataSet
+	}
+	d := ParentDataSet{
+		ParentPort:                            getPortIdentity(b[0:10]),
+		ParentStats:                           b[10]&1 != 0,
+		ObservedParentOffsetScaledLogVariance: binary.BigEndian.Uint16(b[12:14]),
+		ObservedParentClockPhaseChangeRate:    int32(binary.BigEndian.Uint32(b[14:18])),
+		GrandmasterPriority1:                  b[18],
+		GrandmasterQuality:                    ptp.ClockQuality{Class: b[19], Accuracy: b[20], Variance: binary.BigEndian.Uint16(b[21:23])},
+		GrandmasterPriority2:                  b[23],
+	}
+	copy(d.GrandmasterIdentity[:], b[24:32])
+	return d, nil
+}
+
+// ParsePortDataSet разбирает данные PORT_DATA_SET
+func ParsePortDataSet(b []byte) (PortDataSet, error) {
+	if len(b) < 26 {
+		return PortDataSet{}, ErrShortDataSet
+	}
+	return PortDataSet{
+		Port:                    getPortIdentity(b[0:10]),
+		State:                   PortState(b[10]),
+		LogMinDelayReqInterval:  int8(b[11]),
+		PeerMeanPathDelay:       timeInterval(b[12:20]),
+		LogAnnounceInterval:     int8(b[20]),
+		AnnounceReceiptTimeout:  b[21],
+		LogSyncInterval:         int8(b[22]),
+		DelayMechanism:          b[23],
+		LogMinPdelayReqInterval: int8(b[24]),
+		Version:                 b[25] & 0xF,
+	}, nil
+}
+
+// ParseTimeStatusNP разбирает данные TIME_STATUS_NP
+func ParseTimeStatusNP(b []byte) (TimeStatusNP, error) {
+	if len(b) < 50 {
+		return TimeStatusNP{}, ErrShortDataSet
+	}
+	d := TimeStatusNP{
+		MasterOffset:               time.Duration(int64(binary.BigEndian.Uint64(b[0:8]))),
+		CumulativeScaledRateOffset: int32(binary.BigEndian.Uint32(b[16:20])),
+		ScaledLastGmPhaseChange:    int32(binary.BigEndian.Uint32(b[20:24])),
+		GmTimeBaseIndicator:        binary.BigEndian.Uint16(b[24:26]),
+		LastGmPhaseChange:          time.Duration(int64(binary.BigEndian.Uint64(b[28:36]))),
+		GmPresent:                  binary.BigEndian.Uint32(b[38:42]) != 0,
+	}
+	if ns := int64(binary.BigEndian.Uint64(b[8:16])); ns != 0 {
+		d.IngressTime = time.Unix(0, ns).UTC()
+	}
+	copy(d.GmIdentity[:], b[42:50])
+	return d, nil
+}
+
+// ParseGrandmasterSettingsNP разбирает данные GRANDMASTER_SETTINGS_NP
+func ParseGrandmasterSettingsNP(b []byte) (GrandmasterSettingsNP, error) {
+	if len(b) < 8 {
+		return GrandmasterSettingsNP{}, ErrShortDataSet
+	}
+	return GrandmasterSettingsNP{
+		Quality:    ptp.ClockQuality{Class: b[0], Accuracy: b[1], Variance: binary.BigEndian.Uint16(b[2:4])},
+		UTCOffset:  int16(binary.BigEndian.Uint16(b[4:6])),
+		TimeFlags:  b[6],
+		TimeSource: b[7],
+	}, nil
+}
+
+// PMCConfig — параметры клиента управления ptp4l
+type PMCConfig struct {
+	Socket string // сокет ptp4l (uds_address); пусто — DefaultSocket
+	// Local — собственный сокет клиента для ответов; пусто — tc-sync-pmc.<pid>.<n> рядом с Socket
+	Local   string
+	Domain  uint8         // domainNumber ptp4l
+	Timeout time.Duration // ожидание ответа; 0 — 1 с
+}
+
+// PMC — клиент протокола управления linuxptp (как pmc -u -b 0): сообщения Management с GET
+// по UNIX datagram сокету ptp4l
+type PMC struct {
+	cfg   PMCConfig
+	conn  *net.UnixConn
+	raddr *net.UnixAddr
+	id    ptp.PortIdentity
+
+	mu  sync.Mutex
+	seq uint16
+}
+
+var pmcLocalSeq atomic.Uint32
+
+// DialPMC открывает собственный сокет клиента; ptp4l при этом может ещё не работать
+func DialPMC(cfg PMCConfig) (*PMC, error) {
+	if cfg.Socket == "" {
+		cfg.Socket = DefaultSocket
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = pmcTimeout
+	}
+	if cfg.Local == "" {
+		cfg.Local = filepath.Join(filepath.Dir(cfg.Socket), fmt.Sprintf("tc-sync-pmc.%d.%d", os.Getpid(), pmcLocalSeq.Add(1)))
+	}
+	_ = os.Remove(cfg.Local)
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: cfg.Local, Net: "unixgram"})
+	if err != nil {
+		return nil, fmt.Errorf("pmc: %w", err)
+	}
+	c := &PMC{
+		cfg:   cfg,
+		conn:  conn,
+		raddr: &net.UnixAddr{Name: cfg.Socket, Net: "unixgram"},
+		// Как у pmc: clockIdentity нулевой, portNumber — pid
+		id: ptp.PortIdentity{Port: uint16(os.Getpid())},
+	}
+	return c, nil
+}
+
+// Close закрывает сокет клиента и удаляет его файл
+func (c *PMC) Close() error {
+	err := c.conn.Close()
+	_ = os.Remove(c.cfg.Local)
+	return err
+}
+
+// Get запрашивает набор данных и возвращает данные TLV MANAGEMENT первого ответа
+func (c *PMC) Get(id ManagementID) ([]byte, error) {
+	all, err := c.get(id, false)
+	if err != nil {
+		return nil, err
+	}
+	return all[0], nil
+}
+
+// get отправляет GET и собирает ответы; all — ждать ответов всех портов (portsWindow после первого)
+func (c *PMC) get(id ManagementID, all bool) ([][]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.seq++
+	req := ptp.Message{
+		Header: ptp.Header{Type: ptp.MsgManagement, Domain: c.cfg.Domain, Source: c.id, Sequence: c.seq, LogMsgInterval: ptp.LogIntervalUnset},
+		// targetPortIdentity — все часы и порты
+		Port:   ptp.PortIdentity{Clock: ptp.ClockIdentity{0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}, Port: 0xFFFF},
+		Action: actionGet,
+		TLVs:   []ptp.TLV{{Type: TLVManagement, Value: binary.BigEndian.AppendUint16(nil, uint16(id))}},
+	}
+	if _, err := c.conn.WriteToUnix(req.Marshal(), c.raddr); err != nil {
+		return nil, fmt.Errorf("pmc: %s: %w", c.cfg.Socket, err)
+	}
+	deadline := time.Now().Add(c.cfg.Timeout)
+	var out [][]byte
+	buf := make([]byte, 1500)
+	for {
+		_ = c.conn.SetReadDeadline(deadline)
+		n, _, err := c.conn.ReadFromUnix(buf)
+		if err != nil {
+			var ne net.Error
+			if len(out) > 0 && errors.As(err, &ne) && ne.Timeout() {
+				return out, nil
+			}
+			return nil, fmt.Errorf("pmc: %s %s: %w", c.cfg.Socket, id, err)
+		}
+		data, err := c.response(buf[:n], id)
+		if err != nil {
+			return nil, err
+		}
+		if data == nil {
+			continue
+		}
+		out = append(out, data)
+		if !all {
+			return out, nil
+		}
+		if d := time.Now().Add(portsWindow); d.Before(deadline) {
+			deadline = d
+		}
+	}
+}
+
+// response проверяет ответ на текущий запрос; nil, nil — чужое или устаревшее сообщение
+func (c *PMC) response(b []byte, id ManagementID) ([]byte, error) {
+	m, err := ptp.Unmarshal(b)
+	if err != nil || m.Type != ptp.MsgManagement || m.Sequence != c.seq || m.Action != actionResponse || len(m.TLVs) == 0 {
+		return nil, nil
+	}
+	t := m.TLVs[0]
+	switch t.Type {
+	case TLVManagement:
+		if len(t.Value) < 2 || ManagementID(binary.BigEndian.Uint16(t.Value)) != id {
+			return nil, nil
+		}
+		return append([]byte(nil), t.Value[2:]...), nil
+	case TLVManagementErrorStatus:
+		if len(t.Value) < 8 {
+			return nil, &ManagementError{ID: id}
+		}
+		e := &ManagementError{ID: ManagementID(binary.BigEndian.Uint16(t.Value[2:4])), Code: binary.BigEndian.Uint16(t.Value)}
+		if rest := t.Value[8:]; len(rest) > 0 && int(rest[0]) < len(rest) {
+			e.Display = string(rest[1 : 1+int(rest[0])])
+		}
+		return nil, e
+	}
+	return nil, nil
+}
+
+// CurrentDataSet запрашивает CURRENT_DATA_SET
+func (c *PMC) CurrentDataSet() (CurrentDataSet, error) {
+	b, err := c.Get(IDCurrentDataSet)
+	if err != nil {
+		return CurrentDataSet{}, err
+	}
+	return ParseCurrentDataSet(b)
+}
+
+// ParentDataSet запрашивает PARENT_DATA_SET
+func (c *PMC) ParentDataSet() (ParentDataSet, error) {
+	b, err := c.Get(IDParentDataSet)
+	if err != nil {
+		return ParentDataSet{}, err
+	}
+	return ParseParentDataSet(b)
+}
+
+// PortDataSets запрашивает PORT_DATA_SET всех портов ptp4l
+func (c *PMC) PortDataSets() ([]PortDataSet, error) {
+	all, err := c.get(IDPortDataSet, true)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]PortDataSet, 0, len(all))
+	for _, b := range all {
+		d, err := ParsePortDataSet(b)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, d)
+	}
+	return out, nil
+}
+
+// TimeStatus запрашивает TIME_STATUS_NP
+func (c *PMC) TimeStatus() (TimeStatusNP, error) {
+	b, err := c.Get(IDTimeStatusNP)
+	if err != nil {
+		return TimeStatusNP{}, err
+	}
+	return ParseTimeStatusNP(b)
+}
+
+// GrandmasterSettings запрашивает GRANDMASTER_SETTINGS_NP
+func (c *PMC) GrandmasterSettings() (GrandmasterSettingsNP, error) {
+	b, err := c.Get(IDGrandmasterSettingsNP)
+	if err != nil {
+		return GrandmasterSettingsNP{}, err
+	}
+	return ParseGrandmasterSettingsNP(b)
+}
+
+// PMCStatus — наборы данных ptp4l, по которым судят о синхронизации
+type PMCStatus struct {
+	Time        TimeStatusNP
+	Ports       []PortDataSet
+	Parent      ParentDataSet
+	Current     CurrentDataSet
+	Grandmaster GrandmasterSettingsNP
+	Queried     time.Time
+}
+
+// Status запрашивает TIME_STATUS_NP, PORT_DATA_SET, PARENT_DATA_SET, CURRENT_DATA_SET
+// и GRANDMASTER_SETTINGS_NP
+func (c *PMC) Status() (PMCStatus, error) {
+	var st PMCStatus
+	var err error
+	if st.Time, err = c.TimeStatus(); err != nil {
+		return st, err
+	}
+	if st.Ports, err = c.PortDataSets(); err != nil {
+		return st, err
+	}
+	if st.Parent, err = c.ParentDataSet(); err != nil {
+		return st, err
+	}
+	if st.Current, err = c.CurrentDataSet(); err != nil {
+		return st, err
+	}
+	if st.Grandmaster, err = c.GrandmasterSettings(); err != nil {
+		return st, err
+	}
+	st.Queried = time.Now()
+	return st, nil
+}
+
+// SlavePort возвращает порт в состоянии SLAVE; ok=false — такого нет
+func (st PMCStatus) SlavePort() (PortDataSet, bool) {
+	for _, p := range st.Ports {
+		if p.State == PortSlave {
+			return p, true
+		}
+	}
+	return PortDataSet{}, false
+}
+
+// Synchronized возвращает true, если ptp4l синхронизирован: есть порт в SLAVE и grandmaster
+// присутствует (gmPresent TIME_STATUS_NP)
+func (st PMCStatus) Synchronized() bool {
+	_, slave := st.SlavePort()
+	return slave && st.Time.GmPresent
+}
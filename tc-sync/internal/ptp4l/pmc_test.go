@@ -0,0 +1,175 @@
+package ptp4l
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/shiwa/timecard-mini/tc-sync/internal/ptp"
+)
+
+var (
+	testGM     = ptp.ClockIdentity{0x00, 0x11, 0x22, 0xFF, 0xFE, 0x33, 0x44, 0x55}
+	testLocal  = ptp.ClockIdentity{0x00, 0x11, 0x22, 0xFF, 0xFE, 0x33, 0x44, 0x66}
+	testParent = ptp.PortIdentity{Clock: testGM, Port: 1}
+)
+
+func putTimeInterval(b []byte, d time.Duration) {
+	binary.BigEndian.PutUint64(b, uint64(int64(d)<<16))
+}
+
+func putPortIdentity(b []byte, p ptp.PortIdentity) {
+	copy(b, p.Clock[:])
+	binary.BigEndian.PutUint16(b[8:], p.Port)
+}
+
+func portDataSet(port uint16, state PortState) []byte {
+	b := make([]byte, 26)
+	putPortIdentity(b, ptp.PortIdentity{Clock: testLocal, Port: port})
+	b[10], b[11] = byte(state), 0
+	b[20], b[21], b[22], b[23], b[24], b[25] = 1, 3, 0, 1, 0, 2
+	return b
+}
+
+// fakePtp4l отвечает на GET по UNIX сокету как ptp4l: PORT_DATA_SET — от двух портов,
+// неизвестные наборы данных — MANAGEMENT_ERROR_STATUS NO_SUCH_ID
+func fakePtp4l(t *testing.T, path string, slave bool) {
+	t.Helper()
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	current := make([]byte, 18)
+	binary.BigEndian.PutUint16(current, 1)
+	putTimeInterval(current[2:], -23)
+	putTimeInterval(current[10:], 567)
+	parent := make([]byte, 32)
+	putPortIdentity(parent, testParent)
+	parent[18], parent[19], parent[20] = 128, 6, 0x21
+	binary.BigEndian.PutUint16(parent[21:], 0x4E5D)
+	parent[23] = 128
+	copy(parent[24:], testGM[:])
+	status := make([]byte, 50)
+	masterOffset := int64(-23)
+	binary.BigEndian.PutUint64(status, uint64(masterOffset))
+	binary.BigEndian.PutUint64(status[8:], uint64(1700000000123456789))
+	if slave {
+		binary.BigEndian.PutUint32(status[38:], 1)
+	}
+	copy(status[42:], testGM[:])
+	gm := []byte{248, 0xFE, 0xFF, 0xFF, 0, 37, 0x04, 0xA0}
+	state := PortListening
+	if slave {
+		state = PortSlave
+	}
+	data := map[ManagementID][][]byte{
+		IDCurrentDataSet:        {current},
+		IDParentDataSet:         {parent},
+		IDPortDataSet:           {portDataSet(1, state), portDataSet(2, PortMaster)},
+		IDTimeStatusNP:          {status},
+		IDGrandmasterSettingsNP: {gm},
+	}
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, addr, err := conn.ReadFromUnix(buf)
+			if err != nil {
+				return
+			}
+			req, err := ptp.Unmarshal(buf[:n])
+			if err != nil || req.Type != ptp.MsgManagement || len(req.TLVs) != 1 || len(req.TLVs[0].Value) != 2 {
+				continue
+			}
+			id := ManagementID(binary.BigEndian.Uint16(req.TLVs[0].Value))
+			resp := ptp.Message{Header: ptp.Header{Type: ptp.MsgManagement, Domain: req.Domain, Sequence: req.Sequence,
+				Source: ptp.PortIdentity{Clock: testLocal}, LogMsgInterval: ptp.LogIntervalUnset}, Port: req.Source, Action: actionResponse}
+			answers, ok := data[id]
+			if !ok {
+				v := binary.BigEndian.AppendUint16(nil, 2) // NO_SUCH_ID
+				v = binary.BigEndian.AppendUint16(v, uint16(id))
+				v = append(v, 0, 0, 0, 0, 7)
+				v = append(v, "no such"...)
+				resp.TLVs = []ptp.TLV{{Type: TLVManagementErrorStatus, Value: v}}
+				conn.WriteToUnix(resp.Marshal(), addr)
+				continue
+			}
+			for _, a := range answers {
+				resp.TLVs = []ptp.TLV{{Type: TLVManagement, Value: append(binary.BigEndian.AppendUint16(nil, uint16(id)), a...)}}
+				conn.WriteToUnix(resp.Marshal(), addr)
+			}
+		}
+	}()
+}
+
+func TestPMC(t *testing.T) {
+	dir := t.TempDir()
+	sock := filepath.Join(dir, "ptp4l")
+	fakePtp4l(t, sock, true)
+	c, err := DialPMC(PMCConfig{Socket: sock, Domain: 24})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	st, err := c.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !st.Synchronized() || st.Time.MasterOffset != -23 || st.Time.GmIdentity != testGM || st.Time.IngressTime.UnixNano() != 1700000000123456789 {
+		t.Errorf("time status %+v", st.Time)
+	}
+	if len(st.Ports) != 2 || st.Ports[0].State != PortSlave || st.Ports[1].State != PortMaster || st.Ports[0].AnnounceReceiptTimeout != 3 || st.Ports[0].Version != 2 {
+		t.Errorf("ports %+v", st.Ports)
+	}
+	if p, ok := st.SlavePort(); !ok || p.Port.Port != 1 || p.State.String() != "SLAVE" {
+		t.Errorf("slave port %+v", p)
+	}
+	if st.Parent.ParentPort != testParent || st.Parent.GrandmasterQuality != (ptp.ClockQuality{Class: 6, Accuracy: 0x21, Variance: 0x4E5D}) || st.Parent.GrandmasterIdentity != testGM {
+		t.Errorf("parent %+v", st.Parent)
+	}
+	if st.Current != (CurrentDataSet{StepsRemoved: 1, OffsetFromMaster: -23, MeanPathDelay: 567}) {
+		t.Errorf("current %+v", st.Current)
+	}
+	if g := st.Grandmaster; g.Quality.Class != 248 || g.UTCOffset != 37 || g.TimeSource != 0xA0 {
+		t.Errorf("grandmaster settings %+v", g)
+	}
+
+	// Ошибка управления
+	var me *ManagementError
+	if _, err := c.Get(0x2003); !errors.As(err, &me) || me.Code != 2 || me.Display != "no such" {
+		t.Errorf("error status: %v", err)
+	}
+	if id, err := ParseManagementID("TIME_STATUS_NP"); err != nil || id != IDTimeStatusNP {
+		t.Errorf("parse id %v %v", id, err)
+	}
+}
+
+func TestPMC_NotSynchronized(t *testing.T) {
+	dir := t.TempDir()
+	sock := filepath.Join(dir, "ptp4l")
+	fakePtp4l(t, sock, false)
+	c, err := DialPMC(PMCConfig{Socket: sock, Timeout: 200 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	st, err := c.Status()
+	if err != nil || st.Synchronized() {
+		t.Errorf("status %+v, %v", st, err)
+	}
+
+	// ptp4l не запущен: сокета нет
+	down, err := DialPMC(PMCConfig{Socket: filepath.Join(dir, "missing"), Timeout: 200 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer down.Close()
+	if _, err := down.TimeStatus(); err == nil {
+		t.Error("query without ptp4l succeeded")
+	}
+}
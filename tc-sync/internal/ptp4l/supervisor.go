@@ -0,0 +1,335 @@
+package ptp4l
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/shiwa/timecard-mini/tc-sync/internal/logger"
+)
+
+// Перезапуск ptp4l: пауза после выхода удваивается от restartMin до restartMax и сбрасывается,
+// если процесс проработал не меньше stableRun
+const (
+	restartMin = time.Second
+	restartMax = time.Minute
+	stableRun  = time.Minute
+)
+
+// stopTimeout — сколько ждать выхода ptp4l после SIGTERM, прежде чем завершить его SIGKILL
+const stopTimeout = 5 * time.Second
+
+// sampleTimeout — без новых строк servo дольше этого ptp4l не считается синхронизированным
+const sampleTimeout = 10 * time.Second
+
+// ServoState — состояние servo ptp4l в строке «master offset»: s0 — не захвачен, s1 — шаг часов,
+// s2 — захвачен, s3 — захвачен стабильно (linuxptp 4.x)
+type ServoState int
+
+const (
+	ServoUnlocked ServoState = iota
+	ServoJump
+	ServoLocked
+	ServoLockedStable
+)
+
+func (s ServoState) String() string {
+	return "s" + strconv.Itoa(int(s))
+}
+
+// Sample — строка servo: ptp4l «master offset -23 s2 freq +1234 path delay 567», phc2sys
+// «CLOCK_REALTIME phc offset -23 s2 freq +1234 delay 567», ts2phc «eth0 offset -5 s2 freq +12»
+// или сводка summary_interval «rms 12 max 25 freq -1234 +/- 10 delay 567 +/- 2» (выводится только
+// в захвате, offset — rms)
+type Sample struct {
+	Offset     time.Duration
+	ServoState ServoState
+	Freq       float64 // ppb
+	PathDelay  time.Duration
+	Time       time.Time // локальное время строки
+}
+
+// PortEvent — строка смены состояния порта: «port 1 (eth0): UNCALIBRATED to SLAVE on MASTER_CLOCK_SELECTED»
+type PortEvent struct {
+	Port      int
+	Interface string // пусто в старых версиях linuxptp
+	From, To  string
+	Event     string
+}
+
+var (
+	reSample  = regexp.MustCompile(`offset\s+(-?\d+)\s+s(\d)\s+freq\s+([+-]?\d+)(?:\s+(?:path )?delay\s+(-?\d+))?`)
+	reSummary = regexp.MustCompile(`rms\s+(\d+)\s+max\s+\d+\s+freq\s+([+-]?\d+)\s+\+/-\s+\d+(?:\s+delay\s+(-?\d+)\s+\+/-\s+\d+)?`)
+	rePort    = regexp.MustCompile(`port (\d+)(?: \(([^)]*)\))?: (\S+) to (\S+) on (\S+)`)
+	reBest    = regexp.MustCompile(`selected best master clock (\S+)`)
+)
+
+// ParseSample разбирает строку servo ptp4l
+func ParseSample(line string) (Sample, bool) {
+	if m := reSample.FindStringSubmatch(line); m != nil {
+		off, _ := strconv.ParseInt(m[1], 10, 64)
+		state, _ := strconv.Atoi(m[2])
+		freq, _ := strconv.ParseFloat(m[3], 64)
+		var delay int64
+		if m[4] != "" {
+			delay, _ = strconv.ParseInt(m[4], 10, 64)
+		}
+		return Sample{Offset: time.Duration(off), ServoState: ServoState(state), Freq: freq, PathDelay: time.Duration(delay)}, true
+	}
+	if m := reSummary.FindStringSubmatch(line); m != nil {
+		rms, _ := strconv.ParseInt(m[1], 10, 64)
+		freq, _ := strconv.ParseFloat(m[2], 64)
+		var delay int64
+		if m[3] != "" {
+			delay, _ = strconv.ParseInt(m[3], 10, 64)
+		}
+		return Sample{Offset: time.Duration(rms), ServoState: ServoLocked, Freq: freq, PathDelay: time.Duration(delay)}, true
+	}
+	return Sample{}, false
+}
+
+// ParsePortEvent разбирает строку смены состояния порта ptp4l
+func ParsePortEvent(line string) (PortEvent, bool) {
+	m := rePort.FindStringSubmatch(line)
+	if m == nil {
+		return PortEvent{}, false
+	}
+	port, _ := strconv.Atoi(m[1])
+	return PortEvent{Port: port, Interface: m[2], From: m[3], To: m[4], Event: m[5]}, true
+}
+
+// Health — состояние процесса linuxptp под наблюдением Supervisor
+type Health struct {
+	Program     string
+	Interface   string
+	Running     bool
+	PID         int
+	Started     time.Time // запуск текущего (последнего) процесса
+	Restarts    int
+	LastExit    string // причина последнего выхода; пусто — не выходил
+	PortState   string // состояние порта из последней строки смены (SLAVE, MASTER, LISTENING, …)
+	Grandmaster string // «selected best master clock»
+	Last        Sample
+	HaveSample  bool
+}
+
+// Locked возвращает true, если процесс работает, последняя строка servo — s2/s3 не старше
+// sampleTimeout и (для ptp4l) порт в SLAVE
+func (h Health) Locked(now time.Time) bool {
+	return h.Running && (h.Program != ProgramPtp4l || h.PortState == "SLAVE") && h.HaveSample && h.Last.ServoState >= ServoLocked &&
+		now.Sub(h.Last.Time) < sampleTimeout
+}
+
+// Supervisor запускает программу задания, перезапускает её после выхода (пауза растёт
+// от restartMin до restartMax) и разбирает вывод: строки servo и смены состояния порта.
+// В лог выводятся смены состояния порта и grandmaster и выходы процесса (с последней строкой
+// вывода другого вида); строки servo — только в Health.
+type Supervisor struct {
+	job  Job
+	path string
+	args []string
+
+	mu     sync.Mutex
+	health Health
+	output string // последняя строка вывода, не разобранная line
+}
+
+// NewSupervisor создаёт наблюдатель задания; процесс запускает Run. Вывод в stdout (-m)
+// добавляется к аргументам, если его нет: без него состояние не узнать. С Job.Config
+// аргументы — -f <конфиг> и Job.Args.
+func NewSupervisor(j Job) *Supervisor {
+	if j.Program == "" {
+		j.Program = ProgramPtp4l
+	}
+	path := j.Path
+	if path == "" {
+		path = j.Program
+	}
+	args := make([]string, 0, 6+len(j.Args))
+	switch {
+	case j.Config != nil:
+		args = append(args, "-f", j.ConfigPath())
+		args = append(args, j.Args...)
+		if !hasArg(j.Args, "-m") {
+			args = append(args, "-m")
+		}
+	case len(j.Args) == 0:
+		// Стандартные аргументы, если не заданы: slave + вывод в stdout
+		args = append(args, "-i", j.Interface, "-d", strconv.Itoa(j.Domain), "-m", "-s")
+	default:
+		args = append(args, "-i", j.Interface, "-d", strconv.Itoa(j.Domain))
+		args = append(args, j.Args...)
+		if !hasArg(j.Args, "-m") {
+			args = append(args, "-m")
+		}
+	}
+	return &Supervisor{job: j, path: path, args: args, health: Health{Program: j.Program, Interface: j.Interface}}
+}
+
+func hasArg(args []string, a string) bool {
+	for _, s := range args {
+		if s == a {
+			return true
+		}
+	}
+	return false
+}
+
+// Health возвращает состояние процесса и последние разобранные строки
+func (s *Supervisor) Health() Health {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.health
+}
+
+// Run запускает и перезапускает программу до отмены ctx; при отмене процесс получает SIGTERM
+// и через stopTimeout — SIGKILL. Возвращается после выхода процесса.
+func (s *Supervisor) Run(ctx context.Context) {
+	backoff := restartMin
+	for {
+		started := time.Now()
+		err := s.runOnce(ctx)
+		if ctx.Err() != nil {
+			s.exited(err, false)
+			return
+		}
+		if time.Since(started) >= stableRun {
+			backoff = restartMin
+		}
+		s.exited(err, true)
+		if out := s.lastOutput(); out != "" {
+			logger.Error("%s %s exited: %v (last output: %s), restart in %v", s.job.Program, s.job.Interface, exitReason(err), out, backoff)
+		} else {
+			logger.Error("%s %s exited: %v, restart in %v", s.job.Program, s.job.Interface, exitReason(err), backoff)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > restartMax {
+			backoff = restartMax
+		}
+	}
+}
+
+// runOnce записывает конфиг, запускает процесс и читает его вывод до выхода
+func (s *Supervisor) runOnce(ctx context.Context) error {
+	if err := s.writeConfig(); err != nil {
+		return err
+	}
+	cmd := exec.CommandContext(ctx, s.path, s.args...)
+	cmd.Cancel = func() error { return cmd.Process.Signal(syscall.SIGTERM) }
+	cmd.WaitDelay = stopTimeout
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	cmd.Stderr = cmd.Stdout
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.health.Running, s.health.PID, s.health.Started = true, cmd.Process.Pid, time.Now()
+	s.health.PortState, s.health.Grandmaster, s.health.HaveSample = "", "", false
+	s.output = ""
+	s.mu.Unlock()
+	logger.Info("%s started: %s %s (pid %d)", s.job.Program, s.path, strings.Join(s.args, " "), cmd.Process.Pid)
+	sc := bufio.NewScanner(out)
+	for sc.Scan() {
+		s.line(sc.Text(), time.Now())
+	}
+	return cmd.Wait()
+}
+
+// writeConfig записывает Job.Config в Job.ConfigPath (перед каждым запуском: каталог в /run
+// очищается при перезагрузке, файл могли удалить)
+func (s *Supervisor) writeConfig() error {
+	if s.job.Config == nil {
+		return nil
+	}
+	path := s.job.ConfigPath()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, []byte(s.job.Config.String()), 0o644)
+}
+
+// exited отмечает выход процесса; restart — будет перезапущен
+func (s *Supervisor) exited(err error, restart bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.health.Running, s.health.PID = false, 0
+	s.health.LastExit = exitReason(err)
+	if restart {
+		s.health.Restarts++
+	}
+}
+
+func exitReason(err error) string {
+	if err == nil {
+		return "exit status 0"
+	}
+	return err.Error()
+}
+
+func (s *Supervisor) lastOutput() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.output
+}
+
+// line разбирает строку вывода; в лог — смены состояния порта и grandmaster
+func (s *Supervisor) line(text string, now time.Time) {
+	if smp, ok := ParseSample(text); ok {
+		smp.Time = now
+		s.mu.Lock()
+		s.health.Last, s.health.HaveSample = smp, true
+		s.mu.Unlock()
+		return
+	}
+	if ev, ok := ParsePortEvent(text); ok {
+		s.mu.Lock()
+		s.health.PortState = ev.To
+		s.mu.Unlock()
+		logger.Info("%s %s: port %d %s -> %s on %s", s.job.Program, s.job.Interface, ev.Port, ev.From, ev.To, ev.Event)
+		return
+	}
+	if m := reBest.FindStringSubmatch(text); m != nil {
+		s.mu.Lock()
+		changed := s.health.Grandmaster != m[1]
+		s.health.Grandmaster = m[1]
+		s.mu.Unlock()
+		if changed {
+			logger.Info("%s %s: grandmaster %s", s.job.Program, s.job.Interface, m[1])
+		}
+		return
+	}
+	s.mu.Lock()
+	s.output = text
+	s.mu.Unlock()
+}
+
+// String — краткое состояние для логов: «SLAVE s2 offset 12ns, restarts 0»
+func (h Health) String() string {
+	if !h.Running {
+		return fmt.Sprintf("not running (%s), restarts %d", h.LastExit, h.Restarts)
+	}
+	state := h.PortState
+	if state == "" {
+		state = "-"
+	}
+	if !h.HaveSample {
+		return fmt.Sprintf("%s, restarts %d", state, h.Restarts)
+	}
+	return fmt.Sprintf("%s %s offset %v, restarts %d", state, h.Last.ServoState, h.Last.Offset, h.Restarts)
+}
@@ -0,0 +1,178 @@
+package ptp4l
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	for _, tc := range []struct {
+		line string
+		want Sample
+		ok   bool
+	}{
+		{"ptp4l[4711.123]: master offset        -23 s2 freq   +1234 path delay       567", Sample{Offset: -23, ServoState: ServoLocked, Freq: 1234, PathDelay: 567}, true},
+		{"ptp4l[10.0]: [eth0] master offset 1500000 s0 freq -42 path delay 0", Sample{Offset: 1500000, ServoState: ServoUnlocked, Freq: -42}, true},
+		{"ptp4l[12.5]: rms   12 max   25 freq  -1234 +/-  10 delay   567 +/-   2", Sample{Offset: 12, ServoState: ServoLocked, Freq: -1234, PathDelay: 567}, true},
+		{"ptp4l[12.5]: rms    3 max    5 freq   +100 +/-   1", Sample{Offset: 3, ServoState: ServoLocked, Freq: 100}, true},
+		{"phc2sys[20.1]: CLOCK_REALTIME phc offset        -8 s2 freq   -5020 delay    511", Sample{Offset: -8, ServoState: ServoLocked, Freq: -5020, PathDelay: 511}, true},
+		{"ts2phc[30.2]: eth5 offset          3 s2 freq      +7", Sample{Offset: 3, ServoState: ServoLocked, Freq: 7}, true},
+		{"ptp4l[1.0]: selected /dev/ptp0 as PTP clock", Sample{}, false},
+	} {
+		got, ok := ParseSample(tc.line)
+		if ok != tc.ok || got != tc.want {
+			t.Errorf("%q: %+v %v", tc.line, got, ok)
+		}
+	}
+
+	ev, ok := ParsePortEvent("ptp4l[5.1]: port 1 (eth0): UNCALIBRATED to SLAVE on MASTER_CLOCK_SELECTED")
+	if want := (PortEvent{Port: 1, Interface: "eth0", From: "UNCALIBRATED", To: "SLAVE", Event: "MASTER_CLOCK_SELECTED"}); !ok || ev != want {
+		t.Errorf("port event %+v", ev)
+	}
+	if ev, ok := ParsePortEvent("ptp4l[2.0]: port 1: LISTENING to MASTER on ANNOUNCE_RECEIPT_TIMEOUT_EXPIRES"); !ok || ev.To != "MASTER" || ev.Interface != "" {
+		t.Errorf("old port event %+v", ev)
+	}
+}
+
+// В лог — смены состояния порта и grandmaster; строки servo и прочие — нет
+func TestSupervisor_LineLog(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+	s := NewSupervisor(Job{Interface: "eth0"})
+	now := time.Now()
+	for _, l := range []string{
+		"ptp4l[1.0]: selected /dev/ptp0 as PTP clock",
+		"ptp4l[2.0]: port 1 (eth0): LISTENING to UNCALIBRATED on RS_SLAVE",
+		"ptp4l[2.0]: selected best master clock 001122.fffe.334455",
+		"ptp4l[3.0]: master offset        -23 s2 freq   +1234 path delay       567",
+		"ptp4l[4.0]: selected best master clock 001122.fffe.334455",
+		"ptp4l[4.0]: master offset          5 s2 freq   +1230 path delay       567",
+	} {
+		s.line(l, now)
+	}
+	out := buf.String()
+	if n := strings.Count(out, "\n"); n != 2 {
+		t.Errorf("%d log lines, want 2:\n%s", n, out)
+	}
+	for _, want := range []string{"port 1 LISTENING -> UNCALIBRATED on RS_SLAVE", "grandmaster 001122.fffe.334455"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("log without %q:\n%s", want, out)
+		}
+	}
+	if h := s.Health(); !h.HaveSample || h.Last.Offset != 5 || s.lastOutput() != "ptp4l[1.0]: selected /dev/ptp0 as PTP clock" {
+		t.Errorf("health %+v, last output %q", h, s.lastOutput())
+	}
+}
+
+func TestSupervisor_Args(t *testing.T) {
+	s := NewSupervisor(Job{Interface: "eth0", Domain: 24, Args: []string{"-f", "/etc/ptp4l.conf"}})
+	if want := []string{"-i", "eth0", "-d", "24", "-f", "/etc/ptp4l.conf", "-m"}; s.path != "ptp4l" || !reflect.DeepEqual(s.args, want) {
+		t.Errorf("%s %v", s.path, s.args)
+	}
+	if s := NewSupervisor(Job{Interface: "eth0"}); !reflect.DeepEqual(s.args, []string{"-i", "eth0", "-d", "0", "-m", "-s"}) {
+		t.Errorf("default args %v", s.args)
+	}
+
+	// Конфиг из записи: -f вместо -i/-d, файл пишется перед запуском
+	dir := t.TempDir()
+	conf, args, err := RenderTs2phc(Ts2phcOptions{Interface: "eth5"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s = NewSupervisor(Job{Program: ProgramTs2phc, Interface: "eth5", Args: args, Config: conf, ConfigDir: dir})
+	path := filepath.Join(dir, "ts2phc-eth5.conf")
+	if want := []string{"-f", path, "-s", "generic", "-m"}; s.path != "ts2phc" || !reflect.DeepEqual(s.args, want) {
+		t.Errorf("config args %s %v", s.path, s.args)
+	}
+	if err := s.writeConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := os.ReadFile(path); err != nil || string(b) != conf.String() {
+		t.Errorf("config file %q, %v", b, err)
+	}
+	if h := s.Health(); h.Program != ProgramTs2phc || (Job{Interface: "eth5"}).Key() != "ptp4l eth5" {
+		t.Errorf("health %+v", h)
+	}
+}
+
+// fakePtp4lScript: первый запуск синхронизируется и падает, второй — синхронизируется и работает до SIGTERM
+const fakePtp4lScript = `#!/bin/sh
+d=$(dirname "$0")
+echo "ptp4l[1.0]: port 1 (eth9): LISTENING to UNCALIBRATED on RS_SLAVE"
+echo "ptp4l[1.1]: selected best master clock 001122.fffe.334455"
+echo "ptp4l[1.2]: port 1 (eth9): UNCALIBRATED to SLAVE on MASTER_CLOCK_SELECTED"
+echo "ptp4l[1.3]: master offset -23 s2 freq +1234 path delay 567"
+if [ ! -e "$d/ran" ]; then
+	touch "$d/ran"
+	sleep 0.3
+	exit 3
+fi
+trap 'echo "ptp4l: terminated" > "$d/term"; exit 0' TERM
+while true; do
+	echo "ptp4l[2.0]: master offset 5 s2 freq +1230 path delay 560"
+	sleep 0.1
+done
+`
+
+func TestSupervisor_Restart(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "ptp4l")
+	if err := os.WriteFile(path, []byte(fakePtp4lScript), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	s := NewSupervisor(Job{Interface: "eth9", Path: path})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.Run(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	wait := func(what string, ok func(Health) bool) Health {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			h := s.Health()
+			if ok(h) {
+				return h
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("%s: %+v", what, h)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	h := wait("locked", func(h Health) bool { return h.Locked(time.Now()) })
+	if h.Grandmaster != "001122.fffe.334455" || h.Last.Offset != -23 || h.Last.PathDelay != 567 || h.PID == 0 {
+		t.Errorf("health %+v", h)
+	}
+	h = wait("exited", func(h Health) bool { return !h.Running })
+	if h.Restarts != 1 || h.LastExit != "exit status 3" || h.Locked(time.Now()) {
+		t.Errorf("after exit %+v", h)
+	}
+	h = wait("restarted", func(h Health) bool { return h.Running && h.Locked(time.Now()) && h.Last.Offset == 5 })
+	if h.Restarts != 1 {
+		t.Errorf("restarts %d", h.Restarts)
+	}
+	// Отмена: SIGTERM и ожидание выхода, без перезапуска
+	cancel()
+	<-done
+	if _, err := os.Stat(filepath.Join(dir, "term")); err != nil {
+		t.Errorf("ptp4l not terminated with SIGTERM: %v", err)
+	}
+	if h := s.Health(); h.Running || h.Restarts != 1 {
+		t.Errorf("after stop %+v", h)
+	}
+}
@@ -0,0 +1,442 @@
+// Package ptpsim — встроенный симулятор мастеров PTPv2 для тестов и демонстраций: один или несколько
+// grandmaster (ptp.Master, E2E two-step) на loopback или сетевом интерфейсе (veth) с искажениями —
+// сдвиг и дрейф времени мастера, асимметрия задержки, потеря и перестановка сообщений, отказ мастера.
+// Клиент PTP, выбор источника и servo проверяются с ним от начала до конца без оборудования.
+//
+// Искажения времени вносятся подменой меток, а не задержкой передачи: preciseOriginTimestamp
+// Follow_Up (t1) сдвигается на offset − DelayToSlave, receiveTimestamp Delay_Resp (t4) — на
+// offset + DelayFromSlave. Slave при этом видит offsetFromMaster ≈ −offset +
+// (DelayToSlave − DelayFromSlave) / 2 и meanPathDelay, больший на (DelayToSlave + DelayFromSlave) / 2.
+package ptpsim
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/shiwa/timecard-mini/tc-sync/internal/ptp"
+	"github.com/shiwa/timecard-mini/tc-sync/internal/timestamping"
+)
+
+// Impairments — искажения, вносимые симулятором во все сообщения мастеров
+type Impairments struct {
+	// Offset — сдвиг времени мастеров относительно системных часов; Drift — его изменение,
+	// ppb (нс в секунду), отсчитываемое от последнего SetImpairments
+	Offset time.Duration
+	Drift  float64
+	// DelayToSlave, DelayFromSlave — дополнительная задержка направлений мастер → slave
+	// и slave → мастер; разность — асимметрия пути
+	DelayToSlave   time.Duration
+	DelayFromSlave time.Duration
+	// Loss — вероятность потери отправляемого сообщения; Reorder — вероятность задержать
+	// сообщение и отправить его после следующего (0..1)
+	Loss    float64
+	Reorder float64
+}
+
+// Validate проверяет вероятности
+func (imp Impairments) Validate() error {
+	if imp.Loss < 0 || imp.Loss > 1 {
+		return fmt.Errorf("ptpsim: loss %v out of range 0..1", imp.Loss)
+	}
+	if imp.Reorder < 0 || imp.Reorder > 1 {
+		return fmt.Errorf("ptpsim: reorder %v out of range 0..1", imp.Reorder)
+	}
+	return nil
+}
+
+// MasterConfig — мастер симулятора
+type MasterConfig struct {
+	Priority1  uint8 // 0 — 128
+	Priority2  uint8 // 0 — 128
+	ClockClass uint8 // 0 — 6 (синхронизирован с первичным эталоном)
+	// Address — loopback: локальный адрес мастера; пусто — 127.0.0.2, 127.0.0.3, … по номеру
+	Address string
+}
+
+// Config — параметры симулятора
+type Config struct {
+	Domain uint8
+	// Interface — сетевой интерфейс (например, конец veth): мастера делят транспорт ptp.OpenTransport
+	// с multicast. Пусто — loopback: у каждого мастера свой адрес, multicast заменяется отправкой
+	// на Targets.
+	Interface string
+	Transport string // с Interface: udp (по умолчанию), udp6 или l2
+	// EventPort, GeneralPort — loopback: порты мастеров и slave; 0 — 319 и 320
+	EventPort   int
+	GeneralPort int
+	// Targets — loopback: адреса slave для multicast сообщений; пусто — 127.0.0.1
+	Targets []net.IP
+	// Интервалы в log2 секунд (0 — 1 с)
+	LogAnnounceInterval    int8
+	LogSyncInterval        int8
+	LogMinDelayReqInterval int8
+	// Masters — мастера; пусто — один мастер по умолчанию
+	Masters     []MasterConfig
+	Impairments Impairments
+	// FailoverAfter — через сколько после запуска отказывает первый мастер; 0 — не отказывает
+	FailoverAfter time.Duration
+	// Seed — генератор потерь и перестановок; 0 — по текущему времени
+	Seed int64
+}
+
+// Stats — счётчики отправки мастера (или всех мастеров)
+type Stats struct {
+	Sent      uint64 // отправлено в сеть
+	Lost      uint64 // потеряно (Loss и отказ мастера)
+	Reordered uint64 // отправлено после следующего сообщения
+}
+
+func (s *Stats) add(o Stats) {
+	s.Sent += o.Sent
+	s.Lost += o.Lost
+	s.Reordered += o.Reordered
+}
+
+// MasterStatus — состояние мастера симулятора
+type MasterStatus struct {
+	Identity ptp.PortIdentity
+	Failed   bool
+	Stats    Stats
+	Port     ptp.PortStats
+}
+
+// Simulator — запущенные мастера с общими искажениями
+type Simulator struct {
+	cfg     Config
+	masters []*simMaster
+
+	mu    sync.Mutex
+	imp   Impairments
+	since time.Time // начало отсчёта Drift
+	rnd   *rand.Rand
+}
+
+type simMaster struct {
+	master *ptp.Master
+	tr     *simTransport
+	raw    ptp.Transport
+}
+
+// New открывает транспорты и создаёт мастера; Close освобождает транспорты
+func New(cfg Config) (*Simulator, error) {
+	if err := cfg.Impairments.Validate(); err != nil {
+		return nil, err
+	}
+	if len(cfg.Masters) == 0 {
+		cfg.Masters = []MasterConfig{{}}
+	}
+	if len(cfg.Masters) > 253 {
+		return nil, errors.New("ptpsim: too many masters")
+	}
+	seed := cfg.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	s := &Simulator{cfg: cfg, imp: cfg.Impairments, since: time.Now(), rnd: rand.New(rand.NewSource(seed))}
+	var targets []net.Addr
+	if cfg.Interface == "" {
+		ips := cfg.Targets
+		if len(ips) == 0 {
+			ips = []net.IP{net.IPv4(127, 0, 0, 1)}
+		}
+		for _, ip := range ips {
+			targets = append(targets, &net.IPAddr{IP: ip})
+		}
+	}
+	for i, mc := range cfg.Masters {
+		raw, err := s.open(i, mc)
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+		tr := &simTransport{Transport: raw, sim: s, targets: targets}
+		m := ptp.NewMaster(tr, ptp.MasterConfig{
+			Domain:                 cfg.Domain,
+			Identity:               ptp.PortIdentity{Clock: ClockIdentity(i), Port: 1},
+			Priority1:              orDefault(mc.Priority1, 128),
+			Priority2:              orDefault(mc.Priority2, 128),
+			LogAnnounceInterval:    cfg.LogAnnounceInterval,
+			LogSyncInterval:        cfg.LogSyncInterval,
+			LogMinDelayReqInterval: cfg.LogMinDelayReqInterval,
+			Multicast:              true,
+			Unicast:                true,
+			ServerOnly:             true,
+		})
+		tp := ptp.DefaultTimeProperties()
+		tp.Quality = ptp.ClockQuality{Class: orDefault(mc.ClockClass, ptp.ClockClassPrimary), Accuracy: ptp.AccuracyFor(100 * time.Nanosecond), Variance: 0x4E5D}
+		tp.TimeSource, tp.UTCOffsetValid, tp.TimeTraceable, tp.FrequencyTraceable = ptp.TimeSourceGPS, true, true, true
+		m.SetTimeProperties(tp)
+		s.masters = append(s.masters, &simMaster{master: m, tr: tr, raw: raw})
+	}
+	return s, nil
+}
+
+func orDefault(v, def uint8) uint8 {
+	if v == 0 {
+		return def
+	}
+	return v
+}
+
+// ClockIdentity — clockIdentity мастера i симулятора (02:50:54:ff:fe:00:00:i+1)
+func ClockIdentity(i int) ptp.ClockIdentity {
+	return ptp.ClockIdentity{0x02, 0x50, 0x54, 0xFF, 0xFE, 0x00, 0x00, byte(i + 1)}
+}
+
+// open открывает транспорт мастера i
+func (s *Simulator) open(i int, mc MasterConfig) (ptp.Transport, error) {
+	if s.cfg.Interface != "" {
+		return ptp.OpenTransport(ptp.PortOptions{Transport: s.cfg.Transport, Multicast: true}, s.cfg.Interface)
+	}
+	addr := mc.Address
+	if addr == "" {
+		addr = net.IPv4(127, 0, 0, byte(i+2)).String()
+	}
+	// Привязка к lo (SO_REUSEADDR): порты открываются и рядом с native slave на lo, занявшим 319/320
+	tr, err := ptp.NewUDPTransport(ptp.UDPConfig{Interface: "lo", Address: addr, EventPort: s.cfg.EventPort, GeneralPort: s.cfg.GeneralPort, BindToDevice: true})
+	if err != nil {
+		return nil, fmt.Errorf("ptpsim: master %d on %s: %w", i, addr, err)
+	}
+	return tr, nil
+}
+
+// Run рассылает сообщения всех мастеров до отмены ctx
+func (s *Simulator) Run(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var wg sync.WaitGroup
+	for _, m := range s.masters {
+		wg.Add(1)
+		go func(m *ptp.Master) {
+			defer wg.Done()
+			_ = m.Run(ctx)
+		}(m.master)
+	}
+	if s.cfg.FailoverAfter > 0 {
+		t := time.AfterFunc(s.cfg.FailoverAfter, func() { s.Fail(0) })
+		defer t.Stop()
+	}
+	<-ctx.Done()
+	wg.Wait()
+	return ctx.Err()
+}
+
+// Close закрывает транспорты мастеров
+func (s *Simulator) Close() error {
+	for _, m := range s.masters {
+		m.raw.Close()
+	}
+	return nil
+}
+
+// Len возвращает число мастеров
+func (s *Simulator) Len() int {
+	return len(s.masters)
+}
+
+// PortIdentity возвращает portIdentity мастера i
+func (s *Simulator) PortIdentity(i int) (ptp.PortIdentity, bool) {
+	if i < 0 || i >= len(s.masters) {
+		return ptp.PortIdentity{}, false
+	}
+	return s.masters[i].master.Identity(), true
+}
+
+// Master возвращает порт мастера i (nil — нет такого): состояние, таблица unicast разрешений
+func (s *Simulator) Master(i int) *ptp.Master {
+	if i < 0 || i >= len(s.masters) {
+		return nil
+	}
+	return s.masters[i].master
+}
+
+// Fail останавливает передачу мастера i: его сообщения теряются, slave уходит к следующему
+// по BMCA после announceReceiptTimeout
+func (s *Simulator) Fail(i int) {
+	s.setFailed(i, true)
+}
+
+// Recover возобновляет передачу мастера i
+func (s *Simulator) Recover(i int) {
+	s.setFailed(i, false)
+}
+
+func (s *Simulator) setFailed(i int, failed bool) {
+	if i < 0 || i >= len(s.masters) {
+		return
+	}
+	tr := s.masters[i].tr
+	tr.mu.Lock()
+	tr.failed = failed
+	tr.mu.Unlock()
+}
+
+// Impairments возвращает текущие искажения
+func (s *Simulator) Impairments() Impairments {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.imp
+}
+
+// SetImpairments меняет искажения на ходу; отсчёт Drift начинается заново от imp.Offset
+func (s *Simulator) SetImpairments(imp Impairments) error {
+	if err := imp.Validate(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.imp, s.since = imp, time.Now()
+	s.mu.Unlock()
+	return nil
+}
+
+// Offset возвращает сдвиг времени мастеров в момент now (Offset + Drift от последнего SetImpairments)
+func (s *Simulator) Offset(now time.Time) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.offset(now)
+}
+
+func (s *Simulator) offset(now time.Time) time.Duration {
+	return s.imp.Offset + time.Duration(float64(now.Sub(s.since))*s.imp.Drift/1e9)
+}
+
+// Masters возвращает состояние мастеров по номерам
+func (s *Simulator) Masters() []MasterStatus {
+	out := make([]MasterStatus, len(s.masters))
+	for i, m := range s.masters {
+		m.tr.mu.Lock()
+		out[i] = MasterStatus{Identity: m.master.Identity(), Failed: m.tr.failed, Stats: m.tr.stats}
+		m.tr.mu.Unlock()
+		out[i].Port = m.master.Stats()
+	}
+	return out
+}
+
+// Stats возвращает счётчики всех мастеров
+func (s *Simulator) Stats() Stats {
+	var total Stats
+	for _, m := range s.Masters() {
+		total.add(m.Stats)
+	}
+	return total
+}
+
+// distort подменяет метки времени сообщения мастера по текущим искажениям
+func (s *Simulator) distort(b []byte, now time.Time) []byte {
+	msg, err := ptp.Unmarshal(b)
+	if err != nil {
+		return b
+	}
+	s.mu.Lock()
+	off := s.offset(now)
+	toSlave, fromSlave := s.imp.DelayToSlave, s.imp.DelayFromSlave
+	s.mu.Unlock()
+	switch msg.Type {
+	case ptp.MsgSync, ptp.MsgFollowUp:
+		off -= toSlave
+	case ptp.MsgDelayResp:
+		off += fromSlave
+	case ptp.MsgAnnounce:
+	default:
+		return b
+	}
+	if off == 0 || msg.Timestamp.IsZero() {
+		return b
+	}
+	msg.Timestamp = ptp.NewTimestamp(msg.Timestamp.Time().Add(off))
+	return msg.Marshal()
+}
+
+// roll возвращает true с вероятностью p
+func (s *Simulator) roll(p float64) bool {
+	if p <= 0 {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rnd.Float64() < p
+}
+
+// heldMessage — сообщение, задержанное до следующей отправки (Reorder)
+type heldMessage struct {
+	b     []byte
+	dst   net.Addr
+	event bool
+}
+
+// simTransport вносит искажения в отправку мастера; на loopback multicast отправляется на targets
+type simTransport struct {
+	ptp.Transport
+	sim     *Simulator
+	targets []net.Addr
+
+	mu     sync.Mutex
+	failed bool
+	held   *heldMessage
+	stats  Stats
+}
+
+func (t *simTransport) SendEvent(b []byte, dst net.Addr) (timestamping.Stamp, error) {
+	return t.send(b, dst, true)
+}
+
+func (t *simTransport) SendGeneral(b []byte, dst net.Addr) error {
+	_, err := t.send(b, dst, false)
+	return err
+}
+
+// send отправляет сообщение с искажениями. Для потерянного или задержанного event сообщения
+// возвращается программная метка момента вызова: мастер отправит Follow_Up как обычно.
+func (t *simTransport) send(b []byte, dst net.Addr, event bool) (timestamping.Stamp, error) {
+	now := time.Now()
+	b = t.sim.distort(b, now)
+	lost := t.sim.roll(t.sim.Impairments().Loss)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.failed || lost {
+		t.stats.Lost++
+		return timestamping.Stamp{Time: now, Type: timestamping.Software}, nil
+	}
+	if t.held == nil && t.sim.roll(t.sim.Impairments().Reorder) {
+		t.held = &heldMessage{b: b, dst: dst, event: event}
+		t.stats.Reordered++
+		return timestamping.Stamp{Time: now, Type: timestamping.Software}, nil
+	}
+	st, err := t.write(b, dst, event)
+	if h := t.held; h != nil {
+		t.held = nil
+		_, _ = t.write(h.b, h.dst, h.event)
+	}
+	return st, err
+}
+
+// write отправляет сообщение в сеть: multicast на loopback — каждому адресу targets
+func (t *simTransport) write(b []byte, dst net.Addr, event bool) (timestamping.Stamp, error) {
+	dsts := []net.Addr{dst}
+	if dst == nil && t.targets != nil {
+		dsts = t.targets
+	}
+	var first timestamping.Stamp
+	var firstErr error
+	for i, d := range dsts {
+		var st timestamping.Stamp
+		var err error
+		if event {
+			st, err = t.Transport.SendEvent(b, d)
+		} else {
+			err = t.Transport.SendGeneral(b, d)
+		}
+		if err == nil {
+			t.stats.Sent++
+		}
+		if i == 0 {
+			first, firstErr = st, err
+		}
+	}
+	return first, firstErr
+}
@@ -0,0 +1,142 @@
+package ptpsim
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/shiwa/timecard-mini/tc-sync/internal/ptp"
+)
+
+// loopback запускает симулятор на 127.0.0.2, … и slave на 127.0.0.1 с одной парой портов;
+// slave принимает Announce от всех мастеров и отправляет им unicast Delay_Req
+func loopback(t *testing.T, cfg Config) (*Simulator, *ptp.Slave) {
+	t.Helper()
+	cfg.LogAnnounceInterval, cfg.LogSyncInterval, cfg.LogMinDelayReqInterval = -3, -4, -4
+	for i := 0; i < 20; i++ {
+		probe, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		port := probe.LocalAddr().(*net.UDPAddr).Port
+		probe.Close()
+		if port+1 > 65535 {
+			continue
+		}
+		cfg.EventPort, cfg.GeneralPort = port, port+1
+		str, err := ptp.NewUDPTransport(ptp.UDPConfig{Address: "127.0.0.1", EventPort: port, GeneralPort: port + 1})
+		if err != nil {
+			continue
+		}
+		sim, err := New(cfg)
+		if err != nil {
+			str.Close()
+			continue
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		t.Cleanup(func() {
+			cancel()
+			sim.Close()
+			str.Close()
+		})
+		var masters []net.IP
+		for j := 0; j < sim.Len(); j++ {
+			masters = append(masters, net.IPv4(127, 0, 0, byte(j+2)))
+		}
+		s := ptp.NewSlave(str, ptp.SlaveConfig{Domain: cfg.Domain, Masters: masters})
+		go sim.Run(ctx)
+		go s.Run(ctx)
+		return sim, s
+	}
+	t.Fatal("no free port pair")
+	return nil, nil
+}
+
+// waitMeasurement ждёт n новых измерений slave, удовлетворяющих ok
+func waitMeasurement(t *testing.T, s *ptp.Slave, n int, ok func(ptp.Measurement) bool) ptp.Measurement {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	var last ptp.Measurement
+	seen := 0
+	for time.Now().Before(deadline) {
+		if m, have := s.Last(); have && m.Time != last.Time {
+			last = m
+			if ok == nil || ok(m) {
+				if seen++; seen >= n {
+					return last
+				}
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("no measurement, state %s, last %+v", s.State(), last)
+	return last
+}
+
+func near(d, want, tol time.Duration) bool {
+	return d >= want-tol && d <= want+tol
+}
+
+func TestSimulator_OffsetAndAsymmetry(t *testing.T) {
+	sim, s := loopback(t, Config{Domain: 5, Impairments: Impairments{Offset: 5 * time.Millisecond, DelayToSlave: 2 * time.Millisecond}})
+	m := waitMeasurement(t, s, 3, nil)
+	// offsetFromMaster = −5 мс + (2 мс − 0) / 2, meanPathDelay = 1 мс + задержка loopback
+	if !near(m.OffsetFromMaster, -4*time.Millisecond, 300*time.Microsecond) {
+		t.Errorf("offsetFromMaster %v, want -4ms", m.OffsetFromMaster)
+	}
+	if !near(m.MeanPathDelay, time.Millisecond, 300*time.Microsecond) {
+		t.Errorf("meanPathDelay %v, want 1ms", m.MeanPathDelay)
+	}
+	if id, _ := sim.PortIdentity(0); m.Master != id || m.Quality.Class != ptp.ClockClassPrimary {
+		t.Errorf("master %s class %d", m.Master, m.Quality.Class)
+	}
+
+	// Дрейф 1 мс/с без асимметрии: ждём, пока медианный фильтр задержки забудет прежнюю
+	if err := sim.SetImpairments(Impairments{Drift: 1e6}); err != nil {
+		t.Fatal(err)
+	}
+	start := waitMeasurement(t, s, 2, func(m ptp.Measurement) bool { return m.MeanPathDelay < 100*time.Microsecond })
+	time.Sleep(500 * time.Millisecond)
+	end := waitMeasurement(t, s, 1, nil)
+	want := -end.Time.Sub(start.Time)
+	if got := end.OffsetFromMaster - start.OffsetFromMaster; !near(got, want/1000, 300*time.Microsecond) {
+		t.Errorf("drift: offset changed by %v over %v", got, end.Time.Sub(start.Time))
+	}
+
+	if err := sim.SetImpairments(Impairments{Loss: 2}); err == nil {
+		t.Error("loss 2 accepted")
+	}
+}
+
+func TestSimulator_Failover(t *testing.T) {
+	sim, s := loopback(t, Config{Masters: []MasterConfig{{Priority1: 100}, {Priority1: 200}}})
+	first, _ := sim.PortIdentity(0)
+	second, _ := sim.PortIdentity(1)
+	waitMeasurement(t, s, 2, func(m ptp.Measurement) bool { return m.Master == first })
+
+	sim.Fail(0)
+	waitMeasurement(t, s, 2, func(m ptp.Measurement) bool { return m.Master == second })
+	st := sim.Masters()
+	if !st[0].Failed || st[0].Stats.Lost == 0 || st[1].Failed {
+		t.Errorf("masters %+v", st)
+	}
+
+	sim.Recover(0)
+	waitMeasurement(t, s, 2, func(m ptp.Measurement) bool { return m.Master == first })
+}
+
+func TestSimulator_LossAndReorder(t *testing.T) {
+	sim, s := loopback(t, Config{Seed: 1, Impairments: Impairments{Loss: 0.1, Reorder: 0.3}})
+	m := waitMeasurement(t, s, 5, nil)
+	if !near(m.OffsetFromMaster, 0, time.Millisecond) {
+		t.Errorf("offsetFromMaster %v", m.OffsetFromMaster)
+	}
+	st := sim.Stats()
+	if st.Sent == 0 || st.Lost == 0 || st.Reordered == 0 {
+		t.Errorf("stats %+v", st)
+	}
+	if p := sim.Masters()[0].Port; p.In[ptp.MsgDelayReq] == 0 {
+		t.Errorf("port stats %+v", p)
+	}
+}
@@ -0,0 +1,118 @@
+package servo
+
+import (
+	"sync"
+	"time"
+)
+
+// SyncState — состояние синхронизации часов
+type SyncState int
+
+const (
+	StateFreerun  SyncState = iota // не синхронизированы (или удержание дольше предела категорий)
+	StateLocked                    // синхронизированы с источником
+	StateHoldover                  // источник потерян, удержание в пределах holdover_limit
+	StateDegraded                  // удержание вне спецификации, категория 1–3 (G.8275.1)
+)
+
+func (s SyncState) String() string {
+	switch s {
+	case StateLocked:
+		return "locked"
+	case StateHoldover:
+		return "holdover"
+	case StateDegraded:
+		return "degraded"
+	}
+	return "freerun"
+}
+
+// Quality — качество часов по состоянию servo и активного источника: поля dataset PTP (clockClass,
+// clockAccuracy, timeSource, offsetScaledLogVariance) и эталон для NTP
+type Quality struct {
+	State    SyncState
+	Category int // категория удержания вне спецификации (StateDegraded): 1–3
+
+	Class      uint8  // clockClass по IEEE 1588 / G.8275.1: 6, 7, 140/150/160, 248
+	Accuracy   uint8  // clockAccuracy по сдвигу, джиттеру и дисперсии
+	TimeSource uint8  // timeSource активного источника
+	Variance   uint16 // offsetScaledLogVariance по девиации Аллана; 0xFFFF — неизвестна
+
+	Offset         time.Duration // последний сдвиг, поданный в servo
+	Jitter         time.Duration // СКО сдвига по окну Stability
+	AllanDeviation float64       // σy(τ) при τ — интервале измерений; 0 — мало измерений
+
+	// Эталон: stratum и refid активного источника, задержка и дисперсия до первичного эталона,
+	// предстоящая секунда координации (+1/-1)
+	Stratum        uint8
+	ReferenceID    uint32
+	RootDelay      time.Duration
+	RootDispersion time.Duration
+	Leap           int
+
+	ReferenceTime time.Time // последняя коррекция часов по источнику
+	Time          time.Time // момент оценки
+}
+
+// DefaultQuality — несинхронизированные часы: clockClass 248, точность и вариация неизвестны,
+// внутренний генератор
+func DefaultQuality() Quality {
+	return Quality{Class: 248, Accuracy: 0xFE, TimeSource: 0xA0, Variance: 0xFFFF, Stratum: 16}
+}
+
+// ClockQuality хранит текущее качество часов и рассылает его подписчикам (серверам PTP и NTP)
+type ClockQuality struct {
+	mu   sync.Mutex
+	q    Quality
+	subs []chan Quality
+}
+
+// NewClockQuality создаёт ClockQuality с DefaultQuality
+func NewClockQuality() *ClockQuality {
+	return &ClockQuality{q: DefaultQuality()}
+}
+
+// UpdateClockQuality сохраняет качество и передаёт его подписчикам; подписчик, не успевший
+// прочитать предыдущее значение, получает только последнее
+func (c *ClockQuality) UpdateClockQuality(q Quality) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.q = q
+	for _, ch := range c.subs {
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- q
+	}
+}
+
+// Get возвращает текущее качество
+func (c *ClockQuality) Get() Quality {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.q
+}
+
+// Subscribe возвращает канал обновлений; текущее значение доступно в нём сразу
+func (c *ClockQuality) Subscribe() <-chan Quality {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ch := make(chan Quality, 1)
+	ch <- c.q
+	c.subs = append(c.subs, ch)
+	return ch
+}
+
+// Unsubscribe отменяет подписку и закрывает канал
+func (c *ClockQuality) Unsubscribe(ch <-chan Quality) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, sub := range c.subs {
+		if sub == ch {
+			c.subs = append(c.subs[:i], c.subs[i+1:]...)
+			close(sub)
+			return
+		}
+	}
+}
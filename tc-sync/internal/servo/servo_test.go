@@ -1,6 +1,7 @@
 package servo
 
 import (
+	"math"
 	"testing"
 	"time"
 )
@@ -99,3 +100,54 @@ func TestLinReg_Update(t *testing.T) {
 		t.Errorf("LinReg после Reset: ожидали 0, получили %v", out2)
 	}
 }
+
+func TestStability(t *testing.T) {
+	var s Stability
+	if _, _, ok := s.AllanVariance(); ok || s.Jitter() != 0 {
+		t.Error("пустое окно: ожидали отсутствие оценки")
+	}
+	now := time.Now()
+	// Сдвиг ±100 нс через 1 с: джиттер 100 нс, вторые разности 400 нс
+	for i := 0; i < 10; i++ {
+		off := 100 * time.Nanosecond
+		if i%2 == 1 {
+			off = -off
+		}
+		s.Add(now.Add(time.Duration(i)*time.Second), off)
+	}
+	if j := s.Jitter(); j < 99*time.Nanosecond || j > 101*time.Nanosecond {
+		t.Errorf("Jitter = %v, ожидали 100ns", j)
+	}
+	avar, tau, ok := s.AllanVariance()
+	if !ok || tau != time.Second || math.Abs(avar-8e-14)/8e-14 > 1e-6 {
+		t.Errorf("AllanVariance = %v, %v, %v; ожидали 8e-14 при τ = 1s", avar, tau, ok)
+	}
+
+	// Постоянный уход частоты (линейный сдвиг) вариацию Аллана по фазе не меняет
+	s.Reset()
+	for i := 0; i < stabilityWindow+10; i++ {
+		s.Add(now.Add(time.Duration(i)*time.Second), time.Duration(i)*10*time.Nanosecond)
+	}
+	if avar, _, _ := s.AllanVariance(); avar > 1e-30 {
+		t.Errorf("AllanVariance при линейном сдвиге = %v", avar)
+	}
+}
+
+func TestClockQuality(t *testing.T) {
+	cq := NewClockQuality()
+	ch := cq.Subscribe()
+	if q := <-ch; q.Class != 248 || q.State != StateFreerun {
+		t.Errorf("начальное значение %+v", q)
+	}
+	// Подписчик, не читавший канал, получает последнее значение
+	cq.UpdateClockQuality(Quality{State: StateHoldover, Class: 7})
+	cq.UpdateClockQuality(Quality{State: StateLocked, Class: 6})
+	if q := <-ch; q.Class != 6 || q.State != StateLocked || cq.Get().Class != 6 {
+		t.Errorf("обновление %+v", q)
+	}
+	cq.Unsubscribe(ch)
+	cq.UpdateClockQuality(Quality{})
+	if _, ok := <-ch; ok {
+		t.Error("после Unsubscribe канал должен быть закрыт")
+	}
+}
@@ -0,0 +1,80 @@
+package servo
+
+import (
+	"math"
+	"time"
+)
+
+// stabilityWindow — число последних измерений сдвига для оценки джиттера и вариации Аллана
+const stabilityWindow = 64
+
+// Stability оценивает стабильность часов по последним stabilityWindow измерениям сдвига
+// относительно источника
+type Stability struct {
+	t    [stabilityWindow]time.Time
+	x    [stabilityWindow]float64 // сдвиг, с
+	n    int
+	next int
+}
+
+// Add добавляет измерение сдвига offset в момент t
+func (s *Stability) Add(t time.Time, offset time.Duration) {
+	s.t[s.next], s.x[s.next] = t, offset.Seconds()
+	s.next = (s.next + 1) % stabilityWindow
+	if s.n < stabilityWindow {
+		s.n++
+	}
+}
+
+// Reset сбрасывает окно (смена источника, шаг часов)
+func (s *Stability) Reset() {
+	s.n, s.next = 0, 0
+}
+
+func (s *Stability) at(i int) (time.Time, float64) {
+	j := (s.next - s.n + i + stabilityWindow) % stabilityWindow
+	return s.t[j], s.x[j]
+}
+
+// Jitter — среднеквадратичное отклонение сдвига от среднего; 0 — меньше двух измерений
+func (s *Stability) Jitter() time.Duration {
+	if s.n < 2 {
+		return 0
+	}
+	var sum, sum2 float64
+	for i := 0; i < s.n; i++ {
+		_, x := s.at(i)
+		sum += x
+		sum2 += x * x
+	}
+	mean := sum / float64(s.n)
+	v := sum2/float64(s.n) - mean*mean
+	if v < 0 {
+		v = 0
+	}
+	return time.Duration(math.Sqrt(v) * 1e9)
+}
+
+// AllanVariance — вариация Аллана по фазе σ²y(τ) = Σ(x[i+2] − 2x[i+1] + x[i])² / (2(N−2)τ²),
+// τ — средний интервал измерений; ok == false — меньше трёх измерений
+func (s *Stability) AllanVariance() (avar float64, tau time.Duration, ok bool) {
+	if s.n < 3 {
+		return 0, 0, false
+	}
+	first, _ := s.at(0)
+	last, _ := s.at(s.n - 1)
+	tau = last.Sub(first) / time.Duration(s.n-1)
+	if tau <= 0 {
+		return 0, 0, false
+	}
+	var sum float64
+	for i := 0; i+2 < s.n; i++ {
+		_, x0 := s.at(i)
+		_, x1 := s.at(i + 1)
+		_, x2 := s.at(i + 2)
+		d := x2 - 2*x1 + x0
+		sum += d * d
+	}
+	ts := tau.Seconds()
+	return sum / (2 * float64(s.n-2) * ts * ts), tau, true
+}
@@ -5,10 +5,21 @@ import (
 	"time"
 
 	"github.com/shiwa/timecard-mini/tc-sync/internal/config"
+	"github.com/shiwa/timecard-mini/tc-sync/internal/ptp"
 )
 
+// Options — общие параметры источников, которых нет в записи (задаются при запуске из clock_sync)
+type Options struct {
+	// RelaxDelayRequests — ptp_tuning.relax_delay_requests для native slave
+	RelaxDelayRequests bool
+	// Auth — ключи authentication записи, загруженные при запуске; nil — без аутентификации
+	Auth *ptp.SecurityAssociation
+	// Socket — параметры сокетов UDP native slave (ptp_tuning)
+	Socket ptp.SocketOptions
+}
+
 // NewFromClockSource создаёт TimeSource из конфига (аналог Timebeat: primary_clocks / secondary_clocks)
-func NewFromClockSource(c config.ClockSource) (TimeSource, error) {
+func NewFromClockSource(c config.ClockSource, o Options) (TimeSource, error) {
 	if c.Disable {
 		return nil, fmt.Errorf("source disabled")
 	}
@@ -42,8 +53,40 @@ func NewFromClockSource(c config.ClockSource) (TimeSource, error) {
 		if host == "" {
 			return nil, fmt.Errorf("ntp: ip required")
 		}
-		interval := parseDuration(c.PollInterval, 4*time.Second)
-		return NewNTP(host, interval), nil
+		minPoll := parseDuration(c.PollInterval, 4*time.Second)
+		maxPoll := parseDuration(c.MaxPollInterval, 0)
+		if c.NTS && c.KeyID != 0 {
+			return nil, fmt.Errorf("ntp: nts and key_id are mutually exclusive")
+		}
+		n := NewNTP(host, minPoll, maxPoll)
+		if c.NTS {
+			n.EnableNTS(nil)
+		}
+		if c.Interleaved {
+			n.EnableInterleaved()
+		}
+		return n, nil
+	case "ntp_pool":
+		servers := c.Servers
+		if len(servers) == 0 && c.IP != "" {
+			servers = []string{c.IP}
+		}
+		minPoll := parseDuration(c.PollInterval, 4*time.Second)
+		maxPoll := parseDuration(c.MaxPollInterval, 0)
+		if c.NTS && c.KeyID != 0 {
+			return nil, fmt.Errorf("ntp_pool: nts and key_id are mutually exclusive")
+		}
+		p, err := NewNTPPool(servers, minPoll, maxPoll)
+		if err != nil {
+			return nil, err
+		}
+		if c.NTS {
+			p.EnableNTS(nil)
+		}
+		if c.Interleaved {
+			p.EnableInterleaved()
+		}
+		return p, nil
 	case "pps":
 		iface := c.Interface
 		if iface == "" {
@@ -55,8 +98,32 @@ func NewFromClockSource(c config.ClockSource) (TimeSource, error) {
 		if iface == "" {
 			iface = "eth0"
 		}
+		if c.Native {
+			delay := c.DelayMechanism
+			if delay == "" {
+				delay = c.DelayStrategy
+			}
+			return NewNativePTP(NativePTPOptions{
+				Domain:                  c.Domain,
+				Interface:               iface,
+				Transport:               c.Transport,
+				Profile:                 c.Profile,
+				DelayMechanism:          delay,
+				NeighborPropDelayThresh: time.Duration(c.NeighborPropDelayThresh),
+				Masters:                 c.UnicastMasterTable,
+				HybridE2E:               c.HybridE2E,
+				RelaxDelayRequests:      o.RelaxDelayRequests,
+				Auth:                    o.Auth,
+				Socket:                  o.Socket,
+				AnnounceInterval:        c.AnnounceInterval,
+				SyncInterval:            c.SyncInterval,
+				DelayRequestInterval:    c.DelayRequestInterval,
+			})
+		}
 		phcDevice := c.Device // /dev/ptp0 и т.д.; пусто → NewPTP подставит /dev/ptp0
-		return NewPTP(c.Domain, iface, c.UnicastMasterTable, phcDevice), nil
+		p := NewPTP(c.Domain, iface, c.UnicastMasterTable, phcDevice)
+		p.SetPMCSocket(c.Ptp4lSocket)
+		return p, nil
 	default:
 		return nil, fmt.Errorf("unknown protocol: %s", c.Protocol)
 	}
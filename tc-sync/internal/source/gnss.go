@@ -4,12 +4,16 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/shiwa/timecard-mini/tc-sync/internal/ntp"
 	"github.com/shiwa/timecard-mini/tc-sync/internal/ubx"
 )
 
 // NAV-PVT read timeout (u-blox часто шлёт NAV-PVT 1 Hz)
 const gnssReadTimeout = 1500 * time.Millisecond
 
+// gnssLeapPollInterval — как часто запрашивать UBX-NAV-TIMELS (информация о leap second)
+const gnssLeapPollInterval = time.Hour
+
 // GNSS — источник времени по GNSS (UBX/Timecard Mini).
 // GetTime() читает UBX-NAV-PVT с приёмника и возвращает UTC время приёмника при валидном fix.
 type GNSS struct {
@@ -18,6 +22,10 @@ type GNSS struct {
 	baud    int
 	lastNow time.Time
 	lastOk  bool
+
+	leap         ubx.TimeLS // последний NAV-TIMELS
+	leapAt       time.Time  // когда он получен (TimeToLsEvent отсчитывается от этого момента)
+	lastLeapPoll time.Time
 }
 
 // NewGNSS создаёт источник GNSS по последовательному порту
@@ -50,6 +58,10 @@ func (g *GNSS) Protocol() string {
 // Читает UBX-NAV-PVT с порта; при валидном времени (validTime) возвращает UTC приёмника и StatusLocked.
 // Если NAV-PVT не пришёл или время невалидно — возвращает последнее известное время (или time.Now()) и StatusUnlocked.
 func (g *GNSS) GetTime() (time.Time, Status) {
+	if time.Since(g.lastLeapPoll) >= gnssLeapPollInterval {
+		g.lastLeapPoll = time.Now()
+		_ = g.port.WritePacket(ubx.BuildNAVTIMELSPoll())
+	}
 	deadline := time.Now().Add(gnssReadTimeout)
 	for time.Now().Before(deadline) {
 		packet, err := g.port.ReadUBX(gnssReadTimeout / 2)
@@ -59,6 +71,12 @@ func (g *GNSS) GetTime() (time.Time, Status) {
 			}
 			return time.Now().UTC(), StatusUnlocked
 		}
+		if ubx.IsNAVTIMELSPacket(packet) {
+			if ls, ok := ubx.ParseNAVTIMELS(ubx.NAVPVTPayload(packet)); ok {
+				g.leap, g.leapAt = ls, time.Now()
+			}
+			continue
+		}
 		if !ubx.IsNAVPVTPacket(packet) {
 			continue
 		}
@@ -77,6 +95,24 @@ func (g *GNSS) GetTime() (time.Time, Status) {
 	return time.Now().UTC(), StatusUnlocked
 }
 
+// Reference — GNSS: первичный эталон (stratum 1, refid "GPS")
+func (g *GNSS) Reference() (uint8, uint32) {
+	return 1, ntp.RefIDFromString("GPS")
+}
+
+// LeapSecond возвращает предстоящую секунду координации по UBX-NAV-TIMELS:
+// событие в ближайшие 24 часа (конец текущих суток UTC) — lsChange, иначе 0.
+func (g *GNSS) LeapSecond() (int, bool) {
+	if g.leapAt.IsZero() || !g.leap.ValidEvent {
+		return 0, false
+	}
+	left := g.leap.TimeToLsEvent - time.Since(g.leapAt)
+	if left <= 0 || left > 24*time.Hour {
+		return 0, true
+	}
+	return int(g.leap.LsChange), true
+}
+
 // Close закрывает порт
 func (g *GNSS) Close() error {
 	if g.port == nil {
@@ -8,6 +8,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/shiwa/timecard-mini/tc-sync/internal/ntp"
 	"github.com/tarm/serial"
 )
 
@@ -53,6 +54,11 @@ func (n *NMEA) Protocol() string {
 	return "nmea"
 }
 
+// Reference — NMEA: первичный эталон (stratum 1, refid "NMEA")
+func (n *NMEA) Reference() (uint8, uint32) {
+	return 1, ntp.RefIDFromString("NMEA")
+}
+
 // GetTime читает строки NMEA, ищет GPRMC/GNRMC, парсит UTC время и дату; возвращает время + offset.
 func (n *NMEA) GetTime() (time.Time, Status) {
 	deadline := time.Now().Add(nmeaReadTimeout)
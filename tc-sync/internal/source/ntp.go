@@ -1,29 +1,93 @@
 package source
 
 import (
+	"crypto/tls"
+	"errors"
 	"fmt"
-	"net"
+	"sync"
 	"time"
+
+	"github.com/shiwa/timecard-mini/tc-sync/internal/ntp"
 )
 
-// NTP — источник времени по NTP (клиент)
-// Минимальный NTP client (один запрос — время с сервера)
+// ntpQueryTimeout — таймаут одного обмена с NTP сервером
+const ntpQueryTimeout = 2 * time.Second
+
+// NTP — источник времени по NTP (клиент RFC 5905).
+// Опрашивает сервер с адаптивным интервалом (pollinterval..max_pollinterval), вычисляет offset/delay
+// по меткам T1..T4 и пропускает измерения через 8-ступенчатый фильтр часов (minimum delay).
+// Несинхронизированные серверы (leap=3, stratum 0/16) и KoD отклоняются.
+// С nts: true запросы аутентифицируются NTS (см. EnableNTS), с key_id — симметричным ключом (SetKey).
 type NTP struct {
-	host    string
-	timeout time.Duration
+	client *ntp.Client
+	filter *ntp.Filter
+	poller *ntp.Poller
+
+	// qmu — владение client на время обмена; mu — состояние ниже (обмен идёт без mu)
+	qmu      sync.Mutex
+	mu       sync.Mutex
+	stats    ntp.ClientStats // счётчики client после последнего обмена
+	last     ntp.Sample      // последнее отфильтрованное измерение
+	have     bool
+	nextPoll time.Time
+	reach    uint8 // регистр достижимости (RFC 5905): бит 0 — успех последнего опроса
+	denied   bool  // получен KoD DENY/RSTR — сервер больше не опрашиваем
+	lastErr  error
 }
 
-// NewNTP создаёт NTP источник
-func NewNTP(host string, timeout time.Duration) *NTP {
-	if timeout <= 0 {
-		timeout = 5 * time.Second
+// NewNTP создаёт NTP источник.
+// minPoll — начальный и минимальный интервал опроса (pollinterval), maxPoll — максимальный (0 = 16·minPoll).
+func NewNTP(host string, minPoll, maxPoll time.Duration) *NTP {
+	if minPoll <= 0 {
+		minPoll = 4 * time.Second
+	}
+	if maxPoll <= 0 {
+		maxPoll = 16 * minPoll
+	}
+	return &NTP{
+		client: ntp.NewClient(host, ntpQueryTimeout),
+		filter: ntp.NewFilter(),
+		poller: ntp.NewPoller(minPoll, maxPoll),
 	}
-	return &NTP{host: host, timeout: timeout}
+}
+
+// EnableNTS включает NTS (RFC 8915): NTS-KE с тем же хостом (порт 4460), далее только
+// аутентифицированные запросы; измерение принимается лишь при успешной проверке AEAD.
+func (n *NTP) EnableNTS(tlsConf *tls.Config) {
+	n.qmu.Lock()
+	defer n.qmu.Unlock()
+	n.client.NTS = ntp.NewNTSSession(n.client.Host, tlsConf)
+}
+
+// EnableInterleaved включает interleaved режим: T3 — точная метка передачи ответа сервером,
+// полученная в следующем обмене. Если сервер режим не поддерживает, клиент возвращается к basic.
+func (n *NTP) EnableInterleaved() {
+	n.qmu.Lock()
+	defer n.qmu.Unlock()
+	n.client.Interleaved = true
+}
+
+// SetKey задаёт симметричный ключ (key_id из keys файла): запросы подписываются,
+// ответы без верного MAC отклоняются и учитываются в Stats.
+func (n *NTP) SetKey(k *ntp.Key) {
+	n.qmu.Lock()
+	defer n.qmu.Unlock()
+	n.client.Key = k
+}
+
+// Stats возвращает счётчики обменов с сервером (запросы, ответы, ошибки аутентификации)
+func (n *NTP) Stats() ntp.ClientStats {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.stats
 }
 
 // Name возвращает имя источника
 func (n *NTP) Name() string {
-	return fmt.Sprintf("ntp:%s", n.host)
+	if n.client.NTS != nil {
+		return fmt.Sprintf("ntp+nts:%s", n.client.Host)
+	}
+	return fmt.Sprintf("ntp:%s", n.client.Host)
 }
 
 // Protocol возвращает протокол
@@ -31,40 +95,126 @@ func (n *NTP) Protocol() string {
 	return "ntp"
 }
 
-// GetTime запрашивает время у NTP сервера (RFC 5905, упрощённо)
+// GetOffset опрашивает сервер, если подошёл интервал опроса, и возвращает последнее
+// отфильтрованное измерение. Источник пригоден, пока сервер достижим (reach != 0).
+func (n *NTP) GetOffset() (Sample, Status) {
+	n.pollIfDue()
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.denied || !n.have || n.reach == 0 {
+		return Sample{}, StatusUnavailable
+	}
+	return Sample{
+		Offset:     n.last.Offset,
+		Delay:      n.last.Delay,
+		Dispersion: n.last.Dispersion,
+		Stratum:    int(n.last.Stratum),
+		Time:       n.last.Time,
+
+		RootDelay:      n.last.RootDelay,
+		RootDispersion: n.last.RootDispersion,
+	}, StatusLocked
+}
+
+// GetTime возвращает время сервера как локальное время плюс измеренный offset
 func (n *NTP) GetTime() (time.Time, Status) {
-	addr, err := net.ResolveUDPAddr("udp", n.host+":123")
-	if err != nil {
-		return time.Time{}, StatusUnavailable
+	s, st := n.GetOffset()
+	if st != StatusLocked {
+		return time.Time{}, st
 	}
-	conn, err := net.DialUDP("udp", nil, addr)
-	if err != nil {
-		return time.Time{}, StatusUnavailable
+	return time.Now().Add(s.Offset).UTC(), st
+}
+
+// LastSample возвращает последнее отфильтрованное измерение NTP (с jitter, stratum, refid)
+func (n *NTP) LastSample() (ntp.Sample, bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.last, n.have
+}
+
+// Reference возвращает stratum сервера + 1 и адрес сервера как reference ID
+func (n *NTP) Reference() (uint8, uint32) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.last.Stratum + 1, ntp.RefIDFromIP(n.last.Server)
+}
+
+// LeapSecond возвращает предупреждение о секунде координации из ответа сервера
+func (n *NTP) LeapSecond() (int, bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if !n.have {
+		return 0, false
+	}
+	return leapChange(n.last.Leap), true
+}
+
+// leapChange переводит leap indicator NTP в +1/-1/0
+func leapChange(li ntp.LeapIndicator) int {
+	switch li {
+	case ntp.LeapInsert:
+		return 1
+	case ntp.LeapDelete:
+		return -1
 	}
-	defer conn.Close()
-	if err := conn.SetDeadline(time.Now().Add(n.timeout)); err != nil {
-		return time.Time{}, StatusUnavailable
+	return 0
+}
+
+// PollInterval возвращает текущий интервал опроса
+func (n *NTP) PollInterval() time.Duration {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.poller.Interval()
+}
+
+// pollIfDue выполняет обмен с сервером, если наступило время следующего опроса. Обмен (до
+// ntpQueryTimeout) идёт без mu: LastSample, Stats и Reference его не ждут. Если обмен уже
+// идёт в другом вызове, pollIfDue сразу возвращается — вызывающий получит прежнее измерение.
+func (n *NTP) pollIfDue() {
+	if !n.qmu.TryLock() {
+		return
+	}
+	defer n.qmu.Unlock()
+	now := time.Now()
+	n.mu.Lock()
+	due := !n.denied && !now.Before(n.nextPoll)
+	n.mu.Unlock()
+	if !due {
+		return
 	}
-	// NTP request: 48 bytes, first byte = 0x1b (version 4, client)
-	req := make([]byte, 48)
-	req[0] = 0x1b
-	if _, err := conn.Write(req); err != nil {
-		return time.Time{}, StatusUnavailable
+	s, err := n.client.Query()
+	stats := n.client.Stats()
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.stats = stats
+	n.reach <<= 1
+	n.lastErr = err
+	if err != nil {
+		var kod *ntp.KissError
+		if errors.As(err, &kod) && (kod.Code == "DENY" || kod.Code == "RSTR") {
+			n.denied = true
+			return
+		}
+		if errors.Is(err, ntp.ErrNTSNak) {
+			// cookie отвергнут: следующий опрос выполнит NTS-KE сразу, без увеличения интервала
+			n.nextPoll = now
+			return
+		}
+		n.poller.Backoff()
+		n.nextPoll = now.Add(n.poller.Interval())
+		return
 	}
-	resp := make([]byte, 48)
-	if _, err := conn.Read(resp); err != nil {
-		return time.Time{}, StatusUnavailable
+	n.reach |= 1
+	if best, ok := n.filter.Add(s); ok {
+		n.last = best
+		n.have = true
+		n.poller.Update(best.Offset, best.Jitter)
 	}
-	// Seconds in bytes 40-43 (big-endian), fraction 44-47
-	sec := uint32(resp[40])<<24 | uint32(resp[41])<<16 | uint32(resp[42])<<8 | uint32(resp[43])
-	frac := uint32(resp[44])<<24 | uint32(resp[45])<<16 | uint32(resp[46])<<8 | uint32(resp[47])
-	// NTP epoch = 1900-01-01
-	ntpEpoch := time.Date(1900, 1, 1, 0, 0, 0, 0, time.UTC)
-	t := ntpEpoch.Add(time.Duration(sec)*time.Second + time.Duration(frac)*time.Second/0x100000000)
-	return t.UTC(), StatusLocked
+	n.nextPoll = now.Add(n.poller.Interval())
 }
 
-// Close не требует освобождения ресурсов
+// Close не требует освобождения ресурсов (сокет открывается на время обмена)
 func (n *NTP) Close() error {
 	return nil
 }
@@ -0,0 +1,198 @@
+package source
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shiwa/timecard-mini/tc-sync/internal/ntp"
+)
+
+// maxPoolServers — сколько адресов брать из DNS, если пул задан одним именем (pool.ntp.org)
+const maxPoolServers = 4
+
+// NTPPool — NTP источник из нескольких серверов с отбором по RFC 5905:
+// пересечение интервалов (Marzullo) отбрасывает falsetickers, кластеризация — выбросы,
+// offset выживших комбинируется. Один «плохой» сервер не может увести часы, даже если он первый в списке.
+type NTPPool struct {
+	name  string
+	peers []*NTP
+
+	mu     sync.Mutex
+	states []ntp.PeerState
+	sys    ntp.Sample // измерение system peer после последнего отбора
+	last   Sample
+	have   bool
+	err    error
+}
+
+// NTPPeerStatus — состояние одного сервера пула (для логов и статуса)
+type NTPPeerStatus struct {
+	Server  string
+	State   ntp.PeerState
+	Offset  time.Duration
+	Delay   time.Duration
+	Jitter  time.Duration
+	Stratum uint8
+	Poll    time.Duration
+	Stats   ntp.ClientStats
+}
+
+// NewNTPPool создаёт пул из списка серверов. Если список из одного имени, которое
+// резолвится в несколько адресов, используются до 4 адресов (как pool в chrony/ntpd).
+func NewNTPPool(servers []string, minPoll, maxPoll time.Duration) (*NTPPool, error) {
+	if len(servers) == 1 {
+		if addrs, err := net.LookupHost(servers[0]); err == nil && len(addrs) > 1 {
+			if len(addrs) > maxPoolServers {
+				addrs = addrs[:maxPoolServers]
+			}
+			servers = addrs
+		}
+	}
+	if len(servers) == 0 {
+		return nil, fmt.Errorf("ntp_pool: servers required")
+	}
+	p := &NTPPool{
+		name:   strings.Join(servers, ","),
+		states: make([]ntp.PeerState, len(servers)),
+	}
+	for _, s := range servers {
+		p.peers = append(p.peers, NewNTP(s, minPoll, maxPoll))
+	}
+	return p, nil
+}
+
+// Name возвращает имя источника
+func (p *NTPPool) Name() string {
+	return fmt.Sprintf("ntp_pool:%s", p.name)
+}
+
+// Protocol возвращает протокол
+func (p *NTPPool) Protocol() string {
+	return "ntp"
+}
+
+// GetOffset опрашивает серверы (параллельно, каждый по своему интервалу), выполняет отбор
+// и возвращает комбинированный offset. Delay, dispersion и stratum берутся от system peer.
+func (p *NTPPool) GetOffset() (Sample, Status) {
+	cands := make([]ntp.Candidate, len(p.peers))
+	var wg sync.WaitGroup
+	for i, peer := range p.peers {
+		wg.Add(1)
+		go func(i int, peer *NTP) {
+			defer wg.Done()
+			if _, st := peer.GetOffset(); st != StatusLocked {
+				return
+			}
+			s, ok := peer.LastSample()
+			cands[i] = ntp.Candidate{Sample: s, OK: ok}
+		}(i, peer)
+	}
+	wg.Wait()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	sel, err := ntp.Select(cands)
+	p.states = sel.States
+	p.err = err
+	if err != nil {
+		return Sample{}, StatusUnavailable
+	}
+	sys := cands[sel.SystemPeer].Sample
+	p.sys = sys
+	newest := sys.Time
+	for i, st := range sel.States {
+		if (st == ntp.PeerSurvivor || st == ntp.PeerSystemPeer) && cands[i].Sample.Time.After(newest) {
+			newest = cands[i].Sample.Time
+		}
+	}
+	p.last = Sample{
+		Offset:     sel.Offset,
+		Delay:      sys.Delay,
+		Dispersion: sys.Dispersion + sel.Jitter,
+		Stratum:    int(sys.Stratum),
+		Time:       newest,
+
+		RootDelay:      sys.RootDelay,
+		RootDispersion: sys.RootDispersion,
+	}
+	p.have = true
+	return p.last, StatusLocked
+}
+
+// GetTime возвращает локальное время плюс комбинированный offset пула
+func (p *NTPPool) GetTime() (time.Time, Status) {
+	s, st := p.GetOffset()
+	if st != StatusLocked {
+		return time.Time{}, st
+	}
+	return time.Now().Add(s.Offset).UTC(), st
+}
+
+// Reference возвращает stratum и адрес system peer
+func (p *NTPPool) Reference() (uint8, uint32) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.sys.Stratum + 1, ntp.RefIDFromIP(p.sys.Server)
+}
+
+// LeapSecond возвращает предупреждение о секунде координации от system peer
+func (p *NTPPool) LeapSecond() (int, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.have {
+		return 0, false
+	}
+	return leapChange(p.sys.Leap), true
+}
+
+// Peers возвращает состояние каждого сервера после последнего отбора (truechimer/falseticker и т.д.)
+func (p *NTPPool) Peers() []NTPPeerStatus {
+	p.mu.Lock()
+	states := append([]ntp.PeerState(nil), p.states...)
+	p.mu.Unlock()
+	out := make([]NTPPeerStatus, len(p.peers))
+	for i, peer := range p.peers {
+		ps := NTPPeerStatus{Server: peer.client.Host, Poll: peer.PollInterval(), Stats: peer.Stats()}
+		if i < len(states) {
+			ps.State = states[i]
+		}
+		if s, ok := peer.LastSample(); ok {
+			ps.Offset, ps.Delay, ps.Jitter, ps.Stratum = s.Offset, s.Delay, s.Jitter, s.Stratum
+		}
+		out[i] = ps
+	}
+	return out
+}
+
+// EnableNTS включает NTS для каждого сервера пула
+func (p *NTPPool) EnableNTS(tlsConf *tls.Config) {
+	for _, peer := range p.peers {
+		peer.EnableNTS(tlsConf)
+	}
+}
+
+// SetKey задаёт симметричный ключ для каждого сервера пула
+func (p *NTPPool) SetKey(k *ntp.Key) {
+	for _, peer := range p.peers {
+		peer.SetKey(k)
+	}
+}
+
+// EnableInterleaved включает interleaved режим для каждого сервера пула
+func (p *NTPPool) EnableInterleaved() {
+	for _, peer := range p.peers {
+		peer.EnableInterleaved()
+	}
+}
+
+// Close закрывает все серверы пула
+func (p *NTPPool) Close() error {
+	for _, peer := range p.peers {
+		_ = peer.Close()
+	}
+	return nil
+}
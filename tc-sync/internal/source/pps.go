@@ -3,6 +3,8 @@ package source
 import (
 	"fmt"
 	"time"
+
+	"github.com/shiwa/timecard-mini/tc-sync/internal/ntp"
 )
 
 // PPS — источник времени по PPS (1 pulse-per-second).
@@ -78,6 +80,19 @@ func (p *PPS) GetTime() (time.Time, Status) {
 	return ref, StatusLocked
 }
 
+// Reference — PPS: первичный эталон (stratum 1, refid "PPS")
+func (p *PPS) Reference() (uint8, uint32) {
+	return 1, ntp.RefIDFromString("PPS")
+}
+
+// LeapSecond возвращает информацию о секунде координации от linked_device
+func (p *PPS) LeapSecond() (int, bool) {
+	if p.gnss == nil {
+		return 0, false
+	}
+	return p.gnss.LeapSecond()
+}
+
 // Close закрывает linked GNSS
 func (p *PPS) Close() error {
 	if p.gnss != nil {
@@ -2,19 +2,41 @@ package source
 
 import (
 	"fmt"
+	"os"
+	"sync"
 	"time"
+
+	"github.com/shiwa/timecard-mini/tc-sync/internal/ntp"
+	"github.com/shiwa/timecard-mini/tc-sync/internal/ptp4l"
 )
 
 // PTP — источник времени по PTP (IEEE 1588).
 // На Linux: чтение времени из PHC (/dev/ptpN), синхронизированного ptp4l (linuxptp).
 // phcDevice — путь к PHC, например /dev/ptp0; при пустом используется /dev/ptp0 на Linux.
+// Состояние ptp4l запрашивается по его сокету управления (pmc): источник locked, только пока
+// есть порт в SLAVE и grandmaster (gmPresent). Без сокета, если ptp4l запущен tc-sync (start_ptp4l),
+// состояние берётся из его вывода: порт в SLAVE и servo в s2; иначе — locked, когда PHC читается.
 type PTP struct {
 	domain    int
 	iface     string
 	masters   []string
 	phcDevice string // путь к PHC (/dev/ptp0), для чтения времени после ptp4l
+	ptp4l     *ptp4l.Supervisor
+	pmcSocket string
+
+	mu     sync.Mutex
+	pmc    *ptp4l.PMC
+	pmcSt  ptp4l.PMCStatus
+	pmcErr error
+	pmcAt  time.Time // последний запрос; нулевое — не запрашивали
 }
 
+// pmcInterval — состояние ptp4l запрашивается не чаще; pmcTimeout — ожидание ответа
+const (
+	pmcInterval = time.Second
+	pmcTimeout  = 500 * time.Millisecond
+)
+
 // getTimeFromPHC если задана, читает время из PHC (только Linux).
 var getTimeFromPHC func(phcDevice string) (time.Time, bool)
 
@@ -29,7 +51,58 @@ func NewPTP(domain int, iface string, masters []string, phcDevice string) *PTP {
 		iface:     iface,
 		masters:   masters,
 		phcDevice: phcDevice,
+		pmcSocket: ptp4l.DefaultSocket,
+	}
+}
+
+// SetPMCSocket задаёт сокет управления ptp4l (uds_address); пусто — ptp4l.DefaultSocket
+func (p *PTP) SetPMCSocket(path string) {
+	if path == "" {
+		path = ptp4l.DefaultSocket
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.pmc != nil {
+		p.pmc.Close()
+		p.pmc = nil
+	}
+	p.pmcSocket, p.pmcAt = path, time.Time{}
+}
+
+// PMCStatus возвращает наборы данных ptp4l, запрошенные по сокету управления (не чаще pmcInterval);
+// ok=false — сокета нет. Ошибка — ptp4l не отвечает.
+func (p *PTP) PMCStatus() (st ptp4l.PMCStatus, ok bool, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, err := os.Stat(p.pmcSocket); err != nil {
+		return ptp4l.PMCStatus{}, false, nil
+	}
+	now := time.Now()
+	if !p.pmcAt.IsZero() && now.Sub(p.pmcAt) < pmcInterval {
+		return p.pmcSt, true, p.pmcErr
+	}
+	p.pmcAt = now
+	if p.pmc == nil {
+		if p.pmc, p.pmcErr = ptp4l.DialPMC(ptp4l.PMCConfig{Socket: p.pmcSocket, Domain: uint8(p.domain), Timeout: pmcTimeout}); p.pmcErr != nil {
+			p.pmc = nil
+			return ptp4l.PMCStatus{}, true, p.pmcErr
+		}
 	}
+	p.pmcSt, p.pmcErr = p.pmc.Status()
+	return p.pmcSt, true, p.pmcErr
+}
+
+// SetPtp4l подключает наблюдатель ptp4l, синхронизирующего PHC (nil — без наблюдения)
+func (p *PTP) SetPtp4l(s *ptp4l.Supervisor) {
+	p.ptp4l = s
+}
+
+// Ptp4l возвращает состояние ptp4l; ok=false — ptp4l запущен не tc-sync
+func (p *PTP) Ptp4l() (ptp4l.Health, bool) {
+	if p.ptp4l == nil {
+		return ptp4l.Health{}, false
+	}
+	return p.ptp4l.Health(), true
 }
 
 // Name возвращает имя источника
@@ -42,17 +115,44 @@ func (p *PTP) Protocol() string {
 	return "ptp"
 }
 
-// GetTime возвращает время из PHC (Linux, ptp4l) или StatusUnavailable.
+// GetTime возвращает время из PHC (Linux, ptp4l) или StatusUnavailable. ptp4l не работает
+// или не отвечает по сокету управления — StatusUnavailable, не синхронизирован — StatusUnlocked.
 func (p *PTP) GetTime() (time.Time, Status) {
+	status := StatusLocked
+	h, supervised := p.Ptp4l()
+	if supervised && !h.Running {
+		return time.Time{}, StatusUnavailable
+	}
+	if st, ok, err := p.PMCStatus(); ok {
+		if err != nil {
+			return time.Time{}, StatusUnavailable
+		}
+		if !st.Synchronized() {
+			status = StatusUnlocked
+		}
+	} else if supervised && !h.Locked(time.Now()) {
+		status = StatusUnlocked
+	}
 	if getTimeFromPHC != nil {
 		if t, ok := getTimeFromPHC(p.phcDevice); ok {
-			return t, StatusLocked
+			return t, status
 		}
 	}
 	return time.Time{}, StatusUnavailable
 }
 
-// Close не требует освобождения ресурсов (PHC читается по требованию)
+// Reference — PTP: время grandmaster (stratum 1, refid "PTP")
+func (p *PTP) Reference() (uint8, uint32) {
+	return 1, ntp.RefIDFromString("PTP")
+}
+
+// Close закрывает клиент управления ptp4l (PHC читается по требованию)
 func (p *PTP) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.pmc != nil {
+		p.pmc.Close()
+		p.pmc = nil
+	}
 	return nil
 }
@@ -0,0 +1,203 @@
+package source
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/shiwa/timecard-mini/tc-sync/internal/ntp"
+	"github.com/shiwa/timecard-mini/tc-sync/internal/ptp"
+	"github.com/shiwa/timecard-mini/tc-sync/internal/timestamping"
+)
+
+// ptpMinMaxAge — нижняя граница возраста измерения, после которого источник считается потерянным
+const ptpMinMaxAge = 2 * time.Second
+
+// NativePTP — источник PTP без ptp4l: встроенный slave IEEE 1588 (UDP/IPv4, UDP/IPv6 или Ethernet; E2E, P2P или gPTP).
+// Offset и meanPathDelay измеряются по Sync/Follow_Up и Delay_Req/Delay_Resp (или Pdelay) и подаются в servo напрямую.
+// Метки времени — аппаратные (если сетевая карта поддерживает) или ядра.
+type NativePTP struct {
+	domain int
+	iface  string
+	tr     ptp.Transport
+	slave  *ptp.Slave
+	cancel context.CancelFunc
+	phc    string // PHC интерфейса для пересчёта аппаратных меток в системное время
+}
+
+// NativePTPOptions — параметры встроенного slave
+type NativePTPOptions struct {
+	Domain    int
+	Interface string
+	Transport string // "udp" (по умолчанию), "udp6" — UDP/IPv6 или "l2" — Ethernet, только multicast
+	// Profile — профиль PTP (G.8275.1, G.8275.2, G.8265.1, enterprise-draft, IEC/IEEE 61850-9-3):
+	// значения по умолчанию для нулевых параметров, ограничения и алгоритм выбора мастера
+	Profile        string
+	DelayMechanism string // delay_mechanism: "e2e" (по умолчанию) или "p2p"
+	// NeighborPropDelayThresh — gPTP: предел задержки линии, выше которого порт не asCapable (0 — 800 нс)
+	NeighborPropDelayThresh time.Duration
+	// Masters — unicast мастера (IP или имена) с согласованием передачи; пусто — multicast 224.0.1.129
+	Masters []string
+	// HybridE2E — при multicast Sync Delay_Req отправляется unicast на адрес мастера (enterprise profile)
+	HybridE2E bool
+	// RelaxDelayRequests — Delay_Req через случайные 200–800 мс после Sync (ptp_tuning.relax_delay_requests)
+	RelaxDelayRequests bool
+	// Auth — аутентификация сообщений (authentication записи); nil — без аутентификации
+	Auth *ptp.SecurityAssociation
+	// Socket — DSCP, TTL multicast и общие сокеты UDP (ptp_tuning)
+	Socket ptp.SocketOptions
+	// Интервалы (log2 секунд), запрашиваемые у unicast мастеров: Announce, Sync, Delay_Resp
+	AnnounceInterval     int
+	SyncInterval         int
+	DelayRequestInterval int
+}
+
+// NewNativePTP запускает slave на интерфейсе o.Interface
+func NewNativePTP(o NativePTPOptions) (*NativePTP, error) {
+	iface := o.Interface
+	opts, profile, err := ptp.ApplyProfile(o.Profile, ptp.PortOptions{
+		Transport:            o.Transport,
+		DelayMechanism:       o.DelayMechanism,
+		Domain:               o.Domain,
+		AnnounceInterval:     o.AnnounceInterval,
+		SyncInterval:         o.SyncInterval,
+		DelayRequestInterval: o.DelayRequestInterval,
+		Multicast:            len(o.Masters) == 0,
+		Unicast:              len(o.Masters) > 0,
+		HybridE2E:            o.HybridE2E && len(o.Masters) == 0,
+		Socket:               o.Socket,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if opts.Transport == ptp.TransportL2 && len(o.Masters) > 0 {
+		return nil, fmt.Errorf("ptp: unicast_master_table requires transport udp")
+	}
+	if opts.DelayMechanism == ptp.DelayP2P && len(o.Masters) > 0 {
+		return nil, fmt.Errorf("ptp: delay_mechanism p2p does not support unicast_master_table")
+	}
+	network := "ip4"
+	if opts.Transport == ptp.TransportUDP6 {
+		network = "ip6"
+	}
+	var ips []net.IP
+	for _, m := range o.Masters {
+		a, err := net.ResolveIPAddr(network, m)
+		if err != nil {
+			return nil, fmt.Errorf("ptp: master %s: %w", m, err)
+		}
+		ips = append(ips, a.IP)
+	}
+	tr, err := ptp.OpenTransport(opts, iface)
+	if err != nil {
+		return nil, err
+	}
+	p := &NativePTP{domain: opts.Domain, iface: iface, tr: tr}
+	if tr.TimestampType() == timestamping.Hardware {
+		if p.phc, err = ptp.PHCDevice(iface); err != nil {
+			tr.Close()
+			return nil, err
+		}
+	}
+	bmca, gptp := ptp.BMCADefault, false
+	if profile != nil {
+		bmca, gptp = profile.BMCA, profile.GPTP
+	}
+	p.slave = ptp.NewSlave(tr, ptp.SlaveConfig{
+		Domain:               uint8(opts.Domain),
+		Identity:             ptp.PortIdentity{Clock: ptp.DefaultClockIdentity(iface), Port: 1},
+		Masters:              ips,
+		Negotiate:            len(ips) > 0,
+		LogAnnounceInterval:  int8(opts.AnnounceInterval),
+		LogSyncInterval:      int8(opts.SyncInterval),
+		LogDelayRespInterval: int8(opts.DelayRequestInterval),
+		BMCA:                 bmca,
+		HybridE2E:            opts.HybridE2E,
+		RelaxDelayRequests:   o.RelaxDelayRequests,
+		DelayMechanism:       opts.DelayMechanism,
+		Auth:                 o.Auth,
+		PeerDelay: ptp.PeerDelayConfig{
+			LogInterval:             int8(opts.DelayRequestInterval),
+			GPTP:                    gptp,
+			NeighborPropDelayThresh: o.NeighborPropDelayThresh,
+		},
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	p.cancel = cancel
+	go p.slave.Run(ctx)
+	return p, nil
+}
+
+// Name возвращает имя источника
+func (p *NativePTP) Name() string {
+	return fmt.Sprintf("ptp:native domain%d %s", p.domain, p.iface)
+}
+
+// Protocol возвращает протокол
+func (p *NativePTP) Protocol() string {
+	return "ptp"
+}
+
+// GetOffset возвращает последнее измерение slave. Для аппаратных меток offset пересчитывается
+// из шкалы PHC в системное время (PHC − CLOCK_REALTIME).
+func (p *NativePTP) GetOffset() (Sample, Status) {
+	m, ok := p.slave.Last()
+	if !ok {
+		if p.slave.State() == ptp.StateListening {
+			return Sample{}, StatusUnavailable
+		}
+		return Sample{}, StatusUnlocked
+	}
+	maxAge := 4 * m.SyncInterval
+	if maxAge < ptpMinMaxAge {
+		maxAge = ptpMinMaxAge
+	}
+	if time.Since(m.Time) > maxAge {
+		return Sample{}, StatusUnlocked
+	}
+	offset := -m.OffsetFromMaster
+	if m.TimestampType == timestamping.Hardware {
+		phcSys, err := ptp.PHCSystemOffset(p.phc)
+		if err != nil {
+			return Sample{}, StatusUnavailable
+		}
+		offset += phcSys
+	}
+	return Sample{Offset: offset, Delay: m.MeanPathDelay, Time: m.Time}, StatusLocked
+}
+
+// GetTime возвращает время мастера как локальное время плюс измеренный offset
+func (p *NativePTP) GetTime() (time.Time, Status) {
+	s, st := p.GetOffset()
+	if st != StatusLocked {
+		return time.Time{}, st
+	}
+	return time.Now().Add(s.Offset).UTC(), st
+}
+
+// Domain возвращает домен PTP источника
+func (p *NativePTP) Domain() int {
+	return p.domain
+}
+
+// Interface возвращает сетевой интерфейс источника
+func (p *NativePTP) Interface() string {
+	return p.iface
+}
+
+// Slave возвращает порт slave (состояние и измерения для статуса)
+func (p *NativePTP) Slave() *ptp.Slave {
+	return p.slave
+}
+
+// Reference — PTP: время grandmaster (stratum 1, refid "PTP")
+func (p *NativePTP) Reference() (uint8, uint32) {
+	return 1, ntp.RefIDFromString("PTP")
+}
+
+// Close останавливает slave и закрывает сокеты
+func (p *NativePTP) Close() error {
+	p.cancel()
+	return p.tr.Close()
+}
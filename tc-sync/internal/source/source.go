@@ -14,6 +14,42 @@ type TimeSource interface {
 	Close() error
 }
 
+// Sample — измерение смещения локальных часов относительно источника.
+// Источники, которые сами измеряют смещение по сети (NTP, PTP), отдают его через OffsetSource:
+// сравнение GetTime() с time.Now() добавило бы к offset задержку опроса и половину RTT.
+type Sample struct {
+	Offset     time.Duration // время источника минус локальное время
+	Delay      time.Duration // round-trip delay (NTP) или mean path delay (PTP)
+	Dispersion time.Duration // оценка максимальной ошибки измерения
+	Stratum    int           // stratum сервера (NTP); 0 — неприменимо
+	Time       time.Time     // локальное время измерения
+	// RootDelay/RootDispersion — накопленные до первичного эталона значения сервера (NTP)
+	RootDelay      time.Duration
+	RootDispersion time.Duration
+}
+
+// OffsetSource — источник, измеряющий смещение напрямую (NTP, PTP).
+// Servo использует GetOffset вместо GetTime, если источник его реализует.
+type OffsetSource interface {
+	TimeSource
+	// GetOffset возвращает последнее измерение и статус источника
+	GetOffset() (Sample, Status)
+}
+
+// Reference — stratum и reference ID источника (RFC 5905) для встроенного NTP сервера:
+// первичные эталоны (GNSS, PPS, PTP) — stratum 1 и ASCII refid, NTP — stratum сервера + 1
+// и адрес сервера.
+type Reference interface {
+	Reference() (stratum uint8, refID uint32)
+}
+
+// LeapSource — источник, знающий о предстоящей секунде координации (GNSS: UBX-NAV-TIMELS).
+// LeapSecond возвращает +1/-1, если секунда будет вставлена/удалена в конце текущих суток UTC,
+// 0 — если события нет; ok=false — информации от источника нет.
+type LeapSource interface {
+	LeapSecond() (change int, ok bool)
+}
+
 // Status — состояние источника (как в Timebeat: active, unavailable, etc.)
 type Status int
 
@@ -0,0 +1,204 @@
+// Package timestamping — метки времени приёма и передачи UDP пакетов и кадров AF_PACKET.
+// На Linux метка приёма берётся из ядра (SO_TIMESTAMPING / SO_TIMESTAMPNS), метка передачи —
+// из error queue сокета; при поддержке сетевой картой — аппаратные метки. Если ядро метки
+// не даёт, используется time.Now() вокруг вызова сокета (software).
+package timestamping
+
+import (
+	"net"
+	"syscall"
+	"time"
+)
+
+// Type — откуда взята метка времени
+type Type int
+
+const (
+	Software Type = iota // time.Now() в user space до/после вызова сокета
+	Kernel               // метка ядра (CLOCK_REALTIME в момент приёма/передачи драйвером)
+	Hardware             // метка сетевой карты (время PHC)
+)
+
+func (t Type) String() string {
+	switch t {
+	case Software:
+		return "software"
+	case Kernel:
+		return "kernel"
+	case Hardware:
+		return "hardware"
+	default:
+		return "unknown"
+	}
+}
+
+// Stamp — метка времени пакета и её тип
+type Stamp struct {
+	Time time.Time
+	Type Type
+}
+
+// Options — какие метки включать на сокете
+type Options struct {
+	TX        bool   // метки передачи (error queue); без TX — только приём
+	Hardware  bool   // аппаратные метки (SIOCSHWTSTAMP на Interface); при неудаче — метки ядра
+	Interface string // сетевой интерфейс для аппаратных меток
+	// Packet — сокет AF_PACKET (PTP поверх Ethernet): аппаратный фильтр PTP_V2_L2_EVENT, метки
+	// передачи без OPT_ID (ядра до 6.x не нумеруют их для пакетных сокетов)
+	Packet bool
+}
+
+// txTimestampTimeout — сколько ждать метку передачи в error queue
+const txTimestampTimeout = 5 * time.Millisecond
+
+// Conn — UDP сокет с метками времени приёма и передачи
+type Conn struct {
+	conn *net.UDPConn
+	raw  syscall.RawConn
+	rx   Type // лучшая метка приёма, которую удалось включить
+	tx   Type // лучшая метка передачи
+	oob  []byte
+
+	txSent uint32 // число отправленных пакетов — ожидаемый ID следующей метки передачи (OPT_ID)
+}
+
+// New включает метки времени на conn. Ошибки не возвращаются: при отсутствии поддержки
+// сокет работает с метками Software (см. RXType/TXType).
+func New(conn *net.UDPConn, opts Options) *Conn {
+	c := &Conn{conn: conn, oob: make([]byte, 512)}
+	if raw, err := conn.SyscallConn(); err == nil {
+		c.raw = raw
+		c.rx, c.tx = enable(raw, opts)
+	}
+	return c
+}
+
+// UDPConn возвращает исходный сокет
+func (c *Conn) UDPConn() *net.UDPConn {
+	return c.conn
+}
+
+// RXType возвращает тип меток приёма, включённых на сокете
+func (c *Conn) RXType() Type {
+	return c.rx
+}
+
+// TXType возвращает тип меток передачи, включённых на сокете
+func (c *Conn) TXType() Type {
+	return c.tx
+}
+
+// LocalAddr возвращает локальный адрес сокета
+func (c *Conn) LocalAddr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+// SetReadDeadline задаёт таймаут чтения
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+// Close закрывает сокет
+func (c *Conn) Close() error {
+	return c.conn.Close()
+}
+
+// ReadMsg читает пакет и возвращает метку времени приёма
+func (c *Conn) ReadMsg(b []byte) (int, *net.UDPAddr, Stamp, error) {
+	n, oobn, _, addr, err := c.conn.ReadMsgUDP(b, c.oob)
+	ts := Stamp{Time: time.Now(), Type: Software}
+	if err != nil {
+		return n, addr, ts, err
+	}
+	if c.rx != Software {
+		if t, ok := parseStamp(c.oob[:oobn]); ok {
+			ts = t
+		}
+	}
+	return n, addr, ts, nil
+}
+
+// WriteMsg отправляет пакет (addr == nil — для подключённого сокета) и возвращает метку передачи
+func (c *Conn) WriteMsg(b []byte, addr *net.UDPAddr) (Stamp, error) {
+	ts := Stamp{Time: time.Now(), Type: Software}
+	var err error
+	if addr == nil {
+		_, err = c.conn.Write(b)
+	} else {
+		_, err = c.conn.WriteToUDP(b, addr)
+	}
+	if err != nil {
+		return ts, err
+	}
+	if c.tx != Software {
+		id := c.txSent
+		c.txSent++
+		if t, ok := txStamp(c.raw, c.tx, id, true); ok {
+			ts = t
+		}
+	}
+	return ts, nil
+}
+
+// Socket — метки времени для сокета, который читает и пишет вызывающий (например, AF_PACKET):
+// Socket включает метки, разбирает control messages приёма и забирает метки передачи из error queue.
+type Socket struct {
+	raw    syscall.RawConn
+	rx     Type
+	tx     Type
+	noID   bool
+	txSent uint32
+}
+
+// NewSocket включает метки времени на сокете raw; как и New, ошибок не возвращает
+func NewSocket(raw syscall.RawConn, opts Options) *Socket {
+	s := &Socket{raw: raw, noID: opts.Packet}
+	s.rx, s.tx = enable(raw, opts)
+	return s
+}
+
+// RXType возвращает тип меток приёма, включённых на сокете
+func (s *Socket) RXType() Type {
+	return s.rx
+}
+
+// TXType возвращает тип меток передачи, включённых на сокете
+func (s *Socket) TXType() Type {
+	return s.tx
+}
+
+// RXStamp извлекает метку приёма из control messages (oob recvmsg); received — время возврата
+// из recvmsg, оно же метка Software, если ядро метку не передало
+func (s *Socket) RXStamp(oob []byte, received time.Time) Stamp {
+	if s.rx != Software {
+		if ts, ok := parseStamp(oob); ok {
+			return ts
+		}
+	}
+	return Stamp{Time: received, Type: Software}
+}
+
+// Write вызывает send (отправку одного пакета) и возвращает метку передачи. Без OPT_ID метки
+// не нумеруются: error queue очищается перед отправкой, чтобы не взять метку другого пакета.
+func (s *Socket) Write(send func() error) (Stamp, error) {
+	if s.tx != Software && s.noID {
+		drainErrQueue(s.raw)
+	}
+	ts := Stamp{Time: time.Now(), Type: Software}
+	if err := send(); err != nil {
+		return ts, err
+	}
+	id := s.txSent
+	s.txSent++
+	if s.tx != Software {
+		if t, ok := txStamp(s.raw, s.tx, id, !s.noID); ok {
+			ts = t
+		}
+	}
+	return ts, nil
+}
+
+// Sent учитывает пакет, отправленный без ожидания метки (номер OPT_ID следующей метки)
+func (s *Socket) Sent() {
+	s.txSent++
+}
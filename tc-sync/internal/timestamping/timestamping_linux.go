@@ -0,0 +1,175 @@
+//go:build linux
+
+package timestamping
+
+import (
+	"syscall"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// enable включает SO_TIMESTAMPING (программные метки ядра, при Options.Hardware — и аппаратные);
+// если ядро его не принимает — SO_TIMESTAMPNS (только приём).
+func enable(raw syscall.RawConn, opts Options) (Type, Type) {
+	flags := unix.SOF_TIMESTAMPING_RX_SOFTWARE | unix.SOF_TIMESTAMPING_SOFTWARE
+	if opts.TX {
+		// OPT_ID: каждая метка передачи несёт номер пакета — старые метки (после таймаута) не путаются с новыми
+		flags |= unix.SOF_TIMESTAMPING_TX_SOFTWARE | unix.SOF_TIMESTAMPING_OPT_TSONLY
+		if !opts.Packet {
+			flags |= unix.SOF_TIMESTAMPING_OPT_ID
+		}
+	}
+	rx, tx := Kernel, Software
+	if opts.TX {
+		tx = Kernel
+	}
+	var hwErr error
+	if opts.Hardware && opts.Interface != "" {
+		// Не все карты метят любые пакеты: следующий фильтр — только event сообщения PTPv2 (как ptp4l)
+		filters := []int32{unix.HWTSTAMP_FILTER_ALL, unix.HWTSTAMP_FILTER_PTP_V2_EVENT, unix.HWTSTAMP_FILTER_PTP_V2_L4_EVENT}
+		if opts.Packet {
+			filters[2] = unix.HWTSTAMP_FILTER_PTP_V2_L2_EVENT
+		}
+		_ = raw.Control(func(fd uintptr) {
+			for _, filter := range filters {
+				hwErr = unix.IoctlSetHwTstamp(int(fd), opts.Interface, &unix.HwTstampConfig{
+					Tx_type:   unix.HWTSTAMP_TX_ON,
+					Rx_filter: filter,
+				})
+				if hwErr == nil {
+					break
+				}
+			}
+		})
+		if hwErr == nil {
+			flags |= unix.SOF_TIMESTAMPING_RX_HARDWARE | unix.SOF_TIMESTAMPING_RAW_HARDWARE
+			rx = Hardware
+			if opts.TX {
+				flags |= unix.SOF_TIMESTAMPING_TX_HARDWARE
+				tx = Hardware
+			}
+		}
+	}
+	var err error
+	_ = raw.Control(func(fd uintptr) {
+		err = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_TIMESTAMPING, flags)
+		if err != nil {
+			err = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_TIMESTAMPNS, 1)
+			rx, tx = Kernel, Software
+		}
+	})
+	if err != nil {
+		rx, tx = Software, Software
+	}
+	return rx, tx
+}
+
+// parseStamp извлекает метку из control messages: SCM_TIMESTAMPING (ts[2] — аппаратная,
+// ts[0] — программная метка ядра) или SCM_TIMESTAMPNS.
+func parseStamp(oob []byte) (Stamp, bool) {
+	msgs, err := unix.ParseSocketControlMessage(oob)
+	if err != nil {
+		return Stamp{}, false
+	}
+	tsSize := int(unsafe.Sizeof(unix.Timespec{}))
+	var best Stamp
+	var ok bool
+	for _, m := range msgs {
+		if m.Header.Level != unix.SOL_SOCKET {
+			continue
+		}
+		switch m.Header.Type {
+		case unix.SCM_TIMESTAMPING:
+			if len(m.Data) < 3*tsSize {
+				continue
+			}
+			ts := (*[3]unix.Timespec)(unsafe.Pointer(&m.Data[0]))
+			if ts[2].Sec != 0 || ts[2].Nsec != 0 {
+				return Stamp{Time: time.Unix(ts[2].Unix()), Type: Hardware}, true
+			}
+			if ts[0].Sec != 0 || ts[0].Nsec != 0 {
+				best, ok = Stamp{Time: time.Unix(ts[0].Unix()), Type: Kernel}, true
+			}
+		case unix.SCM_TIMESTAMPNS:
+			if len(m.Data) < tsSize {
+				continue
+			}
+			ts := (*unix.Timespec)(unsafe.Pointer(&m.Data[0]))
+			best, ok = Stamp{Time: time.Unix(ts.Unix()), Type: Kernel}, true
+		}
+	}
+	return best, ok
+}
+
+// txID возвращает номер пакета (SOF_TIMESTAMPING_OPT_ID) из sock_extended_err
+func txID(oob []byte) (uint32, bool) {
+	msgs, err := unix.ParseSocketControlMessage(oob)
+	if err != nil {
+		return 0, false
+	}
+	for _, m := range msgs {
+		isErr := (m.Header.Level == unix.SOL_IP && m.Header.Type == unix.IP_RECVERR) ||
+			(m.Header.Level == unix.SOL_IPV6 && m.Header.Type == unix.IPV6_RECVERR) ||
+			(m.Header.Level == unix.SOL_PACKET && m.Header.Type == unix.PACKET_TX_TIMESTAMP)
+		if !isErr || len(m.Data) < int(unsafe.Sizeof(unix.SockExtendedErr{})) {
+			continue
+		}
+		ee := (*unix.SockExtendedErr)(unsafe.Pointer(&m.Data[0]))
+		if ee.Origin == unix.SO_EE_ORIGIN_TIMESTAMPING {
+			return ee.Data, true
+		}
+	}
+	return 0, false
+}
+
+// txStamp читает метку передачи пакета id из error queue (MSG_ERRQUEUE).
+// Ждёт не дольше txTimestampTimeout; при включённых аппаратных метках (want) ждёт аппаратную,
+// иначе возвращает программную. При useID метки более ранних пакетов отбрасываются.
+func txStamp(raw syscall.RawConn, want Type, id uint32, useID bool) (Stamp, bool) {
+	buf := make([]byte, 64)
+	oob := make([]byte, 512)
+	deadline := time.Now().Add(txTimestampTimeout)
+	var best Stamp
+	var ok bool
+	for {
+		var oobn int
+		var err error
+		_ = raw.Control(func(fd uintptr) {
+			_, oobn, _, _, err = unix.Recvmsg(int(fd), buf, oob, unix.MSG_ERRQUEUE|unix.MSG_DONTWAIT)
+		})
+		if err == nil {
+			if got, hasID := txID(oob[:oobn]); useID && hasID && got != id {
+				continue
+			}
+			if ts, got := parseStamp(oob[:oobn]); got && (!ok || ts.Type > best.Type) {
+				best, ok = ts, true
+			}
+			if ok && best.Type >= want {
+				return best, true
+			}
+			continue
+		}
+		if err != unix.EAGAIN && err != unix.EINTR {
+			return best, ok
+		}
+		if time.Now().After(deadline) {
+			return best, ok
+		}
+		time.Sleep(20 * time.Microsecond)
+	}
+}
+
+// drainErrQueue отбрасывает накопившиеся в error queue метки передачи
+func drainErrQueue(raw syscall.RawConn) {
+	buf := make([]byte, 64)
+	oob := make([]byte, 512)
+	_ = raw.Control(func(fd uintptr) {
+		for {
+			if _, _, _, _, err := unix.Recvmsg(int(fd), buf, oob, unix.MSG_ERRQUEUE|unix.MSG_DONTWAIT); err != nil && err != unix.EINTR {
+				return
+			}
+		}
+	})
+}
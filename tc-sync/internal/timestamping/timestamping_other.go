@@ -0,0 +1,20 @@
+//go:build !linux
+
+package timestamping
+
+import "syscall"
+
+// enable — метки ядра есть только на Linux; остаются Software
+func enable(raw syscall.RawConn, opts Options) (Type, Type) {
+	return Software, Software
+}
+
+func parseStamp(oob []byte) (Stamp, bool) {
+	return Stamp{}, false
+}
+
+func txStamp(raw syscall.RawConn, want Type, id uint32, useID bool) (Stamp, bool) {
+	return Stamp{}, false
+}
+
+func drainErrQueue(raw syscall.RawConn) {}
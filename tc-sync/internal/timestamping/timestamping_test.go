@@ -0,0 +1,78 @@
+package timestamping
+
+import (
+	"net"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func listen(t *testing.T) *net.UDPConn {
+	t.Helper()
+	c, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func near(t *testing.T, what string, ts Stamp) {
+	t.Helper()
+	if d := time.Since(ts.Time); d < -time.Second || d > time.Second {
+		t.Errorf("%s %s timestamp %v is %v away from now", what, ts.Type, ts.Time, d)
+	}
+}
+
+func TestConn_Loopback(t *testing.T) {
+	a := New(listen(t), Options{TX: true})
+	b := New(listen(t), Options{})
+	if runtime.GOOS == "linux" {
+		if a.RXType() != Kernel || a.TXType() != Kernel {
+			t.Errorf("linux: rx=%s tx=%s, want kernel", a.RXType(), a.TXType())
+		}
+		if b.TXType() != Software {
+			t.Errorf("TX timestamps not requested, got %s", b.TXType())
+		}
+	}
+
+	dst := b.LocalAddr().(*net.UDPAddr)
+	buf := make([]byte, 64)
+	for i := 0; i < 3; i++ {
+		tx, err := a.WriteMsg([]byte("ping"), dst)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = b.SetReadDeadline(time.Now().Add(time.Second))
+		n, from, rx, err := b.ReadMsg(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(buf[:n]) != "ping" || from.Port != a.LocalAddr().(*net.UDPAddr).Port {
+			t.Fatalf("got %q from %v", buf[:n], from)
+		}
+		if tx.Type != a.TXType() || rx.Type != b.RXType() {
+			t.Errorf("packet %d: tx=%s rx=%s, socket tx=%s rx=%s", i, tx.Type, rx.Type, a.TXType(), b.RXType())
+		}
+		near(t, "tx", tx)
+		near(t, "rx", rx)
+		// Метка приёма не раньше метки передачи (одни и те же часы ядра)
+		if tx.Type == Kernel && rx.Type == Kernel && rx.Time.Before(tx.Time) {
+			t.Errorf("rx %v before tx %v", rx.Time, tx.Time)
+		}
+	}
+}
+
+func TestConn_Software(t *testing.T) {
+	// Сокет без включения меток — только time.Now()
+	c := &Conn{conn: listen(t), oob: make([]byte, 64)}
+	dst := listen(t)
+	ts, err := c.WriteMsg([]byte("x"), dst.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ts.Type != Software {
+		t.Errorf("type %s, want software", ts.Type)
+	}
+	near(t, "tx", ts)
+}
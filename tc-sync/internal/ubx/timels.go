@@ -0,0 +1,67 @@
+package ubx
+
+import (
+	"encoding/binary"
+	"time"
+)
+
+// NAV-TIMELS: информация о секунде координации (leap second)
+const (
+	IDNAVTIMELS   = 0x26
+	NAVTIMELSSize = 24
+)
+
+// NAV-TIMELS offsets в payload
+const (
+	navTimeLSCurrLs        = 9  // int8: текущее число leap seconds (GPS-UTC)
+	navTimeLSLsChange      = 11 // int8: предстоящее изменение (-1, 0, +1)
+	navTimeLSTimeToLsEvent = 12 // int32: секунд до события (конец суток UTC)
+	navTimeLSValid         = 23 // uint8: bit0 validCurrLs, bit1 validTimeToLsEvent
+)
+
+// Valid flags NAV-TIMELS
+const (
+	NavTimeLSValidCurrLs        = 1 << 0
+	NavTimeLSValidTimeToLsEvent = 1 << 1
+)
+
+// TimeLS — данные UBX-NAV-TIMELS
+type TimeLS struct {
+	CurrLs        int8          // GPS-UTC, секунд
+	LsChange      int8          // +1 — секунда будет вставлена, -1 — удалена, 0 — события нет
+	TimeToLsEvent time.Duration // время до события (может быть отрицательным — событие прошло)
+	ValidCurrLs   bool
+	ValidEvent    bool // TimeToLsEvent и LsChange достоверны
+}
+
+// ParseNAVTIMELS парсит payload UBX-NAV-TIMELS (24 байта)
+func ParseNAVTIMELS(payload []byte) (TimeLS, bool) {
+	if len(payload) < NAVTIMELSSize {
+		return TimeLS{}, false
+	}
+	valid := payload[navTimeLSValid]
+	ls := TimeLS{
+		CurrLs:      int8(payload[navTimeLSCurrLs]),
+		LsChange:    int8(payload[navTimeLSLsChange]),
+		ValidCurrLs: valid&NavTimeLSValidCurrLs != 0,
+		ValidEvent:  valid&NavTimeLSValidTimeToLsEvent != 0,
+	}
+	ls.TimeToLsEvent = time.Duration(int32(binary.LittleEndian.Uint32(payload[navTimeLSTimeToLsEvent:]))) * time.Second
+	return ls, true
+}
+
+// IsNAVTIMELSPacket возвращает true, если пакет — UBX-NAV-TIMELS (class 0x01, id 0x26).
+func IsNAVTIMELSPacket(packet []byte) bool {
+	if len(packet) < 8+NAVTIMELSSize {
+		return false
+	}
+	if packet[0] != Sync1 || packet[1] != Sync2 {
+		return false
+	}
+	return packet[2] == ClassNAV && packet[3] == IDNAVTIMELS
+}
+
+// BuildNAVTIMELSPoll собирает запрос (poll) NAV-TIMELS — пакет с пустым payload
+func BuildNAVTIMELSPoll() []byte {
+	return EncodePacket(ClassNAV, IDNAVTIMELS, nil)
+}
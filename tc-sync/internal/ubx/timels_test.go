@@ -0,0 +1,44 @@
+package ubx
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+func TestParseNAVTIMELS(t *testing.T) {
+	p := make([]byte, NAVTIMELSSize)
+	p[navTimeLSCurrLs] = 18
+	p[navTimeLSLsChange] = 1
+	binary.LittleEndian.PutUint32(p[navTimeLSTimeToLsEvent:], uint32(3600))
+	p[navTimeLSValid] = NavTimeLSValidCurrLs | NavTimeLSValidTimeToLsEvent
+
+	ls, ok := ParseNAVTIMELS(p)
+	if !ok {
+		t.Fatal("expected ok")
+	}
+	if ls.CurrLs != 18 || ls.LsChange != 1 || ls.TimeToLsEvent != time.Hour || !ls.ValidCurrLs || !ls.ValidEvent {
+		t.Errorf("got %+v", ls)
+	}
+
+	// Отрицательное время до события и удаление секунды
+	p[navTimeLSLsChange] = 0xff
+	binary.LittleEndian.PutUint32(p[navTimeLSTimeToLsEvent:], uint32(0xffffffff))
+	p[navTimeLSValid] = 0
+	ls, _ = ParseNAVTIMELS(p)
+	if ls.LsChange != -1 || ls.TimeToLsEvent != -time.Second || ls.ValidEvent {
+		t.Errorf("got %+v", ls)
+	}
+
+	if _, ok := ParseNAVTIMELS(p[:10]); ok {
+		t.Error("expected !ok for short payload")
+	}
+
+	packet := EncodePacket(ClassNAV, IDNAVTIMELS, p)
+	if !IsNAVTIMELSPacket(packet) {
+		t.Error("IsNAVTIMELSPacket: expected true")
+	}
+	if IsNAVTIMELSPacket(BuildNAVTIMELSPoll()) {
+		t.Error("poll request is not a NAV-TIMELS report")
+	}
+}
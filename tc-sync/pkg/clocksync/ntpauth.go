@@ -0,0 +1,63 @@
+package clocksync
+
+import (
+	"fmt"
+
+	"github.com/shiwa/timecard-mini/tc-sync/internal/ntp"
+	"github.com/shiwa/timecard-mini/tc-sync/internal/source"
+	pkgconfig "github.com/shiwa/timecard-mini/tc-sync/pkg/config"
+)
+
+// keyedSource — источник, запросы которого подписываются симметричным ключом NTP (ntp, ntp_pool)
+type keyedSource interface {
+	SetKey(k *ntp.Key)
+}
+
+// loadNTPKeys читает clock_sync.ntp_keys; пустой путь — ключей нет
+func loadNTPKeys(path string) (ntp.Keys, error) {
+	if path == "" {
+		return nil, nil
+	}
+	return ntp.LoadKeys(path)
+}
+
+// applyNTPKey задаёт источнику ключ key_id из keys файла. Источник с key_id, для которого
+// ключ не найден, не используется: неаутентифицированная синхронизация недопустима.
+func applyNTPKey(s source.TimeSource, keyID uint32, keys ntp.Keys) error {
+	if keyID == 0 {
+		return nil
+	}
+	ks, ok := s.(keyedSource)
+	if !ok {
+		return fmt.Errorf("key_id is supported only for ntp and ntp_pool")
+	}
+	k := keys[keyID]
+	if k == nil {
+		return fmt.Errorf("key_id %d not found in ntp_keys", keyID)
+	}
+	ks.SetKey(k)
+	return nil
+}
+
+// configureNTPServer задаёт серверу ключи, списки доступа и ограничение частоты запросов
+func configureNTPServer(srv *ntp.Server, ns *pkgconfig.NTPServerConfig, keys ntp.Keys) error {
+	if ns.RequireAuth && len(keys) == 0 {
+		return fmt.Errorf("require_auth is set but clock_sync.ntp_keys has no keys")
+	}
+	access, err := ntp.ParseAccessList(ns.Allow, ns.Deny)
+	if err != nil {
+		return err
+	}
+	srv.Interleaved = ns.Interleaved
+	srv.Keys = keys
+	srv.RequireAuth = ns.RequireAuth
+	srv.Access = access
+	srv.RateLimit = ntp.RateLimit{Interval: parseInterval(ns.RateLimit, 0), Burst: ns.RateBurst}
+	return nil
+}
+
+// ntpServerStatsString — счётчики сервера для лога
+func ntpServerStatsString(st ntp.ServerStats) string {
+	return fmt.Sprintf("received=%d sent=%d denied=%d rate_limited=%d kod=%d unauthenticated=%d auth_failed=%d malformed=%d",
+		st.Received, st.Sent, st.Denied, st.RateLimited, st.KoD, st.Unauthenticated, st.AuthFailed, st.Malformed)
+}
@@ -0,0 +1,75 @@
+package clocksync
+
+import (
+	"time"
+
+	"github.com/shiwa/timecard-mini/tc-sync/internal/ntp"
+	"github.com/shiwa/timecard-mini/tc-sync/internal/servo"
+	"github.com/shiwa/timecard-mini/tc-sync/internal/source"
+)
+
+// defaultHoldoverLimit — сколько NTP сервер отдаёт время без пригодного источника,
+// прежде чем объявить часы несинхронизированными
+const defaultHoldoverLimit = time.Hour
+
+// ntpServerState обновляет ClockQuality встроенного NTP сервера по качеству из qualityTracker:
+// stratum/refid — от активного источника, root delay/dispersion — от измерения, поданного в servo,
+// leap — от источника с информацией о секунде координации (GNSS).
+type ntpServerState struct {
+	srv           *ntp.Server
+	holdoverLimit time.Duration
+}
+
+func newNTPServerState(srv *ntp.Server, holdoverLimit time.Duration) *ntpServerState {
+	if holdoverLimit <= 0 {
+		holdoverLimit = defaultHoldoverLimit
+	}
+	return &ntpServerState{srv: srv, holdoverLimit: holdoverLimit}
+}
+
+// apply обновляет состояние сервера по качеству часов q. Без источника, пока не истёк собственный
+// holdover_limit сервера, отдаётся последнее состояние (root dispersion растёт со временем);
+// затем — leap=3, stratum 16.
+func (st *ntpServerState) apply(q servo.Quality) {
+	if st == nil {
+		return
+	}
+	if q.State != servo.StateLocked {
+		if q.ReferenceTime.IsZero() || q.Time.Sub(q.ReferenceTime) > st.holdoverLimit {
+			st.srv.UpdateClockQuality(ntp.Unsynchronized())
+		}
+		return
+	}
+	leap := ntp.LeapNone
+	switch {
+	case q.Leap > 0:
+		leap = ntp.LeapInsert
+	case q.Leap < 0:
+		leap = ntp.LeapDelete
+	}
+	st.srv.UpdateClockQuality(ntp.ClockQuality{
+		Leap:           leap,
+		Stratum:        q.Stratum,
+		ReferenceID:    q.ReferenceID,
+		ReferenceTime:  q.ReferenceTime,
+		RootDelay:      q.RootDelay,
+		RootDispersion: q.RootDispersion,
+		Precision:      ntp.Unsynchronized().Precision,
+	})
+}
+
+// leapSecond — предстоящая секунда координации (+1/-1, 0 — нет): от активного источника,
+// иначе от любого источника, который её знает
+func leapSecond(active source.TimeSource, sources []source.TimeSource) int {
+	candidates := append([]source.TimeSource{active}, sources...)
+	for _, s := range candidates {
+		ls, ok := s.(source.LeapSource)
+		if !ok {
+			continue
+		}
+		if change, ok := ls.LeapSecond(); ok {
+			return change
+		}
+	}
+	return 0
+}
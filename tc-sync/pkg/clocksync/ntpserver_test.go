@@ -0,0 +1,102 @@
+package clocksync
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/shiwa/timecard-mini/tc-sync/internal/ntp"
+	"github.com/shiwa/timecard-mini/tc-sync/internal/source"
+	pkgconfig "github.com/shiwa/timecard-mini/tc-sync/pkg/config"
+)
+
+// refSource — источник с Reference и LeapSource (как GNSS)
+type refSource struct {
+	proto   string
+	stratum uint8
+	refID   string
+	leap    int
+	hasLeap bool
+}
+
+func (r *refSource) Name() string                        { return r.proto }
+func (r *refSource) Protocol() string                    { return r.proto }
+func (r *refSource) GetTime() (time.Time, source.Status) { return time.Now(), source.StatusLocked }
+func (r *refSource) Close() error                        { return nil }
+func (r *refSource) Reference() (uint8, uint32)          { return r.stratum, ntp.RefIDFromString(r.refID) }
+func (r *refSource) LeapSecond() (int, bool)             { return r.leap, r.hasLeap }
+
+func TestNTPServerState(t *testing.T) {
+	srv := ntp.NewServer("127.0.0.1:0")
+	gnss := &refSource{proto: "gnss", stratum: 1, refID: "GPS", leap: 1, hasLeap: true}
+	pps := &refSource{proto: "pps", stratum: 1, refID: "PPS"}
+	tr := newQualityTracker(time.Minute, []source.TimeSource{pps, gnss})
+	st := newNTPServerState(srv, time.Minute)
+
+	now := time.Now()
+	st.apply(tr.synced(pps, source.Sample{Offset: -300 * time.Nanosecond, Dispersion: time.Microsecond, Time: now}, now))
+	q := srv.ClockQuality()
+	if q.Stratum != 1 || ntp.RefIDString(q.ReferenceID) != "PPS" {
+		t.Errorf("stratum=%d refid=%q", q.Stratum, ntp.RefIDString(q.ReferenceID))
+	}
+	// PPS не знает о leap second — берётся от GNSS
+	if q.Leap != ntp.LeapInsert {
+		t.Errorf("leap = %d, want insert", q.Leap)
+	}
+	if q.RootDispersion != time.Microsecond+300*time.Nanosecond {
+		t.Errorf("root dispersion %v", q.RootDispersion)
+	}
+
+	// Holdover в пределах лимита — состояние сохраняется
+	st.apply(tr.holdover(now.Add(30 * time.Second)))
+	if q := srv.ClockQuality(); q.Stratum != 1 {
+		t.Errorf("within holdover: stratum %d", q.Stratum)
+	}
+	// За пределами лимита — несинхронизирован
+	st.apply(tr.holdover(now.Add(2 * time.Minute)))
+	if q := srv.ClockQuality(); q.Leap != ntp.LeapNotInSync || q.Stratum != ntp.MaxStratum {
+		t.Errorf("beyond holdover: leap=%d stratum=%d", q.Leap, q.Stratum)
+	}
+
+	// NTP upstream: stratum сервера + 1, root delay накапливается, leap — от активного источника
+	up := &refSource{proto: "ntp", stratum: 3, refID: "\x0a\x00\x00\x01", hasLeap: true}
+	st.apply(tr.synced(up, source.Sample{Delay: time.Millisecond, RootDelay: 4 * time.Millisecond, Time: now}, now))
+	q = srv.ClockQuality()
+	if q.Stratum != 3 || q.RootDelay != 5*time.Millisecond || q.Leap != ntp.LeapNone {
+		t.Errorf("ntp upstream: stratum=%d root delay=%v leap=%d", q.Stratum, q.RootDelay, q.Leap)
+	}
+}
+
+func TestApplyNTPKey(t *testing.T) {
+	keys := ntp.Keys{1: {ID: 1, Type: ntp.KeyMD5, Secret: []byte("k")}}
+	n := source.NewNTP("127.0.0.1", time.Second, 0)
+	if err := applyNTPKey(n, 1, keys); err != nil {
+		t.Error(err)
+	}
+	if err := applyNTPKey(n, 2, keys); err == nil {
+		t.Error("missing key accepted")
+	}
+	if err := applyNTPKey(&refSource{proto: "gnss"}, 1, keys); err == nil {
+		t.Error("key_id accepted for gnss")
+	}
+	if err := applyNTPKey(&refSource{proto: "gnss"}, 0, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestConfigureNTPServer(t *testing.T) {
+	srv := ntp.NewServer("127.0.0.1:0")
+	ns := &pkgconfig.NTPServerConfig{Allow: []string{"10.0.0.0/8"}, RateLimit: "2s", RateBurst: 4}
+	if err := configureNTPServer(srv, ns, nil); err != nil {
+		t.Fatal(err)
+	}
+	if srv.RateLimit != (ntp.RateLimit{Interval: 2 * time.Second, Burst: 4}) || srv.Access.Permit(net.ParseIP("192.0.2.1")) {
+		t.Errorf("rate=%+v access=%+v", srv.RateLimit, srv.Access)
+	}
+	if err := configureNTPServer(srv, &pkgconfig.NTPServerConfig{RequireAuth: true}, nil); err == nil {
+		t.Error("require_auth without keys accepted")
+	}
+	if err := configureNTPServer(srv, &pkgconfig.NTPServerConfig{Deny: []string{"bad"}}, nil); err == nil {
+		t.Error("bad deny entry accepted")
+	}
+}
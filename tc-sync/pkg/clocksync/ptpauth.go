@@ -0,0 +1,62 @@
+package clocksync
+
+import (
+	"fmt"
+
+	"github.com/shiwa/timecard-mini/tc-sync/internal/ptp"
+	pkgconfig "github.com/shiwa/timecard-mini/tc-sync/pkg/config"
+)
+
+// Значения advanced.ptp_tuning.ptp_standard
+const (
+	ptpStandard2008 = "1588-2008"
+	ptpStandard2019 = "1588-2019"
+)
+
+// ptpStandard — advanced.ptp_tuning.ptp_standard; пусто — 1588-2008
+func ptpStandard(cs *pkgconfig.ClockSyncConfig) (string, error) {
+	if cs.Advanced == nil || cs.Advanced.PTPTuning.PTPStandard == "" {
+		return ptpStandard2008, nil
+	}
+	switch s := cs.Advanced.PTPTuning.PTPStandard; s {
+	case ptpStandard2008, ptpStandard2019:
+		return s, nil
+	default:
+		return "", fmt.Errorf("ptp_standard %q: want %s or %s", s, ptpStandard2008, ptpStandard2019)
+	}
+}
+
+// ptpSecurity загружает ключи authentication записи ptp; nil — запись без аутентификации. Запись
+// с authentication, для которой ключи не загружены, не используется: неаутентифицированная
+// синхронизация недопустима.
+func ptpSecurity(cs *pkgconfig.ClockSyncConfig, c pkgconfig.ClockSource) (*ptp.SecurityAssociation, error) {
+	a := c.Authentication
+	if a == nil {
+		return nil, nil
+	}
+	if c.Protocol != "ptp" || !c.Native && !isPTPServer(c) {
+		return nil, fmt.Errorf("authentication is supported only for native ptp sources and ptp servers")
+	}
+	std, err := ptpStandard(cs)
+	if err != nil {
+		return nil, err
+	}
+	if std != ptpStandard2019 {
+		return nil, fmt.Errorf("authentication requires ptp_standard %s", ptpStandard2019)
+	}
+	if a.SPP < 0 || a.SPP > 255 {
+		return nil, fmt.Errorf("authentication spp %d out of range 0..255", a.SPP)
+	}
+	if a.Keys == "" {
+		return nil, fmt.Errorf("authentication keys file is not set")
+	}
+	keys, err := ptp.LoadSecurityKeys(a.Keys)
+	if err != nil {
+		return nil, fmt.Errorf("authentication keys: %w", err)
+	}
+	sa := &ptp.SecurityAssociation{SPP: uint8(a.SPP), Keys: keys}
+	if err := sa.Validate(); err != nil {
+		return nil, fmt.Errorf("authentication keys %s: %w", a.Keys, err)
+	}
+	return sa, nil
+}
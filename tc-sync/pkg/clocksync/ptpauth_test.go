@@ -0,0 +1,52 @@
+package clocksync
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	pkgconfig "github.com/shiwa/timecard-mini/tc-sync/pkg/config"
+)
+
+func TestPTPSecurity(t *testing.T) {
+	keys := filepath.Join(t.TempDir(), "ptp.keys")
+	if err := os.WriteFile(keys, []byte("1 HMAC-SHA256 HEX:000102030405060708090a0b0c0d0e0f\n2 HMAC-SHA256-128 ASCII:0123456789abcdef 2026-01-01T00:00:00Z\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	std2019 := &pkgconfig.ClockSyncConfig{Advanced: &pkgconfig.AdvancedConfig{PTPTuning: pkgconfig.PTPTuningConfig{PTPStandard: "1588-2019"}}}
+	slave := pkgconfig.ClockSource{Protocol: "ptp", Native: true, Authentication: &pkgconfig.PTPAuthConfig{SPP: 2, Keys: keys}}
+	server := pkgconfig.ClockSource{Protocol: "ptp", ServerOnly: true, Authentication: slave.Authentication}
+
+	if sa, err := ptpSecurity(std2019, pkgconfig.ClockSource{Protocol: "ptp", Native: true}); sa != nil || err != nil {
+		t.Errorf("without authentication: %v, %v", sa, err)
+	}
+	for _, c := range []pkgconfig.ClockSource{slave, server} {
+		sa, err := ptpSecurity(std2019, c)
+		if err != nil || sa == nil || sa.SPP != 2 || len(sa.Keys) != 2 {
+			t.Errorf("security association %+v, %v", sa, err)
+		}
+	}
+
+	if std, err := ptpStandard(&pkgconfig.ClockSyncConfig{}); std != ptpStandard2008 || err != nil {
+		t.Errorf("default standard %q, %v", std, err)
+	}
+	std2008 := &pkgconfig.ClockSyncConfig{Advanced: &pkgconfig.AdvancedConfig{PTPTuning: pkgconfig.PTPTuningConfig{PTPStandard: "1588-2008"}}}
+	stdBad := &pkgconfig.ClockSyncConfig{Advanced: &pkgconfig.AdvancedConfig{PTPTuning: pkgconfig.PTPTuningConfig{PTPStandard: "802.1AS"}}}
+	for name, c := range map[string]struct {
+		cs  *pkgconfig.ClockSyncConfig
+		src pkgconfig.ClockSource
+	}{
+		"1588-2008":    {std2008, slave},
+		"default":      {&pkgconfig.ClockSyncConfig{}, slave},
+		"bad standard": {stdBad, slave},
+		"ptp4l":        {std2019, pkgconfig.ClockSource{Protocol: "ptp", Authentication: slave.Authentication}},
+		"ntp":          {std2019, pkgconfig.ClockSource{Protocol: "ntp", Authentication: slave.Authentication}},
+		"spp":          {std2019, pkgconfig.ClockSource{Protocol: "ptp", Native: true, Authentication: &pkgconfig.PTPAuthConfig{SPP: 256, Keys: keys}}},
+		"no keys":      {std2019, pkgconfig.ClockSource{Protocol: "ptp", Native: true, Authentication: &pkgconfig.PTPAuthConfig{}}},
+		"missing file": {std2019, pkgconfig.ClockSource{Protocol: "ptp", Native: true, Authentication: &pkgconfig.PTPAuthConfig{Keys: keys + ".missing"}}},
+	} {
+		if _, err := ptpSecurity(c.cs, c.src); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package clocksync
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/shiwa/timecard-mini/tc-sync/internal/clockselect"
+	"github.com/shiwa/timecard-mini/tc-sync/internal/logger"
+	"github.com/shiwa/timecard-mini/tc-sync/internal/ptp"
+	"github.com/shiwa/timecard-mini/tc-sync/internal/source"
+	pkgconfig "github.com/shiwa/timecard-mini/tc-sync/pkg/config"
+)
+
+// discoveryKey — домен, обнаруженный на интерфейсе
+type discoveryKey struct {
+	transport string // udp или udp6
+	iface     string
+	domain    uint8
+}
+
+// discoveredSource — динамический источник автообнаружения
+type discoveredSource struct {
+	key    discoveryKey
+	src    *source.NativePTP
+	master ptp.DiscoveredDomain // последнее состояние домена
+}
+
+// ptpDiscovery — автообнаружение мастеров PTP (advanced.ptp_tuning.auto_discover_enabled): на
+// интерфейсах записей ptp принимаются Announce multicast, для домена без записи в конфиге создаётся
+// динамический secondary источник (native slave), который участвует в обычном выборе источника.
+// Когда Announce домена прекращаются, источник удаляется из выбора и закрывается.
+type ptpDiscovery struct {
+	election  *clockselect.Election
+	explicit  map[int]bool                    // домены записей ptp конфига
+	opts      source.Options                  // relax_delay_requests и сокеты динамических источников
+	listeners map[discoveryKey]*ptp.Discovery // без domain
+	closers   []ptp.Transport
+
+	mu      sync.Mutex
+	sources map[discoveryKey]*discoveredSource
+}
+
+// autoDiscoverEnabled — advanced.ptp_tuning.auto_discover_enabled
+func autoDiscoverEnabled(cs *pkgconfig.ClockSyncConfig) bool {
+	return cs.Advanced != nil && cs.Advanced.PTPTuning.AutoDiscoverEnabled
+}
+
+// ptpDomain — домен записи ptp с учётом профиля (нулевой domain — домен профиля)
+func ptpDomain(c pkgconfig.ClockSource) int {
+	if c.Domain == 0 {
+		if p, err := ptp.LookupProfile(c.Profile); err == nil && p != nil {
+			return p.Domain
+		}
+	}
+	return c.Domain
+}
+
+// startPTPDiscovery слушает Announce на интерфейсах записей ptp (без interface — eth0)
+func startPTPDiscovery(ctx context.Context, cs *pkgconfig.ClockSyncConfig, election *clockselect.Election, opts source.Options) *ptpDiscovery {
+	d := &ptpDiscovery{
+		election:  election,
+		explicit:  make(map[int]bool),
+		opts:      opts,
+		listeners: make(map[discoveryKey]*ptp.Discovery),
+		sources:   make(map[discoveryKey]*discoveredSource),
+	}
+	for _, c := range append(append([]pkgconfig.ClockSource(nil), cs.PrimaryClocks...), cs.SecondaryClocks...) {
+		if c.Protocol != "ptp" || c.Disable {
+			continue
+		}
+		d.explicit[ptpDomain(c)] = true
+		iface := c.Interface
+		if iface == "" {
+			iface = "eth0"
+		}
+		lk := discoveryKey{transport: ptp.TransportUDP, iface: iface}
+		if ptpTransport(c) == ptp.TransportUDP6 {
+			lk.transport = ptp.TransportUDP6
+		}
+		if d.listeners[lk] != nil {
+			continue
+		}
+		tr, err := ptp.OpenTransport(ptp.PortOptions{Transport: lk.transport, Multicast: true, Socket: opts.Socket}, iface)
+		if err != nil {
+			logger.Error("ptp auto discover %s %s: %v", lk.transport, iface, err)
+			continue
+		}
+		l := ptp.NewDiscovery(tr, ptp.DefaultClockIdentity(iface))
+		d.listeners[lk] = l
+		d.closers = append(d.closers, tr)
+		go l.Run(ctx)
+		logger.Info("ptp auto discover: listening for Announce on %s (%s)", iface, lk.transport)
+	}
+	return d
+}
+
+// update создаёт источники для новых доменов и удаляет источники доменов без Announce
+func (d *ptpDiscovery) update(now time.Time) {
+	if d == nil {
+		return
+	}
+	seen := make(map[discoveryKey]bool)
+	for lk, l := range d.listeners {
+		for _, dom := range l.Domains(now) {
+			if d.explicit[int(dom.Domain)] {
+				continue
+			}
+			key := discoveryKey{lk.transport, lk.iface, dom.Domain}
+			seen[key] = true
+			d.mu.Lock()
+			ds := d.sources[key]
+			if ds != nil {
+				ds.master = dom
+			}
+			d.mu.Unlock()
+			if ds != nil {
+				continue
+			}
+			src, err := source.NewNativePTP(source.NativePTPOptions{Domain: int(dom.Domain), Interface: lk.iface, Transport: lk.transport, RelaxDelayRequests: d.opts.RelaxDelayRequests, Socket: d.opts.Socket})
+			if err != nil {
+				logger.Error("ptp auto discover: domain %d on %s: %v", dom.Domain, lk.iface, err)
+				continue
+			}
+			d.mu.Lock()
+			d.sources[key] = &discoveredSource{key: key, src: src, master: dom}
+			d.mu.Unlock()
+			d.election.AddSecondary(src)
+			logger.Info("ptp auto discover: %s, grandmaster %s", src.Name(), dom.Best.GrandmasterIdentity)
+		}
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for key, ds := range d.sources {
+		if seen[key] {
+			continue
+		}
+		d.election.RemoveSecondary(ds.src)
+		_ = ds.src.Close()
+		delete(d.sources, key)
+		logger.Info("ptp auto discover: %s expired (no Announce)", ds.src.Name())
+	}
+}
+
+// discovered возвращает обнаруженные источники по интерфейсу и домену
+func (d *ptpDiscovery) discovered() []discoveredSource {
+	if d == nil {
+		return nil
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	out := make([]discoveredSource, 0, len(d.sources))
+	for _, ds := range d.sources {
+		out = append(out, *ds)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].key.transport != out[j].key.transport {
+			return out[i].key.transport < out[j].key.transport
+		}
+		if out[i].key.iface != out[j].key.iface {
+			return out[i].key.iface < out[j].key.iface
+		}
+		return out[i].key.domain < out[j].key.domain
+	})
+	return out
+}
+
+// Close закрывает обнаруженные источники и транспорты обнаружения
+func (d *ptpDiscovery) Close() {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	for key, ds := range d.sources {
+		_ = ds.src.Close()
+		delete(d.sources, key)
+	}
+	d.mu.Unlock()
+	for _, tr := range d.closers {
+		_ = tr.Close()
+	}
+}
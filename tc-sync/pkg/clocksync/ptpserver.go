@@ -0,0 +1,316 @@
+package clocksync
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/shiwa/timecard-mini/tc-sync/internal/logger"
+	"github.com/shiwa/timecard-mini/tc-sync/internal/ptp"
+	"github.com/shiwa/timecard-mini/tc-sync/internal/servo"
+	"github.com/shiwa/timecard-mini/tc-sync/internal/timestamping"
+	pkgconfig "github.com/shiwa/timecard-mini/tc-sync/pkg/config"
+)
+
+// isPTPServer возвращает true для записи ptp, описывающей сервер (grandmaster), а не источник
+func isPTPServer(c pkgconfig.ClockSource) bool {
+	return c.Protocol == "ptp" && (c.ServerOnly || c.ServeUnicast || c.ServeMulticast)
+}
+
+// ptpTransport — транспорт записи; use_layer2 (как в shiwatime) — то же, что transport: l2
+func ptpTransport(c pkgconfig.ClockSource) string {
+	if c.Transport == "" && c.UseLayer2 {
+		return ptp.TransportL2
+	}
+	return c.Transport
+}
+
+// ptpSocketOptions — параметры сокетов PTP из advanced.ptp_tuning
+func ptpSocketOptions(cs *pkgconfig.ClockSyncConfig) (ptp.SocketOptions, error) {
+	var so ptp.SocketOptions
+	if cs.Advanced == nil {
+		return so, nil
+	}
+	t := cs.Advanced.PTPTuning
+	if t.MulticastTTL < 0 || t.MulticastTTL > 255 {
+		return so, fmt.Errorf("multicast_ttl %d out of range 0..255", t.MulticastTTL)
+	}
+	var err error
+	if so.DSCPGeneral, err = ptp.ParseDSCP(t.DSCPGeneral); err != nil {
+		return so, fmt.Errorf("dscp.general: %w", err)
+	}
+	if so.DSCPEvent, err = ptp.ParseDSCP(t.DSCPEvent); err != nil {
+		return so, fmt.Errorf("dscp.event: %w", err)
+	}
+	so.MulticastTTL, so.GlobalSockets = t.MulticastTTL, t.EnablePTPGlobalSockets
+	return so, nil
+}
+
+// ptpTxSpacing — интервалы между отправками серверов по интерфейсам из advanced.ptp_tuning.synchronise_tx
+// ("ens1:5us")
+func ptpTxSpacing(cs *pkgconfig.ClockSyncConfig) (map[string]time.Duration, error) {
+	if cs.Advanced == nil || len(cs.Advanced.PTPTuning.SynchroniseTX) == 0 {
+		return nil, nil
+	}
+	out := make(map[string]time.Duration)
+	for _, e := range cs.Advanced.PTPTuning.SynchroniseTX {
+		i := strings.LastIndex(e, ":")
+		if i <= 0 {
+			return nil, fmt.Errorf("synchronise_tx %q: want <interface>:<delay>", e)
+		}
+		gap, err := time.ParseDuration(strings.TrimSpace(e[i+1:]))
+		if err != nil {
+			return nil, fmt.Errorf("synchronise_tx %q: %w", e, err)
+		}
+		if gap <= 0 || gap > time.Second {
+			return nil, fmt.Errorf("synchronise_tx %q: delay out of range (0, 1s]", e)
+		}
+		out[strings.TrimSpace(e[:i])] = gap
+	}
+	return out, nil
+}
+
+// ptpDelayMechanism — механизм задержки записи: delay_mechanism (как в ptp4l) или delay_strategy
+func ptpDelayMechanism(c pkgconfig.ClockSource) string {
+	if c.DelayMechanism != "" {
+		return c.DelayMechanism
+	}
+	return c.DelayStrategy
+}
+
+// ptpMasterConfig строит параметры порта master и транспорта из записи конфига с учётом профиля.
+// Без serve_unicast и serve_multicast сервер работает в multicast.
+func ptpMasterConfig(c pkgconfig.ClockSource) (ptp.MasterConfig, ptp.PortOptions, error) {
+	if c.MaxUnicastSubscribers < 0 {
+		return ptp.MasterConfig{}, ptp.PortOptions{}, fmt.Errorf("max_unicast_subscribers %d is negative", c.MaxUnicastSubscribers)
+	}
+	if c.MaxPacketsPerSecond < 0 {
+		return ptp.MasterConfig{}, ptp.PortOptions{}, fmt.Errorf("max_packets_per_second %d is negative", c.MaxPacketsPerSecond)
+	}
+	if c.NeighborPropDelayThresh < 0 {
+		return ptp.MasterConfig{}, ptp.PortOptions{}, fmt.Errorf("neighbor_prop_delay_thresh %d is negative", c.NeighborPropDelayThresh)
+	}
+	o, profile, err := ptp.ApplyProfile(c.Profile, ptp.PortOptions{
+		Transport:            ptpTransport(c),
+		DelayMechanism:       ptpDelayMechanism(c),
+		Domain:               c.Domain,
+		AnnounceInterval:     c.AnnounceInterval,
+		SyncInterval:         c.SyncInterval,
+		DelayRequestInterval: c.DelayRequestInterval,
+		Priority1:            c.Priority1,
+		Priority2:            c.Priority2,
+		Multicast:            c.ServeMulticast || !c.ServeUnicast,
+		Unicast:              c.ServeUnicast,
+	})
+	if err != nil {
+		return ptp.MasterConfig{}, o, err
+	}
+	cfg := ptp.MasterConfig{
+		Domain:                 uint8(o.Domain),
+		Priority1:              uint8(o.Priority1),
+		Priority2:              uint8(o.Priority2),
+		LogAnnounceInterval:    int8(o.AnnounceInterval),
+		LogSyncInterval:        int8(o.SyncInterval),
+		LogMinDelayReqInterval: int8(o.DelayRequestInterval),
+		Multicast:              o.Multicast,
+		Unicast:                o.Unicast,
+		ServerOnly:             c.ServerOnly,
+		MaxUnicastSubscribers:  c.MaxUnicastSubscribers,
+		MaxPacketsPerSecond:    c.MaxPacketsPerSecond,
+	}
+	if o.DelayMechanism == ptp.DelayP2P {
+		// P2P: delayrequest_interval — logMinPdelayReqInterval порта
+		cfg.DelayMechanism = o.DelayMechanism
+		cfg.PeerDelay = ptp.PeerDelayConfig{
+			LogInterval:             int8(o.DelayRequestInterval),
+			GPTP:                    profile != nil && profile.GPTP,
+			NeighborPropDelayThresh: time.Duration(c.NeighborPropDelayThresh),
+		}
+	}
+	if profile != nil {
+		cfg.BMCA, cfg.ClockClasses = profile.BMCA, profile.ClockClasses
+	}
+	return cfg, o, nil
+}
+
+// ptpServer — порт master на сетевом интерфейсе
+type ptpServer struct {
+	iface  string
+	domain uint8
+	tr     ptp.Transport
+	master *ptp.Master
+	txq    *ptp.TxQueue // synchronise_tx интерфейса; nil — без очереди
+}
+
+// startPTPServer открывает транспорт на интерфейсе записи и запускает порт master до отмены ctx;
+// auth — ключи authentication записи (nil — без аутентификации), so — параметры сокетов
+// (ptp_tuning), queues — очереди передачи интерфейсов (synchronise_tx)
+func startPTPServer(ctx context.Context, c pkgconfig.ClockSource, auth *ptp.SecurityAssociation, so ptp.SocketOptions, queues map[string]*ptp.TxQueue) (*ptpServer, error) {
+	cfg, opts, err := ptpMasterConfig(c)
+	if err != nil {
+		return nil, err
+	}
+	opts.Socket = so
+	iface := c.Interface
+	if iface == "" {
+		iface = "eth0"
+	}
+	tr, err := ptp.OpenTransport(opts, iface)
+	if err != nil {
+		return nil, err
+	}
+	if tr.TimestampType() == timestamping.Hardware {
+		if cfg.PHC, err = ptp.PHCDevice(iface); err != nil {
+			tr.Close()
+			return nil, err
+		}
+	}
+	cfg.Identity = ptp.PortIdentity{Clock: ptp.DefaultClockIdentity(iface), Port: 1}
+	cfg.TxQueue = queues[iface]
+	cfg.Auth = auth
+	s := &ptpServer{iface: iface, domain: cfg.Domain, tr: tr, master: ptp.NewMaster(tr, cfg), txq: cfg.TxQueue}
+	go s.master.Run(ctx)
+	return s, nil
+}
+
+// Close останавливает порт (закрытие транспорта завершает Run) и пишет счётчики сообщений в лог
+func (s *ptpServer) Close() error {
+	st, adm := s.master.Stats(), s.master.Admission()
+	logger.Info("ptp server %s domain %d: in %s; out %s; tx timestamp misses %d of %d, dropped by max_packets_per_second %d, unauthenticated %d",
+		s.iface, s.domain, st.In, st.Out, st.TXTimestampMisses, st.TXEvents, adm.Dropped, st.Unauthenticated)
+	return s.tr.Close()
+}
+
+// ptpMasterState обновляет качество часов и свойства шкалы в Announce серверов PTP по качеству из
+// qualityTracker (clock_quality auto) либо объявляет заданные в clock_quality значения.
+// clockClass в режиме auto — по состоянию синхронизации и профилю каждого сервера (ptp.ClockClasses).
+type ptpMasterState struct {
+	masters  []*ptp.Master
+	static   *ptp.TimeProperties // clock_quality без auto
+	variance uint16              // clock_quality.variance; 0 — по вариации Аллана
+}
+
+func newPTPMasterState(masters []*ptp.Master, cq *pkgconfig.ClockQualityConfig) *ptpMasterState {
+	st := &ptpMasterState{masters: masters}
+	if cq != nil {
+		st.variance = uint16(cq.Variance)
+	}
+	if cq != nil && !cq.Auto {
+		tp := ptp.DefaultTimeProperties()
+		tp.Quality = ptp.ClockQuality{Class: uint8(cq.Class), Accuracy: uint8(cq.Accuracy), Variance: st.variance}
+		if st.variance == 0 {
+			tp.Quality.Variance = 0xFFFF
+		}
+		tp.TimeSource = uint8(cq.TimeSource)
+		// clockClass 6/7 — синхронизирован или в удержании: шкала прослеживаема
+		traceable := tp.Quality.Class == ptp.ClockClassPrimary || tp.Quality.Class == ptp.ClockClassHoldover
+		tp.UTCOffsetValid, tp.TimeTraceable, tp.FrequencyTraceable = traceable, traceable, traceable
+		st.static = &tp
+	}
+	st.set(st.initial(), ptp.ClockFreerun)
+	return st
+}
+
+// initial — свойства до первой синхронизации
+func (st *ptpMasterState) initial() ptp.TimeProperties {
+	if st.static != nil {
+		return *st.static
+	}
+	tp := ptp.DefaultTimeProperties()
+	if st.variance != 0 {
+		tp.Quality.Variance = st.variance
+	}
+	return tp
+}
+
+// set объявляет свойства на всех серверах; в режиме auto clockClass — по состоянию и профилю сервера
+func (st *ptpMasterState) set(tp ptp.TimeProperties, state ptp.ClockState) {
+	for _, m := range st.masters {
+		mtp := tp
+		if st.static == nil {
+			mtp.Quality.Class = m.ClockClasses().Class(state)
+		}
+		m.SetTimeProperties(mtp)
+	}
+}
+
+// apply объявляет качество часов q. Синхронизация и удержание в пределах holdover_limit — свойства
+// шкалы последней синхронизации (clockClass удержания — 7); удержание вне спецификации — clockClass
+// профиля для категории (G.8275.x: 140/150/160, по умолчанию 248) со свойствами несинхронизированных
+// часов. С заданным clock_quality меняются только флаги секунды координации.
+func (st *ptpMasterState) apply(q servo.Quality) {
+	if st == nil {
+		return
+	}
+	if st.static != nil {
+		if q.State == servo.StateLocked {
+			tp := *st.static
+			tp.Leap61, tp.Leap59 = q.Leap > 0, q.Leap < 0
+			st.set(tp, ptp.ClockLocked)
+		}
+		return
+	}
+	switch q.State {
+	case servo.StateFreerun:
+		st.set(st.initial(), ptp.ClockFreerun)
+		return
+	case servo.StateDegraded:
+		state := ptp.ClockDegraded
+		switch q.Category {
+		case 2:
+			state = ptp.ClockDegraded2
+		case 3:
+			state = ptp.ClockDegraded3
+		}
+		if q.Class == ptp.ClockClassDefault {
+			// Удержание после источника, не бывшего первичным эталоном
+			state = ptp.ClockFreerun
+		}
+		st.set(st.initial(), state)
+		return
+	}
+	tp := ptp.TimeProperties{
+		Quality:            ptp.ClockQuality{Accuracy: q.Accuracy, Variance: q.Variance},
+		TimeSource:         q.TimeSource,
+		CurrentUTCOffset:   ptp.CurrentUTCOffset,
+		UTCOffsetValid:     true,
+		Leap61:             q.Leap > 0,
+		Leap59:             q.Leap < 0,
+		TimeTraceable:      true,
+		FrequencyTraceable: true,
+	}
+	if st.variance != 0 {
+		tp.Quality.Variance = st.variance
+	}
+	state := ptp.ClockLocked
+	if q.State == servo.StateHoldover {
+		state = ptp.ClockHoldover
+	}
+	if q.Class != ptp.ClockClassPrimary && q.Class != ptp.ClockClassHoldover {
+		// Время от PTP или NTP — не первичный эталон: clockClass не синхронизированных часов
+		state = ptp.ClockFreerun
+	}
+	st.set(tp, state)
+}
+
+// ptpTimeSource — timeSource по протоколу активного источника
+func ptpTimeSource(protocol string) uint8 {
+	switch strings.ToLower(protocol) {
+	case "gnss", "timebeat_opentimecard_mini", "nmea", "pps":
+		return ptp.TimeSourceGPS
+	case "ptp":
+		return ptp.TimeSourcePTP
+	case "ntp", "ntp_pool":
+		return ptp.TimeSourceNTP
+	}
+	return ptp.TimeSourceOther
+}
+
+// clockQualityConfig — clock_sync.advanced.ptp_tuning.clock_quality или nil
+func clockQualityConfig(cs *pkgconfig.ClockSyncConfig) *pkgconfig.ClockQualityConfig {
+	if cs.Advanced == nil {
+		return nil
+	}
+	return cs.Advanced.PTPTuning.ClockQuality
+}
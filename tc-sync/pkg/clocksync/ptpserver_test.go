@@ -0,0 +1,137 @@
+package clocksync
+
+import (
+	"testing"
+	"time"
+
+	"github.com/shiwa/timecard-mini/tc-sync/internal/ptp"
+	"github.com/shiwa/timecard-mini/tc-sync/internal/source"
+	pkgconfig "github.com/shiwa/timecard-mini/tc-sync/pkg/config"
+)
+
+func TestPTPMasterConfig(t *testing.T) {
+	if isPTPServer(pkgconfig.ClockSource{Protocol: "ptp", Native: true}) || !isPTPServer(pkgconfig.ClockSource{Protocol: "ptp", ServeUnicast: true}) {
+		t.Error("isPTPServer")
+	}
+	cfg, _, err := ptpMasterConfig(pkgconfig.ClockSource{Protocol: "ptp", Domain: 24, ServerOnly: true, AnnounceInterval: 1, SyncInterval: -4, DelayRequestInterval: -3, Priority2: 100, MaxUnicastSubscribers: 16})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := ptp.MasterConfig{Domain: 24, Priority1: 128, Priority2: 100, LogAnnounceInterval: 1, LogSyncInterval: -4,
+		LogMinDelayReqInterval: -3, Multicast: true, ServerOnly: true, MaxUnicastSubscribers: 16}
+	if cfg != want {
+		t.Errorf("got %+v\nwant %+v", cfg, want)
+	}
+	if cfg, _, _ := ptpMasterConfig(pkgconfig.ClockSource{ServeUnicast: true}); cfg.Multicast || !cfg.Unicast {
+		t.Errorf("serve_unicast only: %+v", cfg)
+	}
+	for _, bad := range []pkgconfig.ClockSource{{Domain: 256}, {Priority1: 300}, {SyncInterval: -8}, {MaxUnicastSubscribers: -1}, {MaxPacketsPerSecond: -1}} {
+		if _, _, err := ptpMasterConfig(bad); err == nil {
+			t.Errorf("%+v: expected error", bad)
+		}
+	}
+	if ptpTransport(pkgconfig.ClockSource{UseLayer2: true}) != ptp.TransportL2 || ptpTransport(pkgconfig.ClockSource{Transport: "udp", UseLayer2: true}) != ptp.TransportUDP {
+		t.Error("ptpTransport: use_layer2")
+	}
+
+	// Профиль: значения по умолчанию, алгоритм выбора мастера и clockClass
+	cfg, opts, err := ptpMasterConfig(pkgconfig.ClockSource{Protocol: "ptp", Profile: "G.8275.1", ServeMulticast: true, Priority2: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Domain != 24 || cfg.LogSyncInterval != -4 || cfg.Priority2 != 10 || cfg.BMCA != ptp.BMCAG8275 || cfg.ClockClasses.Degraded != 140 || opts.Transport != ptp.TransportL2 {
+		t.Errorf("G.8275.1: %+v %+v", cfg, opts)
+	}
+	if _, _, err := ptpMasterConfig(pkgconfig.ClockSource{Protocol: "ptp", Profile: "G.8275.2", ServeMulticast: true}); err == nil {
+		t.Error("G.8275.2 multicast server: expected error")
+	}
+
+	// gPTP: peer delay, 802.1AS; delay_mechanism приоритетнее delay_strategy
+	cfg, _, err = ptpMasterConfig(pkgconfig.ClockSource{Protocol: "ptp", Profile: "gptp", ServeMulticast: true, NeighborPropDelayThresh: 1500})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.DelayMechanism != ptp.DelayP2P || cfg.PeerDelay != (ptp.PeerDelayConfig{GPTP: true, NeighborPropDelayThresh: 1500 * time.Nanosecond}) || cfg.Priority1 != 246 {
+		t.Errorf("gptp: %+v", cfg)
+	}
+	if ptpDelayMechanism(pkgconfig.ClockSource{DelayStrategy: "e2e", DelayMechanism: "p2p"}) != ptp.DelayP2P || ptpDelayMechanism(pkgconfig.ClockSource{DelayStrategy: "p2p"}) != ptp.DelayP2P {
+		t.Error("ptpDelayMechanism")
+	}
+
+	// Сокеты: DSCP по имени или числу, TTL multicast, общие сокеты
+	so, err := ptpSocketOptions(&pkgconfig.ClockSyncConfig{Advanced: &pkgconfig.AdvancedConfig{PTPTuning: pkgconfig.PTPTuningConfig{
+		DSCPGeneral: "46", DSCPEvent: "ef", MulticastTTL: 8, EnablePTPGlobalSockets: true}}})
+	if err != nil || so != (ptp.SocketOptions{DSCPEvent: 46, DSCPGeneral: 46, MulticastTTL: 8, GlobalSockets: true}) {
+		t.Errorf("socket options %+v, %v", so, err)
+	}
+	for _, bad := range []pkgconfig.PTPTuningConfig{{DSCPEvent: "af55"}, {MulticastTTL: 256}} {
+		if _, err := ptpSocketOptions(&pkgconfig.ClockSyncConfig{Advanced: &pkgconfig.AdvancedConfig{PTPTuning: bad}}); err == nil {
+			t.Errorf("%+v: expected error", bad)
+		}
+	}
+
+	// synchronise_tx: интерфейс и интервал (время Go: 5us, 5µs, 1ms)
+	spacing, err := ptpTxSpacing(&pkgconfig.ClockSyncConfig{Advanced: &pkgconfig.AdvancedConfig{PTPTuning: pkgconfig.PTPTuningConfig{
+		SynchroniseTX: []string{"ens1:5us", "ens2:1ms"}}}})
+	if err != nil || len(spacing) != 2 || spacing["ens1"] != 5*time.Microsecond || spacing["ens2"] != time.Millisecond {
+		t.Errorf("synchronise_tx %v, %v", spacing, err)
+	}
+	for _, bad := range []string{"ens1", ":5us", "ens1:5", "ens1:-5us", "ens1:2s"} {
+		if _, err := ptpTxSpacing(&pkgconfig.ClockSyncConfig{Advanced: &pkgconfig.AdvancedConfig{PTPTuning: pkgconfig.PTPTuningConfig{
+			SynchroniseTX: []string{bad}}}}); err == nil {
+			t.Errorf("synchronise_tx %q: expected error", bad)
+		}
+	}
+}
+
+func TestPTPMasterState(t *testing.T) {
+	m := ptp.NewMaster(nil, ptp.MasterConfig{})
+	gnss := &refSource{proto: "gnss", stratum: 1, refID: "GPS", leap: 1, hasLeap: true}
+	tr := newQualityTracker(time.Minute, []source.TimeSource{gnss})
+	st := newPTPMasterState([]*ptp.Master{m}, &pkgconfig.ClockQualityConfig{Auto: true, Variance: 0x4E5D})
+	if tp := m.TimeProperties(); tp.Quality.Class != ptp.ClockClassDefault || tp.TimeTraceable {
+		t.Errorf("before sync: %+v", tp)
+	}
+
+	now := time.Now()
+	st.apply(tr.synced(gnss, source.Sample{Offset: -80 * time.Nanosecond, Time: now}, now))
+	tp := m.TimeProperties()
+	want := ptp.ClockQuality{Class: ptp.ClockClassPrimary, Accuracy: 0x21, Variance: 0x4E5D}
+	if tp.Quality != want || tp.TimeSource != ptp.TimeSourceGPS || !tp.TimeTraceable || !tp.UTCOffsetValid || !tp.Leap61 {
+		t.Errorf("synced: %+v", tp)
+	}
+
+	st.apply(tr.holdover(now.Add(30 * time.Second)))
+	if tp := m.TimeProperties(); tp.Quality.Class != ptp.ClockClassHoldover || tp.Quality.Accuracy != 0x21 {
+		t.Errorf("holdover: %+v", tp)
+	}
+	st.apply(tr.holdover(now.Add(2 * time.Minute)))
+	if tp := m.TimeProperties(); tp.Quality.Class != ptp.ClockClassDefault || tp.TimeTraceable {
+		t.Errorf("beyond holdover: %+v", tp)
+	}
+
+	// NTP stratum 2: прослеживаемо, но не первичный эталон
+	st.apply(tr.synced(&refSource{proto: "ntp", stratum: 2, refID: "NTP"}, source.Sample{Offset: time.Millisecond, Time: now}, now))
+	if tp := m.TimeProperties(); tp.Quality.Class != ptp.ClockClassDefault || tp.TimeSource != ptp.TimeSourceNTP || tp.Quality.Accuracy != 0x29 {
+		t.Errorf("ntp stratum 2: %+v", tp)
+	}
+
+	// Профиль G.8275.1: удержание вне спецификации — clockClass 140
+	telecom := ptp.NewMaster(nil, ptp.MasterConfig{ClockClasses: ptp.ClockClasses{Locked: 6, Holdover: 7, Degraded: 140, Freerun: 248}})
+	tr = newQualityTracker(time.Minute, nil)
+	st = newPTPMasterState([]*ptp.Master{telecom}, &pkgconfig.ClockQualityConfig{Auto: true})
+	st.apply(tr.synced(gnss, source.Sample{Time: now}, now))
+	st.apply(tr.holdover(now.Add(2 * time.Minute)))
+	if tp := telecom.TimeProperties(); tp.Quality.Class != 140 || tp.TimeTraceable {
+		t.Errorf("G.8275.1 beyond holdover: %+v", tp)
+	}
+
+	// clock_quality без auto — заданные значения независимо от источника
+	tr = newQualityTracker(0, nil)
+	fixed := newPTPMasterState([]*ptp.Master{m}, &pkgconfig.ClockQualityConfig{Class: 6, Accuracy: 0x20, Variance: 0x4E20, TimeSource: 0x20})
+	fixed.apply(tr.synced(&refSource{proto: "ntp", stratum: 3}, source.Sample{Offset: time.Second, Time: now}, now))
+	fixed.apply(tr.holdover(now.Add(time.Hour * 2)))
+	if tp := m.TimeProperties(); tp.Quality != (ptp.ClockQuality{Class: 6, Accuracy: 0x20, Variance: 0x4E20}) || tp.TimeSource != 0x20 || !tp.TimeTraceable {
+		t.Errorf("static: %+v", tp)
+	}
+}
@@ -0,0 +1,157 @@
+package clocksync
+
+import (
+	"context"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/shiwa/timecard-mini/tc-sync/internal/ntp"
+	"github.com/shiwa/timecard-mini/tc-sync/internal/ptp"
+	"github.com/shiwa/timecard-mini/tc-sync/internal/servo"
+	"github.com/shiwa/timecard-mini/tc-sync/internal/source"
+)
+
+// qualityTracker вычисляет качество часов по активному источнику и измерениям servo и рассылает
+// его через servo.ClockQuality подписчикам — серверам PTP и NTP:
+//   - состояние: locked — коррекция по источнику stratum < 16; holdover — источник потерян не дольше
+//     holdoverLimit; degraded — дольше: категория 1–3 до 1, 3 и 7 длительностей holdoverLimit сверх
+//     него; затем freerun;
+//   - clockClass: 6 / 7 / 140, 150, 160 / 248 — только при источнике GNSS (primaryReference);
+//     PTP, NTP и прочие источники — 248 в любом состоянии: часы не первичный эталон;
+//   - clockAccuracy — по |offset| + джиттер + дисперсия измерения;
+//   - timeSource — по протоколу источника (GNSS 0x20, PTP 0x40, NTP 0x50, иначе 0x90; без
+//     синхронизации — внутренний генератор 0xA0);
+//   - offsetScaledLogVariance — по вариации Аллана сдвига (ptp.VarianceFor).
+type qualityTracker struct {
+	cq            *servo.ClockQuality
+	holdoverLimit time.Duration
+	sources       []source.TimeSource
+
+	stability servo.Stability
+	active    source.TimeSource
+	last      servo.Quality // при последней синхронизации
+}
+
+func newQualityTracker(holdoverLimit time.Duration, sources []source.TimeSource) *qualityTracker {
+	if holdoverLimit <= 0 {
+		holdoverLimit = defaultHoldoverLimit
+	}
+	return &qualityTracker{cq: servo.NewClockQuality(), holdoverLimit: holdoverLimit, sources: sources}
+}
+
+// synced вызывается после коррекции часов по активному источнику
+func (t *qualityTracker) synced(active source.TimeSource, sample source.Sample, now time.Time) servo.Quality {
+	if active != t.active {
+		t.stability.Reset()
+		t.active = active
+	}
+	stratum, refID := uint8(1), ntp.RefIDFromString(strings.ToUpper(active.Protocol()))
+	if r, ok := active.(source.Reference); ok {
+		stratum, refID = r.Reference()
+	}
+	if stratum >= ntp.MaxStratum {
+		t.last = servo.Quality{}
+		return t.publish(servo.DefaultQuality(), now)
+	}
+	t.stability.Add(now, sample.Offset)
+	offset := sample.Offset
+	if offset < 0 {
+		offset = -offset
+	}
+	q := servo.Quality{
+		State:          servo.StateLocked,
+		Class:          ptp.ClockClassPrimary,
+		TimeSource:     ptpTimeSource(active.Protocol()),
+		Variance:       0xFFFF,
+		Offset:         sample.Offset,
+		Jitter:         t.stability.Jitter(),
+		Stratum:        stratum,
+		ReferenceID:    refID,
+		RootDelay:      sample.RootDelay + sample.Delay,
+		RootDispersion: sample.RootDispersion + sample.Dispersion + offset,
+		Leap:           leapSecond(active, t.sources),
+		ReferenceTime:  now,
+	}
+	q.Accuracy = ptp.AccuracyFor(offset + q.Jitter + sample.RootDispersion + sample.Dispersion)
+	if avar, tau, ok := t.stability.AllanVariance(); ok {
+		q.AllanDeviation = math.Sqrt(avar)
+		q.Variance = ptp.VarianceFor(avar, tau)
+	}
+	if !primaryReference(active) {
+		// PTP от другого grandmaster, NTP (даже stratum 1) — не первичный эталон
+		q.Class = ptp.ClockClassDefault
+	}
+	t.last = q
+	return t.publish(q, now)
+}
+
+// holdover вызывается, когда пригодного источника нет
+func (t *qualityTracker) holdover(now time.Time) servo.Quality {
+	t.active = nil
+	t.stability.Reset()
+	if t.last.ReferenceTime.IsZero() {
+		return t.publish(servo.DefaultQuality(), now)
+	}
+	q := t.last
+	switch over := now.Sub(t.last.ReferenceTime) - t.holdoverLimit; {
+	case over <= 0:
+		q.State = servo.StateHoldover
+		if q.Class == ptp.ClockClassPrimary {
+			q.Class = ptp.ClockClassHoldover
+		}
+	case over <= t.holdoverLimit:
+		q = t.degraded(1, ptp.ClockClassOutOfSpec1)
+	case over <= 3*t.holdoverLimit:
+		q = t.degraded(2, ptp.ClockClassOutOfSpec2)
+	case over <= 7*t.holdoverLimit:
+		q = t.degraded(3, ptp.ClockClassOutOfSpec3)
+	default:
+		q = servo.DefaultQuality()
+		q.ReferenceTime = t.last.ReferenceTime
+	}
+	return t.publish(q, now)
+}
+
+// degraded — удержание вне спецификации: качество несинхронизированных часов с категорией;
+// clockClass категории — только после первичного эталона
+func (t *qualityTracker) degraded(category int, class uint8) servo.Quality {
+	if t.last.Class != ptp.ClockClassPrimary {
+		class = ptp.ClockClassDefault
+	}
+	q := servo.DefaultQuality()
+	q.State, q.Category, q.Class, q.ReferenceTime = servo.StateDegraded, category, class, t.last.ReferenceTime
+	return q
+}
+
+// primaryReference — источник — первичный эталон (clockClass 6): приёмник GNSS или PPS, секунда
+// которого всегда от GNSS (linked_device; без него источник pps недоступен)
+func primaryReference(s source.TimeSource) bool {
+	switch strings.ToLower(s.Protocol()) {
+	case "gnss", "timebeat_opentimecard_mini", "nmea", "pps":
+		return true
+	}
+	return false
+}
+
+func (t *qualityTracker) publish(q servo.Quality, now time.Time) servo.Quality {
+	q.Time = now
+	t.cq.UpdateClockQuality(q)
+	return q
+}
+
+// follow передаёт обновления качества в apply до отмены ctx
+func follow(ctx context.Context, cq *servo.ClockQuality, apply func(servo.Quality)) {
+	ch := cq.Subscribe()
+	go func() {
+		defer cq.Unsubscribe(ch)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case q := <-ch:
+				apply(q)
+			}
+		}
+	}()
+}
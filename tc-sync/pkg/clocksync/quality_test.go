@@ -0,0 +1,138 @@
+package clocksync
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/shiwa/timecard-mini/tc-sync/internal/ntp"
+	"github.com/shiwa/timecard-mini/tc-sync/internal/ptp"
+	"github.com/shiwa/timecard-mini/tc-sync/internal/servo"
+	"github.com/shiwa/timecard-mini/tc-sync/internal/source"
+	pkgconfig "github.com/shiwa/timecard-mini/tc-sync/pkg/config"
+)
+
+func TestQualityTracker(t *testing.T) {
+	gnss := &refSource{proto: "gnss", stratum: 1, refID: "GPS"}
+	tr := newQualityTracker(time.Minute, nil)
+	if q := tr.holdover(time.Now()); q.State != servo.StateFreerun || q.Class != ptp.ClockClassDefault || q.TimeSource != ptp.TimeSourceInternalOscillator {
+		t.Errorf("before sync: %+v", q)
+	}
+
+	// Сдвиг ±50 нс раз в секунду: джиттер 50 нс, вариация Аллана по 3+ измерениям
+	now := time.Now()
+	var q servo.Quality
+	for i := 0; i < 8; i++ {
+		off := 50 * time.Nanosecond
+		if i%2 == 1 {
+			off = -off
+		}
+		now = now.Add(time.Second)
+		q = tr.synced(gnss, source.Sample{Offset: off, Time: now}, now)
+	}
+	if q.State != servo.StateLocked || q.Class != ptp.ClockClassPrimary || q.TimeSource != ptp.TimeSourceGPS || q.Stratum != 1 {
+		t.Errorf("locked: %+v", q)
+	}
+	if q.Jitter < 49*time.Nanosecond || q.Jitter > 51*time.Nanosecond || q.Accuracy != ptp.AccuracyFor(100*time.Nanosecond) {
+		t.Errorf("jitter %v accuracy %#x", q.Jitter, q.Accuracy)
+	}
+	// σ²y(1 с) = (200 нс)² / 2 = 2·10⁻¹⁴
+	if q.AllanDeviation == 0 || q.Variance != ptp.VarianceFor(2e-14, time.Second) {
+		t.Errorf("allan deviation %v variance %#x", q.AllanDeviation, q.Variance)
+	}
+	if tr.cq.Get().Class != ptp.ClockClassPrimary {
+		t.Error("quality not published")
+	}
+
+	// Удержание: в пределах лимита — 7, затем категории 1–3 (до 1, 3, 7 лимитов сверх него), затем 248
+	for _, c := range []struct {
+		after    time.Duration
+		state    servo.SyncState
+		category int
+		class    uint8
+	}{
+		{30 * time.Second, servo.StateHoldover, 0, ptp.ClockClassHoldover},
+		{2 * time.Minute, servo.StateDegraded, 1, 140},
+		{4 * time.Minute, servo.StateDegraded, 2, 150},
+		{8 * time.Minute, servo.StateDegraded, 3, 160},
+		{9 * time.Minute, servo.StateFreerun, 0, ptp.ClockClassDefault},
+	} {
+		q := tr.holdover(now.Add(c.after))
+		if q.State != c.state || q.Category != c.category || q.Class != c.class || q.ReferenceTime != now {
+			t.Errorf("holdover %v: %+v", c.after, q)
+		}
+	}
+}
+
+func TestQualityTracker_Subscribers(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	tr := newQualityTracker(time.Minute, nil)
+	telecom := ptp.NewMaster(nil, ptp.MasterConfig{ClockClasses: ptp.ClockClasses{Locked: 6, Holdover: 7, Degraded: 140, Freerun: 248}})
+	def := ptp.NewMaster(nil, ptp.MasterConfig{})
+	follow(ctx, tr.cq, newPTPMasterState([]*ptp.Master{telecom, def}, &pkgconfig.ClockQualityConfig{Auto: true}).apply)
+	srv := ntp.NewServer("127.0.0.1:0")
+	follow(ctx, tr.cq, newNTPServerState(srv, 10*time.Minute).apply)
+
+	wait := func(what string, ok func() bool) {
+		t.Helper()
+		deadline := time.Now().Add(time.Second)
+		for !ok() {
+			if time.Now().After(deadline) {
+				t.Fatalf("%s: telecom %+v, default %+v, ntp %+v", what, telecom.TimeProperties(), def.TimeProperties(), srv.ClockQuality())
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	now := time.Now()
+	gnss := &refSource{proto: "gnss", stratum: 1, refID: "GPS"}
+	tr.synced(gnss, source.Sample{Offset: 0, Time: now}, now)
+	wait("locked", func() bool {
+		return telecom.TimeProperties().Quality.Class == 6 && def.TimeProperties().Quality.Class == 6 && srv.ClockQuality().Stratum == 1
+	})
+	// Категория 2: G.8275.x — 150, IEEE 1588 — 248; NTP в пределах своего holdover_limit
+	tr.holdover(now.Add(4 * time.Minute))
+	wait("degraded", func() bool {
+		return telecom.TimeProperties().Quality.Class == 150 && def.TimeProperties().Quality.Class == ptp.ClockClassDefault &&
+			!telecom.TimeProperties().TimeTraceable && srv.ClockQuality().Stratum == 1
+	})
+	tr.holdover(now.Add(11 * time.Minute))
+	wait("ntp beyond holdover", func() bool { return srv.ClockQuality().Stratum == ntp.MaxStratum })
+}
+
+// PTP от другого grandmaster (stratum 1) — не первичный эталон: clockClass 248 в любом состоянии,
+// серверы PTP не объявляют себя эталоном
+func TestQualityTracker_PTPSource(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	tr := newQualityTracker(time.Minute, nil)
+	telecom := ptp.NewMaster(nil, ptp.MasterConfig{ClockClasses: ptp.ClockClasses{Locked: 6, Holdover: 7, Degraded: 140, Freerun: 248}})
+	follow(ctx, tr.cq, newPTPMasterState([]*ptp.Master{telecom}, &pkgconfig.ClockQualityConfig{Auto: true}).apply)
+
+	upstream := &refSource{proto: "ptp", stratum: 1, refID: "PTP"}
+	now := time.Now()
+	q := tr.synced(upstream, source.Sample{Offset: 20 * time.Nanosecond, Time: now}, now)
+	if q.State != servo.StateLocked || q.Class != ptp.ClockClassDefault || q.TimeSource != ptp.TimeSourcePTP || q.Stratum != 1 {
+		t.Errorf("locked to ptp: %+v", q)
+	}
+	for _, c := range []struct {
+		after time.Duration
+		state servo.SyncState
+	}{
+		{30 * time.Second, servo.StateHoldover},
+		{2 * time.Minute, servo.StateDegraded},
+	} {
+		if q := tr.holdover(now.Add(c.after)); q.State != c.state || q.Class != ptp.ClockClassDefault {
+			t.Errorf("holdover %v: %+v", c.after, q)
+		}
+	}
+
+	tr.synced(upstream, source.Sample{Time: now}, now)
+	deadline := time.Now().Add(time.Second)
+	for telecom.TimeProperties().Quality.Class != ptp.ClockClassDefault || telecom.TimeProperties().TimeSource != ptp.TimeSourcePTP {
+		if time.Now().After(deadline) {
+			t.Fatalf("ptp server announces %+v", telecom.TimeProperties())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
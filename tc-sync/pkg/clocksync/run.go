@@ -9,6 +9,8 @@ import (
 	"github.com/shiwa/timecard-mini/tc-sync/internal/clockselect"
 	"github.com/shiwa/timecard-mini/tc-sync/internal/config"
 	"github.com/shiwa/timecard-mini/tc-sync/internal/logger"
+	"github.com/shiwa/timecard-mini/tc-sync/internal/ntp"
+	"github.com/shiwa/timecard-mini/tc-sync/internal/ptp"
 	"github.com/shiwa/timecard-mini/tc-sync/internal/ptp4l"
 	"github.com/shiwa/timecard-mini/tc-sync/internal/servo"
 	"github.com/shiwa/timecard-mini/tc-sync/internal/source"
@@ -26,36 +28,98 @@ func RunDaemon(ctx context.Context, cfg *pkgconfig.Config, quiet bool) error {
 	// Преобразуем в internal config для source factory
 	internalCfg := toInternalConfig(cfg)
 
-	// Запуск ptp4l внутри tc-sync для источников ptp с start_ptp4l: true
+	// Запуск ptp4l, phc2sys и ts2phc внутри tc-sync (start_ptp4l, start_phc2sys, start_ts2phc) с конфигами
+	// из записей (под наблюдением: перезапуск, состояние порта и servo для источника)
+	var ptp4ls map[string]*ptp4l.Supervisor
 	if internalCfg.ClockSync != nil {
-		stopPtp4l := ptp4l.Start(internalCfg.ClockSync.Ptp4lJobs(), quiet)
-		go func() {
-			<-ctx.Done()
-			stopPtp4l()
-		}()
+		jobs, err := internalCfg.ClockSync.LinuxptpJobs()
+		if err != nil {
+			logger.Error("linuxptp: %v", err)
+		}
+		var stopPtp4l func()
+		ptp4ls, stopPtp4l = ptp4l.Start(jobs)
+		defer stopPtp4l()
+	}
+
+	// Симметричные ключи NTP (key_id источников и ntp_server)
+	ntpKeys, err := loadNTPKeys(cs.NTPKeys)
+	if err != nil {
+		logger.Error("ntp_keys: %v", err)
 	}
 
+	// Параметры сокетов PTP (DSCP, TTL multicast, привязка к интерфейсу) для всех портов
+	so, err := ptpSocketOptions(cs)
+	if err != nil {
+		logger.Error("ptp_tuning: %v", err)
+	}
+	if _, err := ptpStandard(cs); err != nil {
+		logger.Error("ptp_tuning: %v", err)
+	}
+	opts := source.Options{RelaxDelayRequests: cs.Advanced != nil && cs.Advanced.PTPTuning.RelaxDelayRequests, Socket: so}
 	var primary, secondary []source.TimeSource
+	var ptpServers []pkgconfig.ClockSource // записи ptp с server_only/serve_*: порты master, не источники
 	for _, c := range cs.PrimaryClocks {
 		if c.Disable || c.MonitorOnly {
 			continue
 		}
-		s, err := source.NewFromClockSource(toInternalClockSource(c))
+		if isPTPServer(c) {
+			// С start_ptp4l сервером работает ptp4l с конфигом из записи
+			if !c.StartPtp4l {
+				ptpServers = append(ptpServers, c)
+			}
+			continue
+		}
+		ic := toInternalClockSource(c)
+		o := opts
+		if o.Auth, err = ptpSecurity(cs, c); err != nil {
+			logger.Info("primary %s: %v", c.Protocol, err)
+			continue
+		}
+		s, err := source.NewFromClockSource(ic, o)
 		if err != nil {
 			logger.Info("primary %s: %v", c.Protocol, err)
 			continue
 		}
+		if err := applyNTPKey(s, c.KeyID, ntpKeys); err != nil {
+			logger.Info("primary %s: %v", c.Protocol, err)
+			_ = s.Close()
+			continue
+		}
+		if p, ok := s.(*source.PTP); ok && c.StartPtp4l {
+			p.SetPtp4l(ptp4ls[ptp4l.Key(ptp4l.ProgramPtp4l, c.Interface)])
+		}
 		primary = append(primary, s)
 	}
 	for _, c := range cs.SecondaryClocks {
 		if c.Disable || c.MonitorOnly {
 			continue
 		}
-		s, err := source.NewFromClockSource(toInternalClockSource(c))
+		if isPTPServer(c) {
+			// С start_ptp4l сервером работает ptp4l с конфигом из записи
+			if !c.StartPtp4l {
+				ptpServers = append(ptpServers, c)
+			}
+			continue
+		}
+		ic := toInternalClockSource(c)
+		o := opts
+		if o.Auth, err = ptpSecurity(cs, c); err != nil {
+			logger.Info("secondary %s: %v", c.Protocol, err)
+			continue
+		}
+		s, err := source.NewFromClockSource(ic, o)
 		if err != nil {
 			logger.Info("secondary %s: %v", c.Protocol, err)
 			continue
 		}
+		if err := applyNTPKey(s, c.KeyID, ntpKeys); err != nil {
+			logger.Info("secondary %s: %v", c.Protocol, err)
+			_ = s.Close()
+			continue
+		}
+		if p, ok := s.(*source.PTP); ok && c.StartPtp4l {
+			p.SetPtp4l(ptp4ls[ptp4l.Key(ptp4l.ProgramPtp4l, c.Interface)])
+		}
 		secondary = append(secondary, s)
 	}
 	defer func() {
@@ -67,12 +131,19 @@ func RunDaemon(ctx context.Context, cfg *pkgconfig.Config, quiet bool) error {
 		}
 	}()
 
-	if len(primary) == 0 && len(secondary) == 0 {
+	ntpServerEnabled := cs.NTPServer != nil && cs.NTPServer.Enable
+	if len(primary) == 0 && len(secondary) == 0 && len(ptpServers) == 0 && len(ptp4ls) == 0 && !ntpServerEnabled && !autoDiscoverEnabled(cs) {
 		return nil
 	}
 
 	election := clockselect.NewElection(primary, secondary)
-	interval := parseInterval(cfg.Servo.Interval)
+	// Автообнаружение мастеров PTP: домены без записи в конфиге — динамические secondary источники
+	var discovery *ptpDiscovery
+	if autoDiscoverEnabled(cs) {
+		discovery = startPTPDiscovery(ctx, cs, election, opts)
+		defer discovery.Close()
+	}
+	interval := parseInterval(cfg.Servo.Interval, time.Second)
 	var algo servo.Algorithm
 	switch cfg.Servo.Algorithm {
 	case "pi":
@@ -88,9 +159,95 @@ func RunDaemon(ctx context.Context, cfg *pkgconfig.Config, quiet bool) error {
 	logger.Info("clocksync: primary=%d secondary=%d interval=%v adjust_clock=%v",
 		len(primary), len(secondary), interval, cs.AdjustClock)
 
+	all := append(append([]source.TimeSource(nil), primary...), secondary...)
+
+	// Качество часов (состояние, clockClass, точность, вариация) для серверов NTP и PTP
+	quality := newQualityTracker(defaultHoldoverLimit, all)
+
+	// Встроенный NTP сервер: отдаёт время, пока часы дисциплинируются (adjust_clock) по источнику
+	var ntpState *ntpServerState
+	if ns := cs.NTPServer; ns != nil && ns.Enable {
+		srv := ntp.NewServer(ns.Listen)
+		err := configureNTPServer(srv, ns, ntpKeys)
+		if err == nil {
+			err = srv.Listen()
+		}
+		if err != nil {
+			logger.Error("ntp_server: %v", err)
+		} else {
+			defer func() {
+				logger.Info("ntp_server: %s", ntpServerStatsString(srv.Stats()))
+				_ = srv.Close()
+			}()
+			go func() {
+				if err := srv.Serve(); err != nil {
+					logger.Error("ntp_server: %v", err)
+				}
+			}()
+			if !cs.AdjustClock {
+				logger.Info("ntp_server: adjust_clock is off, clock is not disciplined — serving as unsynchronized")
+			}
+			ntpState = newNTPServerState(srv, parseInterval(ns.HoldoverLimit, defaultHoldoverLimit))
+			follow(ctx, quality.cq, ntpState.apply)
+			logger.Info("ntp_server: listening on %s", srv.LocalAddr())
+		}
+	}
+
+	// PTP grandmaster: Announce/Sync с качеством часов по активному источнику или clock_quality
+	var ptpState *ptpMasterState
+	var masters []*ptp.Master
+	var servers []*ptpServer
+	txSpacing, err := ptpTxSpacing(cs)
+	if err != nil {
+		logger.Error("ptp_tuning: %v", err)
+	}
+	txQueues := make(map[string]*ptp.TxQueue)
+	for iface, gap := range txSpacing {
+		q := ptp.NewTxQueue(gap)
+		go q.Run(ctx)
+		txQueues[iface] = q
+		logger.Info("ptp server: %s tx spacing %v", iface, gap)
+	}
+	for _, c := range ptpServers {
+		auth, err := ptpSecurity(cs, c)
+		if err != nil {
+			logger.Error("ptp server %s: %v", c.Interface, err)
+			continue
+		}
+		srv, err := startPTPServer(ctx, c, auth, so, txQueues)
+		if err != nil {
+			logger.Error("ptp server %s: %v", c.Interface, err)
+			continue
+		}
+		defer srv.Close()
+		masters = append(masters, srv.master)
+		servers = append(servers, srv)
+		logger.Info("ptp server: %s domain %d, identity %s, timestamps %s", srv.iface, c.Domain, srv.master.Identity(), srv.tr.TimestampType())
+	}
+	if len(masters) > 0 {
+		ptpState = newPTPMasterState(masters, clockQualityConfig(cs))
+		follow(ctx, quality.cq, ptpState.apply)
+		if !cs.AdjustClock {
+			logger.Info("ptp server: adjust_clock is off, clock is not disciplined — announcing clockClass %d", ptpState.initial().Quality.Class)
+		}
+	}
+
+	// Интерфейс статуса (advanced.http)
+	status := &statusReporter{election: election, discovery: discovery, servers: servers, quality: quality.cq}
+	if h := httpConfig(cs); h != nil && h.Enable {
+		srv, addr, err := startStatusServer(h, status)
+		if err != nil {
+			logger.Error("http: %v", err)
+		} else {
+			defer srv.Close()
+			logger.Info("http: status on http://%s/", addr)
+		}
+	}
+
 	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 	lastRun := time.Now()
+	var lastSample time.Time // время последнего измерения OffsetSource, поданного в servo
 
 	for {
 		select {
@@ -99,17 +256,36 @@ func RunDaemon(ctx context.Context, cfg *pkgconfig.Config, quiet bool) error {
 		case <-ticker.C:
 		}
 
+		discovery.update(time.Now())
 		active := election.Select()
 		if active == nil {
 			algo.Reset()
+			quality.holdover(time.Now())
 			continue
 		}
-		refTime, ok := election.GetTimeFromActive()
-		if !ok {
-			continue
+		var refTime time.Time
+		var offsetNs int64
+		var sample source.Sample
+		if offSrc, ok := active.(source.OffsetSource); ok {
+			// NTP/PTP: offset измерен источником; одно измерение подаём в servo один раз
+			var st source.Status
+			sample, st = offSrc.GetOffset()
+			if !st.IsUsable() || !sample.Time.After(lastSample) {
+				continue
+			}
+			lastSample = sample.Time
+			offsetNs = sample.Offset.Nanoseconds()
+			refTime = time.Now().Add(sample.Offset).UTC()
+		} else {
+			t, ok := election.GetTimeFromActive()
+			if !ok {
+				continue
+			}
+			refTime = t
+			offsetNs = refTime.Sub(time.Now().UTC()).Nanoseconds()
+			sample = source.Sample{Offset: time.Duration(offsetNs), Time: time.Now()}
 		}
-		localNow := time.Now().UTC()
-		offsetNs := refTime.Sub(localNow).Nanoseconds()
+		status.synced(active, sample)
 		dt := time.Since(lastRun)
 		lastRun = time.Now()
 
@@ -128,6 +304,7 @@ func RunDaemon(ctx context.Context, cfg *pkgconfig.Config, quiet bool) error {
 					_ = clockadj.SetFrequency(ppm)
 				}
 			}
+			quality.synced(active, sample, time.Now())
 		}
 	}
 }
@@ -146,9 +323,33 @@ func ToPkgConfig(c *config.Config) *pkgconfig.Config {
 		out.ClockSync = &pkgconfig.ClockSyncConfig{
 			AdjustClock:     c.ClockSync.AdjustClock,
 			StepLimit:       c.ClockSync.StepLimit,
+			NTPKeys:         c.ClockSync.NTPKeys,
 			PrimaryClocks:   make([]pkgconfig.ClockSource, len(c.ClockSync.PrimaryClocks)),
 			SecondaryClocks: make([]pkgconfig.ClockSource, len(c.ClockSync.SecondaryClocks)),
 		}
+		if c.ClockSync.NTPServer != nil {
+			s := pkgconfig.NTPServerConfig(*c.ClockSync.NTPServer)
+			out.ClockSync.NTPServer = &s
+		}
+		if a := c.ClockSync.Advanced; a != nil {
+			out.ClockSync.Advanced = &pkgconfig.AdvancedConfig{}
+			if q := a.PTPTuning.ClockQuality; q != nil {
+				cq := pkgconfig.ClockQualityConfig(*q)
+				out.ClockSync.Advanced.PTPTuning.ClockQuality = &cq
+			}
+			out.ClockSync.Advanced.PTPTuning.RelaxDelayRequests = a.PTPTuning.RelaxDelayRequests
+			out.ClockSync.Advanced.PTPTuning.AutoDiscoverEnabled = a.PTPTuning.AutoDiscoverEnabled
+			out.ClockSync.Advanced.PTPTuning.EnablePTPGlobalSockets = a.PTPTuning.EnablePTPGlobalSockets
+			out.ClockSync.Advanced.PTPTuning.MulticastTTL = a.PTPTuning.MulticastTTL
+			out.ClockSync.Advanced.PTPTuning.DSCPGeneral = a.PTPTuning.DSCPGeneral
+			out.ClockSync.Advanced.PTPTuning.DSCPEvent = a.PTPTuning.DSCPEvent
+			out.ClockSync.Advanced.PTPTuning.SynchroniseTX = a.PTPTuning.SynchroniseTX
+			out.ClockSync.Advanced.PTPTuning.PTPStandard = a.PTPTuning.PTPStandard
+			if h := a.HTTP; h != nil {
+				hc := pkgconfig.HTTPConfig(*h)
+				out.ClockSync.Advanced.HTTP = &hc
+			}
+		}
 		for i := range c.ClockSync.PrimaryClocks {
 			out.ClockSync.PrimaryClocks[i] = fromInternalClockSource(c.ClockSync.PrimaryClocks[i])
 		}
@@ -168,17 +369,45 @@ func fromInternalClockSource(c config.ClockSource) pkgconfig.ClockSource {
 		Baud:              c.Baud,
 		IP:                c.IP,
 		PollInterval:     c.PollInterval,
+		MaxPollInterval:   c.MaxPollInterval,
+		Servers:           c.Servers,
+		NTS:               c.NTS,
+		Interleaved:       c.Interleaved,
+		KeyID:             c.KeyID,
 		Domain:            c.Domain,
 		Interface:         c.Interface,
 		UnicastMasterTable: c.UnicastMasterTable,
+		Native:            c.Native,
+		Transport:         c.Transport,
+		Profile:           c.Profile,
+		DelayStrategy:     c.DelayStrategy,
+		DelayMechanism:    c.DelayMechanism,
+		NeighborPropDelayThresh: c.NeighborPropDelayThresh,
+		HybridE2E:         c.HybridE2E,
+		ServeUnicast:      c.ServeUnicast,
+		ServeMulticast:    c.ServeMulticast,
+		ServerOnly:        c.ServerOnly,
+		AnnounceInterval:  c.AnnounceInterval,
+		SyncInterval:      c.SyncInterval,
+		DelayRequestInterval: c.DelayRequestInterval,
+		Priority1:         c.Priority1,
+		Priority2:         c.Priority2,
+		MaxUnicastSubscribers: c.MaxUnicastSubscribers,
+		MaxPacketsPerSecond: c.MaxPacketsPerSecond,
 		StartPtp4l:        c.StartPtp4l,
 		Ptp4lPath:         c.Ptp4lPath,
 		Ptp4lArgs:         c.Ptp4lArgs,
+		Ptp4lSocket:       c.Ptp4lSocket,
+		StartPhc2sys:      c.StartPhc2sys,
+		Phc2sysPath:       c.Phc2sysPath,
+		StartTs2phc:       c.StartTs2phc,
+		Ts2phcPath:        c.Ts2phcPath,
 		Pin:               c.Pin,
 		Index:             c.Index,
 		LinkedDevice:      c.LinkedDevice,
 		CableDelay:        c.CableDelay,
 		Offset:            c.Offset,
+		Authentication:    (*pkgconfig.PTPAuthConfig)(c.Authentication),
 	}
 }
 
@@ -194,9 +423,33 @@ func toInternalConfig(c *pkgconfig.Config) *config.Config {
 	if c.ClockSync != nil {
 		out.ClockSync = &config.ClockSyncConfig{
 			AdjustClock:     c.ClockSync.AdjustClock,
+			NTPKeys:         c.ClockSync.NTPKeys,
 			PrimaryClocks:   make([]config.ClockSource, len(c.ClockSync.PrimaryClocks)),
 			SecondaryClocks: make([]config.ClockSource, len(c.ClockSync.SecondaryClocks)),
 		}
+		if c.ClockSync.NTPServer != nil {
+			s := config.NTPServerConfig(*c.ClockSync.NTPServer)
+			out.ClockSync.NTPServer = &s
+		}
+		if a := c.ClockSync.Advanced; a != nil {
+			out.ClockSync.Advanced = &config.AdvancedConfig{}
+			if q := a.PTPTuning.ClockQuality; q != nil {
+				cq := config.ClockQualityConfig(*q)
+				out.ClockSync.Advanced.PTPTuning.ClockQuality = &cq
+			}
+			out.ClockSync.Advanced.PTPTuning.RelaxDelayRequests = a.PTPTuning.RelaxDelayRequests
+			out.ClockSync.Advanced.PTPTuning.AutoDiscoverEnabled = a.PTPTuning.AutoDiscoverEnabled
+			out.ClockSync.Advanced.PTPTuning.EnablePTPGlobalSockets = a.PTPTuning.EnablePTPGlobalSockets
+			out.ClockSync.Advanced.PTPTuning.MulticastTTL = a.PTPTuning.MulticastTTL
+			out.ClockSync.Advanced.PTPTuning.DSCPGeneral = a.PTPTuning.DSCPGeneral
+			out.ClockSync.Advanced.PTPTuning.DSCPEvent = a.PTPTuning.DSCPEvent
+			out.ClockSync.Advanced.PTPTuning.SynchroniseTX = a.PTPTuning.SynchroniseTX
+			out.ClockSync.Advanced.PTPTuning.PTPStandard = a.PTPTuning.PTPStandard
+			if h := a.HTTP; h != nil {
+				hc := config.HTTPConfig(*h)
+				out.ClockSync.Advanced.HTTP = &hc
+			}
+		}
 		for i := range c.ClockSync.PrimaryClocks {
 			out.ClockSync.PrimaryClocks[i] = toInternalClockSource(c.ClockSync.PrimaryClocks[i])
 		}
@@ -216,27 +469,55 @@ func toInternalClockSource(c pkgconfig.ClockSource) config.ClockSource {
 		Baud:              c.Baud,
 		IP:                c.IP,
 		PollInterval:      c.PollInterval,
+		MaxPollInterval:   c.MaxPollInterval,
+		Servers:           c.Servers,
+		NTS:               c.NTS,
+		Interleaved:       c.Interleaved,
+		KeyID:             c.KeyID,
 		Domain:            c.Domain,
 		Interface:         c.Interface,
 		UnicastMasterTable: c.UnicastMasterTable,
+		Native:            c.Native,
+		Transport:         ptpTransport(c),
+		Profile:           c.Profile,
+		DelayStrategy:     c.DelayStrategy,
+		DelayMechanism:    ptpDelayMechanism(c),
+		NeighborPropDelayThresh: c.NeighborPropDelayThresh,
+		HybridE2E:         c.HybridE2E,
+		ServeUnicast:      c.ServeUnicast,
+		ServeMulticast:    c.ServeMulticast,
+		ServerOnly:        c.ServerOnly,
+		AnnounceInterval:  c.AnnounceInterval,
+		SyncInterval:      c.SyncInterval,
+		DelayRequestInterval: c.DelayRequestInterval,
+		Priority1:         c.Priority1,
+		Priority2:         c.Priority2,
+		MaxUnicastSubscribers: c.MaxUnicastSubscribers,
+		MaxPacketsPerSecond: c.MaxPacketsPerSecond,
 		StartPtp4l:        c.StartPtp4l,
 		Ptp4lPath:         c.Ptp4lPath,
 		Ptp4lArgs:         c.Ptp4lArgs,
+		Ptp4lSocket:       c.Ptp4lSocket,
+		StartPhc2sys:      c.StartPhc2sys,
+		Phc2sysPath:       c.Phc2sysPath,
+		StartTs2phc:       c.StartTs2phc,
+		Ts2phcPath:        c.Ts2phcPath,
 		Pin:               c.Pin,
 		Index:             c.Index,
 		LinkedDevice:      c.LinkedDevice,
 		CableDelay:        c.CableDelay,
 		Offset:            c.Offset,
+		Authentication:    (*config.PTPAuthConfig)(c.Authentication),
 	}
 }
 
-func parseInterval(s string) time.Duration {
+func parseInterval(s string, defaultVal time.Duration) time.Duration {
 	if s == "" {
-		return time.Second
+		return defaultVal
 	}
 	d, err := time.ParseDuration(s)
 	if err != nil {
-		return time.Second
+		return defaultVal
 	}
 	return d
 }
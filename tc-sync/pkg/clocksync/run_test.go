@@ -1,7 +1,12 @@
 package clocksync
 
 import (
+	"context"
+	"net"
 	"testing"
+	"time"
+
+	pkgconfig "github.com/shiwa/timecard-mini/tc-sync/pkg/config"
 )
 
 func TestParseStepLimit(t *testing.T) {
@@ -22,3 +27,55 @@ func TestParseStepLimit(t *testing.T) {
 		}
 	}
 }
+
+// Только NTP сервер без источников (свободный ход, adjust_clock: false): daemon обслуживает
+// запросы до отмены ctx
+func TestRunDaemon_NTPServerOnly(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := pc.LocalAddr().String()
+	pc.Close()
+	cfg := &pkgconfig.Config{ClockSync: &pkgconfig.ClockSyncConfig{
+		NTPServer: &pkgconfig.NTPServerConfig{Enable: true, Listen: addr},
+	}}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		RunDaemon(ctx, cfg, true)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	req := make([]byte, 48)
+	req[0] = 0x23 // LI 0, версия 4, клиент
+	resp := make([]byte, 128)
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		select {
+		case <-done:
+			t.Fatal("RunDaemon returned without serving")
+		default:
+		}
+		conn.Write(req)
+		conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		if n, err := conn.Read(resp); err == nil && n >= 48 {
+			if mode := resp[0] & 7; mode != 4 {
+				t.Errorf("response mode %d", mode)
+			}
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("no response from ntp_server")
+		}
+	}
+}
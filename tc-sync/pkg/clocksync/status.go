@@ -0,0 +1,584 @@
+package clocksync
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shiwa/timecard-mini/tc-sync/internal/clockselect"
+	"github.com/shiwa/timecard-mini/tc-sync/internal/logger"
+	"github.com/shiwa/timecard-mini/tc-sync/internal/ptp"
+	"github.com/shiwa/timecard-mini/tc-sync/internal/ptp4l"
+	"github.com/shiwa/timecard-mini/tc-sync/internal/servo"
+	"github.com/shiwa/timecard-mini/tc-sync/internal/source"
+	pkgconfig "github.com/shiwa/timecard-mini/tc-sync/pkg/config"
+)
+
+// Интерфейс статуса по умолчанию (advanced.http, как у shiwatime)
+const (
+	defaultHTTPHost = "127.0.0.1"
+	defaultHTTPPort = 8088
+)
+
+// Status — состояние синхронизации для интерфейса статуса (advanced.http)
+type Status struct {
+	Time      time.Time      `json:"time"`
+	Active    string         `json:"active,omitempty"` // имя активного источника
+	Clock     *ClockStatus   `json:"clock,omitempty"`  // качество часов, объявляемое серверами PTP и NTP
+	Primary   []SourceStatus `json:"primary"`
+	Secondary []SourceStatus `json:"secondary"`
+	// Discovered — источники автообнаружения PTP (auto_discover_enabled); в Secondary не входят
+	Discovered []SourceStatus `json:"discovered,omitempty"`
+	PTPServers []PTPStatus    `json:"ptp_servers,omitempty"`
+}
+
+// ClockStatus — качество часов по состоянию servo и активного источника
+type ClockStatus struct {
+	State          string        `json:"state"` // locked, holdover, degraded, freerun
+	Category       int           `json:"category,omitempty"`
+	ClockClass     uint8         `json:"clock_class"`
+	ClockAccuracy  uint8         `json:"clock_accuracy"`
+	TimeSource     uint8         `json:"time_source"`
+	Variance       uint16        `json:"offset_scaled_log_variance"`
+	Offset         time.Duration `json:"offset_ns"`
+	Jitter         time.Duration `json:"jitter_ns"`
+	AllanDeviation float64       `json:"allan_deviation,omitempty"`
+}
+
+// SourceStatus — источник времени
+type SourceStatus struct {
+	Name     string `json:"name"`
+	Protocol string `json:"protocol"`
+	// Status — locked, unlocked, unavailable; пусто — источник без собственного измерения (GNSS, PPS)
+	// не активен и не опрашивается ради статуса
+	Status string        `json:"status,omitempty"`
+	Active bool          `json:"active"`
+	Offset time.Duration `json:"offset_ns"`          // время источника минус локальное
+	Delay  time.Duration `json:"delay_ns,omitempty"` // RTT (NTP) или mean path delay (PTP)
+	PTP    *PTPStatus    `json:"ptp,omitempty"`
+	Ptp4l  *Ptp4lStatus  `json:"ptp4l,omitempty"` // ptp4l, запущенный tc-sync (start_ptp4l)
+	PMC    *PMCStatus    `json:"pmc,omitempty"`   // состояние ptp4l по сокету управления
+}
+
+// PMCStatus — наборы данных ptp4l, запрошенные по сокету управления (pmc)
+type PMCStatus struct {
+	PortState     string        `json:"port_state,omitempty"` // SLAVE, если есть порт в SLAVE, иначе состояние первого порта
+	Grandmaster   string        `json:"grandmaster,omitempty"`
+	GmPresent     bool          `json:"gm_present"`
+	MasterOffset  time.Duration `json:"master_offset_ns"`
+	MeanPathDelay time.Duration `json:"mean_path_delay_ns"`
+	StepsRemoved  int           `json:"steps_removed"`
+	ClockClass    uint8         `json:"gm_clock_class"`
+	Synchronized  bool          `json:"synchronized"`
+	Error         string        `json:"error,omitempty"` // ptp4l не отвечает
+}
+
+// Ptp4lStatus — процесс ptp4l под наблюдением: перезапуски и последние строки вывода
+type Ptp4lStatus struct {
+	Running     bool          `json:"running"`
+	PID         int           `json:"pid,omitempty"`
+	Restarts    int           `json:"restarts"`
+	LastExit    string        `json:"last_exit,omitempty"`
+	PortState   string        `json:"port_state,omitempty"`
+	Grandmaster string        `json:"grandmaster,omitempty"`
+	ServoState  string        `json:"servo_state,omitempty"`
+	Offset      time.Duration `json:"offset_ns"`
+	Freq        float64       `json:"freq_ppb"`
+	PathDelay   time.Duration `json:"path_delay_ns"`
+	Locked      bool          `json:"locked"`
+}
+
+// PTPStatus — порт PTP: slave встроенного источника или master сервера
+type PTPStatus struct {
+	Interface   string    `json:"interface"`
+	Domain      int       `json:"domain"`
+	Identity    string    `json:"identity"`
+	State       string    `json:"state"`
+	Master      string    `json:"master,omitempty"` // порт выбранного мастера
+	Grandmaster string    `json:"grandmaster,omitempty"`
+	ClockClass  uint8     `json:"clock_class,omitempty"` // объявляемый сервером clockClass
+	Masters     int       `json:"masters,omitempty"`     // автообнаружение: мастеров в домене
+	Stats       *PTPStats `json:"stats,omitempty"`
+	// Admission — допуск Delay_Req и Signaling сервера (max_packets_per_second)
+	Admission *PTPAdmission `json:"admission,omitempty"`
+	// TxQueue — очередь передачи интерфейса сервера (synchronise_tx)
+	TxQueue *PTPTxQueue `json:"tx_queue,omitempty"`
+}
+
+// PTPTxQueue — отправка через очередь synchronise_tx: доля пропусков меток передачи у сообщений,
+// отправленных без ожидания и после ожидания интервала
+type PTPTxQueue struct {
+	Gap            time.Duration `json:"gap"`
+	Sent           uint64        `json:"sent"`
+	Delayed        uint64        `json:"delayed"`
+	MaxDepth       int           `json:"max_depth"`
+	Events         uint64        `json:"events"`
+	Misses         uint64        `json:"misses"`
+	SpacedEvents   uint64        `json:"spaced_events"`
+	SpacedMisses   uint64        `json:"spaced_misses"`
+	LossRate       float64       `json:"loss_rate"`
+	SpacedLossRate float64       `json:"spaced_loss_rate"`
+}
+
+// PTPAdmission — учёт входящих запросов сервера и отброшенных по WRED
+type PTPAdmission struct {
+	MaxPPS   int                  `json:"max_pps"`
+	Rate     float64              `json:"rate_pps"`
+	Accepted uint64               `json:"accepted"`
+	Dropped  uint64               `json:"dropped"`
+	Clients  []PTPClientAdmission `json:"clients,omitempty"`
+}
+
+// PTPClientAdmission — запросы одного клиента сервера
+type PTPClientAdmission struct {
+	Address  string    `json:"address"`
+	Rate     float64   `json:"rate_pps"`
+	Accepted uint64    `json:"accepted"`
+	Dropped  uint64    `json:"dropped"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// PTPStats — счётчики сообщений порта PTP; ключи In/Out — типы сообщений (Sync, Announce, …)
+type PTPStats struct {
+	In                map[string]uint64 `json:"in"`
+	Out               map[string]uint64 `json:"out"`
+	SendErrors        uint64            `json:"send_errors"`
+	TXTimestampMisses uint64            `json:"tx_timestamp_misses"`
+	TXEvents          uint64            `json:"tx_events"` // event сообщения, для которых ожидалась метка
+	SequenceGaps      uint64            `json:"sequence_gaps"`
+	OutOfOrder        uint64            `json:"out_of_order"`
+	ActualSyncPPS     float64           `json:"actual_sync_pps"`           // отправленные Sync
+	DesiredSyncPPS    float64           `json:"desired_sync_pps"`          // по интервалу Sync сервера
+	Unauthenticated   uint64            `json:"unauthenticated,omitempty"` // отброшено без TLV AUTHENTICATION
+	Peers             []PTPPeerStats    `json:"peers,omitempty"`
+}
+
+// PTPPeerStats — сообщения от одного отправителя (мастер, сосед, unicast клиент)
+type PTPPeerStats struct {
+	Identity        string            `json:"identity"`
+	Address         string            `json:"address,omitempty"`
+	In              map[string]uint64 `json:"in"`
+	SequenceGaps    uint64            `json:"sequence_gaps"`
+	OutOfOrder      uint64            `json:"out_of_order"`
+	ActualSyncPPS   float64           `json:"actual_sync_pps"`
+	DesiredSyncPPS  float64           `json:"desired_sync_pps"` // по logMessageInterval Sync
+	Unauthenticated uint64            `json:"unauthenticated,omitempty"`
+	LastSeen        time.Time         `json:"last_seen"`
+}
+
+// statusReporter собирает состояние daemon для интерфейса статуса. Источники без собственного
+// измерения (GNSS, PPS) читают порт в GetTime, поэтому для них берётся последнее измерение,
+// поданное в servo.
+type statusReporter struct {
+	election  *clockselect.Election
+	discovery *ptpDiscovery
+	servers   []*ptpServer
+	quality   *servo.ClockQuality
+
+	mu     sync.Mutex
+	source source.TimeSource // источник последнего измерения
+	last   source.Sample
+}
+
+// synced запоминает измерение активного источника, поданное в servo
+func (r *statusReporter) synced(active source.TimeSource, sample source.Sample) {
+	r.mu.Lock()
+	r.source, r.last = active, sample
+	r.mu.Unlock()
+}
+
+// Status возвращает снимок состояния
+func (r *statusReporter) Status(now time.Time) Status {
+	st := Status{Time: now.UTC()}
+	active := r.election.Active()
+	if active != nil {
+		st.Active = active.Name()
+	}
+	if r.quality != nil {
+		q := r.quality.Get()
+		st.Clock = &ClockStatus{State: q.State.String(), Category: q.Category, ClockClass: q.Class, ClockAccuracy: q.Accuracy,
+			TimeSource: q.TimeSource, Variance: q.Variance, Offset: q.Offset, Jitter: q.Jitter, AllanDeviation: q.AllanDeviation}
+	}
+	primary, secondary := r.election.Sources()
+	discovered := r.discovery.discovered()
+	dynamic := make(map[source.TimeSource]bool, len(discovered))
+	for _, ds := range discovered {
+		dynamic[ds.src] = true
+	}
+	for _, s := range primary {
+		st.Primary = append(st.Primary, r.sourceStatus(s, active))
+	}
+	for _, s := range secondary {
+		if !dynamic[s] {
+			st.Secondary = append(st.Secondary, r.sourceStatus(s, active))
+		}
+	}
+	for _, ds := range discovered {
+		ss := r.sourceStatus(ds.src, active)
+		ss.PTP.Masters = ds.master.Masters
+		if ss.PTP.Grandmaster == "" {
+			ss.PTP.Grandmaster = ds.master.Best.GrandmasterIdentity.String()
+		}
+		st.Discovered = append(st.Discovered, ss)
+	}
+	for _, s := range r.servers {
+		st.PTPServers = append(st.PTPServers, PTPStatus{
+			Interface:  s.iface,
+			Domain:     int(s.domain),
+			Identity:   s.master.Identity().String(),
+			State:      s.master.State().String(),
+			ClockClass: s.master.TimeProperties().Quality.Class,
+			Stats:      ptpStats(s.master.Stats()),
+			Admission:  ptpAdmission(s.master.Admission()),
+			TxQueue:    ptpTxQueue(s.txq),
+		})
+	}
+	return st
+}
+
+func (r *statusReporter) sourceStatus(s, active source.TimeSource) SourceStatus {
+	ss := SourceStatus{Name: s.Name(), Protocol: s.Protocol(), Active: s == active}
+	if o, ok := s.(source.OffsetSource); ok {
+		sample, st := o.GetOffset()
+		ss.Status = st.String()
+		if st.IsUsable() {
+			ss.Offset, ss.Delay = sample.Offset, sample.Delay
+		}
+	} else if ss.Active {
+		r.mu.Lock()
+		if r.source == s {
+			ss.Status = source.StatusLocked.String()
+			ss.Offset = r.last.Offset
+		}
+		r.mu.Unlock()
+	}
+	if p, ok := s.(*source.PTP); ok {
+		if h, ok := p.Ptp4l(); ok {
+			ss.Ptp4l = ptp4lStatus(h)
+		}
+		if st, ok, err := p.PMCStatus(); ok {
+			ss.PMC = pmcStatus(st, err)
+		}
+		if ss.Status == "" && (ss.Ptp4l != nil || ss.PMC != nil) {
+			_, st := p.GetTime()
+			ss.Status = st.String()
+		}
+	}
+	if n, ok := s.(*source.NativePTP); ok {
+		slave := n.Slave()
+		p := &PTPStatus{Interface: n.Interface(), Domain: n.Domain(), Identity: slave.Identity().String(), State: slave.State().String(),
+			Stats: ptpStats(slave.Stats())}
+		if m, ok := slave.Last(); ok {
+			p.Master, p.Grandmaster = m.Master.String(), m.Grandmaster.String()
+		}
+		ss.PTP = p
+	}
+	return ss
+}
+
+func ptp4lStatus(h ptp4l.Health) *Ptp4lStatus {
+	st := &Ptp4lStatus{Running: h.Running, PID: h.PID, Restarts: h.Restarts, LastExit: h.LastExit, PortState: h.PortState,
+		Grandmaster: h.Grandmaster, Locked: h.Locked(time.Now())}
+	if h.HaveSample {
+		st.ServoState, st.Offset, st.Freq, st.PathDelay = h.Last.ServoState.String(), h.Last.Offset, h.Last.Freq, h.Last.PathDelay
+	}
+	return st
+}
+
+func pmcStatus(st ptp4l.PMCStatus, err error) *PMCStatus {
+	if err != nil {
+		return &PMCStatus{Error: err.Error()}
+	}
+	ps := &PMCStatus{GmPresent: st.Time.GmPresent, MasterOffset: st.Time.MasterOffset, MeanPathDelay: st.Current.MeanPathDelay,
+		StepsRemoved: int(st.Current.StepsRemoved), ClockClass: st.Parent.GrandmasterQuality.Class, Synchronized: st.Synchronized()}
+	if st.Time.GmPresent {
+		ps.Grandmaster = st.Time.GmIdentity.String()
+	}
+	if p, ok := st.SlavePort(); ok {
+		ps.PortState = p.State.String()
+	} else if len(st.Ports) > 0 {
+		ps.PortState = st.Ports[0].State.String()
+	}
+	return ps
+}
+
+func ptpStats(ps ptp.PortStats) *PTPStats {
+	st := &PTPStats{
+		In:                messageCounts(ps.In),
+		Out:               messageCounts(ps.Out),
+		SendErrors:        ps.SendErrors,
+		TXTimestampMisses: ps.TXTimestampMisses,
+		TXEvents:          ps.TXEvents,
+		SequenceGaps:      ps.SequenceGaps,
+		OutOfOrder:        ps.OutOfOrder,
+		ActualSyncPPS:     ps.ActualSyncPPS,
+		DesiredSyncPPS:    ps.DesiredSyncPPS,
+		Unauthenticated:   ps.Unauthenticated,
+	}
+	for _, p := range ps.Peers {
+		st.Peers = append(st.Peers, PTPPeerStats{
+			Identity:        p.Peer.String(),
+			Address:         p.Addr,
+			In:              messageCounts(p.In),
+			SequenceGaps:    p.SequenceGaps,
+			OutOfOrder:      p.OutOfOrder,
+			ActualSyncPPS:   p.ActualSyncPPS,
+			DesiredSyncPPS:  p.DesiredSyncPPS,
+			Unauthenticated: p.Unauthenticated,
+			LastSeen:        p.LastSeen,
+		})
+	}
+	return st
+}
+
+// ptpTxQueue — счётчики очереди передачи; nil — без synchronise_tx
+func ptpTxQueue(q *ptp.TxQueue) *PTPTxQueue {
+	if q == nil {
+		return nil
+	}
+	s := q.Stats()
+	loss, spaced := s.LossRate()
+	return &PTPTxQueue{Gap: s.Gap, Sent: s.Sent, Delayed: s.Delayed, MaxDepth: s.MaxDepth, Events: s.Events, Misses: s.Misses,
+		SpacedEvents: s.SpacedEvents, SpacedMisses: s.SpacedMisses, LossRate: loss, SpacedLossRate: spaced}
+}
+
+// ptpAdmission — учёт допуска; nil — без max_packets_per_second
+func ptpAdmission(as ptp.AdmissionStats) *PTPAdmission {
+	if as.MaxPPS == 0 {
+		return nil
+	}
+	a := &PTPAdmission{MaxPPS: as.MaxPPS, Rate: as.Rate, Accepted: as.Accepted, Dropped: as.Dropped}
+	for _, c := range as.Clients {
+		a.Clients = append(a.Clients, PTPClientAdmission{Address: c.Addr, Rate: c.Rate, Accepted: c.Accepted, Dropped: c.Dropped, LastSeen: c.LastSeen})
+	}
+	return a
+}
+
+func messageCounts(c ptp.MessageCounts) map[string]uint64 {
+	out := make(map[string]uint64, len(c))
+	for t, n := range c {
+		out[t.String()] = n
+	}
+	return out
+}
+
+// WriteText выводит состояние в текстовом виде; обнаруженные источники — отдельным разделом
+func (st Status) WriteText(w io.Writer) {
+	fmt.Fprintf(w, "time: %s\n", st.Time.Format(time.RFC3339Nano))
+	active := st.Active
+	if active == "" {
+		active = "none"
+	}
+	fmt.Fprintf(w, "active: %s\n", active)
+	if c := st.Clock; c != nil {
+		state := c.State
+		if c.Category > 0 {
+			state = fmt.Sprintf("%s (category %d)", state, c.Category)
+		}
+		fmt.Fprintf(w, "clock: %s, clockClass %d, clockAccuracy %#x, timeSource %#x, variance %#x, offset %v, jitter %v\n",
+			state, c.ClockClass, c.ClockAccuracy, c.TimeSource, c.Variance, c.Offset, c.Jitter)
+	}
+	writeSources(w, "primary", st.Primary)
+	writeSources(w, "secondary", st.Secondary)
+	if len(st.Discovered) > 0 {
+		writeSources(w, "discovered", st.Discovered)
+	}
+	if len(st.PTPServers) > 0 {
+		fmt.Fprintln(w, "ptp servers:")
+		for _, p := range st.PTPServers {
+			fmt.Fprintf(w, "  %s domain %d: %s, identity %s, clockClass %d\n", p.Interface, p.Domain, p.State, p.Identity, p.ClockClass)
+			writePTPStats(w, p.Stats)
+			if q := p.TxQueue; q != nil {
+				fmt.Fprintf(w, "    tx queue: gap %v, sent %d, delayed %d, max depth %d; tx timestamp loss %.2f%% unspaced (%d of %d), %.2f%% spaced (%d of %d)\n",
+					q.Gap, q.Sent, q.Delayed, q.MaxDepth, 100*q.LossRate, q.Misses, q.Events, 100*q.SpacedLossRate, q.SpacedMisses, q.SpacedEvents)
+			}
+			if a := p.Admission; a != nil {
+				fmt.Fprintf(w, "    admission: rate %.1f pps (max %d), accepted %d, dropped %d\n", a.Rate, a.MaxPPS, a.Accepted, a.Dropped)
+				for _, c := range a.Clients {
+					if c.Dropped > 0 {
+						fmt.Fprintf(w, "    client %s: rate %.1f pps, accepted %d, dropped %d\n", c.Address, c.Rate, c.Accepted, c.Dropped)
+					}
+				}
+			}
+		}
+	}
+}
+
+func writeSources(w io.Writer, title string, list []SourceStatus) {
+	fmt.Fprintf(w, "%s:\n", title)
+	if len(list) == 0 {
+		fmt.Fprintln(w, "  (none)")
+	}
+	for _, s := range list {
+		mark := " "
+		if s.Active {
+			mark = "*"
+		}
+		status := s.Status
+		if status == "" {
+			status = "-"
+		}
+		fmt.Fprintf(w, "%s %s: %s", mark, s.Name, status)
+		if s.Status == source.StatusLocked.String() {
+			fmt.Fprintf(w, ", offset %v", s.Offset)
+			if s.Delay != 0 {
+				fmt.Fprintf(w, ", delay %v", s.Delay)
+			}
+		}
+		if p := s.PTP; p != nil {
+			fmt.Fprintf(w, ", port %s", p.State)
+			if p.Grandmaster != "" {
+				fmt.Fprintf(w, ", grandmaster %s", p.Grandmaster)
+			}
+			if p.Masters > 0 {
+				fmt.Fprintf(w, ", masters %d", p.Masters)
+			}
+		}
+		fmt.Fprintln(w)
+		if s.PTP != nil {
+			writePTPStats(w, s.PTP.Stats)
+		}
+		if p := s.Ptp4l; p != nil {
+			writePtp4l(w, p)
+		}
+		if p := s.PMC; p != nil {
+			writePMC(w, p)
+		}
+	}
+}
+
+// writePMC выводит состояние ptp4l по сокету управления
+func writePMC(w io.Writer, p *PMCStatus) {
+	if p.Error != "" {
+		fmt.Fprintf(w, "    pmc: %s\n", strings.TrimPrefix(p.Error, "pmc: "))
+		return
+	}
+	fmt.Fprintf(w, "    pmc: port %s", p.PortState)
+	if p.GmPresent {
+		fmt.Fprintf(w, ", grandmaster %s (class %d, steps removed %d)", p.Grandmaster, p.ClockClass, p.StepsRemoved)
+	} else {
+		fmt.Fprint(w, ", no grandmaster")
+	}
+	fmt.Fprintf(w, ", master offset %v, mean path delay %v\n", p.MasterOffset, p.MeanPathDelay)
+}
+
+// writePtp4l выводит состояние ptp4l: процесс, порт и последнюю строку servo
+func writePtp4l(w io.Writer, p *Ptp4lStatus) {
+	if !p.Running {
+		fmt.Fprintf(w, "    ptp4l: not running (%s), restarts %d\n", p.LastExit, p.Restarts)
+		return
+	}
+	fmt.Fprintf(w, "    ptp4l: pid %d, restarts %d", p.PID, p.Restarts)
+	if p.PortState != "" {
+		fmt.Fprintf(w, ", port %s", p.PortState)
+	}
+	if p.ServoState != "" {
+		fmt.Fprintf(w, ", servo %s, offset %v, freq %+.0f ppb, path delay %v", p.ServoState, p.Offset, p.Freq, p.PathDelay)
+	}
+	fmt.Fprintln(w)
+}
+
+// writePTPStats выводит счётчики порта и его отправителей (типы сообщений — по алфавиту)
+func writePTPStats(w io.Writer, st *PTPStats) {
+	if st == nil {
+		return
+	}
+	fmt.Fprintf(w, "    in: %s\n    out: %s\n", countsText(st.In), countsText(st.Out))
+	fmt.Fprintf(w, "    send errors %d, tx timestamp misses %d", st.SendErrors, st.TXTimestampMisses)
+	if st.TXEvents > 0 {
+		fmt.Fprintf(w, " of %d (%.2f%%)", st.TXEvents, 100*float64(st.TXTimestampMisses)/float64(st.TXEvents))
+	}
+	fmt.Fprintf(w, ", sequence gaps %d, out of order %d", st.SequenceGaps, st.OutOfOrder)
+	if st.DesiredSyncPPS > 0 || st.ActualSyncPPS > 0 {
+		fmt.Fprintf(w, ", sync pps %.2f (desired %.2f)", st.ActualSyncPPS, st.DesiredSyncPPS)
+	}
+	if st.Unauthenticated > 0 {
+		fmt.Fprintf(w, ", unauthenticated %d", st.Unauthenticated)
+	}
+	fmt.Fprintln(w)
+	for _, p := range st.Peers {
+		fmt.Fprintf(w, "    peer %s", p.Identity)
+		if p.Address != "" {
+			fmt.Fprintf(w, " (%s)", p.Address)
+		}
+		fmt.Fprintf(w, ": in %s; sequence gaps %d, out of order %d", countsText(p.In), p.SequenceGaps, p.OutOfOrder)
+		if p.DesiredSyncPPS > 0 || p.ActualSyncPPS > 0 {
+			fmt.Fprintf(w, ", sync pps %.2f (desired %.2f)", p.ActualSyncPPS, p.DesiredSyncPPS)
+		}
+		if p.Unauthenticated > 0 {
+			fmt.Fprintf(w, ", unauthenticated %d", p.Unauthenticated)
+		}
+		fmt.Fprintln(w)
+	}
+}
+
+func countsText(c map[string]uint64) string {
+	if len(c) == 0 {
+		return "-"
+	}
+	names := make([]string, 0, len(c))
+	for n := range c {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	parts := make([]string, len(names))
+	for i, n := range names {
+		parts[i] = fmt.Sprintf("%s %d", n, c[n])
+	}
+	return strings.Join(parts, ", ")
+}
+
+// startStatusServer запускает интерфейс статуса advanced.http: GET / — текст, GET /json — JSON
+func startStatusServer(h *pkgconfig.HTTPConfig, r *statusReporter) (*http.Server, net.Addr, error) {
+	host, port := h.BindHost, h.BindPort
+	if host == "" {
+		host = defaultHTTPHost
+	}
+	if port == 0 {
+		port = defaultHTTPPort
+	}
+	if port < 0 || port > 65535 {
+		return nil, nil, fmt.Errorf("bind_port %d out of range", port)
+	}
+	ln, err := net.Listen("tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+	if err != nil {
+		return nil, nil, err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/" {
+			http.NotFound(w, req)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		r.Status(time.Now()).WriteText(w)
+	})
+	mux.HandleFunc("/json", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(r.Status(time.Now()))
+	})
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+	go func() {
+		if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
+			logger.Error("http: %v", err)
+		}
+	}()
+	return srv, ln.Addr(), nil
+}
+
+// httpConfig — advanced.http (nil, если не задан)
+func httpConfig(cs *pkgconfig.ClockSyncConfig) *pkgconfig.HTTPConfig {
+	if cs.Advanced == nil {
+		return nil
+	}
+	return cs.Advanced.HTTP
+}
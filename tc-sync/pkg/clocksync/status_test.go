@@ -0,0 +1,81 @@
+package clocksync
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shiwa/timecard-mini/tc-sync/internal/clockselect"
+	"github.com/shiwa/timecard-mini/tc-sync/internal/source"
+)
+
+func TestStatus(t *testing.T) {
+	gnss := &refSource{proto: "gnss", stratum: 1, refID: "GPS"}
+	pps := &refSource{proto: "pps", stratum: 1, refID: "PPS"}
+	election := clockselect.NewElection([]source.TimeSource{gnss}, []source.TimeSource{pps})
+	tr := newQualityTracker(time.Minute, nil)
+	r := &statusReporter{election: election, quality: tr.cq}
+	active := election.Select()
+	r.synced(active, source.Sample{Offset: 120 * time.Nanosecond})
+	tr.synced(active, source.Sample{Offset: 120 * time.Nanosecond}, time.Now())
+
+	st := r.Status(time.Now())
+	if st.Active != "gnss" || len(st.Primary) != 1 || len(st.Secondary) != 1 || len(st.Discovered) != 0 {
+		t.Fatalf("status %+v", st)
+	}
+	if c := st.Clock; c == nil || c.State != "locked" || c.ClockClass != 6 || c.TimeSource != 0x20 || c.Offset != 120*time.Nanosecond {
+		t.Errorf("clock %+v", c)
+	}
+	if p := st.Primary[0]; !p.Active || p.Status != "locked" || p.Offset != 120*time.Nanosecond {
+		t.Errorf("primary %+v", p)
+	}
+	// Неактивный источник без собственного измерения не опрашивается
+	if s := st.Secondary[0]; s.Active || s.Status != "" {
+		t.Errorf("secondary %+v", s)
+	}
+
+	// Обнаруженные источники — отдельным разделом
+	st.Discovered = []SourceStatus{{Name: "ptp:native domain5 eth0", Protocol: "ptp", Status: "locked", Offset: -40,
+		PTP: &PTPStatus{Interface: "eth0", Domain: 5, State: "slave", Grandmaster: "00-11-22-ff-fe-33-44-55", Masters: 2,
+			Stats: &PTPStats{In: map[string]uint64{"Sync": 16, "Announce": 2}, Out: map[string]uint64{"Delay_Req": 16}, SequenceGaps: 1,
+				Peers: []PTPPeerStats{{Identity: "00-11-22-ff-fe-33-44-55-1", Address: "10.0.0.1:319", In: map[string]uint64{"Sync": 16}, SequenceGaps: 1, ActualSyncPPS: 7.5, DesiredSyncPPS: 8}}}}}}
+	st.PTPServers = []PTPStatus{{Interface: "eth1", Domain: 0, State: "master", Identity: "00-11-22-ff-fe-33-44-66-1", ClockClass: 6,
+		Stats: &PTPStats{Out: map[string]uint64{"Sync": 200}, TXTimestampMisses: 2, TXEvents: 200, Unauthenticated: 4},
+		TxQueue: &PTPTxQueue{Gap: 5 * time.Microsecond, Sent: 400, Delayed: 150, MaxDepth: 3, Events: 120, Misses: 2, SpacedEvents: 80,
+			LossRate: 2.0 / 120, SpacedLossRate: 0},
+		Admission: &PTPAdmission{MaxPPS: 100, Rate: 120, Accepted: 900, Dropped: 50,
+			Clients: []PTPClientAdmission{{Address: "10.0.1.1", Rate: 110, Accepted: 400, Dropped: 50}, {Address: "10.0.1.2", Rate: 10, Accepted: 500}}}}}
+	st.Secondary = append(st.Secondary,
+		SourceStatus{Name: "ptp:domain0 eth2 phc=/dev/ptp1", Protocol: "ptp", Status: "unlocked",
+			Ptp4l: &Ptp4lStatus{Running: true, PID: 42, Restarts: 1, PortState: "UNCALIBRATED", ServoState: "s1", Offset: 1500, Freq: -1234, PathDelay: 567}},
+		SourceStatus{Name: "ptp:domain0 eth3 phc=/dev/ptp2", Protocol: "ptp", Status: "unavailable",
+			Ptp4l: &Ptp4lStatus{Restarts: 3, LastExit: "exit status 255"}},
+		SourceStatus{Name: "ptp:domain24 eth4 phc=/dev/ptp3", Protocol: "ptp", Status: "locked", Offset: -23,
+			PMC: &PMCStatus{PortState: "SLAVE", Grandmaster: "001122.fffe.334455", GmPresent: true, MasterOffset: -23, MeanPathDelay: 567,
+				StepsRemoved: 1, ClockClass: 6, Synchronized: true}},
+		SourceStatus{Name: "ptp:domain24 eth5 phc=/dev/ptp4", Protocol: "ptp", Status: "unavailable",
+			PMC: &PMCStatus{Error: "pmc: /var/run/ptp4l TIME_STATUS_NP: i/o timeout"}})
+	var b strings.Builder
+	st.WriteText(&b)
+	text := b.String()
+	for _, want := range []string{"active: gnss\n",
+		"clock: locked, clockClass 6, clockAccuracy 0x22, timeSource 0x20, variance 0xffff, offset 120ns, jitter 0s\n", "primary:\n* gnss: locked, offset 120ns\n", "secondary:\n  pps: -\n",
+		"discovered:\n  ptp:native domain5 eth0: locked, offset -40ns, port slave, grandmaster 00-11-22-ff-fe-33-44-55, masters 2\n",
+		"    in: Announce 2, Sync 16\n    out: Delay_Req 16\n    send errors 0, tx timestamp misses 0, sequence gaps 1, out of order 0\n",
+		"    peer 00-11-22-ff-fe-33-44-55-1 (10.0.0.1:319): in Sync 16; sequence gaps 1, out of order 0, sync pps 7.50 (desired 8.00)\n",
+		"    send errors 0, tx timestamp misses 2 of 200 (1.00%), sequence gaps 0, out of order 0, unauthenticated 4\n",
+		"    tx queue: gap 5µs, sent 400, delayed 150, max depth 3; tx timestamp loss 1.67% unspaced (2 of 120), 0.00% spaced (0 of 80)\n",
+		"  ptp:domain0 eth2 phc=/dev/ptp1: unlocked\n    ptp4l: pid 42, restarts 1, port UNCALIBRATED, servo s1, offset 1.5µs, freq -1234 ppb, path delay 567ns\n",
+		"  ptp:domain0 eth3 phc=/dev/ptp2: unavailable\n    ptp4l: not running (exit status 255), restarts 3\n",
+		"  ptp:domain24 eth4 phc=/dev/ptp3: locked, offset -23ns\n    pmc: port SLAVE, grandmaster 001122.fffe.334455 (class 6, steps removed 1), master offset -23ns, mean path delay 567ns\n",
+		"  ptp:domain24 eth5 phc=/dev/ptp4: unavailable\n    pmc: /var/run/ptp4l TIME_STATUS_NP: i/o timeout\n",
+		"    admission: rate 120.0 pps (max 100), accepted 900, dropped 50\n    client 10.0.1.1: rate 110.0 pps, accepted 400, dropped 50\n"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("missing %q in\n%s", want, text)
+		}
+	}
+	// Клиенты без отброшенных запросов — только в JSON
+	if strings.Contains(text, "10.0.1.2") {
+		t.Errorf("client without drops in\n%s", text)
+	}
+}
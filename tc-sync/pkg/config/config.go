@@ -19,6 +19,63 @@ type ClockSyncConfig struct {
 	StepLimit       string `yaml:"step_limit" config:"step_limit"` // например "15m" — лимит шага времени
 	PrimaryClocks   []ClockSource `yaml:"primary_clocks" config:"primary_clocks"`
 	SecondaryClocks []ClockSource `yaml:"secondary_clocks" config:"secondary_clocks"`
+	NTPServer       *NTPServerConfig `yaml:"ntp_server" config:"ntp_server"`
+	NTPKeys         string `yaml:"ntp_keys" config:"ntp_keys"`
+	Advanced        *AdvancedConfig `yaml:"advanced" config:"advanced"`
+}
+
+// AdvancedConfig — clock_sync.advanced (ptp_tuning и http).
+type AdvancedConfig struct {
+	PTPTuning PTPTuningConfig `yaml:"ptp_tuning" config:"ptp_tuning"`
+	HTTP      *HTTPConfig     `yaml:"http" config:"http"`
+}
+
+// HTTPConfig — clock_sync.advanced.http: интерфейс статуса (enable, bind_host, bind_port).
+type HTTPConfig struct {
+	Enable   bool   `yaml:"enable" config:"enable"`
+	BindHost string `yaml:"bind_host" config:"bind_host"`
+	BindPort int    `yaml:"bind_port" config:"bind_port"`
+}
+
+// PTPTuningConfig — clock_sync.advanced.ptp_tuning.
+type PTPTuningConfig struct {
+	ClockQuality       *ClockQualityConfig `yaml:"clock_quality" config:"clock_quality"`
+	RelaxDelayRequests  bool                `yaml:"relax_delay_requests" config:"relax_delay_requests"`
+	AutoDiscoverEnabled bool                `yaml:"auto_discover_enabled" config:"auto_discover_enabled"`
+	EnablePTPGlobalSockets bool             `yaml:"enable_ptp_global_sockets" config:"enable_ptp_global_sockets"`
+	MulticastTTL        int                 `yaml:"multicast_ttl" config:"multicast_ttl"`
+	DSCPGeneral         string              `yaml:"dscp.general" config:"dscp.general"`
+	DSCPEvent           string              `yaml:"dscp.event" config:"dscp.event"`
+	SynchroniseTX       []string            `yaml:"synchronise_tx" config:"synchronise_tx"`
+	PTPStandard         string              `yaml:"ptp_standard" config:"ptp_standard"`
+}
+
+// ClockQualityConfig — качество часов в Announce PTP сервера (auto, class, accuracy, variance, timesource).
+type ClockQualityConfig struct {
+	Auto       bool `yaml:"auto" config:"auto"`
+	Class      int  `yaml:"class" config:"class"`
+	Accuracy   int  `yaml:"accuracy" config:"accuracy"`
+	Variance   int  `yaml:"variance" config:"variance"`
+	TimeSource int  `yaml:"timesource" config:"timesource"`
+}
+
+// PTPAuthConfig — аутентификация PTP записи (TLV AUTHENTICATION, IEEE 1588-2019): spp и файл ключей.
+type PTPAuthConfig struct {
+	SPP  int    `yaml:"spp" config:"spp"`
+	Keys string `yaml:"keys" config:"keys"`
+}
+
+// NTPServerConfig — встроенный NTP сервер (enable, listen, holdover_limit, доступ и аутентификация).
+type NTPServerConfig struct {
+	Enable        bool     `yaml:"enable" config:"enable"`
+	Listen        string   `yaml:"listen" config:"listen"`
+	HoldoverLimit string   `yaml:"holdover_limit" config:"holdover_limit"`
+	Interleaved   bool     `yaml:"interleaved" config:"interleaved"`
+	Allow         []string `yaml:"allow" config:"allow"`
+	Deny          []string `yaml:"deny" config:"deny"`
+	RequireAuth   bool     `yaml:"require_auth" config:"require_auth"`
+	RateLimit     string   `yaml:"rate_limit" config:"rate_limit"`
+	RateBurst     int      `yaml:"rate_burst" config:"rate_burst"`
 }
 
 // ClockSource — один источник времени (поля как в shiwatime_ru.yml; неиспользуемые игнорируются).
@@ -30,12 +87,24 @@ type ClockSource struct {
 	Baud         int      `yaml:"baud" config:"baud"`
 	IP           string   `yaml:"ip" config:"ip"`
 	PollInterval string   `yaml:"pollinterval" config:"pollinterval"`
+	MaxPollInterval string `yaml:"max_pollinterval" config:"max_pollinterval"`
+	Servers      []string `yaml:"servers" config:"servers"`
+	NTS          bool     `yaml:"nts" config:"nts"`
+	Interleaved  bool     `yaml:"interleaved" config:"interleaved"`
+	KeyID        uint32   `yaml:"key_id" config:"key_id"`
 	Domain       int      `yaml:"domain" config:"domain"`
 	Interface    string   `yaml:"interface" config:"interface"`
 	UnicastMasterTable []string `yaml:"unicast_master_table" config:"unicast_master_table"`
+	Native       bool     `yaml:"native" config:"native"`
+	Transport    string   `yaml:"transport" config:"transport"`
 	StartPtp4l   bool     `yaml:"start_ptp4l" config:"start_ptp4l"`
 	Ptp4lPath    string   `yaml:"ptp4l_path" config:"ptp4l_path"`
 	Ptp4lArgs    []string `yaml:"ptp4l_args" config:"ptp4l_args"`
+	Ptp4lSocket  string   `yaml:"ptp4l_socket" config:"ptp4l_socket"` // сокет управления ptp4l; пусто — /var/run/ptp4l
+	StartPhc2sys bool     `yaml:"start_phc2sys" config:"start_phc2sys"`
+	Phc2sysPath  string   `yaml:"phc2sys_path" config:"phc2sys_path"`
+	StartTs2phc  bool     `yaml:"start_ts2phc" config:"start_ts2phc"`
+	Ts2phcPath   string   `yaml:"ts2phc_path" config:"ts2phc_path"`
 	Pin          int      `yaml:"pin" config:"pin"`
 	Index        int      `yaml:"index" config:"index"`
 	LinkedDevice string   `yaml:"linked_device" config:"linked_device"`
@@ -52,11 +121,16 @@ type ClockSource struct {
 	SyncInterval int     `yaml:"sync_interval" config:"sync_interval"`
 	DelayRequestInterval int `yaml:"delayrequest_interval" config:"delayrequest_interval"`
 	DelayStrategy string `yaml:"delay_strategy" config:"delay_strategy"`
+	DelayMechanism string `yaml:"delay_mechanism" config:"delay_mechanism"`
+	NeighborPropDelayThresh int64 `yaml:"neighbor_prop_delay_thresh" config:"neighbor_prop_delay_thresh"`
+	HybridE2E    bool    `yaml:"hybrid_e2e" config:"hybrid_e2e"`
 	Priority1    int     `yaml:"priority1" config:"priority1"`
 	Priority2    int     `yaml:"priority2" config:"priority2"`
 	MaxUnicastSubscribers int `yaml:"max_unicast_subscribers" config:"max_unicast_subscribers"`
+	MaxPacketsPerSecond int `yaml:"max_packets_per_second" config:"max_packets_per_second"`
 	UseLayer2    bool    `yaml:"use_layer2" config:"use_layer2"`
 	Profile      string  `yaml:"profile" config:"profile"`
+	Authentication *PTPAuthConfig `yaml:"authentication" config:"authentication"`
 	OcpDevice    int     `yaml:"ocp_device" config:"ocp_device"`
 	OscillatorType string `yaml:"oscillator_type" config:"oscillator_type"`
 }